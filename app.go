@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/health"
+	"github.com/connorkuehl/popple/internal/httpapi"
+	"github.com/connorkuehl/popple/internal/metrics"
+	"github.com/connorkuehl/popple/internal/webhook"
+)
+
+// App is the bot along with the services that run beside it.
+type App struct {
+	Bot      *bot.Bot
+	API      *httpapi.Server
+	Webhooks *webhook.Worker
+	Metrics  *metrics.Metrics
+	Health   *health.Server
+	Backups  *sqlite.Backups
+}
+
+// Run runs the bot until ctx is canceled or the bot stops. Everything else
+// stops with the bot, and the bot stops if anything else fails.
+//
+// Shutting down stops taking messages and requests, lets the ones already
+// taken finish, and then sends any announcements still batched. The caller
+// closes the database once Run returns.
+func (a *App) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	services := []func(context.Context) error{
+		a.API.ListenAndServe,
+		a.Webhooks.Run,
+		a.Metrics.ListenAndServe,
+		a.Health.ListenAndServe,
+		a.Backups.Run,
+	}
+
+	errs := make(chan error, len(services))
+	for _, run := range services {
+		run := run
+		go func() {
+			err := run(ctx)
+			if err != nil {
+				cancel()
+			}
+			errs <- err
+		}()
+	}
+
+	err := a.Bot.Listen(ctx)
+	cancel()
+	log.Info("shutting down")
+
+	var serviceErr error
+	for range services {
+		if err := <-errs; err != nil && serviceErr == nil {
+			serviceErr = err
+		}
+	}
+
+	// The API may have changed karma while the bot was draining.
+	a.Bot.Flush()
+
+	if serviceErr != nil {
+		return serviceErr
+	}
+	return err
+}
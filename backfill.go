@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/backfill"
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/command"
+	"github.com/connorkuehl/popple/internal/env"
+	"github.com/connorkuehl/popple/internal/logging"
+	"github.com/connorkuehl/popple/internal/popple"
+)
+
+// runBackfill applies the karma changes in a Discord channel's history.
+func runBackfill(ctx context.Context, settings env.Lookup, args []string) error {
+	flags := newFlagSet("backfill")
+	guildID := flags.String("guild", "", "ID of the server the channel is in")
+	channelID := flags.String("channel", "", "ID of the channel to backfill")
+	since := flags.String("since", "", "when to start, as an RFC 3339 time or a duration ago, e.g., 72h")
+	until := flags.String("until", "", "when to stop, in the same format as -since; defaults to now")
+	if err := parse(flags, args); err != nil {
+		return err
+	}
+
+	if len(*guildID) == 0 || len(*channelID) == 0 || len(*since) == 0 {
+		return usagef("backfill: -guild, -channel and -since are required")
+	}
+
+	now := time.Now()
+	req := backfill.Request{GuildID: *guildID, ChannelID: *channelID}
+
+	var err error
+	if req.Since, err = parseTime(*since, now); err != nil {
+		return usagef("backfill: -since: %v", err)
+	}
+	// Popple only remembers which messages it applied for so long, so
+	// older ones could be counted twice.
+	if req.Since.Before(now.Add(-popple.BackfillWindow)) {
+		return usagef("backfill: -since: can't be more than %d days ago", popple.BackfillWindow/(24*time.Hour))
+	}
+	if len(*until) > 0 {
+		if req.Until, err = parseTime(*until, now); err != nil {
+			return usagef("backfill: -until: %v", err)
+		}
+	}
+
+	history, err := InitializeHistory(settings)
+	if err != nil {
+		return err
+	}
+
+	db, cleanup, err := InitializeDB(settings)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	logConfig, err := logging.ConfigFromEnv(settings)
+	if err != nil {
+		return err
+	}
+
+	// Backfilling never responds, so there's no session.
+	b := bot.New(nil, db, command.NewRouter("@"+history.Username()), logging.New(log.StandardLogger(), logConfig))
+
+	result, err := backfill.Run(ctx, history, b, req)
+	log.WithFields(log.Fields{
+		"messages": result.Messages,
+		"applied":  result.Applied,
+	}).Info("backfilled")
+	return err
+}
+
+// parseTime parses s as an RFC 3339 time or as a duration before now.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+// runBackup backs up the database now instead of waiting for the next
+// scheduled backup.
+func runBackup(ctx context.Context, settings env.Lookup, args []string) error {
+	config, err := sqlite.BackupConfigFromEnv(settings)
+	if err != nil {
+		return err
+	}
+	if len(config.Dir) == 0 {
+		// Scheduled backups are off, so none of their settings were read.
+		config.Keep = sqlite.DefaultBackupKeep
+	}
+
+	flags := newFlagSet("backup")
+	dir := flags.String("dir", config.Dir, "directory to write the backup to; defaults to POPPLE_BACKUP_DIR")
+	keep := flags.Int("keep", config.Keep, "how many backups to keep in the directory, or 0 to keep them all")
+	if err := parse(flags, args); err != nil {
+		return err
+	}
+	if flags.Arg(0) != "now" {
+		return usagef("backup: expected now")
+	}
+	// Flags may come after now, too.
+	if err := parse(flags, flags.Args()[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usagef("backup: unexpected arguments %q", flags.Args())
+	}
+
+	if len(*dir) == 0 {
+		return usagef("backup: -dir or POPPLE_BACKUP_DIR is required")
+	}
+
+	db, cleanup, err := InitializeDB(settings)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	path, err := db.BackupTo(ctx, *dir, *keep)
+	if err != nil {
+		return err
+	}
+
+	log.WithField("path", path).Info("backed up the database")
+	return nil
+}
+
+// runRestore replaces the database with a backup.
+func runRestore(ctx context.Context, settings env.Lookup, args []string) error {
+	flags := newFlagSet("restore")
+	if err := parse(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return usagef("restore: expected one backup to restore")
+	}
+	from := flags.Arg(0)
+
+	to, err := sqlite.PathFromEnv(settings)
+	if err != nil {
+		return err
+	}
+
+	version, err := sqlite.Restore(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	ll := log.WithFields(log.Fields{
+		"from":           from,
+		"to":             to,
+		"schema_version": version,
+	})
+	ll.Info("restored the database")
+
+	if latest := sqlite.LatestSchemaVersion(); version < latest {
+		ll.Warn("the backup is from an older version of Popple; run popple migrate before starting Popple")
+	}
+	return nil
+}
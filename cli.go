@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+// Exit statuses.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	// exitUsage means popple was run wrong, e.g., with an unknown flag.
+	exitUsage = 2
+)
+
+// subcommand is one of popple's subcommands.
+type subcommand struct {
+	name string
+	// args is what follows the name, for the usage message.
+	args    string
+	summary string
+	run     func(ctx context.Context, settings env.Lookup, args []string) error
+}
+
+// subcommands lists popple's subcommands in the order they're listed in help.
+func subcommands() []subcommand {
+	return []subcommand{
+		{name: "serve", summary: "Connects to the chat service and runs the bot. This is what popple does without a command.", run: runServe},
+		{name: "repl", summary: "Chats with the bot on the terminal.", run: runREPL},
+		{name: "migrate", args: "[flags]", summary: "Applies the database migrations that haven't been applied yet.", run: runMigrate},
+		{name: "stats", summary: "Counts what's in the database.", run: runStats},
+		{name: "backfill", args: "[flags]", summary: "Applies the karma changes in a Discord channel's history.", run: runBackfill},
+		{name: "export", args: "[flags]", summary: "Writes a server's karma to stdout or a file.", run: runExport},
+		{name: "import", args: "[flags] file", summary: "Reads a server's karma from an export or another karma bot's data.", run: runImport},
+		{name: "backup", args: "now [flags]", summary: "Backs up the database.", run: runBackup},
+		{name: "restore", args: "file", summary: "Replaces the database with a backup. Popple must not be running.", run: runRestore},
+		{name: "config", args: "validate", summary: "Checks that the settings are complete and valid without connecting to anything.", run: runConfig},
+		{name: "version", summary: "Prints popple's version.", run: runVersion},
+	}
+}
+
+func lookupSubcommand(name string) (subcommand, bool) {
+	for _, c := range subcommands() {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return subcommand{}, false
+}
+
+// usage describes popple, its commands and the flags in fs.
+func usage(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintf(out, "Usage: popple [flags] [command]\n\nCommands:\n")
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, c := range subcommands() {
+		fmt.Fprintf(w, "  %s\t%s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "  help\tDescribes a command, e.g., popple help export.\n")
+	_ = w.Flush()
+
+	fmt.Fprintf(out, "\nFlags:\n")
+	fs.PrintDefaults()
+}
+
+// runHelp describes the command named in args, or popple if there isn't
+// one.
+func runHelp(ctx context.Context, settings env.Lookup, fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		fs.SetOutput(os.Stdout)
+		usage(fs)
+		return nil
+	}
+
+	c, ok := lookupSubcommand(args[0])
+	if !ok {
+		return usagef("help: unknown command %q", args[0])
+	}
+
+	// Every command describes itself, flags and all, when asked to.
+	return c.run(ctx, settings, []string{"-h"})
+}
+
+// newFlagSet makes the flag set for the command called name, with a usage
+// message drawn from the command.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		c, _ := lookupSubcommand(name)
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: popple [flags] %s %s\n\n%s\n", c.name, c.args, c.summary)
+
+		var hasFlags bool
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(out, "\nFlags:\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// parse parses args with fs. The flag package reports mistakes itself, so
+// they come back as usage errors that have already been reported.
+func parse(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return usageError{err: err, reported: true}
+}
+
+// usageError is a mistake in how popple was run, as opposed to something
+// going wrong while it ran.
+type usageError struct {
+	err error
+	// reported is set if the error has already been printed.
+	reported bool
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+func usagef(format string, args ...any) error {
+	return usageError{err: fmt.Errorf(format, args...)}
+}
+
+// exit reports err and returns the status popple exits with. Being asked
+// to stop, e.g., by SIGTERM, isn't a failure.
+func exit(err error) int {
+	var uerr usageError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp), errors.Is(err, context.Canceled):
+		return exitOK
+	case errors.As(err, &uerr):
+		if !uerr.reported {
+			fmt.Fprintf(os.Stderr, "popple: %v\nRun 'popple help' for usage.\n", err)
+		}
+		return exitUsage
+	default:
+		log.WithError(err).Error("exiting")
+		return exitFailure
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"testing"
+
+	"github.com/connorkuehl/popple/internal/bot"
+)
+
+func TestExit(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "success", want: exitOK},
+		{name: "help", err: flag.ErrHelp, want: exitOK},
+		{name: "stopped", err: context.Canceled, want: exitOK},
+		{name: "stopped while serving", err: fmt.Errorf("listen: %w", context.Canceled), want: exitOK},
+		{name: "usage", err: usagef("unknown command %q", "nope"), want: exitUsage},
+		{name: "reported usage", err: usageError{err: errors.New("bad flag"), reported: true}, want: exitUsage},
+		{name: "failure", err: errors.New("database is locked"), want: exitFailure},
+		{name: "session closed", err: bot.ErrSessionClosed, want: exitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exit(tt.err); got != tt.want {
+				t.Errorf("want %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+	}{
+		{command: "serve", args: []string{"extra"}},
+		{command: "serve", args: []string{"-nope"}},
+		{command: "repl", args: []string{"extra"}},
+		{command: "migrate", args: []string{"extra"}},
+		{command: "migrate", args: []string{"-nope"}},
+		{command: "stats", args: []string{"extra"}},
+		{command: "backfill"},
+		{command: "backfill", args: []string{"-guild", "1", "-channel", "2"}},
+		{command: "backfill", args: []string{"-guild", "1", "-channel", "2", "-since", "yesterday"}},
+		{command: "backfill", args: []string{"-guild", "1", "-channel", "2", "-since", "72h", "-until", "tomorrow"}},
+		{command: "backfill", args: []string{"-guild", "1", "-channel", "2", "-since", "1000h"}},
+		{command: "export"},
+		{command: "export", args: []string{"-server", "1", "-format", "xml"}},
+		{command: "import"},
+		{command: "import", args: []string{"a.json", "b.json"}},
+		{command: "import", args: []string{"-conflict", "merge", "a.json"}},
+		{command: "backup"},
+		{command: "backup", args: []string{"later"}},
+		{command: "backup", args: []string{"now", "extra"}},
+		{command: "backup", args: []string{"now"}},
+		{command: "restore"},
+		{command: "restore", args: []string{"a.db", "b.db"}},
+		{command: "config"},
+		{command: "config", args: []string{"check"}},
+		{command: "version", args: []string{"extra"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %q", tt.command, tt.args), func(t *testing.T) {
+			c, ok := lookupSubcommand(tt.command)
+			if !ok {
+				t.Fatalf("no %s command", tt.command)
+			}
+
+			err := c.run(context.Background(), func(string) string { return "" }, tt.args)
+			var uerr usageError
+			if !errors.As(err, &uerr) {
+				t.Errorf("want a usage error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	for _, args := range [][]string{{"nope"}, {"help", "nope"}, {"-nope"}} {
+		err := run(context.Background(), args)
+		if got := exit(err); got != exitUsage {
+			t.Errorf("%q: want %d, got %d (%v)", args, exitUsage, got, err)
+		}
+	}
+}
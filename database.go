@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+// runMigrate brings the database's schema up to date.
+func runMigrate(ctx context.Context, settings env.Lookup, args []string) error {
+	fs := newFlagSet("migrate")
+	dryRun := fs.Bool("dry-run", false, "list the migrations that would be applied without applying them")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef("migrate: unexpected arguments %q", fs.Args())
+	}
+
+	db, cleanup, err := InitializeDB(settings)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	var migrations []sqlite.Migration
+	if *dryRun {
+		migrations, err = db.PendingMigrations(ctx)
+	} else {
+		migrations, err = db.Migrate(ctx)
+	}
+	for _, m := range migrations {
+		log.WithFields(log.Fields{
+			"migration": m.String(),
+			"dry_run":   *dryRun,
+		}).Info("migrated")
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(migrations) == 0 {
+		log.Info("the database is up to date")
+	}
+	return nil
+}
+
+// runStats prints how much is in the database.
+func runStats(ctx context.Context, settings env.Lookup, args []string) error {
+	fs := newFlagSet("stats")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef("stats: unexpected arguments %q", fs.Args())
+	}
+
+	db, cleanup, err := InitializeDB(settings)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	version, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	stats, err := db.Stats(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "schema version\t%d of %d\n", version, sqlite.LatestSchemaVersion())
+	fmt.Fprintf(w, "servers\t%d\n", stats.Servers)
+	fmt.Fprintf(w, "entities\t%d\n", stats.Entities)
+	fmt.Fprintf(w, "karma events\t%d\n", stats.KarmaEvents)
+	fmt.Fprintf(w, "webhooks\t%d\n", stats.Webhooks)
+	fmt.Fprintf(w, "pending deliveries\t%d\n", stats.PendingDeliveries)
+	fmt.Fprintf(w, "dead letters\t%d\n", stats.DeadLetters)
+	return w.Flush()
+}
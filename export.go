@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/env"
+	"github.com/connorkuehl/popple/internal/export"
+	"github.com/connorkuehl/popple/internal/foreign"
+)
+
+// runExport writes a server's karma to stdout or a file.
+func runExport(ctx context.Context, settings env.Lookup, args []string) error {
+	flags := newFlagSet("export")
+	serverID := flags.String("server", "", "ID of the server to export")
+	format := flags.String("format", string(export.FormatJSON), "json or csv")
+	out := flags.String("out", "", "file to write to; defaults to stdout")
+	if err := parse(flags, args); err != nil {
+		return err
+	}
+
+	if len(*serverID) == 0 {
+		return usagef("export: -server is required")
+	}
+
+	f, err := export.ParseFormat(*format)
+	if err != nil {
+		return usagef("export: %v", err)
+	}
+
+	db, cleanup, err := InitializeDB(settings)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	e, err := export.Dump(ctx, db, *serverID)
+	if err != nil {
+		return err
+	}
+
+	if len(*out) == 0 {
+		return export.Write(os.Stdout, e, f)
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := export.Write(file, e, f); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// runImport reads a server's karma from an export, or from another karma
+// bot's data with -from.
+func runImport(ctx context.Context, settings env.Lookup, args []string) error {
+	flags := newFlagSet("import")
+	serverID := flags.String("server", "", "ID of the server to import into; defaults to the one the export is from")
+	format := flags.String("format", "", "json or csv; defaults to the file's extension")
+	from := flags.String("from", "", "the karma bot the file is from: "+strings.Join(foreign.Names(), ", ")+"; defaults to Popple")
+	conflict := flags.String("conflict", string(database.ConflictSkip), "what to do with karma that already exists: overwrite, add or skip")
+	dryRun := flags.Bool("dry-run", false, "report what would be imported without importing it")
+	if err := parse(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return usagef("import: expected one file to import")
+	}
+	path := flags.Arg(0)
+
+	c, err := export.ParseConflict(*conflict)
+	if err != nil {
+		return usagef("import: %v", err)
+	}
+	opts := database.ImportOptions{Conflict: c, DryRun: *dryRun}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	db, cleanup, err := InitializeDB(settings)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	var result database.ImportResult
+	if len(*from) > 0 {
+		result, err = importForeign(ctx, db, file, *from, *serverID, opts)
+	} else {
+		result, err = importExport(ctx, db, file, path, *format, serverID, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"server_id":        *serverID,
+		"dry_run":          *dryRun,
+		"config":           result.ConfigImported,
+		"entities_created": result.EntitiesCreated,
+		"entities_updated": result.EntitiesUpdated,
+		"entities_skipped": result.EntitiesSkipped,
+		"events_added":     result.EventsAdded,
+		"events_skipped":   result.EventsSkipped,
+	}).Info("imported")
+	return nil
+}
+
+// importExport reads a Popple export. If serverID is empty, it's set to
+// the server the export is from.
+func importExport(ctx context.Context, db export.Sink, r io.Reader, path, format string, serverID *string, opts database.ImportOptions) (database.ImportResult, error) {
+	if len(format) == 0 {
+		format = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+	f, err := export.ParseFormat(format)
+	if err != nil {
+		return database.ImportResult{}, usagef("import: %v", err)
+	}
+
+	e, err := export.Read(r, f)
+	if err != nil {
+		return database.ImportResult{}, err
+	}
+
+	if len(*serverID) == 0 {
+		*serverID = e.ServerID
+	}
+	if len(*serverID) == 0 {
+		return database.ImportResult{}, usagef("import: the export doesn't say which server it's from, so -server is required")
+	}
+
+	return export.Load(ctx, db, *serverID, e, opts)
+}
+
+// importForeign reads another karma bot's data and warns about each record
+// that won't be imported.
+func importForeign(ctx context.Context, db export.Sink, r io.Reader, from, serverID string, opts database.ImportOptions) (database.ImportResult, error) {
+	if len(serverID) == 0 {
+		return database.ImportResult{}, usagef("import: -server is required with -from")
+	}
+
+	p, err := foreign.Lookup(from)
+	if err != nil {
+		return database.ImportResult{}, usagef("import: %v", err)
+	}
+
+	result, err := p.Parse(r)
+	if err != nil {
+		return database.ImportResult{}, fmt.Errorf("import: %w", err)
+	}
+
+	for _, u := range result.Unmapped {
+		log.WithFields(log.Fields{
+			"from":   from,
+			"record": u.Record,
+			"reason": u.Reason,
+		}).Warn("not importing")
+	}
+
+	return db.Import(ctx, serverID, result.Data, opts)
+}
@@ -0,0 +1,100 @@
+// Package backfill applies the karma changes in a channel's history that
+// the bot missed, e.g., during an outage or before it joined the server.
+package backfill
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/discord"
+)
+
+// PageSize is how many messages are read from history at a time. It's
+// the most Discord will send at once.
+const PageSize = 100
+
+// History pages through a channel's messages.
+type History interface {
+	// MessagesAfter returns up to limit messages sent in channelID after
+	// the message afterID, oldest first. Fewer than limit messages means
+	// there are no more.
+	MessagesAfter(ctx context.Context, channelID, afterID string, limit int) ([]discord.HistoricalMessage, error)
+}
+
+// Karma applies the karma changes in messages. Backfilling a message more
+// than once must not change karma more than once.
+type Karma interface {
+	Backfill(ctx context.Context, msg chat.Message, at time.Time) (bool, error)
+}
+
+// Request is the history to backfill.
+type Request struct {
+	GuildID   string
+	ChannelID string
+	Since     time.Time
+	// Until is zero to backfill up to now.
+	Until time.Time
+}
+
+// Result tallies a backfill.
+type Result struct {
+	// Messages is how many messages were read.
+	Messages int
+	// Applied is how many of them changed karma.
+	Applied int
+}
+
+// Run reads the channel's history from Since to Until, oldest first, and
+// applies the karma changes in it. Messages the bot sent are skipped. If
+// it fails partway, it's safe to run again.
+func Run(ctx context.Context, history History, karma Karma, req Request) (Result, error) {
+	ll := log.WithFields(log.Fields{
+		"guild_id":   req.GuildID,
+		"channel_id": req.ChannelID,
+	})
+
+	var result Result
+	// Messages sent during the millisecond Since falls in can have the
+	// smallest ID for that millisecond, so start one earlier.
+	after := discord.SnowflakeAt(req.Since.Add(-time.Millisecond))
+	for {
+		page, err := history.MessagesAfter(ctx, req.ChannelID, after, PageSize)
+		if err != nil {
+			return result, err
+		}
+
+		for _, msg := range page {
+			if !req.Until.IsZero() && msg.SentAt.After(req.Until) {
+				return result, nil
+			}
+			after = msg.ID
+			result.Messages++
+
+			if msg.Self {
+				continue
+			}
+
+			msg.GuildID = req.GuildID
+			msg.ChannelID = req.ChannelID
+			applied, err := karma.Backfill(ctx, msg.Message, msg.SentAt)
+			if err != nil {
+				return result, err
+			}
+			if applied {
+				result.Applied++
+			}
+		}
+
+		ll.WithFields(log.Fields{
+			"messages": result.Messages,
+			"applied":  result.Applied,
+		}).Info("backfilling")
+
+		if len(page) < PageSize {
+			return result, nil
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package backfill_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/backfill"
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/command"
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/discord"
+	"github.com/connorkuehl/popple/internal/discord/discordtest"
+	"github.com/connorkuehl/popple/internal/popple"
+)
+
+// history is a channel's messages, oldest first.
+type history struct {
+	messages []discord.HistoricalMessage
+	// fail makes every read after this many fail.
+	fail  int
+	reads int
+}
+
+func (h *history) MessagesAfter(ctx context.Context, channelID, afterID string, limit int) ([]discord.HistoricalMessage, error) {
+	h.reads++
+	if h.fail > 0 && h.reads > h.fail {
+		return nil, errors.New("nope")
+	}
+
+	after, err := strconv.ParseInt(afterID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	var page []discord.HistoricalMessage
+	for _, msg := range h.messages {
+		id, _ := strconv.ParseInt(msg.ID, 10, 64)
+		if id > after && len(page) < limit {
+			page = append(page, msg)
+		}
+	}
+	return page, nil
+}
+
+var start = time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func message(minute int, content string) discord.HistoricalMessage {
+	at := start.Add(time.Duration(minute) * time.Minute)
+	return discord.HistoricalMessage{
+		Message: chat.Message{ID: discord.SnowflakeAt(at), ChannelID: "456", Content: content},
+		SentAt:  at,
+	}
+}
+
+func newBot(t *testing.T) (*bot.Bot, *sqlite.DB, *discordtest.ResponseRecorder) {
+	t.Helper()
+
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	session := discordtest.NewResponseRecorder(nil)
+	return bot.New(session, db, command.NewRouter("@popple"), nil), db, session
+}
+
+func karma(t *testing.T, db *sqlite.DB, names ...string) map[string]int64 {
+	t.Helper()
+
+	entities, err := db.Entities(context.Background(), "123", names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]int64)
+	for _, e := range entities {
+		got[e.Name] = e.Karma
+	}
+	return got
+}
+
+func TestRun(t *testing.T) {
+	b, db, session := newBot(t)
+
+	self := message(2, "zelda++ has 1 karma")
+	self.Self = true
+	h := &history{messages: []discord.HistoricalMessage{
+		message(-10, "link++"),
+		message(0, "link++"),
+		message(1, "hello"),
+		self,
+		message(3, "zelda++ ganon--"),
+		message(10, "link++"),
+	}}
+
+	req := backfill.Request{GuildID: "123", ChannelID: "456", Since: start, Until: start.Add(5 * time.Minute)}
+	result, err := backfill.Run(context.Background(), h, b, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (backfill.Result{Messages: 4, Applied: 2}); result != want {
+		t.Errorf("want %+v, got %+v", want, result)
+	}
+
+	want := map[string]int64{"link": 1, "zelda": 1, "ganon": -1}
+	if got := karma(t, db, "link", "zelda", "ganon"); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	if len(session.Responses) > 0 {
+		t.Errorf("want nothing announced, got %v", session.Responses)
+	}
+
+	// Backfilled karma happened when the messages were sent.
+	board, err := db.WindowLeaderboard(context.Background(), "123", start.Add(2*time.Minute), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBoard := popple.Board{{Who: "zelda", Karma: 1}, {Who: "ganon", Karma: -1}}
+	if !reflect.DeepEqual(board, wantBoard) {
+		t.Errorf("want %v, got %v", wantBoard, board)
+	}
+
+	t.Run("again", func(t *testing.T) {
+		result, err := backfill.Run(context.Background(), h, b, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := (backfill.Result{Messages: 4, Applied: 0}); result != want {
+			t.Errorf("want %+v, got %+v", want, result)
+		}
+		if got := karma(t, db, "link", "zelda", "ganon"); !reflect.DeepEqual(got, want) {
+			t.Errorf("want %v, got %v", want, got)
+		}
+	})
+}
+
+func TestRunPages(t *testing.T) {
+	b, db, _ := newBot(t)
+
+	h := &history{fail: 2}
+	for i := 0; i < backfill.PageSize*2+50; i++ {
+		h.messages = append(h.messages, message(i, fmt.Sprintf("link++ %d", i)))
+	}
+
+	req := backfill.Request{GuildID: "123", ChannelID: "456", Since: start}
+	result, err := backfill.Run(context.Background(), h, b, req)
+	if err == nil {
+		t.Fatal("want an error")
+	}
+	if want := (backfill.Result{Messages: backfill.PageSize * 2, Applied: backfill.PageSize * 2}); result != want {
+		t.Errorf("want %+v, got %+v", want, result)
+	}
+
+	// Picking up where it failed doesn't count anything twice.
+	h.fail = 0
+	result, err = backfill.Run(context.Background(), h, b, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (backfill.Result{Messages: len(h.messages), Applied: 50}); result != want {
+		t.Errorf("want %+v, got %+v", want, result)
+	}
+
+	want := map[string]int64{"link": int64(len(h.messages))}
+	if got := karma(t, db, "link"); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestRunUnwatchedChannel(t *testing.T) {
+	b, db, _ := newBot(t)
+
+	err := db.PutConfig(context.Background(), popple.ServerConfig{ServerID: "123", ChannelFilter: popple.ChannelFilterAllow, FilteredChannels: []string{"789"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &history{messages: []discord.HistoricalMessage{message(0, "link++")}}
+	result, err := backfill.Run(context.Background(), h, b, backfill.Request{GuildID: "123", ChannelID: "456", Since: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (backfill.Result{Messages: 1}); result != want {
+		t.Errorf("want %+v, got %+v", want, result)
+	}
+}
+
+func TestRunSeenLive(t *testing.T) {
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	h := &history{messages: []discord.HistoricalMessage{message(0, "link++"), message(1, "link++")}}
+
+	// The bot saw the first message when it was sent.
+	live := h.messages[0].Message
+	live.GuildID = "123"
+	session := discordtest.NewResponseRecorder([]chat.Message{live})
+	b := bot.New(session, db, command.NewRouter("@popple"), nil)
+	if err := b.Listen(context.Background()); !errors.Is(err, bot.ErrSessionClosed) {
+		t.Fatalf("want %v, got %v", bot.ErrSessionClosed, err)
+	}
+
+	result, err := backfill.Run(context.Background(), h, b, backfill.Request{GuildID: "123", ChannelID: "456", Since: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (backfill.Result{Messages: 2, Applied: 1}); result != want {
+		t.Errorf("want %+v, got %+v", want, result)
+	}
+
+	want := map[string]int64{"link": 2}
+	if got := karma(t, db, "link"); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
@@ -3,75 +3,279 @@ package bot
 import (
 	"context"
 	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
 
+	"github.com/connorkuehl/popple/internal/chat"
 	"github.com/connorkuehl/popple/internal/command"
-	"github.com/connorkuehl/popple/internal/discord"
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/logging"
 	"github.com/connorkuehl/popple/internal/popple"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer records spans with the global tracer provider, which the tracing
+// package points at a collector when tracing is enabled.
+var tracer = otel.Tracer("github.com/connorkuehl/popple/internal/bot")
+
+// ErrSessionClosed is returned by Listen when the session stops delivering
+// messages.
+var ErrSessionClosed = errors.New("chat message stream closed")
+
 type Session interface {
-	SendMessageToChannel(channelID string, msg string) error
-	ReactToMessageWithEmoji(channelID, messageID, emojiID string) error
-	Messages() <-chan discord.Message
+	SendMessageToChannel(ctx context.Context, channelID string, msg string) error
+	ReplyToMessage(ctx context.Context, channelID, messageID string, msg string) error
+	SendEmbedToChannel(ctx context.Context, channelID string, embed chat.Embed) error
+	ReactToMessageWithEmoji(ctx context.Context, channelID, messageID, emojiID string) error
+	// SendFileToAdmin sends file privately to the author of messageID if
+	// they administer the server, and fails with chat.ErrNotAdmin
+	// otherwise.
+	SendFileToAdmin(ctx context.Context, channelID, messageID string, file chat.File) error
+	// IsAdmin reports whether the author of messageID administers the
+	// server. It fails with chat.ErrUnsupported if the chat service
+	// can't tell.
+	IsAdmin(ctx context.Context, channelID, messageID string) (bool, error)
+	Messages() <-chan chat.Message
 }
 
 type DB interface {
 	Config(ctx context.Context, serverID string) (popple.ServerConfig, error)
 	PutConfig(context.Context, popple.ServerConfig) error
 	Entities(ctx context.Context, serverID string, names ...string) ([]popple.Entity, error)
-	PutEntities(ctx context.Context, serverID string, entities ...popple.Entity) error
 	Leaderboard(ctx context.Context, serverID string, limit uint) (popple.Board, error)
 	Loserboard(ctx context.Context, serverID string, limit uint) (popple.Board, error)
+	ChannelEntities(ctx context.Context, serverID, channelID string, names ...string) ([]popple.Entity, error)
+	ChannelLeaderboard(ctx context.Context, serverID, channelID string, limit uint) (popple.Board, error)
+	ChannelLoserboard(ctx context.Context, serverID, channelID string, limit uint) (popple.Board, error)
+	// ApplyKarma adds the event's increments to everyone's karma and
+	// records the change at once, and returns everyone's new karma. The
+	// levels are nil if the event's message was already applied.
+	ApplyKarma(ctx context.Context, serverID string, event popple.KarmaEvent) (popple.Increments, error)
+	ServerEntities(ctx context.Context, serverID string) ([]database.EntityRecord, error)
+	ServerKarmaEvents(ctx context.Context, serverID string) ([]database.EventRecord, error)
 }
 
+// FailureCounter counts the errors each handler runs into.
+type FailureCounter interface {
+	HandlerFailed(handler string)
+}
+
+type noFailureCounter struct{}
+
+func (noFailureCounter) HandlerFailed(string) {}
+
 type CommandRouter interface {
 	Route(s string) (args command.ArgParser, remainder string)
 }
 
+const (
+	// Workers is how many messages are handled at once. Messages from the
+	// same guild are always handled by the same worker, in the order they
+	// arrived.
+	Workers = 8
+	// QueueSize is how many messages can wait for each worker. Once a
+	// worker's queue is full, Listen stops taking messages until there's
+	// room.
+	QueueSize = 64
+	// DrainTimeout is how long Listen gives queued messages to be handled
+	// once it's asked to stop.
+	DrainTimeout = 10 * time.Second
+)
+
 type Bot struct {
 	discord Session
 	db      DB
 	router  CommandRouter
+	log     *logging.Logger
+	digest  *digest
+	// failures counts the errors handlers run into.
+	failures FailureCounter
 }
 
-func New(discord Session, db DB, router CommandRouter) *Bot {
-	return &Bot{
-		discord: discord,
-		db:      db,
-		router:  router,
+// New returns a bot that logs with logger, or with the standard logger if
+// logger is nil.
+func New(discord Session, db DB, router CommandRouter, logger *logging.Logger) *Bot {
+	if logger == nil {
+		logger = logging.Standard()
+	}
+
+	b := &Bot{
+		discord:  discord,
+		db:       db,
+		router:   router,
+		log:      logger,
+		failures: noFailureCounter{},
 	}
+	b.digest = newDigest(b.sendDigest)
+	return b
+}
+
+// CountFailures reports the errors handlers run into to c. It must be
+// called before the bot starts handling messages.
+func (b *Bot) CountFailures(c FailureCounter) {
+	b.failures = c
 }
 
+// fail logs that handler ran into err and counts it.
+func (b *Bot) fail(ll *log.Entry, handler string, err error, msg string) {
+	ll.WithError(err).Error(msg)
+	b.failures.HandlerFailed(handler)
+}
+
+// Listen handles messages until ctx is canceled or the session closes.
+// Messages are spread across workers by guild. Once Listen is asked to
+// stop, it stops taking messages and waits up to DrainTimeout for the ones
+// already queued to be handled.
 func (b *Bot) Listen(ctx context.Context) error {
 	messages := b.discord.Messages()
 
+	// Don't leave batched announcements behind when we stop listening.
+	defer b.digest.flushAll()
+
+	// Handlers get their own context so that queued messages can still be
+	// handled after ctx is canceled.
+	work, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+
+	var wg sync.WaitGroup
+	shards := make([]chan chat.Message, Workers)
+	for i := range shards {
+		shards[i] = make(chan chat.Message, QueueSize)
+
+		wg.Add(1)
+		go func(queue <-chan chat.Message) {
+			defer wg.Done()
+			for msg := range queue {
+				b.handle(work, msg)
+			}
+		}(shards[i])
+	}
+
+	leftover, err := b.dispatch(ctx, messages, shards)
+
+	for _, shard := range shards {
+		close(shard)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		// The leftover message came after everything queued for its
+		// guild, so it's handled once the workers are done.
+		if leftover != nil {
+			b.handle(work, *leftover)
+		}
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(DrainTimeout):
+		b.log.Warn("gave up waiting for queued messages to be handled")
+		cancelWork()
+		<-drained
+	}
+
+	return err
+}
+
+// Flush sends batched announcements right away. Listen flushes when it
+// returns, but karma changed through ChangeKarma afterwards waits for
+// Flush.
+func (b *Bot) Flush() {
+	b.digest.flushAll()
+}
+
+// dispatch queues messages for the worker responsible for their guild
+// until ctx is canceled or the session closes. If ctx is canceled while a
+// message is waiting for room in its queue, that message is returned so
+// that it can be handled with the rest.
+func (b *Bot) dispatch(ctx context.Context, messages <-chan chat.Message, shards []chan chat.Message) (*chat.Message, error) {
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		case msg, ok := <-messages:
 			if !ok {
-				return errors.New("discord message stream closed")
+				return nil, ErrSessionClosed
 			}
 
-			cmd, remainder := b.router.Route(msg.Content)
+			// A message that has been taken from the session is never
+			// dropped. If the queue is full, this waits for the worker to
+			// catch up, which holds back the session.
+			select {
+			case shards[shard(msg.GuildID, len(shards))] <- msg:
+			case <-ctx.Done():
+				return &msg, ctx.Err()
+			}
+		}
+	}
+}
 
-			switch c := cmd.(type) {
-			case *command.SetAnnounceArgs:
-				b.handleSetAnnounce(ctx, c, msg.GuildID, msg.ChannelID, msg.ID, remainder)
+func shard(guildID string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(guildID))
+	return int(h.Sum32() % uint32(n))
+}
 
-			case *command.ChangeKarmaArgs:
-				b.handleChangeKarma(ctx, c, msg.GuildID, msg.ChannelID, remainder)
+// handle handles msg. Everything logged while handling it, including by
+// the session and database, shares a correlation ID, and everything traced
+// is part of the message's span.
+func (b *Bot) handle(ctx context.Context, msg chat.Message) {
+	ctx = b.log.WithCorrelationID(ctx)
 
-			case *command.CheckKarmaArgs:
-				b.handleCheckKarma(ctx, c, msg.GuildID, msg.ChannelID, remainder)
+	ctx, span := tracer.Start(ctx, "handle message", trace.WithAttributes(
+		attribute.String("popple.guild_id", msg.GuildID),
+		attribute.String("popple.channel_id", msg.ChannelID),
+		attribute.String("popple.correlation_id", logging.CorrelationID(ctx)),
+	))
+	defer span.End()
 
-			case *command.LeaderboardArgs:
-				b.handleLeaderboard(ctx, c, msg.GuildID, msg.ChannelID, remainder)
+	_, route := tracer.Start(ctx, "route")
+	cmd, remainder := b.router.Route(msg.Content)
+	route.End()
 
-			case *command.LoserboardArgs:
-				b.handleLoserboard(ctx, c, msg.GuildID, msg.ChannelID, remainder)
-			}
-		}
+	if cmd != nil {
+		span.SetAttributes(attribute.String("popple.command", command.Name(cmd)))
+	}
+
+	switch c := cmd.(type) {
+	case *command.SetAnnounceArgs:
+		b.handleSetAnnounce(ctx, c, msg.GuildID, msg.ChannelID, msg.ID, remainder)
+
+	case *command.ChangeKarmaArgs:
+		b.handleChangeKarma(ctx, c, msg.GuildID, msg.ChannelID, msg.ID, remainder)
+
+	case *command.CheckKarmaArgs:
+		b.handleCheckKarma(ctx, c, msg.GuildID, msg.ChannelID, remainder)
+
+	case *command.LeaderboardArgs:
+		b.handleLeaderboard(ctx, c, msg.GuildID, msg.ChannelID, remainder)
+
+	case *command.LoserboardArgs:
+		b.handleLoserboard(ctx, c, msg.GuildID, msg.ChannelID, remainder)
+
+	case *command.ChannelsArgs:
+		b.handleChannels(ctx, c, msg.GuildID, msg.ChannelID, msg.ID, remainder)
+
+	case *command.PoolArgs:
+		b.handlePool(ctx, c, msg.GuildID, msg.ChannelID, msg.ID, remainder)
+
+	case *command.TemplateArgs:
+		b.handleTemplate(ctx, c, msg.GuildID, msg.ChannelID, msg.ID, remainder)
+
+	case *command.LanguageArgs:
+		b.handleLanguage(ctx, c, msg.GuildID, msg.ChannelID, msg.ID, remainder)
+
+	case *command.EmbedsArgs:
+		b.handleEmbeds(ctx, c, msg.GuildID, msg.ChannelID, msg.ID, remainder)
+
+	case *command.ExportArgs:
+		b.handleExport(ctx, c, msg.GuildID, msg.ChannelID, msg.ID, remainder)
 	}
 }
@@ -1,155 +1,302 @@
 package bot
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
-	"text/template"
+	"time"
 
+	"github.com/connorkuehl/popple/internal/chat"
 	"github.com/connorkuehl/popple/internal/command"
 	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/export"
+	"github.com/connorkuehl/popple/internal/i18n"
+	"github.com/connorkuehl/popple/internal/logging"
 	"github.com/connorkuehl/popple/internal/popple"
 
 	log "github.com/sirupsen/logrus"
-)
-
-var (
-	templateLevels = template.Must(template.New("levels").Parse(`{{ range $name, $karma := . }}{{ $name }} has {{ $karma }} karma. {{ end }}`))
-	templateBoard  = template.Must(template.New("board").Parse(
-		`{{ range $entry := . }}* {{ $entry.Who }} has {{ $entry.Karma }} karma.
-{{ end }}`))
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func (b *Bot) handleSetAnnounce(ctx context.Context, args *command.SetAnnounceArgs, guildID, channelID, messageID, content string) {
-	ll := log.WithFields(log.Fields{
+	const handler = "set_announce"
+
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
 		"guild_id":   guildID,
 		"channel_id": channelID,
 		"message_id": messageID,
-		"content":    content,
-		"handler":    "set_announce",
+		"content":    b.log.Content(content),
+		"handler":    handler,
 	})
 
 	err := args.ParseArg(content)
 	switch {
 	case errors.Is(err, command.ErrInvalidArgument), errors.Is(err, command.ErrMissingArgument):
-		if err := b.discord.SendMessageToChannel(channelID, `Valid announce settings are "yes", "on", "no", "off"`); err != nil {
-			ll.WithError(err).Error("send message to channel")
+		if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(b.locale(ctx, guildID), i18n.AnnounceUsage)); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
 		}
 		return
 	}
 	if err != nil {
-		ll.WithError(err).Error("unexpected error from arg parser")
+		b.fail(ll, handler, err, "unexpected error from arg parser")
 	}
 
-	config := popple.ServerConfig{
-		ServerID:   guildID,
-		NoAnnounce: args.NoAnnounce,
+	config, err := b.config(ctx, guildID)
+	if err != nil {
+		b.fail(ll, handler, err, "Config")
+		return
 	}
 
+	if !b.isAdmin(ctx, ll, handler, config, channelID, messageID) {
+		return
+	}
+
+	config.Announce = args.Mode
+	config.DigestInterval = args.DigestInterval
+
 	if err := b.db.PutConfig(ctx, config); err != nil {
-		ll.WithError(err).Error("PutConfig")
+		b.fail(ll, handler, err, "PutConfig")
 		return
 	}
 
-	if err := b.discord.ReactToMessageWithEmoji(channelID, messageID, "✅"); err != nil {
-		ll.WithError(err).Error("react to message in channel")
+	if err := b.discord.ReactToMessageWithEmoji(ctx, channelID, messageID, "✅"); err != nil {
+		b.fail(ll, handler, err, "react to message in channel")
 		return
 	}
 }
 
-func (b *Bot) handleChangeKarma(ctx context.Context, args *command.ChangeKarmaArgs, guildID, channelID, content string) {
-	ll := log.WithFields(log.Fields{
+func (b *Bot) handleChangeKarma(ctx context.Context, args *command.ChangeKarmaArgs, guildID, channelID, messageID, content string) {
+	const handler = "change_karma"
+
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
 		"guild_id":   guildID,
 		"channel_id": channelID,
-		"content":    content,
-		"handler":    "change_karma",
+		"message_id": messageID,
+		"content":    b.log.Content(content),
+		"handler":    handler,
 	})
 
 	_ = args.ParseArg(content)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("popple.subjects", len(args.Increments)))
 	if len(args.Increments) == 0 {
 		return
 	}
 
-	var who []string
-	for name := range args.Increments {
-		who = append(who, name)
+	config, err := b.config(ctx, guildID)
+	if err != nil {
+		b.fail(ll, handler, err, "Config")
+		return
 	}
 
-	ents, err := b.db.Entities(ctx, guildID, who...)
-	if err != nil {
-		ll.WithError(err).Error("Entities")
+	if !config.Watches(channelID) {
 		return
 	}
 
-	levels := make(popple.Increments)
-	for name, incr := range args.Increments {
-		levels[name] = incr
+	// The message is recorded along with its karma so that backfilling
+	// it later doesn't count it again.
+	event := popple.KarmaEvent{ChannelID: channelID, Increments: args.Increments, MessageID: messageID}
+	levels, err := b.changeKarma(ctx, ll, handler, config, event)
+	if err != nil || levels == nil {
+		return
 	}
 
-	for _, ent := range ents {
-		levels[ent.Name] += ent.Karma
+	b.announce(ctx, ll, handler, config, channelID, messageID, args.Increments, levels)
+}
+
+// ErrNotWatched is returned when karma is changed in a channel that the
+// server has told the bot not to watch.
+var ErrNotWatched = errors.New("channel is not watched")
+
+// ChangeKarma applies a karma change that didn't come from a chat message,
+// e.g., from the HTTP API, and returns the new karma levels. If the event
+// has a channel, it's treated like a message in that channel: the
+// server's channel filters and pools apply and the new levels are
+// announced there. Since there's no message to react or reply to, those
+// announcements are made as messages.
+func (b *Bot) ChangeKarma(ctx context.Context, guildID string, event popple.KarmaEvent) (popple.Increments, error) {
+	const handler = "api_change_karma"
+
+	ctx = b.log.WithCorrelationID(ctx)
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
+		"guild_id":   guildID,
+		"channel_id": event.ChannelID,
+		"actor":      event.Actor,
+		"reason":     b.log.Content(event.Reason),
+		"handler":    handler,
+	})
+
+	config, err := b.config(ctx, guildID)
+	if err != nil {
+		b.fail(ll, handler, err, "Config")
+		return nil, err
 	}
 
-	ents = nil
-	for name, karma := range levels {
-		entity := popple.Entity{Name: name, Karma: karma}
-		ents = append(ents, entity)
+	if len(event.ChannelID) > 0 && !config.Watches(event.ChannelID) {
+		return nil, ErrNotWatched
 	}
 
-	if err := b.db.PutEntities(ctx, guildID, ents...); err != nil {
-		ll.WithError(err).Error("PutEntities")
-		return
+	levels, err := b.changeKarma(ctx, ll, handler, config, event)
+	if err != nil {
+		return nil, err
 	}
 
-	config, err := b.db.Config(ctx, guildID)
-	if errors.Is(err, database.ErrNotFound) {
-		err = nil
+	if len(event.ChannelID) > 0 {
+		b.announce(ctx, ll, handler, config, event.ChannelID, "", event.Increments, levels)
 	}
+	return levels, nil
+}
+
+// Backfill applies the karma changes in a message the bot didn't see when
+// it was sent, without announcing them. The changes are recorded as
+// happening at the time the message was sent. A message is only ever
+// backfilled once, so it's safe to backfill the same history again. It
+// reports whether the message changed anyone's karma.
+func (b *Bot) Backfill(ctx context.Context, msg chat.Message, at time.Time) (bool, error) {
+	const handler = "backfill"
+
+	ctx = b.log.WithCorrelationID(ctx)
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
+		"guild_id":   msg.GuildID,
+		"channel_id": msg.ChannelID,
+		"message_id": msg.ID,
+		"handler":    handler,
+	})
+
+	cmd, remainder := b.router.Route(msg.Content)
+	args, ok := cmd.(*command.ChangeKarmaArgs)
+	if !ok {
+		return false, nil
+	}
+
+	_ = args.ParseArg(remainder)
+	if len(args.Increments) == 0 {
+		return false, nil
+	}
+
+	config, err := b.config(ctx, msg.GuildID)
 	if err != nil {
-		ll.WithError(err).Error("Config")
-		return
+		b.fail(ll, handler, err, "Config")
+		return false, err
 	}
 
-	if config.NoAnnounce {
-		return
+	if !config.Watches(msg.ChannelID) {
+		return false, nil
 	}
 
-	for _, ent := range ents {
-		levels[ent.Name] = ent.Karma
+	// The message is claimed in the same transaction that applies its
+	// karma, so it's either both claimed and applied or neither.
+	event := popple.KarmaEvent{ChannelID: msg.ChannelID, Increments: args.Increments, Actor: "backfill", At: at, MessageID: msg.ID}
+	levels, err := b.changeKarma(ctx, ll, handler, config, event)
+	if err != nil {
+		return false, err
 	}
+	return levels != nil, nil
+}
 
-	var rsp strings.Builder
-	err = templateLevels.Execute(&rsp, levels)
+// changeKarma applies the event's increments and returns the new karma
+// levels of everyone in it. If the event's message was already applied,
+// nothing changes and the levels are nil.
+func (b *Bot) changeKarma(ctx context.Context, ll *log.Entry, handler string, config popple.ServerConfig, event popple.KarmaEvent) (popple.Increments, error) {
+	event.Pooled = config.Pools(event.ChannelID)
+	levels, err := b.db.ApplyKarma(ctx, config.ServerID, event)
 	if err != nil {
-		ll.WithError(err).Error("apply levels template")
+		b.fail(ll, handler, err, "ApplyKarma")
+		return nil, err
+	}
+
+	return levels, nil
+}
+
+// announce tells channelID about the new karma levels the way the server
+// has asked to be told. messageID is the message that changed the karma,
+// if there was one.
+func (b *Bot) announce(ctx context.Context, ll *log.Entry, handler string, config popple.ServerConfig, channelID, messageID string, increments, levels popple.Increments) {
+	announce := config.Announce
+	if len(messageID) == 0 && (announce == popple.AnnounceReact || announce == popple.AnnounceReply) {
+		announce = popple.AnnounceMessage
+	}
+
+	switch announce {
+	case popple.AnnounceOff:
+		return
+
+	case popple.AnnounceReact:
+		var up, down bool
+		for _, incr := range increments {
+			up = up || incr > 0
+			down = down || incr < 0
+		}
+
+		if up {
+			if err := b.discord.ReactToMessageWithEmoji(ctx, channelID, messageID, "▲"); err != nil {
+				b.fail(ll, handler, err, "react to message in channel")
+				return
+			}
+		}
+		if down {
+			if err := b.discord.ReactToMessageWithEmoji(ctx, channelID, messageID, "▼"); err != nil {
+				b.fail(ll, handler, err, "react to message in channel")
+				return
+			}
+		}
+		return
+
+	case popple.AnnounceDigest:
+		interval := config.DigestInterval
+		if interval <= 0 {
+			interval = command.DefaultDigestInterval
+		}
+		b.digest.add(config.ServerID, channelID, levels, interval)
 		return
 	}
 
-	err = b.discord.SendMessageToChannel(channelID, strings.TrimSpace(rsp.String()))
+	rsp, err := render(ctx, config, responseLevels, levels)
 	if err != nil {
-		ll.WithError(err).Error("send message to channel")
+		b.fail(ll, handler, err, "apply levels template")
 		return
 	}
 
+	if announce == popple.AnnounceReply {
+		err = b.discord.ReplyToMessage(ctx, channelID, messageID, rsp)
+	} else {
+		err = b.discord.SendMessageToChannel(ctx, channelID, rsp)
+	}
+	if err != nil {
+		b.fail(ll, handler, err, "send message to channel")
+		return
+	}
 }
 
 func (b *Bot) handleCheckKarma(ctx context.Context, args *command.CheckKarmaArgs, guildID, channelID, content string) {
-	ll := log.WithFields(log.Fields{
+	const handler = "check_karma"
+
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
 		"guild_id":   guildID,
 		"channel_id": channelID,
-		"content":    content,
-		"handler":    "check_karma",
+		"content":    b.log.Content(content),
+		"handler":    handler,
 	})
 
 	_ = args.ParseArg(content)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("popple.subjects", len(args.Who)))
 
 	if len(args.Who) == 0 {
 		return
 	}
 
-	ents, err := b.db.Entities(ctx, guildID, args.Who...)
+	config, err := b.config(ctx, guildID)
 	if err != nil {
-		ll.WithError(err).Error("Entities")
+		b.fail(ll, handler, err, "Config")
+		return
+	}
+
+	ents, err := b.entities(ctx, config, channelID, args.Who...)
+	if err != nil {
+		b.fail(ll, handler, err, "Entities")
 		return
 	}
 
@@ -164,72 +311,85 @@ func (b *Bot) handleCheckKarma(ctx context.Context, args *command.CheckKarmaArgs
 		levels[ent.Name] = ent.Karma
 	}
 
-	var rsp strings.Builder
-	err = templateLevels.Execute(&rsp, levels)
+	if config.Embeds {
+		err = b.discord.SendEmbedToChannel(ctx, channelID, levelsEmbed(config, levels))
+		if err != nil {
+			b.fail(ll, handler, err, "send embed to channel")
+		}
+		return
+	}
+
+	rsp, err := render(ctx, config, responseLevels, levels)
 	if err != nil {
-		ll.WithError(err).Error("apply levels template")
+		b.fail(ll, handler, err, "apply levels template")
 		return
 	}
 
-	err = b.discord.SendMessageToChannel(channelID, strings.TrimSpace(rsp.String()))
+	err = b.discord.SendMessageToChannel(ctx, channelID, rsp)
 	if err != nil {
-		ll.WithError(err).Error("send message to channel")
+		b.fail(ll, handler, err, "send message to channel")
 		return
 	}
 }
 
 func (b *Bot) handleLeaderboard(ctx context.Context, args *command.LeaderboardArgs, guildID, channelID, content string) {
-	ll := log.WithFields(log.Fields{
+	const handler = "leaderboard"
+
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
 		"guild_id":   guildID,
 		"channel_id": channelID,
-		"content":    content,
-		"handler":    "leaderboard",
+		"content":    b.log.Content(content),
+		"handler":    handler,
 	})
 
 	err := args.ParseArg(content)
 	if errors.Is(err, command.ErrInvalidArgument) {
-		if err := b.discord.SendMessageToChannel(channelID, `Board size must be a positive, non-zero number`); err != nil {
-			ll.WithError(err).Error("send message to channel")
+		if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(b.locale(ctx, guildID), i18n.BoardUsage)); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
 		}
 		return
 	}
 	if err != nil {
-		ll.WithError(err).Error("unexpected error from arg parser")
+		b.fail(ll, handler, err, "unexpected error from arg parser")
 		return
 	}
 
-	b.handleBoard(ctx, guildID, channelID, content, args.Order, args.Limit)
+	b.handleBoard(ctx, guildID, channelID, content, args.Order, args.Limit, args.Here)
 }
 
 func (b *Bot) handleLoserboard(ctx context.Context, args *command.LoserboardArgs, guildID, channelID, content string) {
-	ll := log.WithFields(log.Fields{
+	const handler = "loserboard"
+
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
 		"guild_id":   guildID,
 		"channel_id": channelID,
-		"content":    content,
-		"handler":    "loserboard",
+		"content":    b.log.Content(content),
+		"handler":    handler,
 	})
 
 	err := args.ParseArg(content)
 	if errors.Is(err, command.ErrInvalidArgument) {
-		if err := b.discord.SendMessageToChannel(channelID, `Board size must be a positive, non-zero number`); err != nil {
-			ll.WithError(err).Error("send message to channel")
+		if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(b.locale(ctx, guildID), i18n.BoardUsage)); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
 		}
 		return
 	}
 	if err != nil {
-		ll.WithError(err).Error("unexpected error from arg parser")
+		b.fail(ll, handler, err, "unexpected error from arg parser")
 		return
 	}
 
-	b.handleBoard(ctx, guildID, channelID, content, args.Order, args.Limit)
+	b.handleBoard(ctx, guildID, channelID, content, args.Order, args.Limit, args.Here)
 }
 
-func (b *Bot) handleBoard(ctx context.Context, guildID, channelID, content string, ord popple.BoardOrder, limit uint) {
-	ll := log.WithFields(log.Fields{
+func (b *Bot) handleBoard(ctx context.Context, guildID, channelID, content string, ord popple.BoardOrder, limit uint, here bool) {
+	const handler = "board"
+
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
 		"guild_id":   guildID,
 		"channel_id": channelID,
-		"content":    content,
-		"handler":    "board",
+		"content":    b.log.Content(content),
+		"handler":    handler,
 	})
 
 	boardFunc := b.db.Leaderboard
@@ -237,29 +397,510 @@ func (b *Bot) handleBoard(ctx context.Context, guildID, channelID, content strin
 		boardFunc = b.db.Loserboard
 	}
 
+	if here {
+		boardFunc = func(ctx context.Context, serverID string, limit uint) (popple.Board, error) {
+			if ord == popple.BoardOrderAsc {
+				return b.db.ChannelLoserboard(ctx, serverID, channelID, limit)
+			}
+			return b.db.ChannelLeaderboard(ctx, serverID, channelID, limit)
+		}
+	}
+
 	board, err := boardFunc(ctx, guildID, limit)
 	if err != nil {
-		ll.WithError(err).Error("board")
+		b.fail(ll, handler, err, "board")
+		return
+	}
+
+	config, err := b.config(ctx, guildID)
+	if err != nil {
+		b.fail(ll, handler, err, "Config")
 		return
 	}
 
 	if len(board) == 0 {
-		if err := b.discord.SendMessageToChannel(channelID, `No one has any karma yet.`); err != nil {
-			ll.WithError(err).Error("failed to send message to Discord channel")
+		r, err := render(ctx, config, responseEmptyBoard, nil)
+		if err != nil {
+			b.fail(ll, handler, err, "failed to apply empty board template")
+			return
+		}
+		if err := b.discord.SendMessageToChannel(ctx, channelID, r); err != nil {
+			b.fail(ll, handler, err, "failed to send message to Discord channel")
+		}
+		return
+	}
+
+	if config.Embeds {
+		err = b.discord.SendEmbedToChannel(ctx, channelID, boardEmbed(config, ord, board))
+		if err != nil {
+			b.fail(ll, handler, err, "failed to send embed to Discord channel")
+		}
+		return
+	}
+
+	r, err := render(ctx, config, responseBoard, board)
+	if err != nil {
+		b.fail(ll, handler, err, "failed to apply board template")
+		return
+	}
+
+	err = b.discord.SendMessageToChannel(ctx, channelID, r)
+	if err != nil {
+		b.fail(ll, handler, err, "failed to send message to Discord channel")
+		return
+	}
+}
+
+func (b *Bot) handleChannels(ctx context.Context, args *command.ChannelsArgs, guildID, channelID, messageID, content string) {
+	const handler = "channels"
+
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
+		"guild_id":   guildID,
+		"channel_id": channelID,
+		"message_id": messageID,
+		"content":    b.log.Content(content),
+		"handler":    handler,
+	})
+
+	err := args.ParseArg(content)
+	switch {
+	case errors.Is(err, command.ErrInvalidArgument), errors.Is(err, command.ErrMissingArgument):
+		if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(b.locale(ctx, guildID), i18n.ChannelsUsage)); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
+		}
+		return
+	}
+	if err != nil {
+		b.fail(ll, handler, err, "unexpected error from arg parser")
+		return
+	}
+
+	config, err := b.config(ctx, guildID)
+	if err != nil {
+		b.fail(ll, handler, err, "Config")
+		return
+	}
+
+	if !b.isAdmin(ctx, ll, handler, config, channelID, messageID) {
+		return
+	}
+
+	channels := args.Channels
+	if args.Here {
+		channels = append(channels, channelID)
+	}
+
+	config.ChannelFilter = args.Filter
+	config.FilteredChannels = channels
+
+	if err := b.db.PutConfig(ctx, config); err != nil {
+		b.fail(ll, handler, err, "PutConfig")
+		return
+	}
+
+	if err := b.discord.ReactToMessageWithEmoji(ctx, channelID, messageID, "✅"); err != nil {
+		b.fail(ll, handler, err, "react to message in channel")
+		return
+	}
+}
+
+func (b *Bot) handlePool(ctx context.Context, args *command.PoolArgs, guildID, channelID, messageID, content string) {
+	const handler = "pool"
+
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
+		"guild_id":   guildID,
+		"channel_id": channelID,
+		"message_id": messageID,
+		"content":    b.log.Content(content),
+		"handler":    handler,
+	})
+
+	err := args.ParseArg(content)
+	switch {
+	case errors.Is(err, command.ErrInvalidArgument), errors.Is(err, command.ErrMissingArgument):
+		if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(b.locale(ctx, guildID), i18n.PoolUsage)); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
+		}
+		return
+	}
+	if err != nil {
+		b.fail(ll, handler, err, "unexpected error from arg parser")
+		return
+	}
+
+	config, err := b.config(ctx, guildID)
+	if err != nil {
+		b.fail(ll, handler, err, "Config")
+		return
+	}
+
+	if !b.isAdmin(ctx, ll, handler, config, channelID, messageID) {
+		return
+	}
+
+	var pooled []string
+	for _, id := range config.PooledChannels {
+		if id != channelID {
+			pooled = append(pooled, id)
+		}
+	}
+	if args.Pooled {
+		pooled = append(pooled, channelID)
+	}
+	config.PooledChannels = pooled
+
+	if err := b.db.PutConfig(ctx, config); err != nil {
+		b.fail(ll, handler, err, "PutConfig")
+		return
+	}
+
+	if err := b.discord.ReactToMessageWithEmoji(ctx, channelID, messageID, "✅"); err != nil {
+		b.fail(ll, handler, err, "react to message in channel")
+		return
+	}
+}
+
+// isAdmin reports whether the author of messageID administers the server,
+// and tells them that only admins can change settings if they don't.
+func (b *Bot) isAdmin(ctx context.Context, ll *log.Entry, handler string, config popple.ServerConfig, channelID, messageID string) bool {
+	admin, err := b.discord.IsAdmin(ctx, channelID, messageID)
+	if err != nil {
+		b.fail(ll, handler, err, "IsAdmin")
+		return false
+	}
+
+	if !admin {
+		if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(config.Locale, i18n.SettingsDenied)); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
+		}
+		return false
+	}
+	return true
+}
+
+// config returns the server's configuration, or the defaults if the server
+// has never changed any settings.
+func (b *Bot) config(ctx context.Context, guildID string) (popple.ServerConfig, error) {
+	config, err := b.db.Config(ctx, guildID)
+	if errors.Is(err, database.ErrNotFound) {
+		return popple.ServerConfig{ServerID: guildID}, nil
+	}
+	return config, err
+}
+
+// locale returns the language the server wants responses in. Failing to
+// look it up isn't worth failing a response over, so it falls back to the
+// default language.
+func (b *Bot) locale(ctx context.Context, guildID string) string {
+	config, err := b.config(ctx, guildID)
+	if err != nil {
+		logging.FromContext(ctx).WithField("guild_id", guildID).WithError(err).Warn("falling back to default locale")
+		return i18n.Default
+	}
+	return config.Locale
+}
+
+// quoteAll quotes each of ss and joins them into a list.
+func quoteAll(ss []string) string {
+	var quoted []string
+	for _, s := range ss {
+		quoted = append(quoted, strconv.Quote(s))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// entities reads from the channel's karma pool if it has one, otherwise
+// from the server-wide pool.
+func (b *Bot) entities(ctx context.Context, config popple.ServerConfig, channelID string, names ...string) ([]popple.Entity, error) {
+	if config.Pools(channelID) {
+		return b.db.ChannelEntities(ctx, config.ServerID, channelID, names...)
+	}
+	return b.db.Entities(ctx, config.ServerID, names...)
+}
+
+// sendDigest announces a batch of karma levels that the digest collected
+// for channelID.
+func (b *Bot) sendDigest(guildID, channelID string, levels popple.Increments) {
+	const handler = "digest"
+
+	// The batch may outlive the context of the messages that filled it.
+	ctx := b.log.WithCorrelationID(context.Background())
+
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
+		"guild_id":   guildID,
+		"channel_id": channelID,
+		"handler":    handler,
+	})
+
+	config, err := b.config(ctx, guildID)
+	if err != nil {
+		b.fail(ll, handler, err, "Config")
+		return
+	}
+
+	rsp, err := render(ctx, config, responseLevels, levels)
+	if err != nil {
+		b.fail(ll, handler, err, "apply levels template")
+		return
+	}
+
+	err = b.discord.SendMessageToChannel(ctx, channelID, rsp)
+	if err != nil {
+		b.fail(ll, handler, err, "send message to channel")
+		return
+	}
+}
+
+func (b *Bot) handleTemplate(ctx context.Context, args *command.TemplateArgs, guildID, channelID, messageID, content string) {
+	const handler = "template"
+
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
+		"guild_id":   guildID,
+		"channel_id": channelID,
+		"message_id": messageID,
+		"content":    b.log.Content(content),
+		"handler":    handler,
+	})
+
+	err := args.ParseArg(content)
+	switch {
+	case errors.Is(err, command.ErrInvalidArgument), errors.Is(err, command.ErrMissingArgument):
+		if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(b.locale(ctx, guildID), i18n.TemplateUsage)); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
+		}
+		return
+	}
+	if err != nil {
+		b.fail(ll, handler, err, "unexpected error from arg parser")
+		return
+	}
+
+	if _, ok := templateSamples[args.Name]; !ok {
+		rsp := i18n.Text(b.locale(ctx, guildID), i18n.TemplateNames, quoteAll(templateNames()))
+		if err := b.discord.SendMessageToChannel(ctx, channelID, rsp); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
+		}
+		return
+	}
+
+	config, err := b.config(ctx, guildID)
+	if err != nil {
+		b.fail(ll, handler, err, "Config")
+		return
+	}
+
+	if !b.isAdmin(ctx, ll, handler, config, channelID, messageID) {
+		return
+	}
+
+	if args.Action == command.TemplateSet {
+		if err := validateTemplate(args.Name, config.Locale, args.Body); err != nil {
+			if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(config.Locale, i18n.TemplateBroken, err)); err != nil {
+				b.fail(ll, handler, err, "send message to channel")
+			}
+			return
+		}
+	}
+
+	templates := make(map[string]string)
+	for name, body := range config.Templates {
+		templates[name] = body
+	}
+	switch args.Action {
+	case command.TemplateSet:
+		templates[args.Name] = args.Body
+	case command.TemplateReset:
+		delete(templates, args.Name)
+	}
+	config.Templates = templates
+
+	if err := b.db.PutConfig(ctx, config); err != nil {
+		b.fail(ll, handler, err, "PutConfig")
+		return
+	}
+
+	if err := b.discord.ReactToMessageWithEmoji(ctx, channelID, messageID, "✅"); err != nil {
+		b.fail(ll, handler, err, "react to message in channel")
+		return
+	}
+}
+
+func (b *Bot) handleLanguage(ctx context.Context, args *command.LanguageArgs, guildID, channelID, messageID, content string) {
+	const handler = "language"
+
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
+		"guild_id":   guildID,
+		"channel_id": channelID,
+		"message_id": messageID,
+		"content":    b.log.Content(content),
+		"handler":    handler,
+	})
+
+	config, err := b.config(ctx, guildID)
+	if err != nil {
+		b.fail(ll, handler, err, "Config")
+		return
+	}
+
+	err = args.ParseArg(content)
+	if err == nil && !i18n.Supported(args.Code) {
+		err = command.ErrInvalidArgument
+	}
+	switch {
+	case errors.Is(err, command.ErrInvalidArgument), errors.Is(err, command.ErrMissingArgument):
+		if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(config.Locale, i18n.LanguageUsage, quoteAll(i18n.Languages()))); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
 		}
 		return
 	}
+	if err != nil {
+		b.fail(ll, handler, err, "unexpected error from arg parser")
+		return
+	}
+
+	if !b.isAdmin(ctx, ll, handler, config, channelID, messageID) {
+		return
+	}
+
+	config.Locale = args.Code
+
+	if err := b.db.PutConfig(ctx, config); err != nil {
+		b.fail(ll, handler, err, "PutConfig")
+		return
+	}
+
+	if err := b.discord.ReactToMessageWithEmoji(ctx, channelID, messageID, "✅"); err != nil {
+		b.fail(ll, handler, err, "react to message in channel")
+		return
+	}
+}
+
+func (b *Bot) handleEmbeds(ctx context.Context, args *command.EmbedsArgs, guildID, channelID, messageID, content string) {
+	const handler = "embeds"
+
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
+		"guild_id":   guildID,
+		"channel_id": channelID,
+		"message_id": messageID,
+		"content":    b.log.Content(content),
+		"handler":    handler,
+	})
+
+	err := args.ParseArg(content)
+	switch {
+	case errors.Is(err, command.ErrInvalidArgument), errors.Is(err, command.ErrMissingArgument):
+		if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(b.locale(ctx, guildID), i18n.EmbedsUsage)); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
+		}
+		return
+	}
+	if err != nil {
+		b.fail(ll, handler, err, "unexpected error from arg parser")
+		return
+	}
+
+	config, err := b.config(ctx, guildID)
+	if err != nil {
+		b.fail(ll, handler, err, "Config")
+		return
+	}
+
+	if !b.isAdmin(ctx, ll, handler, config, channelID, messageID) {
+		return
+	}
+
+	config.Embeds = args.On
+
+	if err := b.db.PutConfig(ctx, config); err != nil {
+		b.fail(ll, handler, err, "PutConfig")
+		return
+	}
+
+	if err := b.discord.ReactToMessageWithEmoji(ctx, channelID, messageID, "✅"); err != nil {
+		b.fail(ll, handler, err, "react to message in channel")
+		return
+	}
+}
+
+func (b *Bot) handleExport(ctx context.Context, args *command.ExportArgs, guildID, channelID, messageID, content string) {
+	const handler = "export"
+
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
+		"guild_id":   guildID,
+		"channel_id": channelID,
+		"message_id": messageID,
+		"content":    b.log.Content(content),
+		"handler":    handler,
+	})
 
-	var r strings.Builder
-	err = templateBoard.Execute(&r, board)
+	err := args.ParseArg(content)
+	switch {
+	case errors.Is(err, command.ErrInvalidArgument):
+		if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(b.locale(ctx, guildID), i18n.ExportUsage)); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
+		}
+		return
+	}
 	if err != nil {
-		ll.WithError(err).Error("failed to apply board template")
+		b.fail(ll, handler, err, "unexpected error from arg parser")
 		return
 	}
 
-	err = b.discord.SendMessageToChannel(channelID, strings.TrimSpace(r.String()))
+	// Only admins get the export, so don't do the work for anyone else.
+	admin, err := b.discord.IsAdmin(ctx, channelID, messageID)
+	switch {
+	case errors.Is(err, chat.ErrUnsupported):
+		if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(b.locale(ctx, guildID), i18n.ExportUnsupported)); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
+		}
+		return
+	case err != nil:
+		b.fail(ll, handler, err, "IsAdmin")
+		return
+	case !admin:
+		if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(b.locale(ctx, guildID), i18n.ExportDenied)); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
+		}
+		return
+	}
+
+	e, err := export.Dump(ctx, b.db, guildID)
 	if err != nil {
-		ll.WithError(err).Error("failed to send message to Discord channel")
+		b.fail(ll, handler, err, "Dump")
+		return
+	}
+
+	format := export.Format(args.Format)
+	var buf bytes.Buffer
+	if err := export.Write(&buf, e, format); err != nil {
+		b.fail(ll, handler, err, "Write")
+		return
+	}
+
+	file := chat.File{
+		Name: fmt.Sprintf("popple-%s-%s.%s", guildID, e.ExportedAt.Format("20060102"), format),
+		Data: buf.Bytes(),
+	}
+
+	err = b.discord.SendFileToAdmin(ctx, channelID, messageID, file)
+	switch {
+	case errors.Is(err, chat.ErrNotAdmin):
+		if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(b.locale(ctx, guildID), i18n.ExportDenied)); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
+		}
+		return
+	case errors.Is(err, chat.ErrUnsupported):
+		if err := b.discord.SendMessageToChannel(ctx, channelID, i18n.Text(b.locale(ctx, guildID), i18n.ExportUnsupported)); err != nil {
+			b.fail(ll, handler, err, "send message to channel")
+		}
+		return
+	case err != nil:
+		b.fail(ll, handler, err, "send file to admin")
+		return
+	}
+
+	if err := b.discord.ReactToMessageWithEmoji(ctx, channelID, messageID, "✅"); err != nil {
+		b.fail(ll, handler, err, "react to message in channel")
 		return
 	}
 }
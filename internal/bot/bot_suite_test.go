@@ -2,20 +2,28 @@ package bot_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/chat"
 	"github.com/connorkuehl/popple/internal/command"
+	"github.com/connorkuehl/popple/internal/database"
 	"github.com/connorkuehl/popple/internal/database/sqlite"
-	"github.com/connorkuehl/popple/internal/discord"
 	"github.com/connorkuehl/popple/internal/discord/discordtest"
+	"github.com/connorkuehl/popple/internal/i18n"
+	"github.com/connorkuehl/popple/internal/logging"
 	"github.com/connorkuehl/popple/internal/popple"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 )
 
 func TestBot(t *testing.T) {
@@ -47,25 +55,25 @@ var _ = Describe("Bot", func() {
 	When("the announce command is invoked", func() {
 		Context("and it is missing an argument", func() {
 			It("responds with an error", func(ctx SpecContext) {
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "1234", ChannelID: "9876", Content: fmt.Sprintf("%s announce", botName)},
 				})
 
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(ctx)
-				Expect(session.Responses).To(ConsistOf(discordtest.Response{Message: discordtest.Message{ChannelID: "9876", Content: `Valid announce settings are "yes", "on", "no", "off"`}}))
+				Expect(session.Responses).To(ConsistOf(discordtest.Response{Message: discordtest.Message{ChannelID: "9876", Content: `Valid announce settings are "message", "react", "reply", "digest" (optionally followed by seconds), "off"`}}))
 			})
 		})
 
 		Context("and it has an invalid argument", func() {
 			It("responds with an error", func(ctx SpecContext) {
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "1234", ChannelID: "9876", Content: fmt.Sprintf("%s announce potato", botName)},
 				})
 
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(ctx)
-				Expect(session.Responses).To(ConsistOf(discordtest.Response{Message: discordtest.Message{ChannelID: "9876", Content: `Valid announce settings are "yes", "on", "no", "off"`}}))
+				Expect(session.Responses).To(ConsistOf(discordtest.Response{Message: discordtest.Message{ChannelID: "9876", Content: `Valid announce settings are "message", "react", "reply", "digest" (optionally followed by seconds), "off"`}}))
 			})
 		})
 
@@ -74,11 +82,11 @@ var _ = Describe("Bot", func() {
 			var conf1234, conf5678 popple.ServerConfig
 
 			BeforeAll(func() {
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "2", GuildID: "1234", ChannelID: "1010", Content: fmt.Sprintf("%s announce yes", botName)},
 					{ID: "3", GuildID: "5678", ChannelID: "2020", Content: fmt.Sprintf("%s announce on", botName)},
 				})
-				b = bot.New(session, db, router)
+				b = bot.New(session, db, router, nil)
 				_ = b.Listen(context.Background())
 
 				var err error
@@ -96,9 +104,9 @@ var _ = Describe("Bot", func() {
 				}))
 			})
 
-			It("sets no_announce to false in the database", func(ctx SpecContext) {
-				Expect(conf1234.NoAnnounce).To(BeFalse())
-				Expect(conf5678.NoAnnounce).To(BeFalse())
+			It("sets announce to message in the database", func(ctx SpecContext) {
+				Expect(conf1234.Announce).To(Equal(popple.AnnounceMessage))
+				Expect(conf5678.Announce).To(Equal(popple.AnnounceMessage))
 			})
 		})
 
@@ -107,11 +115,11 @@ var _ = Describe("Bot", func() {
 			var conf1234, conf5678 popple.ServerConfig
 
 			BeforeAll(func() {
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "2", GuildID: "1234", ChannelID: "1010", Content: fmt.Sprintf("%s announce no", botName)},
 					{ID: "3", GuildID: "5678", ChannelID: "2020", Content: fmt.Sprintf("%s announce off", botName)},
 				})
-				b = bot.New(session, db, router)
+				b = bot.New(session, db, router, nil)
 				_ = b.Listen(context.Background())
 
 				var err error
@@ -129,9 +137,30 @@ var _ = Describe("Bot", func() {
 				}))
 			})
 
-			It("sets no_announce to true in the database", func(ctx SpecContext) {
-				Expect(conf1234.NoAnnounce).To(BeTrue())
-				Expect(conf5678.NoAnnounce).To(BeTrue())
+			It("sets announce to off in the database", func(ctx SpecContext) {
+				Expect(conf1234.Announce).To(Equal(popple.AnnounceOff))
+				Expect(conf5678.Announce).To(Equal(popple.AnnounceOff))
+			})
+		})
+
+		Context(`and its value is "digest" with an interval`, Ordered, func() {
+			var conf popple.ServerConfig
+
+			BeforeAll(func() {
+				session = discordtest.NewResponseRecorder([]chat.Message{
+					{ID: "2", GuildID: "1234", ChannelID: "1010", Content: fmt.Sprintf("%s announce digest 30", botName)},
+				})
+				b := bot.New(session, db, router, nil)
+				_ = b.Listen(context.Background())
+
+				var err error
+				conf, err = db.Config(context.Background(), "1234")
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("persists the mode and the interval", func() {
+				Expect(conf.Announce).To(Equal(popple.AnnounceDigest))
+				Expect(conf.DigestInterval).To(Equal(30 * time.Second))
 			})
 		})
 	})
@@ -141,10 +170,10 @@ var _ = Describe("Bot", func() {
 			var board popple.Board
 
 			BeforeAll(func() {
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: "hello+ world, hi"},
 				})
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(context.Background())
 			})
 
@@ -165,10 +194,10 @@ var _ = Describe("Bot", func() {
 			var saved []popple.Entity
 
 			BeforeAll(func() {
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: "popple++"},
 				})
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(context.Background())
 
 				var err error
@@ -196,10 +225,10 @@ var _ = Describe("Bot", func() {
 				err := db.PutEntities(context.Background(), "123", popple.Entity{Name: "panda", Karma: 12})
 				Expect(err).ToNot(HaveOccurred())
 
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: "beluga panda++ whales"},
 				})
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(context.Background())
 
 				saved, err = db.Entities(context.Background(), "123", "panda")
@@ -223,13 +252,13 @@ var _ = Describe("Bot", func() {
 			var saved []popple.Entity
 
 			BeforeAll(func() {
-				err := db.PutConfig(context.Background(), popple.ServerConfig{ServerID: "123", NoAnnounce: true})
+				err := db.PutConfig(context.Background(), popple.ServerConfig{ServerID: "123", Announce: popple.AnnounceOff})
 				Expect(err).ToNot(HaveOccurred())
 
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: "ganondorf--"},
 				})
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(context.Background())
 
 				saved, err = db.Entities(context.Background(), "123", "ganondorf")
@@ -247,13 +276,143 @@ var _ = Describe("Bot", func() {
 		})
 	})
 
+	When("karma is bumped and the server announces with reactions", Ordered, func() {
+		BeforeAll(func() {
+			err := db.PutConfig(context.Background(), popple.ServerConfig{ServerID: "123", Announce: popple.AnnounceReact})
+			Expect(err).ToNot(HaveOccurred())
+
+			session = discordtest.NewResponseRecorder([]chat.Message{
+				{ID: "1", GuildID: "123", ChannelID: "456", Content: "link++"},
+				{ID: "2", GuildID: "123", ChannelID: "456", Content: "zelda++ ganon--"},
+			})
+			b := bot.New(session, db, router, nil)
+			_ = b.Listen(context.Background())
+		})
+
+		It("reacts to the original message instead of posting", func() {
+			Expect(session.Responses).To(Equal([]discordtest.Response{
+				{Reaction: discordtest.Reaction{ChannelID: "456", MessageID: "1", Emoji: "▲"}},
+				{Reaction: discordtest.Reaction{ChannelID: "456", MessageID: "2", Emoji: "▲"}},
+				{Reaction: discordtest.Reaction{ChannelID: "456", MessageID: "2", Emoji: "▼"}},
+			}))
+		})
+	})
+
+	When("karma is bumped and the server announces with replies", Ordered, func() {
+		BeforeAll(func() {
+			err := db.PutConfig(context.Background(), popple.ServerConfig{ServerID: "123", Announce: popple.AnnounceReply})
+			Expect(err).ToNot(HaveOccurred())
+
+			session = discordtest.NewResponseRecorder([]chat.Message{
+				{ID: "1", GuildID: "123", ChannelID: "456", Content: "link++"},
+			})
+			b := bot.New(session, db, router, nil)
+			_ = b.Listen(context.Background())
+		})
+
+		It("replies to the original message", func() {
+			Expect(session.Responses).To(Equal([]discordtest.Response{
+				{Reply: discordtest.Reply{ChannelID: "456", MessageID: "1", Content: "link has 1 karma."}},
+			}))
+		})
+	})
+
+	When("karma is bumped and the server announces with digests", Ordered, func() {
+		BeforeAll(func() {
+			err := db.PutConfig(context.Background(), popple.ServerConfig{ServerID: "123", Announce: popple.AnnounceDigest, DigestInterval: time.Hour})
+			Expect(err).ToNot(HaveOccurred())
+
+			session = discordtest.NewResponseRecorder([]chat.Message{
+				{ID: "1", GuildID: "123", ChannelID: "456", Content: "link++"},
+				{ID: "2", GuildID: "123", ChannelID: "456", Content: "zelda++"},
+				{ID: "3", GuildID: "123", ChannelID: "456", Content: "link++"},
+				{ID: "4", GuildID: "123", ChannelID: "789", Content: "ganon--"},
+			})
+			b := bot.New(session, db, router, nil)
+			_ = b.Listen(context.Background())
+		})
+
+		It("batches the announcements into one message per channel", func() {
+			Expect(session.Responses).To(ConsistOf(
+				discordtest.Response{Message: discordtest.Message{ChannelID: "456", Content: "link has 2 karma. zelda has 1 karma."}},
+				discordtest.Response{Message: discordtest.Message{ChannelID: "789", Content: "ganon has -1 karma."}},
+			))
+		})
+	})
+
+	When("karma is changed outside of chat", func() {
+		var b *bot.Bot
+
+		BeforeEach(func() {
+			session = discordtest.NewResponseRecorder(nil)
+			b = bot.New(session, db, router, nil)
+		})
+
+		Context("and no channel is given", func() {
+			It("changes the karma without announcing it", func(ctx SpecContext) {
+				levels, err := b.ChangeKarma(ctx, "123", popple.KarmaEvent{Increments: popple.Increments{"link": 2}, Actor: "ci"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(levels).To(Equal(popple.Increments{"link": 2}))
+				Expect(session.Responses).To(BeEmpty())
+
+				entities, err := db.Entities(ctx, "123", "link")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entities).To(ConsistOf(popple.Entity{Name: "link", Karma: 2}))
+			})
+		})
+
+		Context("and the server announces with reactions", func() {
+			It("posts the announcement since there's nothing to react to", func(ctx SpecContext) {
+				err := db.PutConfig(ctx, popple.ServerConfig{ServerID: "123", Announce: popple.AnnounceReact})
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = b.ChangeKarma(ctx, "123", popple.KarmaEvent{ChannelID: "456", Increments: popple.Increments{"link": 1}, Actor: "ci"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.Responses).To(Equal([]discordtest.Response{
+					{Message: discordtest.Message{ChannelID: "456", Content: "link has 1 karma."}},
+				}))
+			})
+		})
+
+		Context("and the channel isn't watched", func() {
+			It("refuses the change", func(ctx SpecContext) {
+				err := db.PutConfig(ctx, popple.ServerConfig{ServerID: "123", ChannelFilter: popple.ChannelFilterAllow, FilteredChannels: []string{"789"}})
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = b.ChangeKarma(ctx, "123", popple.KarmaEvent{ChannelID: "456", Increments: popple.Increments{"link": 1}, Actor: "ci"})
+				Expect(err).To(MatchError(bot.ErrNotWatched))
+				Expect(session.Responses).To(BeEmpty())
+
+				entities, err := db.Entities(ctx, "123", "link")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entities).To(ConsistOf(popple.Entity{Name: "link", Karma: 0}))
+			})
+		})
+
+		Context("and the server announces with digests", func() {
+			It("holds the announcement until it's flushed", func(ctx SpecContext) {
+				err := db.PutConfig(ctx, popple.ServerConfig{ServerID: "123", Announce: popple.AnnounceDigest, DigestInterval: time.Hour})
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = b.ChangeKarma(ctx, "123", popple.KarmaEvent{ChannelID: "456", Increments: popple.Increments{"link": 1}, Actor: "ci"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.Responses).To(BeEmpty())
+
+				b.Flush()
+				Expect(session.Responses).To(Equal([]discordtest.Response{
+					{Message: discordtest.Message{ChannelID: "456", Content: "link has 1 karma."}},
+				}))
+			})
+		})
+	})
+
 	When("checking karma", func() {
 		Context("and no entity names are given", func() {
 			It("does not interact with the channel", func(ctx SpecContext) {
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " karma"},
 				})
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(ctx)
 
 				Expect(session.Responses).To(HaveLen(0))
@@ -262,10 +421,10 @@ var _ = Describe("Bot", func() {
 
 		Context("and given name is not persisted", func() {
 			It("says the entity has zero karma", func(ctx SpecContext) {
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " karma potatopirate"},
 				})
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(ctx)
 
 				Expect(session.Responses).To(ContainElement(discordtest.Response{Message: discordtest.Message{
@@ -279,10 +438,10 @@ var _ = Describe("Bot", func() {
 			It("emits the entity's karma count", func(ctx SpecContext) {
 				db.PutEntities(ctx, "123", popple.Entity{Name: "mned", Karma: 999})
 
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " karma mned"},
 				})
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(ctx)
 
 				Expect(session.Responses).To(ContainElement(discordtest.Response{Message: discordtest.Message{
@@ -296,13 +455,13 @@ var _ = Describe("Bot", func() {
 	When("the leaderboard command is invoked", func() {
 		Context("with an invalid argument", func() {
 			It("responds with an error message", func(ctx SpecContext) {
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " top -1"},
 					{ID: "2", GuildID: "123", ChannelID: "456", Content: botName + " top 0"},
 					{ID: "3", GuildID: "123", ChannelID: "456", Content: botName + " top asdf"},
 				})
 
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(context.Background())
 
 				Expect(session.Responses).To(Equal([]discordtest.Response{
@@ -331,10 +490,10 @@ var _ = Describe("Bot", func() {
 				err := db.PutEntities(ctx, "123", preexisting...)
 				Expect(err).ToNot(HaveOccurred())
 
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " top " + strconv.Itoa(limit)},
 				})
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(ctx)
 
 				Expect(session.Responses).To(HaveLen(1))
@@ -346,11 +505,11 @@ var _ = Describe("Bot", func() {
 
 		Context("for a server without any karma bumps", func() {
 			It("responds saying no one has bumped any karma", func(ctx SpecContext) {
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " top"},
 				})
 
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(ctx)
 
 				Expect(session.Responses).To(ContainElement(discordtest.Response{Message: discordtest.Message{
@@ -362,7 +521,7 @@ var _ = Describe("Bot", func() {
 
 		Context("for a server with karma bumps", func() {
 			It("responds with a list ordered from most karma to least karma", func(ctx SpecContext) {
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " top"},
 				})
 
@@ -373,7 +532,7 @@ var _ = Describe("Bot", func() {
 				}
 				Expect(db.PutEntities(ctx, "123", preexisting...)).ToNot(HaveOccurred())
 
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(ctx)
 
 				Expect(session.Responses).To(HaveLen(1))
@@ -391,13 +550,13 @@ var _ = Describe("Bot", func() {
 	When("the loserboard command is invoked", func() {
 		Context("with an invalid argument", func() {
 			It("responds with an error message", func(ctx SpecContext) {
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " bot -1"},
 					{ID: "2", GuildID: "123", ChannelID: "456", Content: botName + " bot 0"},
 					{ID: "3", GuildID: "123", ChannelID: "456", Content: botName + " bot asdf"},
 				})
 
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(context.Background())
 
 				Expect(session.Responses).To(Equal([]discordtest.Response{
@@ -426,10 +585,10 @@ var _ = Describe("Bot", func() {
 				err := db.PutEntities(ctx, "123", preexisting...)
 				Expect(err).ToNot(HaveOccurred())
 
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " bot " + strconv.Itoa(limit)},
 				})
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(ctx)
 
 				Expect(session.Responses).To(HaveLen(1))
@@ -441,11 +600,11 @@ var _ = Describe("Bot", func() {
 
 		Context("for a server without any karma bumps", func() {
 			It("responds saying no one has bumped any karma", func(ctx SpecContext) {
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " bot"},
 				})
 
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(ctx)
 
 				Expect(session.Responses).To(ContainElement(discordtest.Response{Message: discordtest.Message{
@@ -457,7 +616,7 @@ var _ = Describe("Bot", func() {
 
 		Context("for a server with karma bumps", func() {
 			It("responds with a list ordered from least karma to most karma", func(ctx SpecContext) {
-				session = discordtest.NewResponseRecorder([]discord.Message{
+				session = discordtest.NewResponseRecorder([]chat.Message{
 					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " bot"},
 				})
 
@@ -468,7 +627,7 @@ var _ = Describe("Bot", func() {
 				}
 				Expect(db.PutEntities(ctx, "123", preexisting...)).ToNot(HaveOccurred())
 
-				b := bot.New(session, db, router)
+				b := bot.New(session, db, router, nil)
 				_ = b.Listen(ctx)
 
 				Expect(session.Responses).To(HaveLen(1))
@@ -482,6 +641,448 @@ var _ = Describe("Bot", func() {
 			})
 		})
 	})
+
+	When("the template command is invoked", func() {
+		Context("with an unknown template name", func() {
+			It("responds with the valid names", func(ctx SpecContext) {
+				session = discordtest.NewResponseRecorder([]chat.Message{
+					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " template set potato {{ . }}"},
+				})
+				b := bot.New(session, db, router, nil)
+				_ = b.Listen(ctx)
+
+				Expect(session.Responses).To(ConsistOf(discordtest.Response{Message: discordtest.Message{
+					ChannelID: "456",
+					Content:   `Valid template names are "board", "empty_board", "levels"`,
+				}}))
+			})
+		})
+
+		Context("with a template that doesn't work with sample data", func() {
+			It("refuses to save the template", func(ctx SpecContext) {
+				session = discordtest.NewResponseRecorder([]chat.Message{
+					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " template set board {{ .Nope }}"},
+				})
+				b := bot.New(session, db, router, nil)
+				_ = b.Listen(ctx)
+
+				Expect(session.Responses).To(HaveLen(1))
+				Expect(session.Responses[0].Message.Content).To(HavePrefix("That template doesn't work: "))
+
+				_, err := db.Config(ctx, "123")
+				Expect(err).To(MatchError(database.ErrNotFound))
+			})
+		})
+
+		Context("with a template that could run away", func() {
+			It("refuses to save the template", func(ctx SpecContext) {
+				bodies := []string{
+					"`{{ define \"x\" }}hi{{ end }}{{ template \"x\" }}`",
+					"`{{ block \"x\" . }}hi{{ end }}`",
+					"`{{ range 1000000000 }}{{ end }}hi`",
+					"`{{ $n := 1000000000 }}{{ range $n }}{{ end }}hi`",
+					"`{{ with 1000000000 }}{{ range . }}{{ end }}{{ end }}hi`",
+					"`{{ range . }}{{ range $ }}{{ end }}{{ end }}hi`",
+					"`{{ range . }}" + strings.Repeat("x", 5000) + "{{ end }}`",
+				}
+				var messages []chat.Message
+				for i, body := range bodies {
+					messages = append(messages, chat.Message{ID: strconv.Itoa(i), GuildID: "123", ChannelID: "456", Content: botName + " template set board " + body})
+				}
+				session = discordtest.NewResponseRecorder(messages)
+				b := bot.New(session, db, router, nil)
+				_ = b.Listen(ctx)
+
+				Expect(session.Responses).To(HaveLen(len(bodies)))
+				for _, rsp := range session.Responses {
+					Expect(rsp.Message.Content).To(HavePrefix("That template doesn't work: "))
+				}
+
+				_, err := db.Config(ctx, "123")
+				Expect(err).To(MatchError(database.ErrNotFound))
+			})
+		})
+
+		Context("with a valid template", Ordered, func() {
+			BeforeAll(func() {
+				session = discordtest.NewResponseRecorder([]chat.Message{
+					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " template set levels `{{ range $who, $karma := . }}Arr, {{ $who }} be havin' {{ humanize $karma }} {{ pluralize $karma \"doubloon\" \"doubloons\" }}! {{ end }}`"},
+					{ID: "2", GuildID: "123", ChannelID: "456", Content: botName + " template set empty_board Nobody."},
+					{ID: "3", GuildID: "123", ChannelID: "456", Content: "(Captain Hook)++"},
+					{ID: "4", GuildID: "123", ChannelID: "456", Content: botName + " bot"},
+					{ID: "5", GuildID: "123", ChannelID: "456", Content: botName + " template reset levels"},
+					{ID: "6", GuildID: "123", ChannelID: "456", Content: "(Captain Hook)++"},
+					{ID: "7", GuildID: "789", ChannelID: "101", Content: "(Captain Hook)++"},
+				})
+				err := db.PutEntities(context.Background(), "123", popple.Entity{Name: "Captain Hook", Karma: 1233})
+				Expect(err).ToNot(HaveOccurred())
+
+				b := bot.New(session, db, router, nil)
+				_ = b.Listen(context.Background())
+			})
+
+			It("uses the server's template until it is reset", func() {
+				// Guilds are handled concurrently, so only the order within
+				// each one is known.
+				Expect(session.Responses).To(HaveLen(7))
+				Expect(inChannel(session.Responses, "456")).To(Equal([]discordtest.Response{
+					{Reaction: discordtest.Reaction{ChannelID: "456", MessageID: "1", Emoji: "✅"}},
+					{Reaction: discordtest.Reaction{ChannelID: "456", MessageID: "2", Emoji: "✅"}},
+					{Message: discordtest.Message{ChannelID: "456", Content: "Arr, Captain Hook be havin' 1,234 doubloons!"}},
+					{Message: discordtest.Message{ChannelID: "456", Content: "* Captain Hook has 1234 karma."}},
+					{Reaction: discordtest.Reaction{ChannelID: "456", MessageID: "5", Emoji: "✅"}},
+					{Message: discordtest.Message{ChannelID: "456", Content: "Captain Hook has 1235 karma."}},
+				}))
+				Expect(inChannel(session.Responses, "101")).To(Equal([]discordtest.Response{
+					{Message: discordtest.Message{ChannelID: "101", Content: "Captain Hook has 1 karma."}},
+				}))
+			})
+		})
+	})
+
+	When("the language command is invoked", func() {
+		Context("with an unsupported language", func() {
+			It("responds with the supported languages", func(ctx SpecContext) {
+				session = discordtest.NewResponseRecorder([]chat.Message{
+					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " language tlh"},
+				})
+				b := bot.New(session, db, router, nil)
+				_ = b.Listen(ctx)
+
+				Expect(session.Responses).To(ConsistOf(discordtest.Response{Message: discordtest.Message{
+					ChannelID: "456",
+					Content:   `Valid languages are "de", "en", "es"`,
+				}}))
+			})
+		})
+
+		Context("with a supported language", Ordered, func() {
+			BeforeAll(func() {
+				session = discordtest.NewResponseRecorder([]chat.Message{
+					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " language es"},
+					{ID: "2", GuildID: "123", ChannelID: "456", Content: botName + " top"},
+					{ID: "3", GuildID: "123", ChannelID: "456", Content: "gato++"},
+					{ID: "4", GuildID: "123", ChannelID: "456", Content: "gato++"},
+					{ID: "5", GuildID: "123", ChannelID: "456", Content: botName + " top 0"},
+				})
+				b := bot.New(session, db, router, nil)
+				_ = b.Listen(context.Background())
+			})
+
+			It("responds in that language with its plural rules", func() {
+				Expect(session.Responses).To(Equal([]discordtest.Response{
+					{Reaction: discordtest.Reaction{ChannelID: "456", MessageID: "1", Emoji: "✅"}},
+					{Message: discordtest.Message{ChannelID: "456", Content: "Nadie tiene karma todavía."}},
+					{Message: discordtest.Message{ChannelID: "456", Content: "gato tiene 1 punto de karma."}},
+					{Message: discordtest.Message{ChannelID: "456", Content: "gato tiene 2 puntos de karma."}},
+					{Message: discordtest.Message{ChannelID: "456", Content: "El tamaño de la tabla debe ser un número positivo mayor que cero"}},
+				}))
+			})
+		})
+	})
+
+	DescribeTable("every shipped language renders the default responses",
+		func(ctx SpecContext, lang string) {
+			err := db.PutConfig(ctx, popple.ServerConfig{ServerID: "123", Locale: lang})
+			Expect(err).ToNot(HaveOccurred())
+
+			session = discordtest.NewResponseRecorder([]chat.Message{
+				{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " bot"},
+				{ID: "2", GuildID: "123", ChannelID: "456", Content: "popple++"},
+				{ID: "3", GuildID: "123", ChannelID: "456", Content: botName + " top"},
+			})
+			b := bot.New(session, db, router, nil)
+			_ = b.Listen(ctx)
+
+			Expect(session.Responses).To(HaveLen(3))
+			for _, rsp := range session.Responses {
+				Expect(rsp.Message.Content).ToNot(BeEmpty())
+			}
+			Expect(session.Responses[1].Message.Content).To(ContainSubstring("popple"))
+			Expect(session.Responses[2].Message.Content).To(ContainSubstring("popple"))
+		},
+		func() []TableEntry {
+			var entries []TableEntry
+			for _, lang := range i18n.Languages() {
+				entries = append(entries, Entry(lang, lang))
+			}
+			return entries
+		}(),
+	)
+
+	When("the server has embeds turned on", Ordered, func() {
+		BeforeAll(func() {
+			session = discordtest.NewResponseRecorder([]chat.Message{
+				{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " embeds on"},
+				{ID: "2", GuildID: "123", ChannelID: "456", Content: botName + " top"},
+				{ID: "3", GuildID: "123", ChannelID: "456", Content: botName + " karma Bop"},
+			})
+
+			preexisting := []popple.Entity{
+				{Name: "Boop", Karma: 10},
+				{Name: "Bip", Karma: -10},
+				{Name: "Bop", Karma: 100},
+				{Name: "Bap", Karma: 1},
+			}
+			Expect(db.PutEntities(context.Background(), "123", preexisting...)).ToNot(HaveOccurred())
+
+			b := bot.New(session, db, router, nil)
+			_ = b.Listen(context.Background())
+		})
+
+		It("renders the leaderboard as an embed", func() {
+			Expect(session.Responses).To(HaveLen(3))
+			Expect(session.Responses[1]).To(Equal(discordtest.Response{Embed: discordtest.Embed{
+				ChannelID: "456",
+				Embed: chat.Embed{
+					Title: "Leaderboard",
+					Fields: []chat.EmbedField{
+						{Name: "Rank", Values: []string{"🥇", "🥈", "🥉", "#4"}},
+						{Name: "Subject", Values: []string{"Bop", "Boop", "Bap", "Bip"}},
+						{Name: "Karma", Values: []string{"100", "10", "1", "-10"}},
+					},
+					Footer:  "4 subjects, 101 karma in total",
+					Subject: "Bop",
+				},
+			}}))
+		})
+
+		It("renders karma checks as an embed", func() {
+			Expect(session.Responses[2]).To(Equal(discordtest.Response{Embed: discordtest.Embed{
+				ChannelID: "456",
+				Embed: chat.Embed{
+					Title: "Karma",
+					Fields: []chat.EmbedField{
+						{Name: "Subject", Values: []string{"Bop"}},
+						{Name: "Karma", Values: []string{"100"}},
+					},
+					Footer:  "1 subject, 100 karma in total",
+					Subject: "Bop",
+				},
+			}}))
+		})
+	})
+
+	When("the channels command is invoked", func() {
+		Context("with an invalid argument", func() {
+			It("responds with an error", func(ctx SpecContext) {
+				session = discordtest.NewResponseRecorder([]chat.Message{
+					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " channels deny"},
+				})
+
+				b := bot.New(session, db, router, nil)
+				_ = b.Listen(ctx)
+				Expect(session.Responses).To(ConsistOf(discordtest.Response{Message: discordtest.Message{
+					ChannelID: "456",
+					Content:   `Valid channel settings are "all", or "allow" or "deny" followed by channels or "here"`,
+				}}))
+			})
+		})
+
+		Context("and a channel is denied", Ordered, func() {
+			var saved []popple.Entity
+
+			BeforeAll(func() {
+				session = discordtest.NewResponseRecorder([]chat.Message{
+					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " channels deny here <#789>"},
+					{ID: "2", GuildID: "123", ChannelID: "789", Content: "ironic++"},
+					{ID: "3", GuildID: "123", ChannelID: "101", Content: "sincere++"},
+				})
+				b := bot.New(session, db, router, nil)
+				_ = b.Listen(context.Background())
+
+				var err error
+				saved, err = db.Entities(context.Background(), "123", "ironic", "sincere")
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("ignores karma events in the denied channel", func() {
+				Expect(saved).To(ContainElement(popple.Entity{Name: "ironic", Karma: 0}))
+			})
+
+			It("counts karma events in other channels", func() {
+				Expect(saved).To(ContainElement(popple.Entity{Name: "sincere", Karma: 1}))
+			})
+
+			It("reacts with an affirmative emoji and only announces the counted karma", func() {
+				Expect(session.Responses).To(Equal([]discordtest.Response{
+					{Reaction: discordtest.Reaction{ChannelID: "456", MessageID: "1", Emoji: "✅"}},
+					{Message: discordtest.Message{ChannelID: "101", Content: "sincere has 1 karma."}},
+				}))
+			})
+		})
+
+		Context("and only some channels are allowed", Ordered, func() {
+			var saved []popple.Entity
+
+			BeforeAll(func() {
+				session = discordtest.NewResponseRecorder([]chat.Message{
+					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " channels allow <#101>"},
+					{ID: "2", GuildID: "123", ChannelID: "456", Content: "ignored++"},
+					{ID: "3", GuildID: "123", ChannelID: "101", Content: "counted++"},
+				})
+				b := bot.New(session, db, router, nil)
+				_ = b.Listen(context.Background())
+
+				var err error
+				saved, err = db.Entities(context.Background(), "123", "ignored", "counted")
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("only counts karma events in the allowed channels", func() {
+				Expect(saved).To(ConsistOf(
+					popple.Entity{Name: "ignored", Karma: 0},
+					popple.Entity{Name: "counted", Karma: 1},
+				))
+			})
+		})
+	})
+
+	When("a channel has its own karma pool", Ordered, func() {
+		var (
+			server, channel []popple.Entity
+		)
+
+		BeforeAll(func() {
+			session = discordtest.NewResponseRecorder([]chat.Message{
+				{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " pool on"},
+				{ID: "2", GuildID: "123", ChannelID: "456", Content: "team++ team++"},
+				{ID: "3", GuildID: "123", ChannelID: "101", Content: "team--"},
+				{ID: "4", GuildID: "123", ChannelID: "456", Content: botName + " top here"},
+				{ID: "5", GuildID: "123", ChannelID: "456", Content: botName + " top"},
+			})
+			b := bot.New(session, db, router, nil)
+			_ = b.Listen(context.Background())
+
+			var err error
+			server, err = db.Entities(context.Background(), "123", "team")
+			Expect(err).ToNot(HaveOccurred())
+
+			channel, err = db.ChannelEntities(context.Background(), "123", "456", "team")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("keeps the channel's karma separate from the server's", func() {
+			Expect(channel).To(ConsistOf(popple.Entity{Name: "team", Karma: 2}))
+			Expect(server).To(ConsistOf(popple.Entity{Name: "team", Karma: -1}))
+		})
+
+		It("shows the channel's leaderboard when asked for here", func() {
+			Expect(session.Responses).To(HaveLen(5))
+			Expect(parseBoardOutput(session.Responses[3].Message.Content)).To(Equal([]popple.Entity{{Name: "team", Karma: 2}}))
+			Expect(parseBoardOutput(session.Responses[4].Message.Content)).To(Equal([]popple.Entity{{Name: "team", Karma: -1}}))
+		})
+	})
+
+	DescribeTable("settings can only be changed by admins",
+		func(ctx SpecContext, args string) {
+			recorder := discordtest.NewResponseRecorder([]chat.Message{
+				{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " " + args},
+			})
+			b := bot.New(refusingSession{recorder}, db, router, nil)
+			_ = b.Listen(ctx)
+
+			Expect(recorder.Responses).To(Equal([]discordtest.Response{
+				{Message: discordtest.Message{ChannelID: "456", Content: i18n.Text(i18n.Default, i18n.SettingsDenied)}},
+			}))
+
+			_, err := db.Config(ctx, "123")
+			Expect(err).To(MatchError(database.ErrNotFound))
+		},
+		Entry("announce", "announce off"),
+		Entry("channels", "channels deny here"),
+		Entry("pool", "pool on"),
+		Entry("template", "template set empty_board Nobody."),
+		Entry("language", "language es"),
+		Entry("embeds", "embeds on"),
+	)
+
+	When("the export command is invoked", func() {
+		Context("by an admin", func() {
+			It("sends them the server's karma", func(ctx SpecContext) {
+				session = discordtest.NewResponseRecorder([]chat.Message{
+					{ID: "1", GuildID: "123", ChannelID: "456", Content: "link++"},
+					{ID: "2", GuildID: "123", ChannelID: "456", Content: botName + " export csv"},
+				})
+				b := bot.New(session, db, router, nil)
+				_ = b.Listen(ctx)
+
+				Expect(session.Responses).To(HaveLen(3))
+				sent := session.Responses[1].File
+				Expect(sent.ChannelID).To(Equal("456"))
+				Expect(sent.MessageID).To(Equal("2"))
+				Expect(sent.File.Name).To(MatchRegexp(`^popple-123-\d{8}\.csv$`))
+				Expect(string(sent.File.Data)).To(ContainSubstring("\nentity,,link,1,,,\n"))
+				Expect(session.Responses[2].Reaction).To(Equal(discordtest.Reaction{ChannelID: "456", MessageID: "2", Emoji: "✅"}))
+			})
+		})
+
+		Context("by someone else", func() {
+			It("refuses", func(ctx SpecContext) {
+				recorder := discordtest.NewResponseRecorder([]chat.Message{
+					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " export"},
+				})
+				b := bot.New(refusingSession{recorder}, unreadableDB{db}, router, nil)
+				_ = b.Listen(ctx)
+
+				Expect(recorder.Responses).To(Equal([]discordtest.Response{
+					{Message: discordtest.Message{ChannelID: "456", Content: i18n.Text(i18n.Default, i18n.ExportDenied)}},
+				}))
+			})
+		})
+
+		Context("with an unknown format", func() {
+			It("explains the formats", func(ctx SpecContext) {
+				session = discordtest.NewResponseRecorder([]chat.Message{
+					{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " export xml"},
+				})
+				b := bot.New(session, db, router, nil)
+				_ = b.Listen(ctx)
+
+				Expect(session.Responses).To(Equal([]discordtest.Response{
+					{Message: discordtest.Message{ChannelID: "456", Content: i18n.Text(i18n.Default, i18n.ExportUsage)}},
+				}))
+			})
+		})
+	})
+
+	When("a handler logs an error", func() {
+		var (
+			logger *logrus.Logger
+			hook   *logtest.Hook
+			broken mutedSession
+		)
+
+		BeforeEach(func() {
+			logger, hook = logtest.NewNullLogger()
+			broken = mutedSession{discordtest.NewResponseRecorder([]chat.Message{
+				{ID: "1", GuildID: "123", ChannelID: "456", Content: botName + " karma link"},
+				{ID: "2", GuildID: "123", ChannelID: "456", Content: botName + " karma zelda"},
+			})}
+		})
+
+		It("correlates each message's entries", func(ctx SpecContext) {
+			b := bot.New(broken, db, router, logging.New(logger, logging.Config{}))
+			_ = b.Listen(ctx)
+
+			entries := hook.AllEntries()
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0].Data["content"]).To(ContainSubstring("link"))
+			Expect(entries[0].Data[logging.CorrelationIDField]).ToNot(BeEmpty())
+			Expect(entries[1].Data[logging.CorrelationIDField]).ToNot(Equal(entries[0].Data[logging.CorrelationIDField]))
+		})
+
+		Context("and content is redacted", func() {
+			It("leaves the message's content out", func(ctx SpecContext) {
+				b := bot.New(broken, db, router, logging.New(logger, logging.Config{RedactContent: true}))
+				_ = b.Listen(ctx)
+
+				for _, entry := range hook.AllEntries() {
+					Expect(entry.Data["content"]).To(Equal(logging.Redacted))
+				}
+			})
+		})
+	})
 })
 
 func parseBoardOutput(s string) []popple.Entity {
@@ -505,3 +1106,52 @@ func parseBoardOutput(s string) []popple.Entity {
 
 	return entities
 }
+
+// inChannel returns the responses sent to channelID, in order.
+func inChannel(responses []discordtest.Response, channelID string) []discordtest.Response {
+	var in []discordtest.Response
+	for _, rsp := range responses {
+		if rsp.Message.ChannelID == channelID || rsp.Reply.ChannelID == channelID || rsp.Reaction.ChannelID == channelID || rsp.Embed.ChannelID == channelID || rsp.File.ChannelID == channelID {
+			in = append(in, rsp)
+		}
+	}
+	return in
+}
+
+// refusingSession is used by someone who doesn't administer the server.
+type refusingSession struct {
+	*discordtest.ResponseRecorder
+}
+
+func (s refusingSession) SendFileToAdmin(ctx context.Context, channelID, messageID string, file chat.File) error {
+	return chat.ErrNotAdmin
+}
+
+func (s refusingSession) IsAdmin(ctx context.Context, channelID, messageID string) (bool, error) {
+	return false, nil
+}
+
+// unreadableDB fails the test if the server's data is read in bulk, as it
+// is for an export.
+type unreadableDB struct {
+	*sqlite.DB
+}
+
+func (d unreadableDB) ServerEntities(ctx context.Context, serverID string) ([]database.EntityRecord, error) {
+	Fail("read the server's entities")
+	return nil, nil
+}
+
+func (d unreadableDB) ServerKarmaEvents(ctx context.Context, serverID string) ([]database.EventRecord, error) {
+	Fail("read the server's karma events")
+	return nil, nil
+}
+
+// mutedSession can't send messages.
+type mutedSession struct {
+	*discordtest.ResponseRecorder
+}
+
+func (s mutedSession) SendMessageToChannel(ctx context.Context, channelID string, msg string) error {
+	return errors.New("muted")
+}
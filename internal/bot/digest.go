@@ -0,0 +1,77 @@
+package bot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/popple"
+)
+
+// digest batches karma announcements per channel so that a channel
+// receives at most one announcement per interval.
+type digest struct {
+	mu      sync.Mutex
+	pending map[string]*batch
+	send    func(guildID, channelID string, levels popple.Increments)
+}
+
+type batch struct {
+	guildID string
+	levels  popple.Increments
+	timer   *time.Timer
+}
+
+func newDigest(send func(guildID, channelID string, levels popple.Increments)) *digest {
+	return &digest{
+		pending: make(map[string]*batch),
+		send:    send,
+	}
+}
+
+// add queues levels to be announced in channelID. The first addition to an
+// empty batch schedules the batch to be sent after interval.
+func (d *digest) add(guildID, channelID string, levels popple.Increments, interval time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	b, ok := d.pending[channelID]
+	if !ok {
+		b = &batch{guildID: guildID, levels: make(popple.Increments)}
+		b.timer = time.AfterFunc(interval, func() { d.flush(channelID) })
+		d.pending[channelID] = b
+	}
+
+	// Later levels are more recent, so they replace whatever was queued.
+	for name, karma := range levels {
+		b.levels[name] = karma
+	}
+}
+
+// flush sends the batch queued for channelID, if there is one.
+func (d *digest) flush(channelID string) {
+	d.mu.Lock()
+	b, ok := d.pending[channelID]
+	if ok {
+		b.timer.Stop()
+		delete(d.pending, channelID)
+	}
+	d.mu.Unlock()
+
+	if ok {
+		d.send(b.guildID, channelID, b.levels)
+	}
+}
+
+// flushAll sends every queued batch immediately.
+func (d *digest) flushAll() {
+	d.mu.Lock()
+	var channels []string
+	for channelID := range d.pending {
+		channels = append(channels, channelID)
+	}
+	d.mu.Unlock()
+
+	for _, channelID := range channels {
+		d.flush(channelID)
+	}
+}
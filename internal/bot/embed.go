@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/i18n"
+	"github.com/connorkuehl/popple/internal/popple"
+)
+
+// boardEmbed lays a board out as columns of rank, subject and karma, with
+// the subject in first place as the embed's subject.
+func boardEmbed(config popple.ServerConfig, ord popple.BoardOrder, board popple.Board) chat.Embed {
+	lang := config.Locale
+
+	title := i18n.Text(lang, i18n.LeaderboardTitle)
+	if ord == popple.BoardOrderAsc {
+		title = i18n.Text(lang, i18n.LoserboardTitle)
+	}
+
+	var (
+		ranks, who, karma []string
+		total             int64
+	)
+	for i, entry := range board {
+		r, _ := rank(i)
+		ranks = append(ranks, r)
+		who = append(who, entry.Who)
+		karma = append(karma, strconv.FormatInt(entry.Karma, 10))
+		total += entry.Karma
+	}
+
+	embed := chat.Embed{
+		Title: title,
+		Fields: []chat.EmbedField{
+			{Name: i18n.Text(lang, i18n.RankColumn), Values: ranks},
+			{Name: i18n.Text(lang, i18n.SubjectColumn), Values: who},
+			{Name: i18n.Text(lang, i18n.KarmaColumn), Values: karma},
+		},
+		Footer: footer(lang, len(board), total),
+	}
+	if len(board) > 0 {
+		embed.Subject = board[0].Who
+	}
+	return embed
+}
+
+// levelsEmbed lays out the karma levels of the given subjects in
+// alphabetical order.
+func levelsEmbed(config popple.ServerConfig, levels popple.Increments) chat.Embed {
+	lang := config.Locale
+
+	var names []string
+	for name := range levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		karma []string
+		total int64
+	)
+	for _, name := range names {
+		karma = append(karma, strconv.FormatInt(levels[name], 10))
+		total += levels[name]
+	}
+
+	embed := chat.Embed{
+		Title: i18n.Text(lang, i18n.KarmaTitle),
+		Fields: []chat.EmbedField{
+			{Name: i18n.Text(lang, i18n.SubjectColumn), Values: names},
+			{Name: i18n.Text(lang, i18n.KarmaColumn), Values: karma},
+		},
+		Footer: footer(lang, len(names), total),
+	}
+	if len(names) == 1 {
+		embed.Subject = names[0]
+	}
+	return embed
+}
+
+func footer(lang string, subjects int, total int64) string {
+	key := i18n.Plural(lang, int64(subjects), string(i18n.FooterOne), string(i18n.FooterOther))
+	return i18n.Text(lang, i18n.Key(key), subjects, total)
+}
@@ -0,0 +1,195 @@
+package bot_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/command"
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/discord/discordtest"
+	"github.com/connorkuehl/popple/internal/popple"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// slowDB takes its time looking up configs, and can be held up entirely
+// until gate is closed.
+type slowDB struct {
+	*sqlite.DB
+	delay time.Duration
+	gate  chan struct{}
+
+	mu      sync.Mutex
+	running int
+	// maxRunning is the most lookups that were ever in progress at once.
+	maxRunning int
+}
+
+func (d *slowDB) Config(ctx context.Context, serverID string) (popple.ServerConfig, error) {
+	d.mu.Lock()
+	d.running++
+	if d.running > d.maxRunning {
+		d.maxRunning = d.running
+	}
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		d.running--
+		d.mu.Unlock()
+	}()
+
+	if d.gate != nil {
+		<-d.gate
+	}
+	time.Sleep(d.delay)
+	return d.DB.Config(ctx, serverID)
+}
+
+func (d *slowDB) MaxRunning() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.maxRunning
+}
+
+// liveSession delivers whatever is sent on its messages channel.
+type liveSession struct {
+	*discordtest.ResponseRecorder
+	messages chan chat.Message
+}
+
+func (s liveSession) Messages() <-chan chat.Message {
+	return s.messages
+}
+
+var _ = Describe("Listen", func() {
+	var (
+		router *command.Router
+		db     *slowDB
+	)
+
+	BeforeEach(func() {
+		sdb, cleanup, err := sqlite.NewInMemory()
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(cleanup)
+
+		router = command.NewRouter("popple")
+		db = &slowDB{DB: sdb, delay: 20 * time.Millisecond}
+	})
+
+	When("several guilds are busy at once", func() {
+		It("handles them concurrently, in order within each guild", func(ctx SpecContext) {
+			var messages []chat.Message
+			for i := 0; i < 10; i++ {
+				for g := 0; g < 4; g++ {
+					guild := strconv.Itoa(g)
+					messages = append(messages, chat.Message{
+						ID:        fmt.Sprintf("%d-%d", g, i),
+						GuildID:   guild,
+						ChannelID: "channel-" + guild,
+						Content:   "link++",
+					})
+				}
+			}
+
+			session := discordtest.NewResponseRecorder(messages)
+			b := bot.New(session, db, router, nil)
+			Expect(b.Listen(ctx)).To(MatchError(bot.ErrSessionClosed))
+
+			Expect(db.MaxRunning()).To(BeNumerically(">", 1))
+			Expect(session.Responses).To(HaveLen(40))
+			for g := 0; g < 4; g++ {
+				var want []discordtest.Response
+				for i := 1; i <= 10; i++ {
+					want = append(want, discordtest.Response{Message: discordtest.Message{
+						ChannelID: "channel-" + strconv.Itoa(g),
+						Content:   fmt.Sprintf("link has %d karma.", i),
+					}})
+				}
+				Expect(inChannel(session.Responses, "channel-"+strconv.Itoa(g))).To(Equal(want))
+			}
+		})
+	})
+
+	When("a guild can't keep up", func() {
+		It("stops taking messages once its queue is full", func() {
+			db.gate = make(chan struct{})
+			session := liveSession{discordtest.NewResponseRecorder(nil), make(chan chat.Message)}
+			b := bot.New(session, db, router, nil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error)
+			go func() { done <- b.Listen(ctx) }()
+
+			// One message is being handled, one is waiting for room in the
+			// queue, and the rest fill the queue.
+			accepted := 0
+			for accepted < bot.QueueSize+2 {
+				select {
+				case session.messages <- chat.Message{ID: strconv.Itoa(accepted), GuildID: "1", ChannelID: "2", Content: "link++"}:
+					accepted++
+				case <-time.After(5 * time.Second):
+					Fail(fmt.Sprintf("only %d messages were taken", accepted))
+				}
+			}
+			Consistently(session.messages).WithTimeout(100 * time.Millisecond).ShouldNot(BeSent(chat.Message{GuildID: "1", ChannelID: "2", Content: "link++"}))
+
+			close(db.gate)
+			cancel()
+			Eventually(done).WithTimeout(5 * time.Second).Should(Receive(MatchError(context.Canceled)))
+			Expect(session.Responses).To(HaveLen(bot.QueueSize + 2))
+		})
+
+		It("stops while a message is waiting for room, and still handles it", func() {
+			db.gate = make(chan struct{})
+			session := liveSession{discordtest.NewResponseRecorder(nil), make(chan chat.Message)}
+			b := bot.New(session, db, router, nil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error)
+			go func() { done <- b.Listen(ctx) }()
+
+			for i := 0; i < bot.QueueSize+2; i++ {
+				session.messages <- chat.Message{ID: strconv.Itoa(i), GuildID: "1", ChannelID: "2", Content: "link++"}
+			}
+
+			// Listen stops waiting for room, but the message that was
+			// waiting is handled along with the queued ones.
+			cancel()
+			Consistently(done).WithTimeout(100 * time.Millisecond).ShouldNot(Receive())
+
+			close(db.gate)
+			Eventually(done).WithTimeout(5 * time.Second).Should(Receive(MatchError(context.Canceled)))
+			Expect(session.Responses).To(HaveLen(bot.QueueSize + 2))
+			Expect(session.Responses[bot.QueueSize+1].Message.Content).To(Equal(fmt.Sprintf("link has %d karma.", bot.QueueSize+2)))
+		})
+	})
+
+	When("it's asked to stop", func() {
+		It("handles the messages it already took first", func() {
+			session := liveSession{discordtest.NewResponseRecorder(nil), make(chan chat.Message)}
+			b := bot.New(session, db, router, nil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- b.Listen(ctx) }()
+
+			for i := 0; i < 5; i++ {
+				session.messages <- chat.Message{ID: strconv.Itoa(i), GuildID: "1", ChannelID: "2", Content: "link++"}
+			}
+			cancel()
+
+			Eventually(done).WithTimeout(5 * time.Second).Should(Receive(MatchError(context.Canceled)))
+			Expect(session.Responses).To(HaveLen(5))
+			Expect(session.Responses[4].Message.Content).To(Equal("link has 5 karma."))
+		})
+	})
+})
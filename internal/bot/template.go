@@ -0,0 +1,252 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/connorkuehl/popple/internal/i18n"
+	"github.com/connorkuehl/popple/internal/logging"
+	"github.com/connorkuehl/popple/internal/popple"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Names of the responses that servers may override with their own
+// templates.
+const (
+	responseLevels     = string(i18n.Levels)
+	responseBoard      = string(i18n.Board)
+	responseEmptyBoard = string(i18n.EmptyBoard)
+)
+
+// maxResponseLength is the longest message Discord will accept.
+const maxResponseLength = 2000
+
+// maxTemplateOutput is how much a template may write, whitespace and all,
+// before it's stopped.
+const maxTemplateOutput = 4 * maxResponseLength
+
+// templateSamples is the data each template is test-driven with before a
+// server is allowed to save it.
+var templateSamples = map[string]any{
+	responseLevels: popple.Increments{"Popple": 1, "(Poe the Potato Pirate)": -1234},
+	responseBoard: popple.Board{
+		{Who: "Popple", Karma: 1234},
+		{Who: "Poe the Potato Pirate", Karma: 12},
+		{Who: "meme-bot", Karma: 1},
+		{Who: "HelloWorld", Karma: -2},
+	},
+	responseEmptyBoard: nil,
+}
+
+var errUnknownTemplate = errors.New("unknown template")
+
+// templateNames returns the names of the responses that can be overridden.
+func templateNames() []string {
+	var names []string
+	for name := range templateSamples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newTemplate(name, lang string) *template.Template {
+	return template.New(name).Funcs(template.FuncMap{
+		"pluralize": func(n any, singular, plural string) (string, error) {
+			i, err := toInt64(n)
+			if err != nil {
+				return "", err
+			}
+			return i18n.Plural(lang, i, singular, plural), nil
+		},
+		"rank":     rank,
+		"humanize": humanizeNumber,
+	})
+}
+
+// validateTemplate checks that text parses and renders a sensible response
+// when it is given sample data for the named response.
+func validateTemplate(name, lang, text string) error {
+	sample, ok := templateSamples[name]
+	if !ok {
+		return errUnknownTemplate
+	}
+
+	rsp, err := execute(name, lang, text, sample)
+	if err != nil {
+		return err
+	}
+	if len(rsp) == 0 {
+		return errors.New("template renders an empty response")
+	}
+	if len(rsp) > maxResponseLength {
+		return fmt.Errorf("template renders a response longer than %d characters", maxResponseLength)
+	}
+	return nil
+}
+
+func execute(name, lang, text string, data any) (string, error) {
+	tmpl, err := newTemplate(name, lang).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	if len(tmpl.Templates()) > 1 {
+		return "", errors.New("templates can't define other templates")
+	}
+	if err := checkNodes(tmpl.Root, true, false); err != nil {
+		return "", err
+	}
+
+	rsp := limitedWriter{n: maxTemplateOutput}
+	if err := tmpl.Execute(&rsp, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(rsp.String()), nil
+}
+
+// checkNodes rejects the parts of the template language that could make
+// a template run for a long time without writing anything: including
+// other templates, ranging over anything but the response's data, e.g.,
+// a number, and ranging inside a range. dot is whether "." is the
+// response's data.
+func checkNodes(node parse.Node, dot, inRange bool) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, node := range n.Nodes {
+			if err := checkNodes(node, dot, inRange); err != nil {
+				return err
+			}
+		}
+	case *parse.TemplateNode:
+		return errors.New("templates can't include other templates")
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode, dot, dot, inRange)
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode, isData(n.Pipe, dot), dot, inRange)
+	case *parse.RangeNode:
+		if inRange {
+			return errors.New("ranges can't be nested")
+		}
+		if !isData(n.Pipe, dot) {
+			return errors.New("templates can only range over the response's data")
+		}
+		return checkBranch(&n.BranchNode, false, dot, true)
+	}
+	return nil
+}
+
+// checkBranch checks both sides of a branch. dot is whether "." is the
+// response's data inside the branch, and elseDot whether it is in the
+// else branch.
+func checkBranch(n *parse.BranchNode, dot, elseDot, inRange bool) error {
+	if err := checkNodes(n.List, dot, inRange); err != nil {
+		return err
+	}
+	return checkNodes(n.ElseList, elseDot, inRange)
+}
+
+// isData reports whether pipe is the response's data itself, i.e., "$", or
+// "." where dot is the data.
+func isData(pipe *parse.PipeNode, dot bool) bool {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.VariableNode:
+		return len(arg.Ident) == 1 && arg.Ident[0] == "$"
+	default:
+		return false
+	}
+}
+
+// limitedWriter fails once more than n bytes have been written to it,
+// which stops the template writing to it.
+type limitedWriter struct {
+	strings.Builder
+	n int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.Len()+len(p) > w.n {
+		return 0, fmt.Errorf("template renders more than %d characters", w.n)
+	}
+	return w.Builder.Write(p)
+}
+
+// render applies the server's template for the named response, falling back
+// to the default for the server's language if the server hasn't set one or
+// if theirs doesn't work.
+func render(ctx context.Context, config popple.ServerConfig, name string, data any) (string, error) {
+	if _, ok := templateSamples[name]; !ok {
+		return "", errUnknownTemplate
+	}
+
+	if text, ok := config.Templates[name]; ok {
+		rsp, err := execute(name, config.Locale, text, data)
+		if err == nil && len(rsp) > 0 {
+			return rsp, nil
+		}
+
+		logging.FromContext(ctx).WithFields(log.Fields{
+			"guild_id": config.ServerID,
+			"template": name,
+		}).WithError(err).Warn("falling back to default template")
+	}
+
+	return execute(name, config.Locale, i18n.Text(config.Locale, i18n.Key(name)), data)
+}
+
+// rank turns a zero-based board position into a medal for the podium and
+// a number for everyone else.
+func rank(i any) (string, error) {
+	n, err := toInt64(i)
+	if err != nil {
+		return "", err
+	}
+
+	switch n {
+	case 0:
+		return "🥇", nil
+	case 1:
+		return "🥈", nil
+	case 2:
+		return "🥉", nil
+	default:
+		return fmt.Sprintf("#%d", n+1), nil
+	}
+}
+
+// humanizeNumber adds thousands separators to n.
+func humanizeNumber(n any) (string, error) {
+	i, err := toInt64(n)
+	if err != nil {
+		return "", err
+	}
+	return humanize.Comma(i), nil
+}
+
+func toInt64(v any) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case uint:
+		return int64(n), nil
+	default:
+		return 0, fmt.Errorf("expected a number, got %T", v)
+	}
+}
@@ -0,0 +1,29 @@
+// Package chat holds what the bot and the chat services it's connected to
+// exchange, whichever service that is.
+package chat
+
+import "errors"
+
+var (
+	// ErrNotAdmin is returned when someone who doesn't administer the
+	// server asks for something only admins may have.
+	ErrNotAdmin = errors.New("not a server admin")
+	// ErrUnsupported is returned by transports that can't do what was
+	// asked on their chat service.
+	ErrUnsupported = errors.New("not supported by this chat service")
+)
+
+// Message is a message the bot received. Chat services without guilds or
+// channels map what they have onto them.
+type Message struct {
+	ID        string
+	GuildID   string
+	ChannelID string
+	Content   string
+}
+
+// File is a file to send to someone.
+type File struct {
+	Name string
+	Data []byte
+}
@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"sync"
+	"time"
+)
+
+// ConnState tracks whether a session is connected to its chat service.
+// Sessions embed it so that health checks can ask.
+type ConnState struct {
+	mu         sync.Mutex
+	connected  bool
+	disconnect time.Time
+	reconnects uint64
+	heartbeat  time.Time
+}
+
+// SetConnected records that the session connected or disconnected.
+func (c *ConnState) SetConnected(connected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connected && !connected {
+		c.disconnect = time.Now()
+	}
+	if !c.connected && connected && !c.disconnect.IsZero() {
+		c.reconnects++
+	}
+	if connected {
+		c.heartbeat = time.Now()
+	}
+	c.connected = connected
+}
+
+// Heartbeat records that the chat service showed the connection is still
+// alive, e.g., by answering a ping.
+func (c *ConnState) Heartbeat() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.heartbeat = time.Now()
+}
+
+// LastHeartbeat is when the connection was last known to be alive. It's
+// zero if the session has never connected.
+func (c *ConnState) LastHeartbeat() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.heartbeat
+}
+
+// Connected reports whether the session is connected right now.
+func (c *ConnState) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+// Reconnects is how many times the session has connected again after
+// losing its connection.
+func (c *ConnState) Reconnects() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reconnects
+}
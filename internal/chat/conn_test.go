@@ -0,0 +1,41 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnStateReconnects(t *testing.T) {
+	var c ConnState
+
+	c.SetConnected(true)
+	c.SetConnected(true)
+	if got := c.Reconnects(); got != 0 {
+		t.Errorf("want no reconnects before a disconnect, got %d", got)
+	}
+
+	c.SetConnected(false)
+	c.SetConnected(true)
+	if got := c.Reconnects(); got != 1 {
+		t.Errorf("want 1 reconnect, got %d", got)
+	}
+}
+
+func TestConnStateHeartbeat(t *testing.T) {
+	var c ConnState
+	if got := c.LastHeartbeat(); !got.IsZero() {
+		t.Errorf("want no heartbeat before connecting, got %v", got)
+	}
+
+	c.SetConnected(true)
+	connected := c.LastHeartbeat()
+	if connected.IsZero() {
+		t.Fatal("want connecting to count as a heartbeat")
+	}
+
+	time.Sleep(time.Millisecond)
+	c.Heartbeat()
+	if got := c.LastHeartbeat(); !got.After(connected) {
+		t.Errorf("want heartbeat after %v, got %v", connected, got)
+	}
+}
@@ -0,0 +1,62 @@
+package chat
+
+import (
+	"strings"
+)
+
+// Embed is a structured response. Transports that support rich messages
+// render it natively and the rest fall back to its String form.
+type Embed struct {
+	Title  string
+	Fields []EmbedField
+	Footer string
+	// Subject is the name of whoever the embed is about, if anyone.
+	// Transports that know what a subject looks like, e.g., a user's
+	// avatar, may show it.
+	Subject string
+}
+
+// EmbedField is a column of an embed. Fields are laid out side by side
+// and their values are lined up row by row.
+type EmbedField struct {
+	Name   string
+	Values []string
+}
+
+// Rows transposes the embed's fields into rows of cells.
+func (e Embed) Rows() [][]string {
+	var n int
+	for _, f := range e.Fields {
+		if len(f.Values) > n {
+			n = len(f.Values)
+		}
+	}
+
+	rows := make([][]string, n)
+	for i := range rows {
+		for _, f := range e.Fields {
+			if i < len(f.Values) {
+				rows[i] = append(rows[i], f.Values[i])
+			}
+		}
+	}
+	return rows
+}
+
+// String renders the embed as plain text, one row per line.
+func (e Embed) String() string {
+	var b strings.Builder
+	if len(e.Title) > 0 {
+		b.WriteString("**" + e.Title + "**\n")
+	}
+
+	for _, row := range e.Rows() {
+		b.WriteString(strings.Join(row, " ") + "\n")
+	}
+
+	if len(e.Footer) > 0 {
+		b.WriteString(e.Footer + "\n")
+	}
+
+	return strings.TrimSpace(b.String())
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/connorkuehl/popple/internal/popple"
 )
@@ -16,36 +18,296 @@ var (
 
 var DefaultLimit uint = 10
 
+var DefaultDigestInterval = 60 * time.Second
+
 type SetAnnounceArgs struct {
-	NoAnnounce bool
+	Mode           popple.AnnounceMode
+	DigestInterval time.Duration
 }
 
 func (args *SetAnnounceArgs) ParseArg(s string) error {
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		return ErrMissingArgument
+	}
+
+	var (
+		mode     popple.AnnounceMode
+		interval time.Duration
+	)
+	switch words[0] {
+	case "on", "yes", "message":
+		mode = popple.AnnounceMessage
+	case "off", "no":
+		mode = popple.AnnounceOff
+	case "react":
+		mode = popple.AnnounceReact
+	case "reply":
+		mode = popple.AnnounceReply
+	case "digest":
+		mode = popple.AnnounceDigest
+		interval = DefaultDigestInterval
+	default:
+		return ErrInvalidArgument
+	}
+
+	if len(words) > 1 {
+		if mode != popple.AnnounceDigest || len(words) > 2 {
+			return ErrInvalidArgument
+		}
+
+		seconds, err := strconv.Atoi(words[1])
+		if err != nil || seconds < 1 {
+			return ErrInvalidArgument
+		}
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	args.Mode = mode
+	args.DigestInterval = interval
+	return nil
+}
+
+type ChannelsArgs struct {
+	Filter   popple.ChannelFilter
+	Channels []string
+	// Here is set when the current channel was named as "here".
+	Here bool
+}
+
+func (args *ChannelsArgs) ParseArg(s string) error {
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		return ErrMissingArgument
+	}
+
+	switch words[0] {
+	case "all":
+		args.Filter = popple.ChannelFilterNone
+	case "allow":
+		args.Filter = popple.ChannelFilterAllow
+	case "deny":
+		args.Filter = popple.ChannelFilterDeny
+	default:
+		return ErrInvalidArgument
+	}
+
+	for _, word := range words[1:] {
+		if word == "here" {
+			args.Here = true
+			continue
+		}
+
+		id, ok := parseChannel(word)
+		if !ok {
+			return ErrInvalidArgument
+		}
+		args.Channels = append(args.Channels, id)
+	}
+
+	if args.Filter != popple.ChannelFilterNone && len(args.Channels) == 0 && !args.Here {
+		return ErrMissingArgument
+	}
+	return nil
+}
+
+// parseChannel accepts a channel mention (<#123>), a bare channel ID, an
+// IRC channel name (#popple) or a Matrix room ID (!abc:example.org). IDs are
+// numeric on Discord and upper case alphanumeric on Slack. IRC channel names
+// are case-insensitive, so they're lower cased like the IRC session does.
+func parseChannel(s string) (id string, ok bool) {
+	if strings.HasPrefix(s, "#") || strings.HasPrefix(s, "&") {
+		return parseIRCChannel(s)
+	}
+	if strings.HasPrefix(s, "!") {
+		return parseRoomID(s)
+	}
+
+	if strings.HasPrefix(s, "<#") && strings.HasSuffix(s, ">") {
+		s = s[len("<#") : len(s)-len(">")]
+	}
+	if len(s) == 0 {
+		return "", false
+	}
+	for _, r := range s {
+		if !('0' <= r && r <= '9') && !('A' <= r && r <= 'Z') {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+func parseIRCChannel(s string) (id string, ok bool) {
+	if len(s) < 2 || strings.ContainsAny(s, ",:\x07") {
+		return "", false
+	}
+	return strings.ToLower(s), true
+}
+
+func parseRoomID(s string) (id string, ok bool) {
+	localpart, server, found := strings.Cut(s[len("!"):], ":")
+	if !found || len(localpart) == 0 || len(server) == 0 {
+		return "", false
+	}
+	return s, true
+}
+
+type PoolArgs struct {
+	Pooled bool
+}
+
+func (args *PoolArgs) ParseArg(s string) error {
+	on, err := parseOnOff(s)
+	if err != nil {
+		return err
+	}
+
+	args.Pooled = on
+	return nil
+}
+
+func parseOnOff(s string) (bool, error) {
 	scanner := bufio.NewScanner(strings.NewReader(s))
 	scanner.Split(bufio.ScanWords)
 	if ok := scanner.Scan(); !ok {
 		err := scanner.Err()
 		if err == nil {
-			return ErrMissingArgument
+			return false, ErrMissingArgument
 		}
-		return err
+		return false, err
 	}
 
-	var on bool
-	setting := scanner.Text()
-	switch setting {
+	switch scanner.Text() {
 	case "on", "yes":
-		on = true
+		return true, nil
 	case "off", "no":
-		on = false
+		return false, nil
+	default:
+		return false, ErrInvalidArgument
+	}
+}
+
+type EmbedsArgs struct {
+	On bool
+}
+
+func (args *EmbedsArgs) ParseArg(s string) error {
+	on, err := parseOnOff(s)
+	if err != nil {
+		return err
+	}
+
+	args.On = on
+	return nil
+}
+
+type ExportArgs struct {
+	// Format is "json" or "csv".
+	Format string
+}
+
+func (args *ExportArgs) ParseArg(s string) error {
+	words := strings.Fields(s)
+	switch len(words) {
+	case 0:
+		args.Format = "json"
+		return nil
+	case 1:
+		switch format := strings.ToLower(words[0]); format {
+		case "json", "csv":
+			args.Format = format
+			return nil
+		}
+		return ErrInvalidArgument
+	default:
+		return ErrInvalidArgument
+	}
+}
+
+type LanguageArgs struct {
+	Code string
+}
+
+func (args *LanguageArgs) ParseArg(s string) error {
+	words := strings.Fields(s)
+	switch len(words) {
+	case 0:
+		return ErrMissingArgument
+	case 1:
+		args.Code = strings.ToLower(words[0])
+		return nil
 	default:
 		return ErrInvalidArgument
 	}
+}
+
+type TemplateAction int
 
-	args.NoAnnounce = !on
+const (
+	TemplateSet   TemplateAction = 1
+	TemplateReset TemplateAction = 2
+)
+
+type TemplateArgs struct {
+	Action TemplateAction
+	Name   string
+	// Body is the template text exactly as it was written, less any
+	// surrounding code block.
+	Body string
+}
+
+func (args *TemplateArgs) ParseArg(s string) error {
+	action, rest := cutWord(s)
+	name, body := cutWord(rest)
+	if len(action) == 0 || len(name) == 0 {
+		return ErrMissingArgument
+	}
+
+	switch action {
+	case "set":
+		body = trimCodeBlock(body)
+		if len(body) == 0 {
+			return ErrMissingArgument
+		}
+		args.Action = TemplateSet
+	case "reset":
+		if len(strings.TrimSpace(body)) != 0 {
+			return ErrInvalidArgument
+		}
+		body = ""
+		args.Action = TemplateReset
+	default:
+		return ErrInvalidArgument
+	}
+
+	args.Name = name
+	args.Body = body
 	return nil
 }
 
+// cutWord splits s after its first whitespace-delimited word, leaving the
+// remainder untouched apart from the whitespace that separated them.
+func cutWord(s string) (word, rest string) {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	end := strings.IndexFunc(s, unicode.IsSpace)
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], strings.TrimLeftFunc(s[end:], unicode.IsSpace)
+}
+
+// trimCodeBlock removes the ``` or ` fences people use to keep chat clients
+// from mangling a template.
+func trimCodeBlock(s string) string {
+	s = strings.TrimSpace(s)
+	for _, fence := range []string{"```", "`"} {
+		if len(s) >= 2*len(fence) && strings.HasPrefix(s, fence) && strings.HasSuffix(s, fence) {
+			return strings.TrimSpace(s[len(fence) : len(s)-len(fence)])
+		}
+	}
+	return s
+}
+
 type ChangeKarmaArgs struct {
 	Increments popple.Increments
 }
@@ -102,19 +364,21 @@ func (args *LoserboardArgs) ParseArg(s string) error {
 type BoardArgs struct {
 	Limit uint
 	Order popple.BoardOrder
+	// Here requests the board for the current channel's karma pool.
+	Here bool
 }
 
 func (args *BoardArgs) ParseArg(s string) error {
 	scanner := bufio.NewScanner(strings.NewReader(s))
 	scanner.Split(bufio.ScanWords)
 
-	if ok := scanner.Scan(); !ok {
-		err := scanner.Err()
-		if err != nil {
-			return err
+	limit, here := DefaultLimit, false
+	for scanner.Scan() {
+		if scanner.Text() == "here" && !here {
+			here = true
+			continue
 		}
-		args.Limit = DefaultLimit
-	} else {
+
 		parsedLimit, err := strconv.Atoi(scanner.Text())
 		if err != nil {
 			return ErrInvalidArgument
@@ -122,8 +386,13 @@ func (args *BoardArgs) ParseArg(s string) error {
 		if parsedLimit < 1 {
 			return ErrInvalidArgument
 		}
-		args.Limit = uint(parsedLimit)
+		limit = uint(parsedLimit)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
 	}
 
+	args.Limit = limit
+	args.Here = here
 	return nil
 }
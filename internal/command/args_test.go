@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/connorkuehl/popple/internal/popple"
 )
@@ -21,19 +22,47 @@ func TestParseSetAnnounceArgs(t *testing.T) {
 	}{
 		{
 			input: "on",
-			want:  result{arg: SetAnnounceArgs{NoAnnounce: false}},
+			want:  result{arg: SetAnnounceArgs{Mode: popple.AnnounceMessage}},
 		},
 		{
 			input: "yes",
-			want:  result{arg: SetAnnounceArgs{NoAnnounce: false}},
+			want:  result{arg: SetAnnounceArgs{Mode: popple.AnnounceMessage}},
+		},
+		{
+			input: "message",
+			want:  result{arg: SetAnnounceArgs{Mode: popple.AnnounceMessage}},
 		},
 		{
 			input: "off",
-			want:  result{arg: SetAnnounceArgs{NoAnnounce: true}},
+			want:  result{arg: SetAnnounceArgs{Mode: popple.AnnounceOff}},
 		},
 		{
 			input: "no",
-			want:  result{arg: SetAnnounceArgs{NoAnnounce: true}},
+			want:  result{arg: SetAnnounceArgs{Mode: popple.AnnounceOff}},
+		},
+		{
+			input: "react",
+			want:  result{arg: SetAnnounceArgs{Mode: popple.AnnounceReact}},
+		},
+		{
+			input: "reply",
+			want:  result{arg: SetAnnounceArgs{Mode: popple.AnnounceReply}},
+		},
+		{
+			input: "digest",
+			want:  result{arg: SetAnnounceArgs{Mode: popple.AnnounceDigest, DigestInterval: DefaultDigestInterval}},
+		},
+		{
+			input: "digest 30",
+			want:  result{arg: SetAnnounceArgs{Mode: popple.AnnounceDigest, DigestInterval: 30 * time.Second}},
+		},
+		{
+			input: "digest 0",
+			want:  result{err: ErrInvalidArgument},
+		},
+		{
+			input: "react 30",
+			want:  result{err: ErrInvalidArgument},
 		},
 		{
 			input: "",
@@ -156,6 +185,18 @@ func TestBoardArgs(t *testing.T) {
 			input: "nonsense",
 			want:  result{err: ErrInvalidArgument},
 		},
+		{
+			input: "here",
+			want:  result{args: BoardArgs{Limit: DefaultLimit, Here: true}},
+		},
+		{
+			input: "5 here",
+			want:  result{args: BoardArgs{Limit: 5, Here: true}},
+		},
+		{
+			input: "here here",
+			want:  result{err: ErrInvalidArgument},
+		},
 	}
 
 	for _, tt := range tests {
@@ -173,3 +214,307 @@ func TestBoardArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestChannelsArgs(t *testing.T) {
+	type result struct {
+		args ChannelsArgs
+		err  error
+	}
+
+	tests := []struct {
+		input string
+		want  result
+	}{
+		{
+			input: "all",
+			want:  result{args: ChannelsArgs{Filter: popple.ChannelFilterNone}},
+		},
+		{
+			input: "allow <#123> 456",
+			want:  result{args: ChannelsArgs{Filter: popple.ChannelFilterAllow, Channels: []string{"123", "456"}}},
+		},
+		{
+			input: "allow <#C024BE91L>",
+			want:  result{args: ChannelsArgs{Filter: popple.ChannelFilterAllow, Channels: []string{"C024BE91L"}}},
+		},
+		{
+			input: "deny here",
+			want:  result{args: ChannelsArgs{Filter: popple.ChannelFilterDeny, Here: true}},
+		},
+		{
+			input: "deny",
+			want:  result{err: ErrMissingArgument},
+		},
+		{
+			input: "allow #General &ops",
+			want:  result{args: ChannelsArgs{Filter: popple.ChannelFilterAllow, Channels: []string{"#general", "&ops"}}},
+		},
+		{
+			input: "deny !OpaqueID:example.org",
+			want:  result{args: ChannelsArgs{Filter: popple.ChannelFilterDeny, Channels: []string{"!OpaqueID:example.org"}}},
+		},
+		{
+			input: "allow #",
+			want:  result{err: ErrInvalidArgument},
+		},
+		{
+			input: "allow !OpaqueID",
+			want:  result{err: ErrInvalidArgument},
+		},
+		{
+			input: "allow general",
+			want:  result{err: ErrInvalidArgument},
+		},
+		{
+			input: "",
+			want:  result{err: ErrMissingArgument},
+		},
+		{
+			input: "bogus",
+			want:  result{err: ErrInvalidArgument},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var got ChannelsArgs
+			err := got.ParseArg(tt.input)
+
+			if !errors.Is(err, tt.want.err) {
+				t.Errorf("want err=%v, got err=%v", tt.want.err, err)
+			}
+
+			if tt.want.err == nil && !reflect.DeepEqual(got, tt.want.args) {
+				t.Errorf("want arg=%v, got arg=%v", tt.want.args, got)
+			}
+		})
+	}
+}
+
+func TestPoolArgs(t *testing.T) {
+	type result struct {
+		arg PoolArgs
+		err error
+	}
+
+	tests := []struct {
+		input string
+		want  result
+	}{
+		{
+			input: "on",
+			want:  result{arg: PoolArgs{Pooled: true}},
+		},
+		{
+			input: "no",
+			want:  result{arg: PoolArgs{Pooled: false}},
+		},
+		{
+			input: "",
+			want:  result{err: ErrMissingArgument},
+		},
+		{
+			input: "bogus",
+			want:  result{err: ErrInvalidArgument},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var got PoolArgs
+			err := got.ParseArg(tt.input)
+
+			if !errors.Is(err, tt.want.err) {
+				t.Errorf("want err=%v, got err=%v", tt.want.err, err)
+			}
+
+			if got != tt.want.arg {
+				t.Errorf("want arg=%v, got arg=%v", tt.want.arg, got)
+			}
+		})
+	}
+}
+
+func TestTemplateArgs(t *testing.T) {
+	type result struct {
+		args TemplateArgs
+		err  error
+	}
+
+	tests := []struct {
+		input string
+		want  result
+	}{
+		{
+			input: " set levels {{ range $n, $k := . }}{{ $n }}: {{ $k }}\n{{ end }}",
+			want:  result{args: TemplateArgs{Action: TemplateSet, Name: "levels", Body: "{{ range $n, $k := . }}{{ $n }}: {{ $k }}\n{{ end }}"}},
+		},
+		{
+			input: " set board ```\n{{ len . }} entries\n```",
+			want:  result{args: TemplateArgs{Action: TemplateSet, Name: "board", Body: "{{ len . }} entries"}},
+		},
+		{
+			input: " set board `{{ len . }}`",
+			want:  result{args: TemplateArgs{Action: TemplateSet, Name: "board", Body: "{{ len . }}"}},
+		},
+		{
+			input: " reset levels",
+			want:  result{args: TemplateArgs{Action: TemplateReset, Name: "levels"}},
+		},
+		{
+			input: " reset levels now",
+			want:  result{err: ErrInvalidArgument},
+		},
+		{
+			input: " set levels",
+			want:  result{err: ErrMissingArgument},
+		},
+		{
+			input: " set",
+			want:  result{err: ErrMissingArgument},
+		},
+		{
+			input: " frobnicate levels",
+			want:  result{err: ErrInvalidArgument},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var got TemplateArgs
+			err := got.ParseArg(tt.input)
+
+			if !errors.Is(err, tt.want.err) {
+				t.Errorf("want err=%v, got err=%v", tt.want.err, err)
+			}
+
+			if got != tt.want.args {
+				t.Errorf("want arg=%v, got arg=%v", tt.want.args, got)
+			}
+		})
+	}
+}
+
+func TestLanguageArgs(t *testing.T) {
+	type result struct {
+		args LanguageArgs
+		err  error
+	}
+
+	tests := []struct {
+		input string
+		want  result
+	}{
+		{
+			input: " DE",
+			want:  result{args: LanguageArgs{Code: "de"}},
+		},
+		{
+			input: "",
+			want:  result{err: ErrMissingArgument},
+		},
+		{
+			input: " de es",
+			want:  result{err: ErrInvalidArgument},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var got LanguageArgs
+			err := got.ParseArg(tt.input)
+
+			if !errors.Is(err, tt.want.err) {
+				t.Errorf("want err=%v, got err=%v", tt.want.err, err)
+			}
+
+			if got != tt.want.args {
+				t.Errorf("want arg=%v, got arg=%v", tt.want.args, got)
+			}
+		})
+	}
+}
+
+func TestEmbedsArgs(t *testing.T) {
+	type result struct {
+		arg EmbedsArgs
+		err error
+	}
+
+	tests := []struct {
+		input string
+		want  result
+	}{
+		{
+			input: "yes",
+			want:  result{arg: EmbedsArgs{On: true}},
+		},
+		{
+			input: "off",
+			want:  result{arg: EmbedsArgs{On: false}},
+		},
+		{
+			input: "sometimes",
+			want:  result{err: ErrInvalidArgument},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var got EmbedsArgs
+			err := got.ParseArg(tt.input)
+
+			if !errors.Is(err, tt.want.err) {
+				t.Errorf("want err=%v, got err=%v", tt.want.err, err)
+			}
+
+			if got != tt.want.arg {
+				t.Errorf("want arg=%v, got arg=%v", tt.want.arg, got)
+			}
+		})
+	}
+}
+
+func TestExportArgs(t *testing.T) {
+	type result struct {
+		arg ExportArgs
+		err error
+	}
+
+	tests := []struct {
+		input string
+		want  result
+	}{
+		{
+			input: "",
+			want:  result{arg: ExportArgs{Format: "json"}},
+		},
+		{
+			input: "CSV",
+			want:  result{arg: ExportArgs{Format: "csv"}},
+		},
+		{
+			input: "xml",
+			want:  result{err: ErrInvalidArgument},
+		},
+		{
+			input: "json please",
+			want:  result{err: ErrInvalidArgument},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var got ExportArgs
+			err := got.ParseArg(tt.input)
+
+			if !errors.Is(err, tt.want.err) {
+				t.Errorf("want err=%v, got err=%v", tt.want.err, err)
+			}
+
+			if got != tt.want.arg {
+				t.Errorf("want arg=%v, got arg=%v", tt.want.arg, got)
+			}
+		})
+	}
+}
@@ -1,19 +1,37 @@
 package command
 
-import "regexp"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
 
 type ArgParser interface {
 	ParseArg(s string) error
 }
 
+// Name names a command after its arguments' type, e.g.,
+// *command.LeaderboardArgs is "Leaderboard".
+func Name(args ArgParser) string {
+	name := fmt.Sprintf("%T", args)
+	name = name[strings.LastIndex(name, ".")+1:]
+	return strings.TrimSuffix(name, "Args")
+}
+
 type ArgConstructor func() ArgParser
 
 type Router struct {
-	name     string
+	name     func() string
 	handlers map[*regexp.Regexp]ArgConstructor
 }
 
 func NewRouter(name string) *Router {
+	return NewRouterFunc(func() string { return name })
+}
+
+// NewRouterFunc routes on whatever name returns when a message arrives, for
+// chat services where the bot's name can change while it's connected.
+func NewRouterFunc(name func() string) *Router {
 	r := Router{
 		name:     name,
 		handlers: make(map[*regexp.Regexp]ArgConstructor),
@@ -24,21 +42,34 @@ func NewRouter(name string) *Router {
 		"karma":    func() ArgParser { return new(CheckKarmaArgs) },
 		"top":      func() ArgParser { return new(LeaderboardArgs) },
 		"bot":      func() ArgParser { return new(LoserboardArgs) },
+		"channels": func() ArgParser { return new(ChannelsArgs) },
+		"pool":     func() ArgParser { return new(PoolArgs) },
+		"template": func() ArgParser { return new(TemplateArgs) },
+		"language": func() ArgParser { return new(LanguageArgs) },
+		"embeds":   func() ArgParser { return new(EmbedsArgs) },
+		"export":   func() ArgParser { return new(ExportArgs) },
 	}
 
 	// install handlers
-	// the prefix requires the message to be prefaced with the bot's name
-	prefix := `^(` + r.name + `)`
+	// the handlers match what follows the bot's name
 	for cmd, ctor := range handlers {
-		r.handlers[regexp.MustCompile(prefix+"\\s+"+cmd)] = ctor
+		r.handlers[regexp.MustCompile("^\\s+"+cmd)] = ctor
 	}
 
 	return &r
 }
 
+// Route picks the command for s. Commands must be prefaced with the bot's
+// name, in any case.
 func (r *Router) Route(s string) (args ArgParser, remainder string) {
+	name := r.name()
+	if len(s) < len(name) || !strings.EqualFold(s[:len(name)], name) {
+		return new(ChangeKarmaArgs), s
+	}
+
+	rest := s[len(name):]
 	for matcher, action := range r.handlers {
-		if matched := matcher.ReplaceAllString(s, ""); matched != s {
+		if matched := matcher.ReplaceAllString(rest, ""); matched != rest {
 			return action(), matched
 		}
 	}
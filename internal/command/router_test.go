@@ -40,6 +40,48 @@ func TestRoute(t *testing.T) {
 				remainder: "",
 			},
 		},
+		{
+			input: "popple channels allow <#123>",
+			want: result{
+				typecheck: func(a ArgParser) { _ = a.(*ChannelsArgs) },
+				remainder: " allow <#123>",
+			},
+		},
+		{
+			input: "popple pool on",
+			want: result{
+				typecheck: func(a ArgParser) { _ = a.(*PoolArgs) },
+				remainder: " on",
+			},
+		},
+		{
+			input: "popple template reset levels",
+			want: result{
+				typecheck: func(a ArgParser) { _ = a.(*TemplateArgs) },
+				remainder: " reset levels",
+			},
+		},
+		{
+			input: "popple language de",
+			want: result{
+				typecheck: func(a ArgParser) { _ = a.(*LanguageArgs) },
+				remainder: " de",
+			},
+		},
+		{
+			input: "popple embeds on",
+			want: result{
+				typecheck: func(a ArgParser) { _ = a.(*EmbedsArgs) },
+				remainder: " on",
+			},
+		},
+		{
+			input: "popple export csv",
+			want: result{
+				typecheck: func(a ArgParser) { _ = a.(*ExportArgs) },
+				remainder: " csv",
+			},
+		},
 		{
 			input: "some text",
 			want: result{
@@ -90,3 +132,51 @@ func TestRoute(t *testing.T) {
 		})
 	}
 }
+
+func TestRouteNameWithSymbols(t *testing.T) {
+	router := NewRouter("[Popple]:")
+
+	args, rem := router.Route("[Popple]: karma potato")
+	if _, ok := args.(*CheckKarmaArgs); !ok {
+		t.Errorf("want *CheckKarmaArgs, got %T", args)
+	}
+	if rem != " potato" {
+		t.Errorf("want remainder %q, got remainder %q", " potato", rem)
+	}
+
+	args, _ = router.Route("P: karma potato")
+	if _, ok := args.(*ChangeKarmaArgs); !ok {
+		t.Errorf("want *ChangeKarmaArgs, got %T", args)
+	}
+}
+
+func TestRouteIgnoresNameCase(t *testing.T) {
+	router := NewRouter("@Popple")
+
+	args, rem := router.Route("@popple top 3")
+	if _, ok := args.(*LeaderboardArgs); !ok {
+		t.Errorf("want *LeaderboardArgs, got %T", args)
+	}
+	if rem != " 3" {
+		t.Errorf("want remainder %q, got remainder %q", " 3", rem)
+	}
+}
+
+func TestRouteFollowsName(t *testing.T) {
+	name := "Popple:"
+	router := NewRouterFunc(func() string { return name })
+
+	name = "Popple_:"
+	args, rem := router.Route("popple_: karma potato")
+	if _, ok := args.(*CheckKarmaArgs); !ok {
+		t.Errorf("want *CheckKarmaArgs, got %T", args)
+	}
+	if rem != " potato" {
+		t.Errorf("want remainder %q, got remainder %q", " potato", rem)
+	}
+
+	args, _ = router.Route("Popple: karma potato")
+	if _, ok := args.(*ChangeKarmaArgs); !ok {
+		t.Errorf("want *ChangeKarmaArgs, got %T", args)
+	}
+}
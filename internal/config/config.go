@@ -0,0 +1,281 @@
+// Package config gathers Popple's settings from a config file, the
+// environment and command-line flags.
+//
+// Every setting is named by an environment variable, e.g.,
+// POPPLE_DISCORD_TOKEN, and the rest of Popple looks settings up by that
+// name. A setting can also come from a file named by the variable with a
+// _FILE suffix, which is how Docker secrets are mounted, from a flag named
+// after the variable, e.g., -discord-token, or from the config file. Flags
+// win over the environment, and the environment wins over the config file.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+// PathKey names the config file when the -config flag doesn't.
+const PathKey = "POPPLE_CONFIG"
+
+// FileSuffix is added to a setting's name to read the setting from a file.
+const FileSuffix = "_FILE"
+
+var ErrConflict = errors.New("set more than once")
+
+// File is the config file. Settings that aren't needed can be left out.
+type File struct {
+	Backend string `yaml:"backend" env:"POPPLE_BACKEND"`
+
+	Log     logConfig     `yaml:"log"`
+	SQLite  sqliteConfig  `yaml:"sqlite"`
+	Backup  backupConfig  `yaml:"backup"`
+	Discord discordConfig `yaml:"discord"`
+	Slack   slackConfig   `yaml:"slack"`
+	IRC     ircConfig     `yaml:"irc"`
+	Matrix  matrixConfig  `yaml:"matrix"`
+	HTTPAPI httpAPIConfig `yaml:"http_api"`
+	Metrics metricsConfig `yaml:"metrics"`
+	Health  healthConfig  `yaml:"health"`
+	Tracing tracingConfig `yaml:"tracing"`
+}
+
+type logConfig struct {
+	Level         string `yaml:"level" env:"POPPLE_LOG_LEVEL"`
+	Format        string `yaml:"format" env:"POPPLE_LOG_FORMAT"`
+	RedactContent bool   `yaml:"redact_content" env:"POPPLE_LOG_REDACT_CONTENT"`
+}
+
+type sqliteConfig struct {
+	Path string `yaml:"path" env:"POPPLE_SQLITE_DB_PATH"`
+}
+
+type backupConfig struct {
+	Dir      string        `yaml:"dir" env:"POPPLE_BACKUP_DIR"`
+	Interval time.Duration `yaml:"interval" env:"POPPLE_BACKUP_INTERVAL"`
+	// Keep is a pointer because 0 means to keep every backup.
+	Keep *int `yaml:"keep" env:"POPPLE_BACKUP_KEEP"`
+}
+
+type discordConfig struct {
+	Token string `yaml:"token" env:"POPPLE_DISCORD_TOKEN"`
+}
+
+type slackConfig struct {
+	BotToken string `yaml:"bot_token" env:"POPPLE_SLACK_BOT_TOKEN"`
+	AppToken string `yaml:"app_token" env:"POPPLE_SLACK_APP_TOKEN"`
+	APIURL   string `yaml:"api_url" env:"POPPLE_SLACK_API_URL"`
+}
+
+type ircConfig struct {
+	Addr         string   `yaml:"addr" env:"POPPLE_IRC_ADDR"`
+	Network      string   `yaml:"network" env:"POPPLE_IRC_NETWORK"`
+	Nick         string   `yaml:"nick" env:"POPPLE_IRC_NICK"`
+	Channels     []string `yaml:"channels" env:"POPPLE_IRC_CHANNELS"`
+	SASLUser     string   `yaml:"sasl_user" env:"POPPLE_IRC_SASL_USER"`
+	SASLPassword string   `yaml:"sasl_password" env:"POPPLE_IRC_SASL_PASSWORD"`
+}
+
+type matrixConfig struct {
+	Homeserver  string   `yaml:"homeserver" env:"POPPLE_MATRIX_HOMESERVER"`
+	AccessToken string   `yaml:"access_token" env:"POPPLE_MATRIX_ACCESS_TOKEN"`
+	Admins      []string `yaml:"admins" env:"POPPLE_MATRIX_ADMINS"`
+}
+
+type httpAPIConfig struct {
+	Addr       string `yaml:"addr" env:"POPPLE_HTTP_API_ADDR"`
+	Token      string `yaml:"token" env:"POPPLE_HTTP_API_TOKEN"`
+	WriteToken string `yaml:"write_token" env:"POPPLE_HTTP_API_WRITE_TOKEN"`
+}
+
+type metricsConfig struct {
+	Addr string `yaml:"addr" env:"POPPLE_METRICS_ADDR"`
+}
+
+type healthConfig struct {
+	Addr         string        `yaml:"addr" env:"POPPLE_HEALTH_ADDR"`
+	QuietTimeout time.Duration `yaml:"quiet_timeout" env:"POPPLE_HEALTH_QUIET_TIMEOUT"`
+}
+
+type tracingConfig struct {
+	Endpoint string `yaml:"endpoint" env:"POPPLE_TRACING_ENDPOINT"`
+}
+
+// Setting is one of Popple's settings.
+type Setting struct {
+	// Key is the setting's environment variable.
+	Key string
+	// Path is where the setting is in the config file, e.g., sqlite.path.
+	Path string
+}
+
+// Flag is the name of the setting's flag.
+func (s Setting) Flag() string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(s.Key, "POPPLE_"), "_", "-"))
+}
+
+// Settings lists every setting in the order they appear in File.
+func Settings() []Setting {
+	var settings []Setting
+	walk(reflect.ValueOf(File{}), "", func(s Setting, _ reflect.Value) {
+		settings = append(settings, s)
+	})
+	return settings
+}
+
+// walk calls f with each setting in v, a File or one of its sections.
+func walk(v reflect.Value, prefix string, f func(Setting, reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		path := prefix + name
+
+		key, ok := field.Tag.Lookup("env")
+		if !ok {
+			walk(v.Field(i), path+".", f)
+			continue
+		}
+		f(Setting{Key: key, Path: path}, v.Field(i))
+	}
+}
+
+// Load reads the settings in the config file at path. An empty path reads
+// no file.
+func Load(path string) (map[string]string, error) {
+	if len(path) == 0 {
+		return map[string]string{}, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file File
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return file.values(), nil
+}
+
+// values flattens the file into settings by key. Settings the file leaves
+// out aren't included.
+func (f File) values() map[string]string {
+	values := make(map[string]string)
+	walk(reflect.ValueOf(f), "", func(s Setting, v reflect.Value) {
+		switch val := v.Interface().(type) {
+		case string:
+			if len(val) > 0 {
+				values[s.Key] = val
+			}
+		case bool:
+			if val {
+				values[s.Key] = strconv.FormatBool(val)
+			}
+		case time.Duration:
+			if val != 0 {
+				values[s.Key] = val.String()
+			}
+		case *int:
+			if val != nil {
+				values[s.Key] = strconv.Itoa(*val)
+			}
+		case []string:
+			if len(val) > 0 {
+				values[s.Key] = strings.Join(val, ",")
+			}
+		default:
+			panic(fmt.Sprintf("config: %s has unsupported type %T", s.Path, val))
+		}
+	})
+	return values
+}
+
+// AddFlags adds -config and a flag for every setting to fs.
+func AddFlags(fs *flag.FlagSet) {
+	fs.String("config", "", "YAML config file to read; defaults to "+PathKey)
+	for _, s := range Settings() {
+		fs.String(s.Flag(), "", fmt.Sprintf("sets %s, or %s in the config file", s.Key, s.Path))
+	}
+}
+
+// Gather gathers the settings from the flags AddFlags added to fs, which
+// has been parsed, environ and the config file.
+func Gather(fs *flag.FlagSet, environ env.Lookup) (env.Lookup, error) {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	flagValue := func(name string) string {
+		if f := fs.Lookup(name); f != nil {
+			return f.Value.String()
+		}
+		return ""
+	}
+
+	path := environ(PathKey)
+	if set["config"] {
+		path = flagValue("config")
+	}
+	file, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, s := range Settings() {
+		if set[s.Flag()] {
+			values[s.Key] = flagValue(s.Flag())
+			continue
+		}
+
+		val, err := fromEnv(s.Key, environ)
+		if err != nil {
+			return nil, err
+		}
+		if len(val) == 0 {
+			val = file[s.Key]
+		}
+		values[s.Key] = val
+	}
+
+	lookup := func(key string) string {
+		if val, ok := values[key]; ok {
+			return val
+		}
+		return environ(key)
+	}
+	return lookup, nil
+}
+
+// fromEnv reads key from environ, or from the file key_FILE names.
+func fromEnv(key string, environ env.Lookup) (string, error) {
+	val, path := environ(key), environ(key+FileSuffix)
+	if len(path) == 0 {
+		return val, nil
+	}
+	if len(val) > 0 {
+		return "", fmt.Errorf("%s and %s%s: %w", key, key, FileSuffix, ErrConflict)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s%s: %w", key, FileSuffix, err)
+	}
+	// Secrets are usually written with a trailing newline.
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
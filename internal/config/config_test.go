@@ -0,0 +1,168 @@
+package config_test
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/connorkuehl/popple/internal/config"
+)
+
+func environ(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func TestLoad(t *testing.T) {
+	got, err := config.Load(filepath.Join("testdata", "popple.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"POPPLE_BACKEND":              "irc",
+		"POPPLE_LOG_FORMAT":           "json",
+		"POPPLE_LOG_REDACT_CONTENT":   "true",
+		"POPPLE_SQLITE_DB_PATH":       "/var/lib/popple/popple.sqlite",
+		"POPPLE_BACKUP_DIR":           "/var/backups/popple",
+		"POPPLE_BACKUP_INTERVAL":      "12h0m0s",
+		"POPPLE_BACKUP_KEEP":          "0",
+		"POPPLE_IRC_ADDR":             "irc.libera.chat:6697",
+		"POPPLE_IRC_CHANNELS":         "#popple,#zelda",
+		"POPPLE_HEALTH_ADDR":          ":8081",
+		"POPPLE_HEALTH_QUIET_TIMEOUT": "15m0s",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "unknown setting", input: "discord:\n  tokn: abc\n", want: "field tokn not found"},
+		{name: "bad duration", input: "backup:\n  interval: daily\n", want: "daily"},
+		{name: "bad number", input: "backup:\n  keep: lots\n", want: "lots"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "popple.yaml")
+			if err := os.WriteFile(path, []byte(tt.input), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := config.Load(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), path) {
+				t.Errorf("want an error about %q in %s, got %v", tt.want, path, err)
+			}
+		})
+	}
+}
+
+// parse parses args as popple's flags and gathers the settings.
+func parse(t *testing.T, args []string, env map[string]string) (func(string) string, []string, error) {
+	t.Helper()
+
+	fs := flag.NewFlagSet("popple", flag.ContinueOnError)
+	config.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := config.Gather(fs, environ(env))
+	return settings, fs.Args(), err
+}
+
+func TestGather(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(secret, []byte("from a secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, args, err := parse(t,
+		[]string{"-config", filepath.Join("testdata", "popple.yaml"), "-backend", "discord", "-health-addr", ":9000", "export", "-server", "123"},
+		map[string]string{
+			"POPPLE_BACKEND":            "slack",
+			"POPPLE_HEALTH_ADDR":        ":8000",
+			"POPPLE_SQLITE_DB_PATH":     "from-env.sqlite",
+			"POPPLE_DISCORD_TOKEN_FILE": secret,
+			"SOMETHING_ELSE":            "untouched",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantArgs := []string{"export", "-server", "123"}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("want args %v, got %v", wantArgs, args)
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "POPPLE_BACKEND", want: "discord"},
+		{key: "POPPLE_HEALTH_ADDR", want: ":9000"},
+		{key: "POPPLE_SQLITE_DB_PATH", want: "from-env.sqlite"},
+		{key: "POPPLE_DISCORD_TOKEN", want: "from a secret"},
+		{key: "POPPLE_BACKUP_DIR", want: "/var/backups/popple"},
+		{key: "POPPLE_METRICS_ADDR", want: ""},
+		{key: "SOMETHING_ELSE", want: "untouched"},
+	}
+	for _, tt := range tests {
+		if got := settings(tt.key); got != tt.want {
+			t.Errorf("%s: want %q, got %q", tt.key, tt.want, got)
+		}
+	}
+}
+
+func TestGatherConfigFromEnv(t *testing.T) {
+	settings, _, err := parse(t, nil, map[string]string{
+		config.PathKey: filepath.Join("testdata", "popple.yaml"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := settings("POPPLE_BACKEND"); got != "irc" {
+		t.Errorf("want irc, got %q", got)
+	}
+}
+
+func TestGatherRejects(t *testing.T) {
+	_, _, err := parse(t, nil, map[string]string{
+		"POPPLE_DISCORD_TOKEN":      "abc",
+		"POPPLE_DISCORD_TOKEN_FILE": "/run/secrets/discord_token",
+	})
+	if !errors.Is(err, config.ErrConflict) {
+		t.Errorf("want %v, got %v", config.ErrConflict, err)
+	}
+
+	_, _, err = parse(t, nil, map[string]string{
+		"POPPLE_DISCORD_TOKEN_FILE": filepath.Join(t.TempDir(), "missing"),
+	})
+	if !errors.Is(err, os.ErrNotExist) || !strings.Contains(err.Error(), "POPPLE_DISCORD_TOKEN_FILE") {
+		t.Errorf("want an error about POPPLE_DISCORD_TOKEN_FILE, got %v", err)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	for _, s := range config.Settings() {
+		if !strings.HasPrefix(s.Key, "POPPLE_") || strings.HasSuffix(s.Key, config.FileSuffix) {
+			t.Errorf("%s: settings are named POPPLE_*, and can't end in %s", s.Key, config.FileSuffix)
+		}
+	}
+
+	s := config.Setting{Key: "POPPLE_HTTP_API_ADDR", Path: "http_api.addr"}
+	if got := s.Flag(); got != "http-api-addr" {
+		t.Errorf("want http-api-addr, got %q", got)
+	}
+}
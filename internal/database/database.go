@@ -1,7 +1,116 @@
 package database
 
-import "errors"
+import (
+	"errors"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/popple"
+)
 
 var (
 	ErrNotFound = errors.New("not found")
 )
+
+// IdempotentResponse is a response remembered for an idempotency key so
+// that a retried request gets the same response instead of being applied
+// again.
+type IdempotentResponse struct {
+	// RequestHash identifies the request the key was first used with.
+	RequestHash string
+	// Status is zero until the request has been applied.
+	Status int
+	Body   []byte
+}
+
+// WebhookDelivery is a karma event waiting to be delivered to a webhook.
+type WebhookDelivery struct {
+	ID      int64
+	Webhook popple.Webhook
+	// Event is the part of the karma event the webhook wants.
+	Event     popple.KarmaEvent
+	CreatedAt time.Time
+	// Attempts is how many times delivery has failed so far.
+	Attempts int
+}
+
+// WebhookDeadLetter is a delivery that was given up on.
+type WebhookDeadLetter struct {
+	ID        int64
+	WebhookID int64
+	// URL is where the webhook pointed when the delivery was given up on.
+	URL       string
+	Event     popple.KarmaEvent
+	QueuedAt  time.Time
+	FailedAt  time.Time
+	Attempts  int
+	LastError string
+}
+
+// EntityRecord is an entity along with the channel whose pool it's in. The
+// channel is empty for server-wide entities.
+type EntityRecord struct {
+	ChannelID string
+	popple.Entity
+}
+
+// EventRecord is one entity's part in a karma event.
+type EventRecord struct {
+	CreatedAt time.Time
+	ChannelID string
+	Name      string
+	Delta     int64
+	Actor     string
+	Reason    string
+}
+
+// Import is a server's data to import.
+type Import struct {
+	// Config is nil to leave the server's config alone.
+	Config   *popple.ServerConfig
+	Entities []EntityRecord
+	Events   []EventRecord
+}
+
+// Conflict is what an import does with an entity or config that already
+// exists.
+type Conflict string
+
+const (
+	// ConflictOverwrite replaces what exists with what's imported.
+	ConflictOverwrite Conflict = "overwrite"
+	// ConflictAdd adds imported karma to existing karma. An existing
+	// config is kept.
+	ConflictAdd Conflict = "add"
+	// ConflictSkip keeps what exists.
+	ConflictSkip Conflict = "skip"
+)
+
+type ImportOptions struct {
+	Conflict Conflict
+	// DryRun tallies what the import would do without changing anything.
+	DryRun bool
+}
+
+// ImportResult tallies what an import did.
+type ImportResult struct {
+	ConfigImported  bool
+	EntitiesCreated int
+	EntitiesUpdated int
+	EntitiesSkipped int
+	// Events that were already recorded are skipped whatever the conflict
+	// strategy.
+	EventsAdded   int
+	EventsSkipped int
+}
+
+// Stats counts what's in the database.
+type Stats struct {
+	// Servers is how many servers have karma or settings.
+	Servers     int64
+	Entities    int64
+	KarmaEvents int64
+	Webhooks    int64
+	// PendingDeliveries are webhook deliveries that haven't succeeded yet.
+	PendingDeliveries int64
+	DeadLetters       int64
+}
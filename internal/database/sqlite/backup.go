@@ -0,0 +1,248 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+const (
+	// DefaultBackupInterval is how often the database is backed up.
+	DefaultBackupInterval = 24 * time.Hour
+	// DefaultBackupKeep is how many backups are kept before the oldest
+	// are removed.
+	DefaultBackupKeep = 7
+
+	backupPrefix = "popple-"
+	backupSuffix = ".db"
+	backupLayout = "20060102T150405.000000Z"
+)
+
+var (
+	ErrNotPopple       = errors.New("not a Popple database")
+	ErrNewerSchema     = errors.New("database is from a newer version of Popple")
+	ErrCorruptDatabase = errors.New("database failed its integrity check")
+)
+
+// BackupConfig is where and how often the database is backed up. Backups
+// are off if Dir is empty.
+type BackupConfig struct {
+	Dir      string
+	Interval time.Duration
+	// Keep is how many backups to keep. Zero keeps them all.
+	Keep int
+}
+
+func BackupConfigFromEnv(lookup env.Lookup) (BackupConfig, error) {
+	return backupConfigFromEnv(lookup)
+}
+
+// Backup writes a consistent copy of the database to path, which must not
+// exist. It's safe to call while the database is in use.
+func (d *DB) Backup(ctx context.Context, path string) error {
+	_, err := d.db.ExecContext(ctx, `VACUUM INTO $1`, path)
+	return err
+}
+
+// BackupTo writes a timestamped backup to dir and then removes all but the
+// newest keep backups there. It returns the backup's path.
+func (d *DB) BackupTo(ctx context.Context, dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, backupPrefix+time.Now().UTC().Format(backupLayout)+backupSuffix)
+	if err := d.Backup(ctx, path); err != nil {
+		return "", err
+	}
+
+	return path, pruneBackups(dir, keep)
+}
+
+// backups lists the backups in dir, oldest first.
+func backups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if _, ok := backupTime(entry.Name()); ok && entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	// The timestamps sort in the order they were taken.
+	sort.Strings(names)
+	return names, nil
+}
+
+// backupTime is when the backup called name was taken.
+func backupTime(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+		return time.Time{}, false
+	}
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix)
+	t, err := time.Parse(backupLayout, stamp)
+	return t, err == nil
+}
+
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	names, err := backups(dir)
+	if err != nil {
+		return err
+	}
+
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// Backups backs up the database on a schedule.
+type Backups struct {
+	db     *DB
+	config BackupConfig
+}
+
+func NewBackups(db *DB, config BackupConfig) *Backups {
+	return &Backups{db: db, config: config}
+}
+
+// Run backs up the database every Interval until ctx is canceled. The
+// interval counts from the newest backup already in Dir, so restarting
+// Popple doesn't back up any more often.
+func (b *Backups) Run(ctx context.Context) error {
+	if len(b.config.Dir) == 0 {
+		<-ctx.Done()
+		return nil
+	}
+
+	ll := log.WithField("dir", b.config.Dir)
+	for {
+		wait := b.config.Interval - time.Since(b.newest())
+		if wait <= 0 {
+			path, err := b.db.BackupTo(ctx, b.config.Dir, b.config.Keep)
+			if err != nil && ctx.Err() == nil {
+				ll.WithError(err).Error("backup")
+			}
+			if err == nil {
+				ll.WithField("path", path).Info("backed up the database")
+			}
+			wait = b.config.Interval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// newest is when the newest backup was taken, or the zero time if there
+// are none.
+func (b *Backups) newest() time.Time {
+	names, err := backups(b.config.Dir)
+	if err != nil || len(names) == 0 {
+		return time.Time{}
+	}
+	t, _ := backupTime(names[len(names)-1])
+	return t
+}
+
+// Restore replaces the database at to with the backup at from. The backup
+// must pass an integrity check and can't be from a newer version of
+// Popple. The database it replaces, if any, is kept beside it with a
+// ".pre-restore" suffix. Popple must not be running.
+//
+// It returns the backup's schema version, which is older than Popple's if
+// the backup was taken before an upgrade.
+func Restore(ctx context.Context, from string, to Path) (int, error) {
+	if _, err := os.Stat(from); err != nil {
+		return 0, err
+	}
+
+	// The backup is opened read-only so that checking it doesn't change it,
+	// e.g., by switching it to WAL the way New does.
+	db, err := sql.Open("sqlite", "file:"+from+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	backup := &DB{db: db}
+
+	version, err := backup.check(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", from, err)
+	}
+
+	// Copy the backup beside the database so that swapping it in is a
+	// rename, which can't leave half a database behind.
+	tmp := string(to) + ".restoring"
+	_ = os.Remove(tmp)
+	if err := backup.Backup(ctx, tmp); err != nil {
+		return 0, err
+	}
+
+	// A journal left beside the old database would be applied to the new
+	// one, so it goes with the old database.
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		err := os.Rename(string(to)+suffix, string(to)+suffix+".pre-restore")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			_ = os.Remove(tmp)
+			return 0, err
+		}
+	}
+
+	return version, os.Rename(tmp, string(to))
+}
+
+// check makes sure the database is a Popple database this version of
+// Popple can use, and returns its schema version.
+func (d *DB) check(ctx context.Context) (int, error) {
+	var result string
+	err := d.db.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&result)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && result != "ok") {
+		return 0, fmt.Errorf("%w: %s", ErrCorruptDatabase, result)
+	}
+	if err != nil {
+		// SQLite says a file that isn't a database is corrupt too.
+		return 0, fmt.Errorf("%w: %v", ErrCorruptDatabase, err)
+	}
+
+	version, err := d.SchemaVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	switch latest := LatestSchemaVersion(); {
+	case version == 0:
+		return 0, ErrNotPopple
+	case version > latest:
+		return 0, fmt.Errorf("%w: schema version %d, want at most %d", ErrNewerSchema, version, latest)
+	}
+	return version, nil
+}
@@ -0,0 +1,227 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/popple"
+)
+
+func TestBackupTo(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	dir := t.TempDir()
+	// Something that isn't a backup is never pruned.
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := db.BackupTo(ctx, dir, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, filepath.Join(dir, entry.Name()))
+	}
+
+	want := []string{filepath.Join(dir, "notes.txt"), paths[1], paths[2]}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for _, path := range want {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("want %s kept: %v", path, err)
+		}
+	}
+}
+
+func TestBackupsRun(t *testing.T) {
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	dir := t.TempDir()
+	config := sqlite.BackupConfig{Dir: dir, Interval: time.Hour, Keep: 3}
+
+	// The first run has no backups yet, so it backs up right away. The
+	// second is a restart within the interval, so it waits.
+	for run := 0; run < 2; run++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- sqlite.NewBackups(db, config).Run(ctx) }()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) > 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("want 1 backup, got %d", len(entries))
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	if err := db.PutEntities(ctx, "123", popple.Entity{Name: "link", Karma: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	backup, err := db.BackupTo(ctx, dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := sqlite.Path(filepath.Join(dir, "popple.db"))
+	if err := os.WriteFile(string(to), []byte("the old database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := sqlite.Restore(ctx, backup, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest := sqlite.LatestSchemaVersion(); version != latest {
+		t.Errorf("want version %d, got %d", latest, version)
+	}
+
+	old, err := os.ReadFile(string(to) + ".pre-restore")
+	if err != nil || string(old) != "the old database" {
+		t.Errorf("want the old database kept, got %q, %v", old, err)
+	}
+
+	restored, cleanup, err := sqlite.New(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	entities, err := restored.Entities(ctx, "123", "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 1 || entities[0].Karma != 3 {
+		t.Errorf("want link with 3 karma, got %+v", entities)
+	}
+}
+
+func TestRestoreRefuses(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	newer := func(t *testing.T) string {
+		db, cleanup, err := sqlite.NewInMemory()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanup()
+
+		path, err := db.BackupTo(ctx, dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Pretend a later version of Popple migrated it further.
+		exec(t, path, `PRAGMA user_version = 1000`)
+		return path
+	}
+
+	notPopple := func(t *testing.T) string {
+		path := filepath.Join(dir, "notes.db")
+		exec(t, path, `CREATE TABLE notes (body TEXT)`)
+		return path
+	}
+
+	garbage := func(t *testing.T) string {
+		path := filepath.Join(dir, "garbage.db")
+		if err := os.WriteFile(path, []byte("definitely not a database, but long enough to have a header of sorts....."), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name   string
+		backup func(t *testing.T) string
+		want   error
+	}{
+		{name: "newer", backup: newer, want: sqlite.ErrNewerSchema},
+		{name: "not popple", backup: notPopple, want: sqlite.ErrNotPopple},
+		{name: "garbage", backup: garbage, want: sqlite.ErrCorruptDatabase},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			to := sqlite.Path(filepath.Join(dir, "popple.db"))
+			_, err := sqlite.Restore(ctx, tt.backup(t), to)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("want %v, got %v", tt.want, err)
+			}
+			if _, err := os.Stat(string(to)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("want nothing restored, got %v", err)
+			}
+		})
+	}
+}
+
+// exec runs query against the database at path.
+func exec(t *testing.T, path, query string) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(query); err != nil {
+		t.Fatal(err)
+	}
+}
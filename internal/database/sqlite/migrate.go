@@ -0,0 +1,171 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrUnversioned = errors.New("database predates schema versions")
+
+// Migration is one of the changes to the schema, numbered in the order
+// they're applied.
+type Migration struct {
+	Version int
+	Name    string
+}
+
+// Migrations lists every migration Popple has, oldest first.
+func Migrations() []Migration {
+	ups, _ := fs.Glob(migrations, "migrations/*.up.sql")
+
+	var ms []Migration
+	for _, name := range ups {
+		base := strings.TrimSuffix(path.Base(name), ".up.sql")
+		prefix, rest, _ := strings.Cut(base, "_")
+		if n, err := strconv.Atoi(prefix); err == nil {
+			ms = append(ms, Migration{Version: n, Name: rest})
+		}
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	return ms
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%06d_%s", m.Version, m.Name)
+}
+
+// PendingMigrations lists the migrations that haven't been applied to the
+// database, oldest first.
+func (d *DB) PendingMigrations(ctx context.Context) ([]Migration, error) {
+	version, err := d.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if latest := LatestSchemaVersion(); version > latest {
+		return nil, fmt.Errorf("%w: schema version %d, want at most %d", ErrNewerSchema, version, latest)
+	}
+
+	if version == 0 {
+		// Migrations were applied by hand, without recording their
+		// number, before Popple applied them itself, so a database
+		// without one may still have some applied.
+		version, err = d.handAppliedVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var pending []Migration
+	for _, m := range Migrations() {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies the pending migrations in order and returns the ones it
+// applied. Each migration is applied in its own transaction, so a failed
+// one leaves the database at the migration before it.
+func (d *DB) Migrate(ctx context.Context) ([]Migration, error) {
+	pending, err := d.PendingMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the migrations that were applied by hand, if any, so that
+	// they're known even if none of the pending ones can be applied.
+	if len(pending) > 0 && pending[0].Version > 1 {
+		if _, err := d.db.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, pending[0].Version-1)); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, m := range pending {
+		if err := d.apply(ctx, m); err != nil {
+			return pending[:i], fmt.Errorf("%s: %w", m, err)
+		}
+	}
+	return pending, nil
+}
+
+// handApplied lists what each migration that could have been applied by
+// hand added, oldest first: a table, or a column of one.
+var handApplied = []struct {
+	table, column string
+}{
+	{table: "configs"},
+	{table: "config_channels"},
+	{table: "configs", column: "announce"},
+	{table: "config_templates"},
+	{table: "configs", column: "locale"},
+	{table: "configs", column: "embeds"},
+	{table: "karma_events"},
+	{table: "karma_events", column: "actor"},
+	{table: "webhooks"},
+}
+
+// handAppliedVersion recognizes the migrations that were applied to a
+// database by hand, before migrations recorded their number, by what
+// they added. A database without any tables has none applied.
+func (d *DB) handAppliedVersion(ctx context.Context) (int, error) {
+	var tables int
+	err := d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'`).Scan(&tables)
+	if err != nil || tables == 0 {
+		return 0, err
+	}
+
+	version := 0
+	for _, added := range handApplied {
+		var n int
+		if len(added.column) == 0 {
+			err = d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1`, added.table).Scan(&n)
+		} else {
+			err = d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info($1) WHERE name = $2`, added.table, added.column).Scan(&n)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+		version++
+	}
+
+	if version == 0 {
+		return 0, fmt.Errorf("%w: its tables aren't Popple's", ErrUnversioned)
+	}
+	return version, nil
+}
+
+func (d *DB) apply(ctx context.Context, m Migration) error {
+	up, err := migrations.ReadFile("migrations/" + m.String() + ".up.sql")
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, string(up)); err != nil {
+		return err
+	}
+
+	// The migration's number comes from its file's name and is recorded
+	// here, along with its changes, rather than by the migration itself.
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, m.Version)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,259 @@
+package sqlite_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/popple"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "popple.db")
+
+	db, cleanup, err := sqlite.New(sqlite.Path(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	applied, err := db.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(sqlite.Migrations()) {
+		t.Errorf("want every migration applied, got %v", applied)
+	}
+
+	version, err := db.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest := sqlite.LatestSchemaVersion(); version != latest {
+		t.Errorf("want version %d, got %d", latest, version)
+	}
+
+	applied, err = db.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("want nothing left to apply, got %v", applied)
+	}
+}
+
+// TestMigrationsLeaveVersionToMigrate checks that the number each migration
+// is recorded under only comes from its file's name.
+func TestMigrationsLeaveVersionToMigrate(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("migrations", "*.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(strings.ToLower(string(b)), "user_version") {
+			t.Errorf("%s sets user_version, which Migrate records", file)
+		}
+	}
+}
+
+func TestMigrateHandApplied(t *testing.T) {
+	tests := []struct {
+		name    string
+		applied int
+	}{
+		{name: "baseline", applied: 1},
+		{name: "before schema versions", applied: 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "popple.db")
+			for _, m := range sqlite.Migrations()[:tt.applied] {
+				up, err := os.ReadFile(filepath.Join("migrations", m.String()+".up.sql"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				exec(t, path, string(up))
+			}
+
+			db, cleanup, err := sqlite.New(sqlite.Path(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cleanup()
+
+			applied, err := db.Migrate(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(applied) == 0 || applied[0].Version != tt.applied+1 {
+				t.Errorf("want migrations from %d applied, got %v", tt.applied+1, applied)
+			}
+
+			version, err := db.SchemaVersion(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if latest := sqlite.LatestSchemaVersion(); version != latest {
+				t.Errorf("want version %d, got %d", latest, version)
+			}
+		})
+	}
+}
+
+func TestMigrateRefuses(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup string
+		want  error
+	}{
+		{name: "unrecognized", setup: `CREATE TABLE entities (name TEXT)`, want: sqlite.ErrUnversioned},
+		{name: "newer", setup: `PRAGMA user_version = 1000`, want: sqlite.ErrNewerSchema},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "popple.db")
+			exec(t, path, tt.setup)
+
+			db, cleanup, err := sqlite.New(sqlite.Path(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cleanup()
+
+			_, err = db.Migrate(context.Background())
+			if !errors.Is(err, tt.want) {
+				t.Errorf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	if err := db.PutEntities(ctx, "123", popple.Entity{Name: "link", Karma: 1}, popple.Entity{Name: "zelda", Karma: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutChannelEntities(ctx, "456", "789", popple.Entity{Name: "ganon", Karma: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutKarmaEvents(ctx, "123", popple.KarmaEvent{ChannelID: "1", Increments: popple.Increments{"link": 1, "zelda": 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := database.Stats{Servers: 2, Entities: 3, KarmaEvents: 2}
+	if got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "popple.db")
+
+	db, cleanup, err := sqlite.New(sqlite.Path(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	if _, err := db.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, writes = 16, 50
+	errs := make(chan error, writers*writes)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				event := popple.KarmaEvent{ChannelID: "1", Increments: popple.Increments{fmt.Sprintf("writer%d", i): 1}}
+				errs <- db.PutKarmaEvents(ctx, "1", event)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := db.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.KarmaEvents != writers*writes {
+		t.Errorf("want %d karma events, got %d", writers*writes, stats.KarmaEvents)
+	}
+}
+
+func TestApplyKarmaOnce(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	event := popple.KarmaEvent{
+		ChannelID:  "1",
+		Increments: popple.Increments{"link": 1},
+		MessageID:  "100",
+	}
+	for i, want := range []bool{true, false} {
+		levels, err := db.ApplyKarma(ctx, "1", event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if applied := levels != nil; applied != want {
+			t.Errorf("attempt %d: want applied %v, got %v", i+1, want, applied)
+		}
+	}
+
+	ents, err := db.Entities(ctx, "1", "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ents[0].Karma != 1 {
+		t.Errorf("want link to have 1 karma, got %d", ents[0].Karma)
+	}
+
+	stats, err := db.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.KarmaEvents != 1 {
+		t.Errorf("want 1 karma event, got %d", stats.KarmaEvents)
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS channel_entities;
+DROP TABLE IF EXISTS config_channels;
+ALTER TABLE configs DROP COLUMN channel_filter;
@@ -0,0 +1,21 @@
+ALTER TABLE configs ADD COLUMN channel_filter INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS config_channels (
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    server_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    filtered BOOLEAN NOT NULL DEFAULT FALSE,
+    pooled BOOLEAN NOT NULL DEFAULT FALSE,
+    UNIQUE (server_id, channel_id)
+);
+
+CREATE TABLE IF NOT EXISTS channel_entities (
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    name TEXT NOT NULL,
+    server_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    karma BIGINT NOT NULL DEFAULT 0,
+    UNIQUE (name, server_id, channel_id)
+);
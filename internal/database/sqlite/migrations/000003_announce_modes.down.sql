@@ -0,0 +1,6 @@
+ALTER TABLE configs ADD COLUMN no_announce BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE configs SET no_announce = TRUE WHERE announce = 1;
+
+ALTER TABLE configs DROP COLUMN digest_interval;
+ALTER TABLE configs DROP COLUMN announce;
@@ -0,0 +1,6 @@
+ALTER TABLE configs ADD COLUMN announce INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE configs ADD COLUMN digest_interval INTEGER NOT NULL DEFAULT 0;
+
+UPDATE configs SET announce = 1 WHERE no_announce;
+
+ALTER TABLE configs DROP COLUMN no_announce;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS config_templates (
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    server_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    body TEXT NOT NULL,
+    UNIQUE (server_id, name)
+);
@@ -0,0 +1 @@
+ALTER TABLE configs ADD COLUMN locale TEXT NOT NULL DEFAULT '';
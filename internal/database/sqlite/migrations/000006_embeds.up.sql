@@ -0,0 +1 @@
+ALTER TABLE configs ADD COLUMN embeds BOOLEAN NOT NULL DEFAULT FALSE;
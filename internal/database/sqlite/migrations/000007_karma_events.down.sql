@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS karma_events_server_id_created_at;
+DROP TABLE IF EXISTS karma_events;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS karma_events (
+    created_at TIMESTAMP NOT NULL,
+    server_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    delta BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS karma_events_server_id_created_at ON karma_events (server_id, created_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS idempotency_keys;
+ALTER TABLE karma_events DROP COLUMN reason;
+ALTER TABLE karma_events DROP COLUMN actor;
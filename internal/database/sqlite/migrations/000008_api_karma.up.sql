@@ -0,0 +1,12 @@
+ALTER TABLE karma_events ADD COLUMN actor TEXT NOT NULL DEFAULT '';
+ALTER TABLE karma_events ADD COLUMN reason TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    created_at TIMESTAMP NOT NULL,
+    server_id TEXT NOT NULL,
+    key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    body BLOB NOT NULL,
+    UNIQUE (server_id, key)
+);
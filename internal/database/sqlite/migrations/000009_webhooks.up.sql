@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    id INTEGER PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    server_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_server_id ON webhooks (server_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id INTEGER PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    webhook_id INTEGER NOT NULL,
+    event BLOB NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_next_attempt_at ON webhook_deliveries (next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+    id INTEGER PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    queued_at TIMESTAMP NOT NULL,
+    webhook_id INTEGER NOT NULL,
+    server_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    event BLOB NOT NULL,
+    attempts INTEGER NOT NULL,
+    last_error TEXT NOT NULL
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS backfilled_messages (
+    created_at TIMESTAMP NOT NULL,
+    server_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    PRIMARY KEY (server_id, channel_id, message_id)
+);
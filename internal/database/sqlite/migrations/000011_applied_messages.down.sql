@@ -0,0 +1 @@
+ALTER TABLE applied_messages RENAME TO backfilled_messages;
@@ -0,0 +1 @@
+ALTER TABLE backfilled_messages RENAME TO applied_messages;
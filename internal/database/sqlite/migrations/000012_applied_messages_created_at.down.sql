@@ -0,0 +1 @@
+DROP INDEX IF EXISTS applied_messages_created_at;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS applied_messages_created_at ON applied_messages (created_at);
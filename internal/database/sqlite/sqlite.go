@@ -3,27 +3,45 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
-	"os"
+	"fmt"
+	"strings"
+	"time"
 
 	_ "modernc.org/sqlite"
 
 	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/env"
 	"github.com/connorkuehl/popple/internal/popple"
 )
 
 type Path string
 
-func PathFromEnv() (Path, error) {
-	return pathFromEnv(os.Getenv)
+func PathFromEnv(lookup env.Lookup) (Path, error) {
+	return pathFromEnv(lookup)
 }
 
 type DB struct {
 	db *sql.DB
 }
 
+// pragmas let the bot's workers, the API, the webhook worker and the
+// backups share the database file. Writers wait for each other instead of
+// failing with SQLITE_BUSY, readers don't block the writer, and
+// transactions take the write lock when they begin so that a transaction
+// that reads before it writes can't deadlock with another one.
+const pragmas = "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+
 func New(path Path) (*DB, func(), error) {
-	db, err := sql.Open("sqlite", string(path))
+	dsn := string(path)
+	if strings.Contains(dsn, "?") {
+		dsn += "&" + pragmas
+	} else {
+		dsn += "?" + pragmas
+	}
+
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -31,22 +49,113 @@ func New(path Path) (*DB, func(), error) {
 	return &DB{db: db}, func() { _ = db.Close() }, nil
 }
 
+// Ping checks that the database can be reached.
+func (d *DB) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+// SchemaVersion is the number of the newest migration that has been applied
+// to the database. Every migration records its number in the database's
+// user_version.
+func (d *DB) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := d.db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+// LatestSchemaVersion is the package's LatestSchemaVersion, for callers
+// that only have the database, such as the health checks.
+func (d *DB) LatestSchemaVersion() int {
+	return LatestSchemaVersion()
+}
+
+// LatestSchemaVersion is the number of the newest migration Popple has.
+func LatestSchemaVersion() int {
+	ms := Migrations()
+	if len(ms) == 0 {
+		return 0
+	}
+	return ms[len(ms)-1].Version
+}
+
 func (d *DB) Config(ctx context.Context, serverID string) (popple.ServerConfig, error) {
-	query := `SELECT server_id, no_announce FROM configs WHERE server_id = $1`
+	query := `SELECT server_id, announce, digest_interval, channel_filter, locale, embeds FROM configs WHERE server_id = $1`
 	args := []any{serverID}
 	r := d.db.QueryRowContext(ctx, query, args...)
 
-	var c popple.ServerConfig
-	err := r.Scan(&c.ServerID, &c.NoAnnounce)
+	var (
+		c              popple.ServerConfig
+		digestInterval int64
+	)
+	err := r.Scan(&c.ServerID, &c.Announce, &digestInterval, &c.ChannelFilter, &c.Locale, &c.Embeds)
 	if errors.Is(err, sql.ErrNoRows) {
 		err = database.ErrNotFound
 	}
 	if err != nil {
 		return popple.ServerConfig{}, err
 	}
+	c.DigestInterval = time.Duration(digestInterval) * time.Second
+
+	query = `SELECT channel_id, filtered, pooled FROM config_channels WHERE server_id = $1 ORDER BY channel_id`
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return popple.ServerConfig{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			channelID        string
+			filtered, pooled bool
+		)
+		if err := rows.Scan(&channelID, &filtered, &pooled); err != nil {
+			return popple.ServerConfig{}, err
+		}
+
+		if filtered {
+			c.FilteredChannels = append(c.FilteredChannels, channelID)
+		}
+		if pooled {
+			c.PooledChannels = append(c.PooledChannels, channelID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return popple.ServerConfig{}, err
+	}
+
+	c.Templates, err = d.templates(ctx, serverID)
+	if err != nil {
+		return popple.ServerConfig{}, err
+	}
+
 	return c, nil
 }
 
+func (d *DB) templates(ctx context.Context, serverID string) (map[string]string, error) {
+	query := `SELECT name, body FROM config_templates WHERE server_id = $1`
+	args := []any{serverID}
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates map[string]string
+	for rows.Next() {
+		var name, body string
+		if err := rows.Scan(&name, &body); err != nil {
+			return nil, err
+		}
+
+		if templates == nil {
+			templates = make(map[string]string)
+		}
+		templates[name] = body
+	}
+
+	return templates, rows.Err()
+}
+
 func (d *DB) PutConfig(ctx context.Context, config popple.ServerConfig) error {
 	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -54,30 +163,109 @@ func (d *DB) PutConfig(ctx context.Context, config popple.ServerConfig) error {
 	}
 	defer tx.Rollback()
 
-	query := `UPDATE configs SET no_announce = $1, updated_at = datetime('now') WHERE server_id = $2`
-	args := []any{config.NoAnnounce, config.ServerID}
+	if err := putConfig(ctx, tx, config); err != nil {
+		return err
+	}
+
+	if err := putConfigChannels(ctx, tx, config); err != nil {
+		return err
+	}
+
+	if err := putConfigTemplates(ctx, tx, config); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func putConfig(ctx context.Context, tx *sql.Tx, config popple.ServerConfig) error {
+	digestInterval := int64(config.DigestInterval / time.Second)
+
+	query := `UPDATE configs SET announce = $1, digest_interval = $2, channel_filter = $3, locale = $4, embeds = $5, updated_at = datetime('now') WHERE server_id = $6`
+	args := []any{config.Announce, digestInterval, config.ChannelFilter, config.Locale, config.Embeds, config.ServerID}
 	res, err := tx.ExecContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
 
 	if affected, _ := res.RowsAffected(); affected == 1 {
-		return tx.Commit()
+		return nil
 	}
 
 	query = `INSERT INTO configs (
 		created_at,
 		updated_at,
 		server_id,
-		no_announce
-		) VALUES (datetime('now'), datetime('now'), $1, $2)`
-	args = []any{config.ServerID, config.NoAnnounce}
+		announce,
+		digest_interval,
+		channel_filter,
+		locale,
+		embeds
+		) VALUES (datetime('now'), datetime('now'), $1, $2, $3, $4, $5, $6)`
+	args = []any{config.ServerID, config.Announce, digestInterval, config.ChannelFilter, config.Locale, config.Embeds}
 	_, err = tx.ExecContext(ctx, query, args...)
-	if err != nil {
+	return err
+}
+
+func putConfigChannels(ctx context.Context, tx *sql.Tx, config popple.ServerConfig) error {
+	type flags struct{ filtered, pooled bool }
+
+	channels := make(map[string]flags)
+	for _, id := range config.FilteredChannels {
+		f := channels[id]
+		f.filtered = true
+		channels[id] = f
+	}
+	for _, id := range config.PooledChannels {
+		f := channels[id]
+		f.pooled = true
+		channels[id] = f
+	}
+
+	query := `DELETE FROM config_channels WHERE server_id = $1`
+	if _, err := tx.ExecContext(ctx, query, config.ServerID); err != nil {
 		return err
 	}
 
-	return tx.Commit()
+	for id, f := range channels {
+		query := `INSERT INTO config_channels (
+			created_at,
+			updated_at,
+			server_id,
+			channel_id,
+			filtered,
+			pooled
+			) VALUES (datetime('now'), datetime('now'), $1, $2, $3, $4)`
+		args := []any{config.ServerID, id, f.filtered, f.pooled}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func putConfigTemplates(ctx context.Context, tx *sql.Tx, config popple.ServerConfig) error {
+	query := `DELETE FROM config_templates WHERE server_id = $1`
+	if _, err := tx.ExecContext(ctx, query, config.ServerID); err != nil {
+		return err
+	}
+
+	for name, body := range config.Templates {
+		query := `INSERT INTO config_templates (
+			created_at,
+			updated_at,
+			server_id,
+			name,
+			body
+			) VALUES (datetime('now'), datetime('now'), $1, $2, $3)`
+		args := []any{config.ServerID, name, body}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (d *DB) Entities(ctx context.Context, serverID string, names ...string) ([]popple.Entity, error) {
@@ -113,6 +301,14 @@ func (d *DB) PutEntities(ctx context.Context, serverID string, entities ...poppl
 	}
 	defer tx.Rollback()
 
+	if err := putEntities(ctx, tx, serverID, entities...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func putEntities(ctx context.Context, tx *sql.Tx, serverID string, entities ...popple.Entity) error {
 	upsert := func(tx *sql.Tx, entity popple.Entity) error {
 		query := `UPDATE entities SET karma = $1, updated_at = datetime('now') WHERE name = $2 AND server_id = $3`
 		args := []any{entity.Karma, entity.Name, serverID}
@@ -144,17 +340,391 @@ func (d *DB) PutEntities(ctx context.Context, serverID string, entities ...poppl
 		}
 	}
 
-	return tx.Commit()
+	return nil
 }
 
 func (d *DB) Leaderboard(ctx context.Context, serverID string, limit uint) (popple.Board, error) {
-	query := `SELECT name, karma FROM entities WHERE server_id = $1 ORDER BY karma DESC LIMIT $2`
-	args := []any{serverID, limit}
+	return d.LeaderboardPage(ctx, serverID, 0, limit)
+}
+
+// LeaderboardPage is the part of the leaderboard that starts offset
+// entries in.
+func (d *DB) LeaderboardPage(ctx context.Context, serverID string, offset, limit uint) (popple.Board, error) {
+	query := `SELECT name, karma FROM entities WHERE server_id = $1 ORDER BY karma DESC LIMIT $2 OFFSET $3`
+	args := []any{serverID, limit, offset}
+
+	return d.board(ctx, query, args...)
+}
+
+func (d *DB) Loserboard(ctx context.Context, serverID string, limit uint) (popple.Board, error) {
+	return d.LoserboardPage(ctx, serverID, 0, limit)
+}
+
+// LoserboardPage is the part of the loserboard that starts offset entries
+// in.
+func (d *DB) LoserboardPage(ctx context.Context, serverID string, offset, limit uint) (popple.Board, error) {
+	query := `SELECT name, karma FROM entities WHERE server_id = $1 ORDER BY karma ASC LIMIT $2 OFFSET $3`
+	args := []any{serverID, limit, offset}
+
+	return d.board(ctx, query, args...)
+}
+
+func (d *DB) ChannelEntities(ctx context.Context, serverID, channelID string, names ...string) ([]popple.Entity, error) {
+	var entities []popple.Entity
+
+	for _, name := range names {
+		query := `SELECT name, karma FROM channel_entities WHERE server_id = $1 AND channel_id = $2 AND name = $3`
+		args := []any{serverID, channelID, name}
+
+		var entity popple.Entity
+		row := d.db.QueryRowContext(ctx, query, args...)
+		err := row.Scan(&entity.Name, &entity.Karma)
+		if errors.Is(err, sql.ErrNoRows) {
+			entity.Name = name
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entities = append(entities, entity)
+	}
+
+	return entities, nil
+}
+
+func (d *DB) PutChannelEntities(ctx context.Context, serverID, channelID string, entities ...popple.Entity) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := putChannelEntities(ctx, tx, serverID, channelID, entities...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func putChannelEntities(ctx context.Context, tx *sql.Tx, serverID, channelID string, entities ...popple.Entity) error {
+	upsert := func(tx *sql.Tx, entity popple.Entity) error {
+		query := `UPDATE channel_entities SET karma = $1, updated_at = datetime('now') WHERE name = $2 AND server_id = $3 AND channel_id = $4`
+		args := []any{entity.Karma, entity.Name, serverID, channelID}
+
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		if affected, _ := res.RowsAffected(); affected == 1 {
+			return nil
+		}
+
+		query = `INSERT INTO channel_entities (created_at, updated_at, name, server_id, channel_id, karma) VALUES (datetime('now'), datetime('now'), $1, $2, $3, $4)`
+		args = []any{entity.Name, serverID, channelID, entity.Karma}
+
+		_, err = tx.ExecContext(ctx, query, args...)
+		return err
+	}
+
+	for _, entity := range entities {
+		err := upsert(tx, entity)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *DB) ChannelLeaderboard(ctx context.Context, serverID, channelID string, limit uint) (popple.Board, error) {
+	query := `SELECT name, karma FROM channel_entities WHERE server_id = $1 AND channel_id = $2 ORDER BY karma DESC LIMIT $3`
+	args := []any{serverID, channelID, limit}
+
+	return d.board(ctx, query, args...)
+}
+
+func (d *DB) ChannelLoserboard(ctx context.Context, serverID, channelID string, limit uint) (popple.Board, error) {
+	query := `SELECT name, karma FROM channel_entities WHERE server_id = $1 AND channel_id = $2 ORDER BY karma ASC LIMIT $3`
+	args := []any{serverID, channelID, limit}
+
+	return d.board(ctx, query, args...)
+}
+
+// idempotencyWindow is how long responses are remembered for their
+// idempotency keys, and idempotencyLease is how long a key stays reserved
+// for a request that never finished, e.g., because Popple stopped while
+// applying it.
+const (
+	idempotencyWindow = "-1 day"
+	idempotencyLease  = "-1 minute"
+)
+
+// ReserveIdempotencyKey claims key for the request with requestHash
+// before the request is applied, and forgets keys that are too old to be
+// retried or whose reservation has lapsed. If the key was already claimed,
+// it reports false along with what was remembered for it, which has no
+// status yet if that request hasn't finished.
+func (d *DB) ReserveIdempotencyKey(ctx context.Context, serverID, key, requestHash string) (database.IdempotentResponse, bool, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.IdempotentResponse{}, false, err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM idempotency_keys WHERE created_at < datetime('now', $1) OR (status = 0 AND created_at < datetime('now', $2))`
+	args := []any{idempotencyWindow, idempotencyLease}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return database.IdempotentResponse{}, false, err
+	}
+
+	query = `INSERT OR IGNORE INTO idempotency_keys (created_at, server_id, key, request_hash, status, body) VALUES (datetime('now'), $1, $2, $3, 0, x'')`
+	args = []any{serverID, key, requestHash}
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return database.IdempotentResponse{}, false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return database.IdempotentResponse{}, false, err
+	}
+	if n > 0 {
+		if err := tx.Commit(); err != nil {
+			return database.IdempotentResponse{}, false, err
+		}
+		return database.IdempotentResponse{}, true, nil
+	}
+
+	query = `SELECT request_hash, status, body FROM idempotency_keys WHERE server_id = $1 AND key = $2`
+	args = []any{serverID, key}
+
+	var rsp database.IdempotentResponse
+	row := tx.QueryRowContext(ctx, query, args...)
+	if err := row.Scan(&rsp.RequestHash, &rsp.Status, &rsp.Body); err != nil {
+		return database.IdempotentResponse{}, false, err
+	}
+	return rsp, false, tx.Commit()
+}
+
+// PutIdempotentResponse remembers the response for a reserved key.
+func (d *DB) PutIdempotentResponse(ctx context.Context, serverID, key string, rsp database.IdempotentResponse) error {
+	query := `UPDATE idempotency_keys SET status = $1, body = $2 WHERE server_id = $3 AND key = $4 AND request_hash = $5`
+	args := []any{rsp.Status, rsp.Body, serverID, key, rsp.RequestHash}
+
+	_, err := d.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ReleaseIdempotencyKey forgets a reserved key so that the request can be
+// retried.
+func (d *DB) ReleaseIdempotencyKey(ctx context.Context, serverID, key string) error {
+	query := `DELETE FROM idempotency_keys WHERE server_id = $1 AND key = $2`
+	args := []any{serverID, key}
+
+	_, err := d.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// timestamp is the layout datetime('now') writes timestamps in.
+const timestamp = "2006-01-02 15:04:05"
+
+// PutKarmaEvents records a change in karma so that karma can be tallied
+// over a window of time.
+func (d *DB) PutKarmaEvents(ctx context.Context, serverID string, event popple.KarmaEvent) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := putKarmaEvents(ctx, tx, serverID, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func putKarmaEvents(ctx context.Context, tx *sql.Tx, serverID string, event popple.KarmaEvent) error {
+	var at any
+	if !event.At.IsZero() {
+		at = event.At.UTC().Format(timestamp)
+	}
+
+	for name, delta := range event.Increments {
+		query := `INSERT INTO karma_events (created_at, server_id, channel_id, name, delta, actor, reason) VALUES (COALESCE($7, datetime('now')), $1, $2, $3, $4, $5, $6)`
+		args := []any{serverID, event.ChannelID, name, delta, event.Actor, event.Reason, at}
+
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	// Changes that happened in the past, e.g., backfilled ones, aren't
+	// news, so webhooks aren't told about them.
+	if !event.At.IsZero() {
+		return nil
+	}
+	return enqueueWebhookDeliveries(ctx, tx, serverID, event)
+}
+
+// ApplyKarma adds the event's increments to the karma of everyone in it,
+// in the channel's pool if it has one, and records the change, all at once.
+// It returns everyone's new karma. If the event came from a message that
+// has already been applied, nothing is written and the levels are nil.
+// Messages applied before the backfill window are forgotten.
+//
+// The increments are added in the database rather than read, changed and
+// written back, so changes made at the same time, even by other processes,
+// aren't lost.
+func (d *DB) ApplyKarma(ctx context.Context, serverID string, event popple.KarmaEvent) (popple.Increments, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if len(event.MessageID) > 0 {
+		// A message is applied after it's sent, so messages applied before
+		// the backfill window were sent before it too.
+		query := `DELETE FROM applied_messages WHERE created_at < $1`
+		args := []any{time.Now().Add(-popple.BackfillWindow).UTC().Format(timestamp)}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return nil, err
+		}
+
+		query = `INSERT OR IGNORE INTO applied_messages (created_at, server_id, channel_id, message_id) VALUES (datetime('now'), $1, $2, $3)`
+		args = []any{serverID, event.ChannelID, event.MessageID}
+
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return nil, err
+		}
+	}
+
+	if event.Pooled {
+		event.Levels, err = addChannelKarma(ctx, tx, serverID, event.ChannelID, event.Increments)
+	} else {
+		event.Levels, err = addKarma(ctx, tx, serverID, event.Increments)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := putKarmaEvents(ctx, tx, serverID, event); err != nil {
+		return nil, err
+	}
 
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return event.Levels, nil
+}
+
+// addKarma adds the increments to the server's karma and returns the new
+// levels.
+func addKarma(ctx context.Context, tx *sql.Tx, serverID string, increments popple.Increments) (popple.Increments, error) {
+	levels := make(popple.Increments)
+	for name, delta := range increments {
+		query := `UPDATE entities SET karma = karma + $1, updated_at = datetime('now') WHERE name = $2 AND server_id = $3`
+		args := []any{delta, name, serverID}
+
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			query = `INSERT INTO entities (created_at, updated_at, name, server_id, karma) VALUES (datetime('now'), datetime('now'), $1, $2, $3)`
+			args = []any{name, serverID, delta}
+
+			if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+				return nil, err
+			}
+		}
+
+		query = `SELECT karma FROM entities WHERE name = $1 AND server_id = $2`
+		args = []any{name, serverID}
+
+		var karma int64
+		if err := tx.QueryRowContext(ctx, query, args...).Scan(&karma); err != nil {
+			return nil, err
+		}
+		levels[name] = karma
+	}
+
+	return levels, nil
+}
+
+// addChannelKarma adds the increments to the channel's pool and returns the
+// new levels.
+func addChannelKarma(ctx context.Context, tx *sql.Tx, serverID, channelID string, increments popple.Increments) (popple.Increments, error) {
+	levels := make(popple.Increments)
+	for name, delta := range increments {
+		query := `UPDATE channel_entities SET karma = karma + $1, updated_at = datetime('now') WHERE name = $2 AND server_id = $3 AND channel_id = $4`
+		args := []any{delta, name, serverID, channelID}
+
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			query = `INSERT INTO channel_entities (created_at, updated_at, name, server_id, channel_id, karma) VALUES (datetime('now'), datetime('now'), $1, $2, $3, $4)`
+			args = []any{name, serverID, channelID, delta}
+
+			if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+				return nil, err
+			}
+		}
+
+		query = `SELECT karma FROM channel_entities WHERE name = $1 AND server_id = $2 AND channel_id = $3`
+		args = []any{name, serverID, channelID}
+
+		var karma int64
+		if err := tx.QueryRowContext(ctx, query, args...).Scan(&karma); err != nil {
+			return nil, err
+		}
+		levels[name] = karma
+	}
+
+	return levels, nil
+}
+
+// WindowLeaderboard ranks entities by the karma they've gained since,
+// starting offset entries in. Like the leaderboard, it leaves out karma
+// given in channels that keep their own pool.
+func (d *DB) WindowLeaderboard(ctx context.Context, serverID string, since time.Time, offset, limit uint) (popple.Board, error) {
+	query := `SELECT name, SUM(delta) AS karma FROM karma_events WHERE server_id = $1 AND created_at >= $2 AND ` + unpooled + ` GROUP BY name ORDER BY karma DESC LIMIT $3 OFFSET $4`
+	args := []any{serverID, since.UTC().Format(timestamp), limit, offset}
+
+	return d.board(ctx, query, args...)
+}
+
+// WindowLoserboard ranks entities by the karma they've lost since,
+// starting offset entries in. Like the loserboard, it leaves out karma
+// given in channels that keep their own pool.
+func (d *DB) WindowLoserboard(ctx context.Context, serverID string, since time.Time, offset, limit uint) (popple.Board, error) {
+	query := `SELECT name, SUM(delta) AS karma FROM karma_events WHERE server_id = $1 AND created_at >= $2 AND ` + unpooled + ` GROUP BY name ORDER BY karma ASC LIMIT $3 OFFSET $4`
+	args := []any{serverID, since.UTC().Format(timestamp), limit, offset}
+
+	return d.board(ctx, query, args...)
+}
+
+// unpooled matches the karma events of server $1 that count toward the
+// server's karma rather than a channel's pool.
+const unpooled = `channel_id NOT IN (SELECT channel_id FROM config_channels WHERE server_id = $1 AND pooled)`
+
+func (d *DB) board(ctx context.Context, query string, args ...any) (popple.Board, error) {
 	rows, err := d.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var board popple.Board
 	for rows.Next() {
@@ -166,27 +736,500 @@ func (d *DB) Leaderboard(ctx context.Context, serverID string, limit uint) (popp
 		board = append(board, entry)
 	}
 
-	return board, nil
+	return board, rows.Err()
 }
 
-func (d *DB) Loserboard(ctx context.Context, serverID string, limit uint) (popple.Board, error) {
-	query := `SELECT name, karma FROM entities WHERE server_id = $1 ORDER BY karma ASC LIMIT $2`
-	args := []any{serverID, limit}
+// PutWebhook saves a new webhook and returns it with its ID.
+func (d *DB) PutWebhook(ctx context.Context, webhook popple.Webhook) (popple.Webhook, error) {
+	events := make([]string, 0, len(webhook.Events))
+	for _, e := range webhook.Events {
+		events = append(events, string(e))
+	}
+
+	query := `INSERT INTO webhooks (created_at, server_id, url, secret, events) VALUES (datetime('now'), $1, $2, $3, $4)`
+	args := []any{webhook.ServerID, webhook.URL, webhook.Secret, strings.Join(events, ",")}
+	res, err := d.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return popple.Webhook{}, err
+	}
+
+	webhook.ID, err = res.LastInsertId()
+	return webhook, err
+}
+
+// Webhooks returns the server's webhooks.
+func (d *DB) Webhooks(ctx context.Context, serverID string) ([]popple.Webhook, error) {
+	return webhooks(ctx, d.db, serverID)
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func webhooks(ctx context.Context, q querier, serverID string) ([]popple.Webhook, error) {
+	query := `SELECT id, server_id, url, secret, events FROM webhooks WHERE server_id = $1 ORDER BY id`
+	args := []any{serverID}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []popple.Webhook
+	for rows.Next() {
+		var (
+			webhook popple.Webhook
+			events  string
+		)
+		if err := rows.Scan(&webhook.ID, &webhook.ServerID, &webhook.URL, &webhook.Secret, &events); err != nil {
+			return nil, err
+		}
+		webhook.Events = splitWebhookEvents(events)
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func splitWebhookEvents(s string) []popple.WebhookEvent {
+	var events []popple.WebhookEvent
+	for _, e := range strings.Split(s, ",") {
+		if len(e) > 0 {
+			events = append(events, popple.WebhookEvent(e))
+		}
+	}
+	return events
+}
+
+// DeleteWebhook deletes the webhook along with any deliveries it still has
+// queued.
+func (d *DB) DeleteWebhook(ctx context.Context, serverID string, id int64) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM webhooks WHERE server_id = $1 AND id = $2`
+	args := []any{serverID, id}
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return database.ErrNotFound
+	}
+
+	query = `DELETE FROM webhook_deliveries WHERE webhook_id = $1`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// webhookEvent is how karma events are stored while they wait to be
+// delivered.
+type webhookEvent struct {
+	ServerID   string            `json:"server_id"`
+	ChannelID  string            `json:"channel_id"`
+	Increments popple.Increments `json:"increments"`
+	Levels     popple.Increments `json:"levels"`
+	Actor      string            `json:"actor"`
+	Reason     string            `json:"reason"`
+}
+
+// enqueueWebhookDeliveries queues the event for every webhook on the server
+// that wants it. It's done in the same transaction that records the event
+// so that an event is never recorded without being queued.
+func enqueueWebhookDeliveries(ctx context.Context, tx *sql.Tx, serverID string, event popple.KarmaEvent) error {
+	webhooks, err := webhooks(ctx, tx, serverID)
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range webhooks {
+		filtered, ok := webhook.Filter(event)
+		if !ok {
+			continue
+		}
+
+		stored, err := json.Marshal(webhookEvent{
+			ServerID:   serverID,
+			ChannelID:  filtered.ChannelID,
+			Increments: filtered.Increments,
+			Levels:     filtered.Levels,
+			Actor:      filtered.Actor,
+			Reason:     filtered.Reason,
+		})
+		if err != nil {
+			return err
+		}
+
+		query := `INSERT INTO webhook_deliveries (created_at, webhook_id, event, next_attempt_at) VALUES (datetime('now'), $1, $2, datetime('now'))`
+		args := []any{webhook.ID, stored}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unmarshalWebhookEvent(stored []byte) (popple.KarmaEvent, error) {
+	var event webhookEvent
+	if err := json.Unmarshal(stored, &event); err != nil {
+		return popple.KarmaEvent{}, err
+	}
+
+	return popple.KarmaEvent{
+		ChannelID:  event.ChannelID,
+		Increments: event.Increments,
+		Levels:     event.Levels,
+		Actor:      event.Actor,
+		Reason:     event.Reason,
+	}, nil
+}
+
+// DueWebhookDeliveries returns up to limit deliveries that are ready to be
+// attempted, oldest first.
+func (d *DB) DueWebhookDeliveries(ctx context.Context, limit uint) ([]database.WebhookDelivery, error) {
+	query := `SELECT d.id, d.created_at, d.attempts, d.event, w.id, w.server_id, w.url, w.secret, w.events
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.next_attempt_at <= datetime('now')
+		ORDER BY d.next_attempt_at, d.id
+		LIMIT $1`
+	rows, err := d.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []database.WebhookDelivery
+	for rows.Next() {
+		var (
+			delivery database.WebhookDelivery
+			stored   []byte
+			events   string
+		)
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.CreatedAt,
+			&delivery.Attempts,
+			&stored,
+			&delivery.Webhook.ID,
+			&delivery.Webhook.ServerID,
+			&delivery.Webhook.URL,
+			&delivery.Webhook.Secret,
+			&events,
+		)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Webhook.Events = splitWebhookEvents(events)
 
+		delivery.Event, err = unmarshalWebhookEvent(stored)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// CompleteWebhookDelivery removes a delivery that succeeded from the queue.
+func (d *DB) CompleteWebhookDelivery(ctx context.Context, id int64) error {
+	query := `DELETE FROM webhook_deliveries WHERE id = $1`
+	_, err := d.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// RetryWebhookDelivery records a failed attempt and holds the delivery back
+// for the given time before it's tried again.
+func (d *DB) RetryWebhookDelivery(ctx context.Context, id int64, after time.Duration, reason string) error {
+	query := `UPDATE webhook_deliveries SET attempts = attempts + 1, last_error = $1, next_attempt_at = datetime('now', $2) WHERE id = $3`
+	args := []any{reason, fmt.Sprintf("+%d seconds", int64(after/time.Second)), id}
+	_, err := d.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// DeadLetterWebhookDelivery gives up on a delivery and moves it to the dead
+// letters so that it can be looked into.
+func (d *DB) DeadLetterWebhookDelivery(ctx context.Context, id int64, reason string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO webhook_dead_letters (created_at, queued_at, webhook_id, server_id, url, event, attempts, last_error)
+		SELECT datetime('now'), d.created_at, d.webhook_id, w.server_id, w.url, d.event, d.attempts + 1, $1
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.id = $2`
+	args := []any{reason, id}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	query = `DELETE FROM webhook_deliveries WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// WebhookDeadLetters returns the deliveries to the webhook that were given
+// up on, newest first.
+func (d *DB) WebhookDeadLetters(ctx context.Context, serverID string, webhookID int64) ([]database.WebhookDeadLetter, error) {
+	query := `SELECT id, created_at, queued_at, url, event, attempts, last_error FROM webhook_dead_letters WHERE server_id = $1 AND webhook_id = $2 ORDER BY id DESC`
+	args := []any{serverID, webhookID}
 	rows, err := d.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	var board popple.Board
+	var letters []database.WebhookDeadLetter
 	for rows.Next() {
-		var entry popple.BoardEntry
-		if err := rows.Scan(&entry.Who, &entry.Karma); err != nil {
+		var (
+			letter database.WebhookDeadLetter
+			stored []byte
+		)
+		if err := rows.Scan(&letter.ID, &letter.FailedAt, &letter.QueuedAt, &letter.URL, &stored, &letter.Attempts, &letter.LastError); err != nil {
 			return nil, err
 		}
+		letter.WebhookID = webhookID
 
-		board = append(board, entry)
+		letter.Event, err = unmarshalWebhookEvent(stored)
+		if err != nil {
+			return nil, err
+		}
+
+		letters = append(letters, letter)
+	}
+
+	return letters, rows.Err()
+}
+
+// ServerEntities are every entity on the server, server-wide ones first
+// and then those in each pooled channel.
+func (d *DB) ServerEntities(ctx context.Context, serverID string) ([]database.EntityRecord, error) {
+	query := `SELECT '' AS channel_id, name, karma FROM entities WHERE server_id = $1
+		UNION ALL SELECT channel_id, name, karma FROM channel_entities WHERE server_id = $1
+		ORDER BY channel_id, name`
+	args := []any{serverID}
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entities []database.EntityRecord
+	for rows.Next() {
+		var e database.EntityRecord
+		if err := rows.Scan(&e.ChannelID, &e.Name, &e.Karma); err != nil {
+			return nil, err
+		}
+		entities = append(entities, e)
+	}
+
+	return entities, rows.Err()
+}
+
+// ServerKarmaEvents are every karma event recorded on the server, oldest
+// first.
+func (d *DB) ServerKarmaEvents(ctx context.Context, serverID string) ([]database.EventRecord, error) {
+	query := `SELECT created_at, channel_id, name, delta, actor, reason FROM karma_events WHERE server_id = $1 ORDER BY created_at, rowid`
+	args := []any{serverID}
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []database.EventRecord
+	for rows.Next() {
+		var e database.EventRecord
+		if err := rows.Scan(&e.CreatedAt, &e.ChannelID, &e.Name, &e.Delta, &e.Actor, &e.Reason); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// Import brings data into the server in one transaction, resolving
+// conflicts with existing data as opts says. Imported events aren't sent
+// to webhooks.
+func (d *DB) Import(ctx context.Context, serverID string, data database.Import, opts database.ImportOptions) (database.ImportResult, error) {
+	var result database.ImportResult
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	if data.Config != nil {
+		result.ConfigImported, err = importConfig(ctx, tx, serverID, *data.Config, opts.Conflict)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	for _, e := range data.Entities {
+		if err := importEntity(ctx, tx, serverID, e, opts.Conflict, &result); err != nil {
+			return result, err
+		}
+	}
+
+	if err := importEvents(ctx, tx, serverID, data.Events, &result); err != nil {
+		return result, err
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
+
+func importConfig(ctx context.Context, tx *sql.Tx, serverID string, config popple.ServerConfig, conflict database.Conflict) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM configs WHERE server_id = $1)`
+	if err := tx.QueryRowContext(ctx, query, serverID).Scan(&exists); err != nil {
+		return false, err
+	}
+
+	if exists && conflict != database.ConflictOverwrite {
+		return false, nil
+	}
+
+	config.ServerID = serverID
+	if err := putConfig(ctx, tx, config); err != nil {
+		return false, err
+	}
+	if err := putConfigChannels(ctx, tx, config); err != nil {
+		return false, err
 	}
+	if err := putConfigTemplates(ctx, tx, config); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func importEntity(ctx context.Context, tx *sql.Tx, serverID string, e database.EntityRecord, conflict database.Conflict, result *database.ImportResult) error {
+	var (
+		query string
+		args  []any
+	)
+	if len(e.ChannelID) == 0 {
+		query = `SELECT karma FROM entities WHERE server_id = $1 AND name = $2`
+		args = []any{serverID, e.Name}
+	} else {
+		query = `SELECT karma FROM channel_entities WHERE server_id = $1 AND name = $2 AND channel_id = $3`
+		args = []any{serverID, e.Name, e.ChannelID}
+	}
+
+	var karma int64
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&karma)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if len(e.ChannelID) == 0 {
+			query = `INSERT INTO entities (created_at, updated_at, name, server_id, karma) VALUES (datetime('now'), datetime('now'), $1, $2, $3)`
+			args = []any{e.Name, serverID, e.Karma}
+		} else {
+			query = `INSERT INTO channel_entities (created_at, updated_at, name, server_id, channel_id, karma) VALUES (datetime('now'), datetime('now'), $1, $2, $3, $4)`
+			args = []any{e.Name, serverID, e.ChannelID, e.Karma}
+		}
+		result.EntitiesCreated++
+		_, err := tx.ExecContext(ctx, query, args...)
+		return err
+
+	case err != nil:
+		return err
+	}
+
+	switch conflict {
+	case database.ConflictAdd:
+		karma += e.Karma
+	case database.ConflictOverwrite:
+		karma = e.Karma
+	default:
+		result.EntitiesSkipped++
+		return nil
+	}
+
+	if len(e.ChannelID) == 0 {
+		query = `UPDATE entities SET karma = $1, updated_at = datetime('now') WHERE server_id = $2 AND name = $3`
+		args = []any{karma, serverID, e.Name}
+	} else {
+		query = `UPDATE channel_entities SET karma = $1, updated_at = datetime('now') WHERE server_id = $2 AND name = $3 AND channel_id = $4`
+		args = []any{karma, serverID, e.Name, e.ChannelID}
+	}
+	result.EntitiesUpdated++
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// importEvents adds the events that aren't already recorded. Events
+// don't have IDs, and the same change can legitimately happen more than
+// once at the same second, so events are matched by how many times each
+// one occurs: an event that occurs three times in the import and once in
+// the database is added twice.
+func importEvents(ctx context.Context, tx *sql.Tx, serverID string, events []database.EventRecord, result *database.ImportResult) error {
+	type key struct {
+		createdAt, channelID, name string
+		delta                      int64
+		actor, reason              string
+	}
+	existing := make(map[key]int)
+	seen := make(map[key]int)
+
+	for _, e := range events {
+		k := key{e.CreatedAt.UTC().Format(timestamp), e.ChannelID, e.Name, e.Delta, e.Actor, e.Reason}
+		args := []any{serverID, k.createdAt, e.ChannelID, e.Name, e.Delta, e.Actor, e.Reason}
+
+		n, ok := existing[k]
+		if !ok {
+			query := `SELECT COUNT(*) FROM karma_events WHERE server_id = $1 AND created_at = $2 AND channel_id = $3 AND name = $4 AND delta = $5 AND actor = $6 AND reason = $7`
+			if err := tx.QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
+				return err
+			}
+			existing[k] = n
+		}
+
+		seen[k]++
+		if seen[k] <= n {
+			result.EventsSkipped++
+			continue
+		}
+
+		query := `INSERT INTO karma_events (created_at, server_id, channel_id, name, delta, actor, reason) VALUES ($2, $1, $3, $4, $5, $6, $7)`
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+		result.EventsAdded++
+	}
+	return nil
+}
+
+func (d *DB) Stats(ctx context.Context) (database.Stats, error) {
+	query := `SELECT
+		(SELECT COUNT(*) FROM (SELECT server_id FROM entities UNION SELECT server_id FROM channel_entities UNION SELECT server_id FROM configs)),
+		(SELECT COUNT(*) FROM entities) + (SELECT COUNT(*) FROM channel_entities),
+		(SELECT COUNT(*) FROM karma_events),
+		(SELECT COUNT(*) FROM webhooks),
+		(SELECT COUNT(*) FROM webhook_deliveries),
+		(SELECT COUNT(*) FROM webhook_dead_letters)`
 
-	return board, nil
+	var s database.Stats
+	err := d.db.QueryRowContext(ctx, query).Scan(&s.Servers, &s.Entities, &s.KarmaEvents, &s.Webhooks, &s.PendingDeliveries, &s.DeadLetters)
+	return s, err
 }
@@ -1,6 +1,13 @@
 package sqlite
 
-import "github.com/connorkuehl/popple/internal/env"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
 
 func pathFromEnv(f func(key string) (val string)) (Path, error) {
 	path, err := env.Get("POPPLE_SQLITE_DB_PATH", f)
@@ -9,3 +16,41 @@ func pathFromEnv(f func(key string) (val string)) (Path, error) {
 	}
 	return Path(path), nil
 }
+
+func backupConfigFromEnv(f func(key string) (val string)) (BackupConfig, error) {
+	dir, err := env.Get("POPPLE_BACKUP_DIR", f)
+	if errors.Is(err, env.ErrKeyNotFound) {
+		return BackupConfig{}, nil
+	}
+	if err != nil {
+		return BackupConfig{}, err
+	}
+
+	config := BackupConfig{Dir: dir, Interval: DefaultBackupInterval, Keep: DefaultBackupKeep}
+
+	interval, err := env.Get("POPPLE_BACKUP_INTERVAL", f)
+	switch {
+	case errors.Is(err, env.ErrKeyNotFound):
+	case err != nil:
+		return BackupConfig{}, err
+	default:
+		config.Interval, err = time.ParseDuration(interval)
+		if err != nil || config.Interval <= 0 {
+			return BackupConfig{}, fmt.Errorf("POPPLE_BACKUP_INTERVAL must be a positive duration, e.g., 24h: %q", interval)
+		}
+	}
+
+	keep, err := env.Get("POPPLE_BACKUP_KEEP", f)
+	switch {
+	case errors.Is(err, env.ErrKeyNotFound):
+	case err != nil:
+		return BackupConfig{}, err
+	default:
+		config.Keep, err = strconv.Atoi(keep)
+		if err != nil || config.Keep < 0 {
+			return BackupConfig{}, fmt.Errorf("POPPLE_BACKUP_KEEP must be a number of backups, or 0 to keep them all: %q", keep)
+		}
+	}
+
+	return config, nil
+}
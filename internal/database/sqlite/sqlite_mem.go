@@ -1,14 +1,15 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
-	_ "embed"
+	"embed"
 
 	_ "modernc.org/sqlite"
 )
 
-//go:embed migrations/000001_create_tables.up.sql
-var up string
+//go:embed migrations/*.up.sql
+var migrations embed.FS
 
 func NewInMemory() (*DB, func(), error) {
 	db, err := sql.Open("sqlite", ":memory:")
@@ -16,11 +17,15 @@ func NewInMemory() (*DB, func(), error) {
 		return nil, func() {}, err
 	}
 
-	_, err = db.Exec(up)
-	if err != nil {
+	// Every connection to :memory: gets its own database, so pin the pool
+	// to a single connection to keep the schema visible to all queries.
+	db.SetMaxOpenConns(1)
+
+	d := &DB{db}
+	if _, err := d.Migrate(context.Background()); err != nil {
 		db.Close()
 		return nil, func() {}, err
 	}
 
-	return &DB{db}, func() { db.Close() }, nil
+	return d, func() { db.Close() }, nil
 }
@@ -0,0 +1,231 @@
+package sqlite_test
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/popple"
+)
+
+func TestReserveIdempotencyKeyLapses(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "popple.db")
+
+	db, cleanup, err := sqlite.New(sqlite.Path(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if _, err := db.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, reserved, err := db.ReserveIdempotencyKey(ctx, "1", "build-42", "hash"); err != nil || !reserved {
+		t.Fatalf("want the key reserved, got %v, %v", reserved, err)
+	}
+	if _, reserved, err := db.ReserveIdempotencyKey(ctx, "1", "build-42", "hash"); err != nil || reserved {
+		t.Fatalf("want the key still reserved by the first request, got %v, %v", reserved, err)
+	}
+
+	// The first request never finished.
+	exec(t, path, `UPDATE idempotency_keys SET created_at = datetime('now', '-2 minutes')`)
+
+	if _, reserved, err := db.ReserveIdempotencyKey(ctx, "1", "build-42", "hash"); err != nil || !reserved {
+		t.Errorf("want the lapsed reservation taken over, got %v, %v", reserved, err)
+	}
+}
+
+// TestApplyKarmaConcurrently applies karma from two connections to the same
+// database at once, the way the bot and a backfill do, and checks that no
+// change is lost.
+func TestApplyKarmaConcurrently(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "popple.db")
+
+	var dbs []*sqlite.DB
+	for i := 0; i < 2; i++ {
+		db, cleanup, err := sqlite.New(sqlite.Path(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanup()
+		dbs = append(dbs, db)
+	}
+	if _, err := dbs[0].Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	const writes = 50
+	errs := make(chan error, 2*len(dbs)*writes)
+	var wg sync.WaitGroup
+	for _, db := range dbs {
+		for _, pooled := range []bool{false, true} {
+			wg.Add(1)
+			go func(db *sqlite.DB, pooled bool) {
+				defer wg.Done()
+				for j := 0; j < writes; j++ {
+					event := popple.KarmaEvent{ChannelID: "2", Increments: popple.Increments{"link": 1}, Pooled: pooled}
+					_, err := db.ApplyKarma(ctx, "1", event)
+					errs <- err
+				}
+			}(db, pooled)
+		}
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := int64(len(dbs) * writes)
+	ents, err := dbs[0].Entities(ctx, "1", "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 1 || ents[0].Karma != want {
+		t.Errorf("want link to have %d karma, got %+v", want, ents)
+	}
+
+	ents, err = dbs[0].ChannelEntities(ctx, "1", "2", "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 1 || ents[0].Karma != want {
+		t.Errorf("want link to have %d karma in the pool, got %+v", want, ents)
+	}
+
+	levels, err := dbs[1].ApplyKarma(ctx, "1", popple.KarmaEvent{ChannelID: "2", Increments: popple.Increments{"link": -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if levels["link"] != want-1 {
+		t.Errorf("want link's new level to be %d, got %d", want-1, levels["link"])
+	}
+}
+
+// TestWindowLeaderboardPools checks that the windowed boards count the
+// same karma as the server's boards, which leave out pooled channels.
+func TestWindowLeaderboardPools(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	config := popple.ServerConfig{ServerID: "1", PooledChannels: []string{"pool"}}
+	if err := db.PutConfig(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, event := range []popple.KarmaEvent{
+		{ChannelID: "general", Increments: popple.Increments{"link": 2, "ganon": -1}},
+		{ChannelID: "pool", Increments: popple.Increments{"link": 5, "zelda": 3}, Pooled: true},
+		{ChannelID: "pool", Increments: popple.Increments{"ganon": -4}, Pooled: true},
+	} {
+		if _, err := db.ApplyKarma(ctx, "1", event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	since := time.Now().Add(-time.Hour)
+	for _, tt := range []struct {
+		name            string
+		board, windowed func() (popple.Board, error)
+	}{
+		{
+			name:     "leaderboard",
+			board:    func() (popple.Board, error) { return db.Leaderboard(ctx, "1", 10) },
+			windowed: func() (popple.Board, error) { return db.WindowLeaderboard(ctx, "1", since, 0, 10) },
+		},
+		{
+			name:     "loserboard",
+			board:    func() (popple.Board, error) { return db.Loserboard(ctx, "1", 10) },
+			windowed: func() (popple.Board, error) { return db.WindowLoserboard(ctx, "1", since, 0, 10) },
+		},
+	} {
+		want, err := tt.board()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := tt.windowed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: want %v, got %v", tt.name, want, got)
+		}
+	}
+}
+
+func TestBackfilledKarmaIsNotDelivered(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	if _, err := db.PutWebhook(ctx, popple.Webhook{ServerID: "1", URL: "https://example.com/hook"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, event := range []popple.KarmaEvent{
+		{ChannelID: "2", Increments: popple.Increments{"link": 1}, Actor: "backfill", At: time.Now().Add(-48 * time.Hour), MessageID: "100"},
+		{ChannelID: "2", Increments: popple.Increments{"zelda": 1}, MessageID: "101"},
+	} {
+		if _, err := db.ApplyKarma(ctx, "1", event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries, err := db.DueWebhookDeliveries(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Event.Increments["zelda"] != 1 {
+		t.Errorf("want only the live change delivered, got %+v", deliveries)
+	}
+}
+
+// TestAppliedMessagesLapse checks that messages applied before the
+// backfill window are forgotten and the rest are still applied only once.
+func TestAppliedMessagesLapse(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "popple.db")
+
+	db, cleanup, err := sqlite.New(sqlite.Path(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if _, err := db.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	event := func(messageID string) popple.KarmaEvent {
+		return popple.KarmaEvent{ChannelID: "2", Increments: popple.Increments{"link": 1}, MessageID: messageID}
+	}
+	for _, id := range []string{"100", "101"} {
+		if _, err := db.ApplyKarma(ctx, "1", event(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exec(t, path, `UPDATE applied_messages SET created_at = datetime('now', '-31 days') WHERE message_id = '100'`)
+
+	if levels, err := db.ApplyKarma(ctx, "1", event("101")); err != nil || levels != nil {
+		t.Errorf("want message 101 still applied, got %v, %v", levels, err)
+	}
+	if levels, err := db.ApplyKarma(ctx, "1", event("100")); err != nil || levels == nil {
+		t.Errorf("want message 100 forgotten, got %v, %v", levels, err)
+	}
+}
@@ -1,15 +1,25 @@
 package discord
 
 import (
-	"os"
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/env"
+	"github.com/connorkuehl/popple/internal/logging"
 )
 
 type Token string
 
-func TokenFromEnv() (Token, error) {
-	return tokenFromEnv(os.Getenv)
+func TokenFromEnv(lookup env.Lookup) (Token, error) {
+	return tokenFromEnv(lookup)
 }
 
 type Dialer struct {
@@ -27,6 +37,8 @@ func (d *Dialer) Dial() (*Session, error) {
 	}
 
 	session.Identify.Intents |= discordgo.IntentMessageContent
+	// Session reconnects on its own.
+	session.ShouldReconnectOnError = false
 	err = session.Open()
 	if err != nil {
 		return nil, err
@@ -36,66 +48,312 @@ func (d *Dialer) Dial() (*Session, error) {
 }
 
 type Session struct {
-	s        *discordgo.Session
-	messages chan Message
+	chat.ConnState
+
+	s      *discordgo.Session
+	log    *logging.Logger
+	intake *intake
+
+	mu sync.Mutex
+	// avatars maps the usernames that mentions are replaced with to the
+	// mentioned users' avatars, for the most recently mentioned users.
+	avatars     map[string]string
+	avatarOrder []string
+	// down is when the connection dropped, and is zero while connected or
+	// resumed.
+	down         time.Time
+	reconnecting bool
+	outages      []Outage
 }
 
-func NewSession(dialer *Dialer) (*Session, func(), error) {
+// maxAvatars is how many mentioned users' avatars are remembered.
+const maxAvatars = 1024
+
+const (
+	// MinBackoff is how long a session waits before reconnecting, and
+	// MaxBackoff is the longest it waits between failed attempts.
+	MinBackoff = time.Second
+	MaxBackoff = time.Minute
+)
+
+// NewSession returns a session that logs with logger, or with the standard
+// logger if logger is nil.
+func NewSession(dialer *Dialer, logger *logging.Logger) (*Session, func(), error) {
+	if logger == nil {
+		logger = logging.Standard()
+	}
+
 	s, err := dialer.Dial()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	s.messages = make(chan Message)
-	ch := s.messages
+	s.log = logger
+	s.intake = newIntake(IntakeSize, logger)
+	s.SetConnected(true)
+
+	var detachers []func()
+	detachers = append(detachers,
+		s.s.AddHandler(func(_ *discordgo.Session, _ *discordgo.Connect) {
+			s.SetConnected(true)
+			s.log.Info("connected to discord gateway")
+		}),
+		s.s.AddHandler(func(_ *discordgo.Session, _ *discordgo.Disconnect) {
+			s.disconnected()
+		}),
+		s.s.AddHandler(func(_ *discordgo.Session, _ *discordgo.Resumed) {
+			s.resumed()
+		}),
+		s.s.AddHandler(func(_ *discordgo.Session, _ *discordgo.Ready) {
+			s.ready()
+		}),
+		s.s.AddHandler(s.messageCreate),
+	)
 
-	detach := s.s.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
-		// Ignore messages from self.
-		if s.State.User.Username == m.Author.Username {
-			return
+	return s, func() {
+		s.intake.close()
+		for _, detach := range detachers {
+			detach()
 		}
+		_ = s.s.Close()
+	}, nil
+}
+
+func (s *Session) messageCreate(ds *discordgo.Session, m *discordgo.MessageCreate) {
+	// Ignore messages from self.
+	if ds.State.User.Username == m.Author.Username {
+		return
+	}
+
+	// No DMs.
+	if len(m.GuildID) == 0 {
+		return
+	}
+
+	for _, u := range m.Mentions {
+		s.rememberAvatar(u.Username, u.AvatarURL("128"))
+	}
+
+	s.intake.push(chat.Message{
+		ID:        m.ID,
+		GuildID:   m.GuildID,
+		ChannelID: m.ChannelID,
+		Content:   m.ContentWithMentionsReplaced(),
+	})
+}
+
+// rememberAvatar remembers username's avatar, forgetting the avatar of the
+// user who was mentioned first if there are too many.
+func (s *Session) rememberAvatar(username, url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		// No DMs.
-		if len(m.GuildID) == 0 {
-			return
+	if s.avatars == nil {
+		s.avatars = make(map[string]string)
+	}
+	if _, ok := s.avatars[username]; !ok {
+		s.avatarOrder = append(s.avatarOrder, username)
+	}
+	s.avatars[username] = url
+	if len(s.avatarOrder) > maxAvatars {
+		delete(s.avatars, s.avatarOrder[0])
+		s.avatarOrder = s.avatarOrder[1:]
+	}
+}
+
+func (s *Session) avatar(username string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	url, ok := s.avatars[username]
+	return url, ok
+}
+
+// LastHeartbeat is when the gateway last acknowledged a heartbeat.
+func (s *Session) LastHeartbeat() time.Time {
+	s.s.RLock()
+	defer s.s.RUnlock()
+	return s.s.LastHeartbeatAck
+}
+
+// disconnected reconnects unless the session is being closed. discordgo
+// is told not to reconnect on its own so that it happens here, where it's
+// logged and counted.
+func (s *Session) disconnected() {
+	select {
+	case <-s.intake.done:
+		return
+	default:
+	}
+
+	s.SetConnected(false)
+
+	s.mu.Lock()
+	if s.down.IsZero() {
+		s.down = time.Now()
+	}
+	if s.reconnecting {
+		s.mu.Unlock()
+		return
+	}
+	s.reconnecting = true
+	s.mu.Unlock()
+
+	s.log.Warn("disconnected from discord gateway")
+
+	open := func() error {
+		err := s.s.Open()
+		if errors.Is(err, discordgo.ErrWSAlreadyOpen) {
+			return nil
 		}
+		return err
+	}
+	if reconnect(s.log, s.intake.done, open, MinBackoff, MaxBackoff) {
+		s.log.Info("reconnected to discord gateway")
+	}
+
+	s.mu.Lock()
+	s.reconnecting = false
+	s.mu.Unlock()
+}
+
+// resumed means the gateway replays whatever was sent while the session
+// was disconnected, so nothing was missed.
+func (s *Session) resumed() {
+	s.mu.Lock()
+	down := s.down
+	s.down = time.Time{}
+	s.mu.Unlock()
+
+	s.SetConnected(true)
+	if !down.IsZero() {
+		s.log.WithField("downtime", time.Since(down)).Info("resumed discord gateway session")
+	}
+}
 
-		msg := Message{
-			ID:        m.ID,
-			GuildID:   m.GuildID,
-			ChannelID: m.ChannelID,
-			Content:   m.ContentWithMentionsReplaced(),
+// ready means the gateway started a new session, so anything sent while
+// the old one was disconnected was missed.
+func (s *Session) ready() {
+	s.mu.Lock()
+	down := s.down
+	s.down = time.Time{}
+	if !down.IsZero() {
+		s.outages = append(s.outages, Outage{Start: down, End: time.Now()})
+		if len(s.outages) > MaxOutages {
+			s.outages = s.outages[len(s.outages)-MaxOutages:]
 		}
+	}
+	s.mu.Unlock()
 
-		ch <- msg
-	})
+	s.SetConnected(true)
+	if !down.IsZero() {
+		s.log.WithFields(log.Fields{
+			"since":    down,
+			"downtime": time.Since(down),
+		}).Warn("discord gateway session could not be resumed, messages sent while disconnected were missed")
+	}
+}
 
-	return s, func() {
-		detach()
-		_ = s.s.Close()
-	}, nil
+// Outages are the most recent times the session was disconnected and
+// missed messages, oldest first.
+func (s *Session) Outages() []Outage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Outage(nil), s.outages...)
+}
+
+// Dropped is how many messages were dropped because the intake was full.
+func (s *Session) Dropped() uint64 {
+	return s.intake.dropped.Load()
 }
 
-func (s *Session) SendMessageToChannel(channelID string, msg string) error {
-	_, err := s.s.ChannelMessageSend(channelID, msg)
+func (s *Session) SendMessageToChannel(ctx context.Context, channelID string, msg string) error {
+	_, err := s.s.ChannelMessageSend(channelID, msg, discordgo.WithContext(ctx))
 	return err
 }
 
-func (s *Session) ReactToMessageWithEmoji(channelID, messageID, emojiID string) error {
-	return s.s.MessageReactionAdd(channelID, messageID, emojiID)
+func (s *Session) ReplyToMessage(ctx context.Context, channelID, messageID string, msg string) error {
+	ref := &discordgo.MessageReference{
+		MessageID: messageID,
+		ChannelID: channelID,
+	}
+	_, err := s.s.ChannelMessageSendReply(channelID, msg, ref, discordgo.WithContext(ctx))
+	return err
 }
 
-func (s *Session) Username() string {
-	return s.s.State.User.Username
+func (s *Session) SendEmbedToChannel(ctx context.Context, channelID string, embed chat.Embed) error {
+	e := &discordgo.MessageEmbed{
+		Title: embed.Title,
+	}
+
+	for _, f := range embed.Fields {
+		e.Fields = append(e.Fields, &discordgo.MessageEmbedField{
+			Name:   f.Name,
+			Value:  strings.Join(f.Values, "\n"),
+			Inline: true,
+		})
+	}
+
+	if len(embed.Footer) > 0 {
+		e.Footer = &discordgo.MessageEmbedFooter{Text: embed.Footer}
+	}
+
+	if avatar, ok := s.avatar(strings.TrimPrefix(embed.Subject, "@")); ok {
+		e.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: avatar}
+	}
+
+	_, err := s.s.ChannelMessageSendEmbed(channelID, e, discordgo.WithContext(ctx))
+	return err
 }
 
-func (s *Session) Messages() <-chan Message {
-	return s.messages
+func (s *Session) ReactToMessageWithEmoji(ctx context.Context, channelID, messageID, emojiID string) error {
+	return s.s.MessageReactionAdd(channelID, messageID, emojiID, discordgo.WithContext(ctx))
+}
+
+// SendFileToAdmin sends file by direct message to the author of messageID
+// if they can manage the server.
+func (s *Session) SendFileToAdmin(ctx context.Context, channelID, messageID string, file chat.File) error {
+	author, admin, err := s.author(ctx, channelID, messageID)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return chat.ErrNotAdmin
+	}
+
+	dm, err := s.s.UserChannelCreate(author, discordgo.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.s.ChannelFileSend(dm.ID, file.Name, bytes.NewReader(file.Data), discordgo.WithContext(ctx))
+	return err
+}
+
+// IsAdmin reports whether the author of messageID can manage the server.
+func (s *Session) IsAdmin(ctx context.Context, channelID, messageID string) (bool, error) {
+	_, admin, err := s.author(ctx, channelID, messageID)
+	return admin, err
+}
+
+// author looks up who wrote messageID and whether they can manage the
+// server.
+func (s *Session) author(ctx context.Context, channelID, messageID string) (string, bool, error) {
+	m, err := s.s.ChannelMessage(channelID, messageID, discordgo.WithContext(ctx))
+	if err != nil {
+		return "", false, err
+	}
+
+	perms, err := s.s.UserChannelPermissions(m.Author.ID, channelID, discordgo.WithContext(ctx))
+	if err != nil {
+		return "", false, err
+	}
+	return m.Author.ID, perms&(discordgo.PermissionAdministrator|discordgo.PermissionManageServer) != 0, nil
+}
+
+func (s *Session) Username() string {
+	return s.s.State.User.Username
 }
 
-type Message struct {
-	ID        string
-	GuildID   string
-	ChannelID string
-	Content   string
+func (s *Session) Messages() <-chan chat.Message {
+	return s.intake.ch
 }
@@ -0,0 +1,26 @@
+package discord
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestAvatarsAreBounded(t *testing.T) {
+	var s Session
+	for i := 0; i < maxAvatars+1; i++ {
+		s.rememberAvatar(strconv.Itoa(i), "https://example.com/"+strconv.Itoa(i))
+	}
+
+	// Mentioning someone again doesn't make room for anyone else.
+	s.rememberAvatar("1", "https://example.com/new")
+
+	if _, ok := s.avatar("0"); ok {
+		t.Error("want the first user mentioned forgotten")
+	}
+	if got, _ := s.avatar("1"); got != "https://example.com/new" {
+		t.Errorf("want the newest avatar, got %q", got)
+	}
+	if len(s.avatars) != maxAvatars || len(s.avatarOrder) != maxAvatars {
+		t.Errorf("want %d avatars, got %d in %d", maxAvatars, len(s.avatars), len(s.avatarOrder))
+	}
+}
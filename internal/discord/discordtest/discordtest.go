@@ -1,6 +1,11 @@
 package discordtest
 
-import "github.com/connorkuehl/popple/internal/discord"
+import (
+	"context"
+	"sync"
+
+	"github.com/connorkuehl/popple/internal/chat"
+)
 
 type Reaction struct {
 	ChannelID string
@@ -13,32 +18,82 @@ type Message struct {
 	Content   string
 }
 
+type Reply struct {
+	ChannelID string
+	MessageID string
+	Content   string
+}
+
+type Embed struct {
+	ChannelID string
+	Embed     chat.Embed
+}
+
+// File is a file sent privately to the author of a message.
+type File struct {
+	ChannelID string
+	MessageID string
+	File      chat.File
+}
+
 type Response struct {
 	Reaction Reaction
 	Message  Message
+	Reply    Reply
+	Embed    Embed
+	File     File
 }
 
+// ResponseRecorder records the bot's responses. It's safe to respond from
+// several goroutines, but Responses must only be read once the bot is done.
 type ResponseRecorder struct {
 	Responses []Response
-	messages  []discord.Message
+	messages  []chat.Message
+	mu        sync.Mutex
 }
 
-func NewResponseRecorder(messages []discord.Message) *ResponseRecorder {
+func (r *ResponseRecorder) record(rsp Response) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Responses = append(r.Responses, rsp)
+}
+
+func NewResponseRecorder(messages []chat.Message) *ResponseRecorder {
 	return &ResponseRecorder{messages: messages}
 }
 
-func (r *ResponseRecorder) SendMessageToChannel(channelID string, msg string) error {
-	r.Responses = append(r.Responses, Response{Message: Message{ChannelID: channelID, Content: msg}})
+func (r *ResponseRecorder) SendMessageToChannel(ctx context.Context, channelID string, msg string) error {
+	r.record(Response{Message: Message{ChannelID: channelID, Content: msg}})
 	return nil
 }
 
-func (r *ResponseRecorder) ReactToMessageWithEmoji(channelID, messageID, emojiID string) error {
-	r.Responses = append(r.Responses, Response{Reaction: Reaction{ChannelID: channelID, MessageID: messageID, Emoji: emojiID}})
+func (r *ResponseRecorder) ReplyToMessage(ctx context.Context, channelID, messageID string, msg string) error {
+	r.record(Response{Reply: Reply{ChannelID: channelID, MessageID: messageID, Content: msg}})
 	return nil
 }
 
-func (r *ResponseRecorder) Messages() <-chan discord.Message {
-	ch := make(chan discord.Message, len(r.messages))
+func (r *ResponseRecorder) SendEmbedToChannel(ctx context.Context, channelID string, embed chat.Embed) error {
+	r.record(Response{Embed: Embed{ChannelID: channelID, Embed: embed}})
+	return nil
+}
+
+func (r *ResponseRecorder) ReactToMessageWithEmoji(ctx context.Context, channelID, messageID, emojiID string) error {
+	r.record(Response{Reaction: Reaction{ChannelID: channelID, MessageID: messageID, Emoji: emojiID}})
+	return nil
+}
+
+func (r *ResponseRecorder) SendFileToAdmin(ctx context.Context, channelID, messageID string, file chat.File) error {
+	r.record(Response{File: File{ChannelID: channelID, MessageID: messageID, File: file}})
+	return nil
+}
+
+// IsAdmin is always true.
+func (r *ResponseRecorder) IsAdmin(ctx context.Context, channelID, messageID string) (bool, error) {
+	return true, nil
+}
+
+func (r *ResponseRecorder) Messages() <-chan chat.Message {
+	ch := make(chan chat.Message, len(r.messages))
 	for _, msg := range r.messages {
 		ch <- msg
 	}
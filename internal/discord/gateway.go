@@ -0,0 +1,107 @@
+package discord
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/logging"
+)
+
+const (
+	// IntakeSize is how many messages can wait to be taken from a session.
+	// Once it's full, new messages are dropped so that the gateway
+	// connection isn't held up.
+	IntakeSize = 256
+
+	// MaxOutages is how many outages a session remembers.
+	MaxOutages = 16
+)
+
+// Outage is a stretch of time the session was disconnected and couldn't
+// resume, so messages sent during it were missed.
+type Outage struct {
+	Start time.Time
+	End   time.Time
+}
+
+// intake buffers messages between the gateway and whoever is reading them.
+type intake struct {
+	ch   chan chat.Message
+	done chan struct{}
+	log  *logging.Logger
+
+	dropped     atomic.Uint64
+	mu          sync.Mutex
+	overflowing bool
+	// overflowStart is how many messages had been dropped when the current
+	// overflow started.
+	overflowStart uint64
+}
+
+func newIntake(size int, logger *logging.Logger) *intake {
+	return &intake{
+		ch:   make(chan chat.Message, size),
+		done: make(chan struct{}),
+		log:  logger,
+	}
+}
+
+// push queues msg, or drops it if the intake is full. It never blocks.
+func (in *intake) push(msg chat.Message) {
+	select {
+	case <-in.done:
+		return
+	default:
+	}
+
+	select {
+	case in.ch <- msg:
+		in.mu.Lock()
+		if in.overflowing {
+			in.overflowing = false
+			in.log.WithField("dropped", in.dropped.Load()-in.overflowStart).Warn("discord intake caught up")
+		}
+		in.mu.Unlock()
+	default:
+		n := in.dropped.Add(1)
+		in.mu.Lock()
+		if !in.overflowing {
+			in.overflowing = true
+			in.overflowStart = n - 1
+			in.log.WithField("size", cap(in.ch)).Warn("discord intake is full, dropping messages")
+		}
+		in.mu.Unlock()
+	}
+}
+
+// close stops taking messages.
+func (in *intake) close() {
+	close(in.done)
+}
+
+// reconnect calls open until it succeeds or done is closed, waiting twice
+// as long after each failure, up to max, and logs each failure to logger.
+// It reports whether it succeeded.
+func reconnect(logger *logging.Logger, done <-chan struct{}, open func() error, min, max time.Duration) bool {
+	backoff := min
+	for {
+		select {
+		case <-done:
+			return false
+		case <-time.After(backoff):
+		}
+
+		err := open()
+		if err == nil {
+			return true
+		}
+		logger.WithError(err).WithField("backoff", backoff).Warn("discord reconnect failed")
+
+		backoff *= 2
+		if backoff > max {
+			backoff = max
+		}
+	}
+}
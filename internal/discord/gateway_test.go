@@ -0,0 +1,75 @@
+package discord
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/logging"
+)
+
+func TestIntake(t *testing.T) {
+	in := newIntake(2, logging.Standard())
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		in.push(chat.Message{ID: id})
+	}
+
+	if got := in.dropped.Load(); got != 2 {
+		t.Errorf("want 2 dropped, got %d", got)
+	}
+	for _, want := range []string{"1", "2"} {
+		if got := <-in.ch; got.ID != want {
+			t.Errorf("want message %s, got %s", want, got.ID)
+		}
+	}
+
+	// There's room again.
+	in.push(chat.Message{ID: "5"})
+	if got := <-in.ch; got.ID != "5" {
+		t.Errorf("want message 5, got %s", got.ID)
+	}
+
+	in.close()
+	in.push(chat.Message{ID: "6"})
+	if len(in.ch) != 0 {
+		t.Errorf("want nothing taken after close, got %d", len(in.ch))
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	t.Run("retries until it connects", func(t *testing.T) {
+		attempts := 0
+		open := func() error {
+			attempts++
+			if attempts < 3 {
+				return errors.New("nope")
+			}
+			return nil
+		}
+
+		if !reconnect(logging.Standard(), make(chan struct{}), open, time.Millisecond, 2*time.Millisecond) {
+			t.Fatal("want reconnected")
+		}
+		if attempts != 3 {
+			t.Errorf("want 3 attempts, got %d", attempts)
+		}
+	})
+
+	t.Run("gives up when closed", func(t *testing.T) {
+		done := make(chan struct{})
+		attempts := 0
+		open := func() error {
+			attempts++
+			if attempts == 1 {
+				close(done)
+			}
+			return errors.New("nope")
+		}
+
+		if reconnect(logging.Standard(), done, open, time.Millisecond, time.Hour) {
+			t.Fatal("want gave up")
+		}
+	})
+}
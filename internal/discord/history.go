@@ -0,0 +1,83 @@
+package discord
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/connorkuehl/popple/internal/chat"
+)
+
+// epoch is the start of time for Discord's snowflake IDs.
+var epoch = time.UnixMilli(1420070400000)
+
+// SnowflakeAt is the smallest snowflake ID that can be given to anything
+// created at t.
+func SnowflakeAt(t time.Time) string {
+	if t.Before(epoch) {
+		return "0"
+	}
+	return strconv.FormatInt(t.Sub(epoch).Milliseconds()<<22, 10)
+}
+
+// HistoricalMessage is a message read from a channel's history.
+type HistoricalMessage struct {
+	chat.Message
+	SentAt time.Time
+	// Self is set for messages the bot sent.
+	Self bool
+}
+
+// History reads channel history through Discord's REST API. Unlike a
+// Session, it doesn't connect to the gateway.
+type History struct {
+	s    *discordgo.Session
+	self *discordgo.User
+}
+
+func NewHistory(token Token) (*History, error) {
+	s, err := discordgo.New("Bot " + string(token))
+	if err != nil {
+		return nil, err
+	}
+
+	self, err := s.User("@me")
+	if err != nil {
+		return nil, err
+	}
+
+	return &History{s: s, self: self}, nil
+}
+
+func (h *History) Username() string {
+	return h.self.Username
+}
+
+// MessagesAfter returns up to limit messages sent in channelID after the
+// message afterID, oldest first.
+func (h *History) MessagesAfter(ctx context.Context, channelID, afterID string, limit int) ([]HistoricalMessage, error) {
+	page, err := h.s.ChannelMessages(channelID, limit, "", afterID, "", discordgo.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	// Discord sends the newest first.
+	messages := make([]HistoricalMessage, 0, len(page))
+	for i := len(page) - 1; i >= 0; i-- {
+		m := page[i]
+		messages = append(messages, HistoricalMessage{
+			Message: chat.Message{
+				ID:        m.ID,
+				GuildID:   m.GuildID,
+				ChannelID: m.ChannelID,
+				Content:   m.ContentWithMentionsReplaced(),
+			},
+			SentAt: m.Timestamp,
+			Self:   m.Author != nil && m.Author.ID == h.self.ID,
+		})
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,24 @@
+package discord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestSnowflakeAt(t *testing.T) {
+	at := time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	got, err := discordgo.SnowflakeTimestamp(SnowflakeAt(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(at) {
+		t.Errorf("want %v, got %v", at, got)
+	}
+
+	if got := SnowflakeAt(epoch.Add(-time.Hour)); got != "0" {
+		t.Errorf("want 0 before the epoch, got %s", got)
+	}
+}
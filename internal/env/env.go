@@ -1,13 +1,20 @@
 package env
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var ErrKeyNotFound = errors.New("not found")
 
+// Lookup finds a setting by its environment variable's name. It returns an
+// empty string if the setting isn't set.
+type Lookup func(key string) (val string)
+
 func Get(key string, f func(key string) (val string)) (string, error) {
 	v := f(key)
 	if v == "" {
-		return "", ErrKeyNotFound
+		return "", fmt.Errorf("%s: %w", key, ErrKeyNotFound)
 	}
 
 	return v, nil
@@ -0,0 +1,183 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+var ErrMalformed = errors.New("malformed export")
+
+// csvHeader names the columns of a CSV export. Each row is a record of the
+// kind in the first column, and only uses the columns that kind needs:
+//
+//	version: value is the schema version, and it's always the first row
+//	server: value is the server ID, created_at is when it was exported
+//	config: value is the config as JSON
+//	entity: channel_id, name, and karma in value
+//	event: channel_id, name, delta in value, created_at, actor, reason
+var csvHeader = []string{"record", "channel_id", "name", "value", "created_at", "actor", "reason"}
+
+// Write writes e to w in format.
+func Write(w io.Writer, e Export, format Format) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(e)
+	case FormatCSV:
+		return writeCSV(w, e)
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+// Read reads an export in format from r.
+func Read(r io.Reader, format Format) (Export, error) {
+	var (
+		e   Export
+		err error
+	)
+	switch format {
+	case FormatJSON:
+		err = json.NewDecoder(r).Decode(&e)
+	case FormatCSV:
+		e, err = readCSV(r)
+	default:
+		err = fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	if err != nil {
+		return Export{}, err
+	}
+
+	if e.Version == 0 {
+		return Export{}, fmt.Errorf("%w: no version", ErrMalformed)
+	}
+	if e.Version > Version {
+		return Export{}, fmt.Errorf("%w: version %d", ErrNewerVersion, e.Version)
+	}
+	return e, nil
+}
+
+func writeCSV(w io.Writer, e Export) error {
+	cw := csv.NewWriter(w)
+
+	records := [][]string{
+		csvHeader,
+		{"version", "", "", strconv.Itoa(e.Version), "", "", ""},
+		{"server", "", "", e.ServerID, e.ExportedAt.Format(time.RFC3339), "", ""},
+	}
+
+	if e.Config != nil {
+		config, err := json.Marshal(e.Config)
+		if err != nil {
+			return err
+		}
+		records = append(records, []string{"config", "", "", string(config), "", "", ""})
+	}
+
+	for _, ent := range e.Entities {
+		records = append(records, []string{"entity", ent.ChannelID, ent.Name, strconv.FormatInt(ent.Karma, 10), "", "", ""})
+	}
+
+	for _, ev := range e.Events {
+		records = append(records, []string{"event", ev.ChannelID, ev.Name, strconv.FormatInt(ev.Delta, 10), ev.CreatedAt.Format(time.RFC3339), ev.Actor, ev.Reason})
+	}
+
+	if err := cw.WriteAll(records); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func readCSV(r io.Reader) (Export, error) {
+	// Newer versions may add columns, so the number of columns isn't
+	// fixed until the version has been read.
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return Export{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if len(header) < len(csvHeader) {
+		return Export{}, fmt.Errorf("%w: want %d columns, got %d", ErrMalformed, len(csvHeader), len(header))
+	}
+	for i, name := range csvHeader {
+		if header[i] != name {
+			return Export{}, fmt.Errorf("%w: want column %q, got %q", ErrMalformed, name, header[i])
+		}
+	}
+
+	e := Export{Entities: []Entity{}}
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Export{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+
+		if len(record) < len(csvHeader) {
+			return Export{}, fmt.Errorf("%w: line %d: want %d columns, got %d", ErrMalformed, line, len(csvHeader), len(record))
+		}
+
+		err = e.readRecord(record)
+		if errors.Is(err, ErrNewerVersion) {
+			return Export{}, err
+		}
+		if err != nil {
+			return Export{}, fmt.Errorf("%w: line %d: %v", ErrMalformed, line, err)
+		}
+	}
+
+	return e, nil
+}
+
+func (e *Export) readRecord(record []string) error {
+	kind, channelID, name, value, createdAt, actor, reason := record[0], record[1], record[2], record[3], record[4], record[5], record[6]
+
+	if kind != "version" && e.Version == 0 {
+		return errors.New("version must come first")
+	}
+
+	var err error
+	switch kind {
+	case "version":
+		e.Version, err = strconv.Atoi(value)
+		if err == nil && e.Version > Version {
+			// Later rows may not make sense to this version.
+			return fmt.Errorf("%w: version %d", ErrNewerVersion, e.Version)
+		}
+
+	case "server":
+		e.ServerID = value
+		e.ExportedAt, err = time.Parse(time.RFC3339, createdAt)
+
+	case "config":
+		e.Config = new(Config)
+		err = json.Unmarshal([]byte(value), e.Config)
+
+	case "entity":
+		ent := Entity{ChannelID: channelID, Name: name}
+		ent.Karma, err = strconv.ParseInt(value, 10, 64)
+		e.Entities = append(e.Entities, ent)
+
+	case "event":
+		ev := Event{ChannelID: channelID, Name: name, Actor: actor, Reason: reason}
+		if ev.Delta, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return err
+		}
+		ev.CreatedAt, err = time.Parse(time.RFC3339, createdAt)
+		e.Events = append(e.Events, ev)
+
+	default:
+		err = fmt.Errorf("unknown record %q", kind)
+	}
+	return err
+}
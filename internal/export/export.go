@@ -0,0 +1,250 @@
+// Package export moves a server's karma in and out of Popple as JSON or
+// CSV files.
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/popple"
+)
+
+// Version is the version of the export schema. It goes up whenever the
+// schema changes in a way older versions of Popple can't read.
+const Version = 1
+
+var (
+	ErrUnknownFormat  = errors.New("unknown export format")
+	ErrNewerVersion   = errors.New("export is from a newer version of Popple")
+	ErrUnknownSetting = errors.New("unknown setting")
+)
+
+// Format is how an export is written.
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatCSV  Format = "csv"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatJSON, FormatCSV:
+		return f, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownFormat, s)
+	}
+}
+
+// Export is everything Popple knows about a server's karma.
+type Export struct {
+	Version    int       `json:"version"`
+	ServerID   string    `json:"server_id"`
+	ExportedAt time.Time `json:"exported_at"`
+	// Config is nil if the server never changed its settings.
+	Config   *Config  `json:"config,omitempty"`
+	Entities []Entity `json:"entities"`
+	Events   []Event  `json:"events,omitempty"`
+}
+
+// Config is a server's settings. Settings are named rather than numbered
+// so that exports don't depend on how Popple stores them.
+type Config struct {
+	Announce string `json:"announce"`
+	// DigestInterval is in seconds.
+	DigestInterval   int64             `json:"digest_interval,omitempty"`
+	ChannelFilter    string            `json:"channel_filter"`
+	FilteredChannels []string          `json:"filtered_channels,omitempty"`
+	PooledChannels   []string          `json:"pooled_channels,omitempty"`
+	Locale           string            `json:"locale,omitempty"`
+	Embeds           bool              `json:"embeds,omitempty"`
+	Templates        map[string]string `json:"templates,omitempty"`
+}
+
+// Entity is a subject's karma. ChannelID is empty for server-wide karma
+// and set for karma in a pooled channel.
+type Entity struct {
+	ChannelID string `json:"channel_id,omitempty"`
+	Name      string `json:"name"`
+	Karma     int64  `json:"karma"`
+}
+
+// Event is a change to a subject's karma.
+type Event struct {
+	CreatedAt time.Time `json:"created_at"`
+	ChannelID string    `json:"channel_id,omitempty"`
+	Name      string    `json:"name"`
+	Delta     int64     `json:"delta"`
+	Actor     string    `json:"actor,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// Source is where a server's karma is exported from.
+type Source interface {
+	Config(ctx context.Context, serverID string) (popple.ServerConfig, error)
+	ServerEntities(ctx context.Context, serverID string) ([]database.EntityRecord, error)
+	ServerKarmaEvents(ctx context.Context, serverID string) ([]database.EventRecord, error)
+}
+
+// Sink is where a server's karma is imported to.
+type Sink interface {
+	Import(ctx context.Context, serverID string, data database.Import, opts database.ImportOptions) (database.ImportResult, error)
+}
+
+// Dump exports everything about serverID.
+func Dump(ctx context.Context, db Source, serverID string) (Export, error) {
+	e := Export{
+		Version:    Version,
+		ServerID:   serverID,
+		ExportedAt: time.Now().UTC().Truncate(time.Second),
+		Entities:   []Entity{},
+	}
+
+	config, err := db.Config(ctx, serverID)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+	case err != nil:
+		return Export{}, err
+	default:
+		c := fromServerConfig(config)
+		e.Config = &c
+	}
+
+	entities, err := db.ServerEntities(ctx, serverID)
+	if err != nil {
+		return Export{}, err
+	}
+	for _, ent := range entities {
+		e.Entities = append(e.Entities, Entity{ChannelID: ent.ChannelID, Name: ent.Name, Karma: ent.Karma})
+	}
+
+	events, err := db.ServerKarmaEvents(ctx, serverID)
+	if err != nil {
+		return Export{}, err
+	}
+	for _, ev := range events {
+		e.Events = append(e.Events, Event{
+			CreatedAt: ev.CreatedAt.UTC(),
+			ChannelID: ev.ChannelID,
+			Name:      ev.Name,
+			Delta:     ev.Delta,
+			Actor:     ev.Actor,
+			Reason:    ev.Reason,
+		})
+	}
+
+	return e, nil
+}
+
+// Load imports e into serverID, which need not be the server it was
+// exported from.
+func Load(ctx context.Context, db Sink, serverID string, e Export, opts database.ImportOptions) (database.ImportResult, error) {
+	if e.Version > Version {
+		return database.ImportResult{}, fmt.Errorf("%w: version %d", ErrNewerVersion, e.Version)
+	}
+
+	var data database.Import
+	if e.Config != nil {
+		config, err := e.Config.serverConfig()
+		if err != nil {
+			return database.ImportResult{}, err
+		}
+		config.ServerID = serverID
+		data.Config = &config
+	}
+
+	for _, ent := range e.Entities {
+		data.Entities = append(data.Entities, database.EntityRecord{
+			ChannelID: ent.ChannelID,
+			Entity:    popple.Entity{Name: ent.Name, Karma: ent.Karma},
+		})
+	}
+
+	for _, ev := range e.Events {
+		data.Events = append(data.Events, database.EventRecord{
+			CreatedAt: ev.CreatedAt,
+			ChannelID: ev.ChannelID,
+			Name:      ev.Name,
+			Delta:     ev.Delta,
+			Actor:     ev.Actor,
+			Reason:    ev.Reason,
+		})
+	}
+
+	return db.Import(ctx, serverID, data, opts)
+}
+
+// ParseConflict parses a conflict strategy by name.
+func ParseConflict(s string) (database.Conflict, error) {
+	switch c := database.Conflict(s); c {
+	case database.ConflictOverwrite, database.ConflictAdd, database.ConflictSkip:
+		return c, nil
+	default:
+		return "", fmt.Errorf("%w: conflict strategy %q", ErrUnknownSetting, s)
+	}
+}
+
+var announceModes = map[int]string{
+	int(popple.AnnounceMessage): "message",
+	int(popple.AnnounceOff):     "off",
+	int(popple.AnnounceReact):   "react",
+	int(popple.AnnounceReply):   "reply",
+	int(popple.AnnounceDigest):  "digest",
+}
+
+var channelFilters = map[int]string{
+	int(popple.ChannelFilterNone):  "all",
+	int(popple.ChannelFilterAllow): "allow",
+	int(popple.ChannelFilterDeny):  "deny",
+}
+
+func fromServerConfig(c popple.ServerConfig) Config {
+	return Config{
+		Announce:         announceModes[int(c.Announce)],
+		DigestInterval:   int64(c.DigestInterval / time.Second),
+		ChannelFilter:    channelFilters[int(c.ChannelFilter)],
+		FilteredChannels: c.FilteredChannels,
+		PooledChannels:   c.PooledChannels,
+		Locale:           c.Locale,
+		Embeds:           c.Embeds,
+		Templates:        c.Templates,
+	}
+}
+
+func (c Config) serverConfig() (popple.ServerConfig, error) {
+	config := popple.ServerConfig{
+		DigestInterval:   time.Duration(c.DigestInterval) * time.Second,
+		FilteredChannels: c.FilteredChannels,
+		PooledChannels:   c.PooledChannels,
+		Locale:           c.Locale,
+		Embeds:           c.Embeds,
+		Templates:        c.Templates,
+	}
+
+	announce, ok := parseName(announceModes, c.Announce)
+	if !ok {
+		return popple.ServerConfig{}, fmt.Errorf("%w: announce %q", ErrUnknownSetting, c.Announce)
+	}
+	config.Announce = popple.AnnounceMode(announce)
+
+	filter, ok := parseName(channelFilters, c.ChannelFilter)
+	if !ok {
+		return popple.ServerConfig{}, fmt.Errorf("%w: channel filter %q", ErrUnknownSetting, c.ChannelFilter)
+	}
+	config.ChannelFilter = popple.ChannelFilter(filter)
+
+	return config, nil
+}
+
+// parseName finds the setting called name in names.
+func parseName(names map[int]string, name string) (int, bool) {
+	for k, v := range names {
+		if v == name {
+			return k, true
+		}
+	}
+	return 0, false
+}
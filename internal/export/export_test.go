@@ -0,0 +1,309 @@
+package export_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/export"
+	"github.com/connorkuehl/popple/internal/popple"
+)
+
+func newDB(t *testing.T) *sqlite.DB {
+	t.Helper()
+
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	return db
+}
+
+// seed gives server 123 a config, server-wide and pooled karma, and
+// history.
+func seed(t *testing.T, db *sqlite.DB) {
+	t.Helper()
+	ctx := context.Background()
+
+	config := popple.ServerConfig{
+		ServerID:       "123",
+		Announce:       popple.AnnounceDigest,
+		DigestInterval: 30 * time.Second,
+		ChannelFilter:  popple.ChannelFilterDeny,
+		FilteredChannels: []string{
+			"789",
+		},
+		PooledChannels: []string{"456"},
+		Locale:         "de",
+		Templates:      map[string]string{"empty_board": "Nothing yet"},
+	}
+	if err := db.PutConfig(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.PutEntities(ctx, "123", popple.Entity{Name: "link", Karma: 3}, popple.Entity{Name: "ganon", Karma: -2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutChannelEntities(ctx, "123", "456", popple.Entity{Name: "zelda", Karma: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+	events := []popple.KarmaEvent{
+		{ChannelID: "111", Increments: popple.Increments{"link": 3}, At: at},
+		{ChannelID: "111", Increments: popple.Increments{"ganon": -2}, Actor: "ci", Reason: "broke the build", At: at.Add(time.Minute)},
+	}
+	for _, e := range events {
+		if err := db.PutKarmaEvents(ctx, "123", e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []export.Format{export.FormatJSON, export.FormatCSV} {
+		t.Run(string(format), func(t *testing.T) {
+			ctx := context.Background()
+			from := newDB(t)
+			seed(t, from)
+
+			e, err := export.Dump(ctx, from, "123")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := export.Write(&buf, e, format); err != nil {
+				t.Fatal(err)
+			}
+
+			read, err := export.Read(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(read, e) {
+				t.Errorf("want\n%+v\ngot\n%+v", e, read)
+			}
+
+			to := newDB(t)
+			result, err := export.Load(ctx, to, "999", read, database.ImportOptions{Conflict: database.ConflictSkip})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := database.ImportResult{ConfigImported: true, EntitiesCreated: 3, EventsAdded: 2}
+			if result != want {
+				t.Errorf("want %+v, got %+v", want, result)
+			}
+
+			again, err := export.Dump(ctx, to, "999")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(again.Config, e.Config) {
+				t.Errorf("want config %+v, got %+v", e.Config, again.Config)
+			}
+			if !reflect.DeepEqual(again.Entities, e.Entities) {
+				t.Errorf("want entities %+v, got %+v", e.Entities, again.Entities)
+			}
+			if !reflect.DeepEqual(again.Events, e.Events) {
+				t.Errorf("want events %+v, got %+v", e.Events, again.Events)
+			}
+		})
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	incoming := export.Export{
+		Version:  export.Version,
+		Config:   &export.Config{Announce: "off", ChannelFilter: "all"},
+		Entities: []export.Entity{{Name: "link", Karma: 10}, {Name: "navi", Karma: 1}},
+	}
+
+	tests := []struct {
+		conflict   database.Conflict
+		dryRun     bool
+		wantResult database.ImportResult
+		wantKarma  map[string]int64
+		wantConfig popple.AnnounceMode
+	}{
+		{
+			conflict:   database.ConflictOverwrite,
+			wantResult: database.ImportResult{ConfigImported: true, EntitiesCreated: 1, EntitiesUpdated: 1},
+			wantKarma:  map[string]int64{"link": 10, "navi": 1},
+			wantConfig: popple.AnnounceOff,
+		},
+		{
+			conflict:   database.ConflictAdd,
+			wantResult: database.ImportResult{EntitiesCreated: 1, EntitiesUpdated: 1},
+			wantKarma:  map[string]int64{"link": 13, "navi": 1},
+			wantConfig: popple.AnnounceDigest,
+		},
+		{
+			conflict:   database.ConflictSkip,
+			wantResult: database.ImportResult{EntitiesCreated: 1, EntitiesSkipped: 1},
+			wantKarma:  map[string]int64{"link": 3, "navi": 1},
+			wantConfig: popple.AnnounceDigest,
+		},
+		{
+			conflict:   database.ConflictOverwrite,
+			dryRun:     true,
+			wantResult: database.ImportResult{ConfigImported: true, EntitiesCreated: 1, EntitiesUpdated: 1},
+			wantKarma:  map[string]int64{"link": 3, "navi": 0},
+			wantConfig: popple.AnnounceDigest,
+		},
+	}
+
+	for _, tt := range tests {
+		name := string(tt.conflict)
+		if tt.dryRun {
+			name += " dry run"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			db := newDB(t)
+			seed(t, db)
+
+			result, err := export.Load(ctx, db, "123", incoming, database.ImportOptions{Conflict: tt.conflict, DryRun: tt.dryRun})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.wantResult {
+				t.Errorf("want %+v, got %+v", tt.wantResult, result)
+			}
+
+			entities, err := db.Entities(ctx, "123", "link", "navi")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]int64)
+			for _, e := range entities {
+				got[e.Name] = e.Karma
+			}
+			if !reflect.DeepEqual(got, tt.wantKarma) {
+				t.Errorf("want karma %v, got %v", tt.wantKarma, got)
+			}
+
+			config, err := db.Config(ctx, "123")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Announce != tt.wantConfig {
+				t.Errorf("want announce %v, got %v", tt.wantConfig, config.Announce)
+			}
+		})
+	}
+}
+
+func TestEventsAreNotImportedTwice(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	seed(t, db)
+
+	e, err := export.Dump(ctx, db, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := export.Load(ctx, db, "123", e, database.ImportOptions{Conflict: database.ConflictSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.EventsAdded != 0 || result.EventsSkipped != 2 {
+		t.Errorf("want every event skipped, got %+v", result)
+	}
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name   string
+		format export.Format
+		input  string
+		want   error
+	}{
+		{
+			name:   "newer json",
+			format: export.FormatJSON,
+			input:  `{"version": 2, "server_id": "123"}`,
+			want:   export.ErrNewerVersion,
+		},
+		{
+			name:   "json without a version",
+			format: export.FormatJSON,
+			input:  `{"server_id": "123"}`,
+			want:   export.ErrMalformed,
+		},
+		{
+			name:   "newer csv",
+			format: export.FormatCSV,
+			input:  "record,channel_id,name,value,created_at,actor,reason,color\nversion,,,2,,,,\nentity,,link,1,,,,red\n",
+			want:   export.ErrNewerVersion,
+		},
+		{
+			name:   "csv without a version",
+			format: export.FormatCSV,
+			input:  "record,channel_id,name,value,created_at,actor,reason\nentity,,link,1,,,\n",
+			want:   export.ErrMalformed,
+		},
+		{
+			name:   "csv with bad karma",
+			format: export.FormatCSV,
+			input:  "record,channel_id,name,value,created_at,actor,reason\nversion,,,1,,,\nentity,,link,lots,,,\n",
+			want:   export.ErrMalformed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := export.Read(strings.NewReader(tt.input), tt.format)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestIdenticalEventsAreAllImported(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+
+	// Someone gave link karma twice within the same second.
+	at := time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if err := db.PutKarmaEvents(ctx, "123", popple.KarmaEvent{ChannelID: "111", Increments: popple.Increments{"link": 1}, At: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e, err := export.Dump(ctx, db, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := newDB(t)
+	result, err := export.Load(ctx, other, "123", e, database.ImportOptions{Conflict: database.ConflictSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.EventsAdded != 2 {
+		t.Errorf("want both events added, got %+v", result)
+	}
+
+	// Importing again adds only what's missing.
+	e.Events = append(e.Events, e.Events[0])
+	result, err = export.Load(ctx, other, "123", e, database.ImportOptions{Conflict: database.ConflictSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.EventsAdded != 1 || result.EventsSkipped != 2 {
+		t.Errorf("want one event added and two skipped, got %+v", result)
+	}
+}
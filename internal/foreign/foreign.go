@@ -0,0 +1,66 @@
+// Package foreign reads the data other karma bots keep so that servers can
+// bring their karma with them when they switch to Popple.
+package foreign
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/connorkuehl/popple/internal/database"
+)
+
+var (
+	ErrUnknownSource = errors.New("unknown karma bot")
+	ErrMalformed     = errors.New("malformed karma bot data")
+)
+
+// Parser reads one karma bot's data.
+type Parser interface {
+	Parse(r io.Reader) (Result, error)
+}
+
+// Result is what a parser made of a karma bot's data.
+type Result struct {
+	Data database.Import
+	// Unmapped are the records that have no place in Popple and won't be
+	// imported.
+	Unmapped []Unmapped
+}
+
+// Unmapped is a record that can't be imported and why.
+type Unmapped struct {
+	Record string
+	Reason string
+}
+
+var parsers = map[string]Parser{
+	"hubot":    Hubot{},
+	"karmabot": KarmaBot{},
+}
+
+// Register makes p available by name. It replaces any parser already
+// registered by that name.
+func Register(name string, p Parser) {
+	parsers[name] = p
+}
+
+// Lookup finds the parser registered by name.
+func Lookup(name string) (Parser, error) {
+	p, ok := parsers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSource, name)
+	}
+	return p, nil
+}
+
+// Names lists the registered parsers in order.
+func Names() []string {
+	names := make([]string, 0, len(parsers))
+	for name := range parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,127 @@
+package foreign_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/foreign"
+	"github.com/connorkuehl/popple/internal/popple"
+)
+
+func TestParsers(t *testing.T) {
+	at := time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		source  string
+		fixture string
+		want    foreign.Result
+	}{
+		{
+			source:  "hubot",
+			fixture: "hubot.json",
+			want: foreign.Result{
+				Data: database.Import{
+					Entities: []database.EntityRecord{
+						{Entity: popple.Entity{Name: "ganon", Karma: -4}},
+						{Entity: popple.Entity{Name: "link", Karma: 12}},
+						{Entity: popple.Entity{Name: "triforce", Karma: 3}},
+					},
+				},
+				Unmapped: []foreign.Unmapped{
+					{Record: "log.zelda", Reason: "only the last time each subject was given karma is recorded"},
+					{Record: `reasons.link["saving hyrule"] = 10`, Reason: "reasons aren't recorded with a time"},
+					{Record: "scores.navi = 0.5", Reason: "karma must be a whole number"},
+				},
+			},
+		},
+		{
+			source:  "karmabot",
+			fixture: "karmabot.csv",
+			want: foreign.Result{
+				Data: database.Import{
+					Entities: []database.EntityRecord{
+						{Entity: popple.Entity{Name: "ganon", Karma: -1}},
+						{Entity: popple.Entity{Name: "link", Karma: 3}},
+					},
+					Events: []database.EventRecord{
+						{CreatedAt: at, ChannelID: "C1", Name: "link", Delta: 1, Actor: "zelda", Reason: "saving hyrule"},
+						{CreatedAt: at.Add(5 * time.Minute), ChannelID: "C1", Name: "link", Delta: 2, Actor: "zelda"},
+						{CreatedAt: at.Add(10 * time.Minute), ChannelID: "C2", Name: "ganon", Delta: -1, Actor: "link", Reason: "being ganon"},
+					},
+				},
+				Unmapped: []foreign.Unmapped{
+					{Record: "line 5: 1677672900,C2,link,,1,", Reason: "no receiver"},
+					{Record: "line 6: 1677673200,C2,link,navi,lots,", Reason: "points must be a whole number"},
+					{Record: "line 7: yesterday,C2,link,navi,1,", Reason: "timestamp must be Unix seconds or RFC 3339"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			p, err := foreign.Lookup(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			got, err := p.Parse(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want\n%+v\ngot\n%+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	tests := []struct {
+		source string
+		input  string
+	}{
+		{source: "hubot", input: `{"users": {}}`},
+		{source: "hubot", input: `not json`},
+		{source: "karmabot", input: "timestamp,giver,receiver\n1677672000,zelda,link\n"},
+		{source: "karmabot", input: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			p, err := foreign.Lookup(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = p.Parse(strings.NewReader(tt.input))
+			if !errors.Is(err, foreign.ErrMalformed) {
+				t.Errorf("want %v, got %v", foreign.ErrMalformed, err)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	_, err := foreign.Lookup("plusplusbot")
+	if !errors.Is(err, foreign.ErrUnknownSource) {
+		t.Errorf("want %v, got %v", foreign.ErrUnknownSource, err)
+	}
+
+	want := []string{"hubot", "karmabot"}
+	if got := foreign.Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,85 @@
+package foreign
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/popple"
+)
+
+// Hubot reads the brain of a Hubot running hubot-plusplus. Scores become
+// server-wide karma. Reasons and the giving log only say how often, not
+// when, so they have no history to become and are reported as unmapped.
+type Hubot struct{}
+
+type hubotBrain struct {
+	PlusPlus *struct {
+		Scores  map[string]json.Number            `json:"scores"`
+		Reasons map[string]map[string]json.Number `json:"reasons"`
+		Log     map[string]json.RawMessage        `json:"log"`
+	} `json:"plusPlus"`
+}
+
+func (Hubot) Parse(r io.Reader) (Result, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var brain hubotBrain
+	if err := dec.Decode(&brain); err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if brain.PlusPlus == nil {
+		return Result{}, fmt.Errorf("%w: no plusPlus scores in the brain", ErrMalformed)
+	}
+
+	var result Result
+	for name, score := range brain.PlusPlus.Scores {
+		karma, err := score.Int64()
+		if err != nil {
+			// hubot-plusplus can be configured to give fractional points.
+			f, ferr := score.Float64()
+			if ferr != nil || f != math.Trunc(f) {
+				result.Unmapped = append(result.Unmapped, Unmapped{
+					Record: fmt.Sprintf("scores.%s = %s", name, score),
+					Reason: "karma must be a whole number",
+				})
+				continue
+			}
+			karma = int64(f)
+		}
+
+		result.Data.Entities = append(result.Data.Entities, database.EntityRecord{
+			Entity: popple.Entity{Name: name, Karma: karma},
+		})
+	}
+
+	for name, reasons := range brain.PlusPlus.Reasons {
+		for reason, count := range reasons {
+			result.Unmapped = append(result.Unmapped, Unmapped{
+				Record: fmt.Sprintf("reasons.%s[%q] = %s", name, reason, count),
+				Reason: "reasons aren't recorded with a time",
+			})
+		}
+	}
+
+	for giver := range brain.PlusPlus.Log {
+		result.Unmapped = append(result.Unmapped, Unmapped{
+			Record: fmt.Sprintf("log.%s", giver),
+			Reason: "only the last time each subject was given karma is recorded",
+		})
+	}
+
+	// Maps have no order, but the report should read the same every time.
+	sort.Slice(result.Data.Entities, func(i, j int) bool {
+		return result.Data.Entities[i].Name < result.Data.Entities[j].Name
+	})
+	sort.Slice(result.Unmapped, func(i, j int) bool {
+		return result.Unmapped[i].Record < result.Unmapped[j].Record
+	})
+
+	return result, nil
+}
@@ -0,0 +1,124 @@
+package foreign
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/popple"
+)
+
+// KarmaBot reads the karma log KarmaBot exports as CSV. Each row is one
+// subject's karma changing, and the subject's karma is the sum of its
+// rows. Columns are found by their header, so their order doesn't matter:
+//
+//	timestamp: when it changed, in Unix seconds or RFC 3339
+//	channel:   where it changed; optional
+//	giver:     who changed it; optional
+//	receiver:  the subject
+//	points:    how much it changed by
+//	reason:    why it changed; optional
+//
+// Other columns are ignored.
+type KarmaBot struct{}
+
+var karmaBotRequired = []string{"timestamp", "receiver", "points"}
+
+func (KarmaBot) Parse(r io.Reader) (Result, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range karmaBotRequired {
+		if _, ok := columns[name]; !ok {
+			return Result{}, fmt.Errorf("%w: no %q column", ErrMalformed, name)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var result Result
+	karma := make(map[string]int64)
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Result{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+
+		unmapped := func(reason string) {
+			result.Unmapped = append(result.Unmapped, Unmapped{
+				Record: fmt.Sprintf("line %d: %s", line, strings.Join(record, ",")),
+				Reason: reason,
+			})
+		}
+
+		name := field(record, "receiver")
+		if len(name) == 0 {
+			unmapped("no receiver")
+			continue
+		}
+
+		points, err := strconv.ParseInt(field(record, "points"), 10, 64)
+		if err != nil {
+			unmapped("points must be a whole number")
+			continue
+		}
+
+		at, err := parseKarmaBotTime(field(record, "timestamp"))
+		if err != nil {
+			unmapped("timestamp must be Unix seconds or RFC 3339")
+			continue
+		}
+
+		karma[name] += points
+		result.Data.Events = append(result.Data.Events, database.EventRecord{
+			CreatedAt: at,
+			ChannelID: field(record, "channel"),
+			Name:      name,
+			Delta:     points,
+			Actor:     field(record, "giver"),
+			Reason:    field(record, "reason"),
+		})
+	}
+
+	for name, k := range karma {
+		result.Data.Entities = append(result.Data.Entities, database.EntityRecord{
+			Entity: popple.Entity{Name: name, Karma: k},
+		})
+	}
+	sort.Slice(result.Data.Entities, func(i, j int) bool {
+		return result.Data.Entities[i].Name < result.Data.Entities[j].Name
+	})
+
+	return result, nil
+}
+
+func parseKarmaBotTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
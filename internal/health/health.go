@@ -0,0 +1,177 @@
+// Package health serves the endpoints an orchestrator uses to tell whether
+// Popple is alive and ready: /healthz and /readyz.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/env"
+	"github.com/connorkuehl/popple/internal/httpserver"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultQuietTimeout is how long the connection may go without a
+// heartbeat before the watchdog gives up on it. Every chat service shows
+// signs of life well within it.
+const DefaultQuietTimeout = 5 * time.Minute
+
+// Config configures the health endpoints. They're disabled unless Addr is
+// set.
+type Config struct {
+	// Addr is the address to serve the endpoints on, e.g., ":8081".
+	Addr string
+	// QuietTimeout is how long the connection may go without a heartbeat
+	// before Popple isn't ready anymore.
+	QuietTimeout time.Duration
+}
+
+func ConfigFromEnv(lookup env.Lookup) (Config, error) {
+	return configFromEnv(lookup)
+}
+
+// Transport is the connection to the chat service.
+type Transport interface {
+	Connected() bool
+	// LastHeartbeat is when the chat service last showed that the
+	// connection is alive. It's zero if the transport has no connection to
+	// watch.
+	LastHeartbeat() time.Time
+}
+
+type DB interface {
+	Ping(ctx context.Context) error
+	SchemaVersion(ctx context.Context) (int, error)
+	LatestSchemaVersion() int
+}
+
+// checkTimeout bounds how long each readiness check can take.
+const checkTimeout = 2 * time.Second
+
+type Server struct {
+	config    Config
+	transport Transport
+	db        DB
+	mux       *http.ServeMux
+}
+
+func New(config Config, transport Transport, db DB) *Server {
+	if config.QuietTimeout <= 0 {
+		config.QuietTimeout = DefaultQuietTimeout
+	}
+
+	s := &Server{
+		config:    config,
+		transport: transport,
+		db:        db,
+		mux:       http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/healthz", s.healthz)
+	s.mux.HandleFunc("/readyz", s.readyz)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the endpoints until ctx is canceled. It returns
+// immediately if they're disabled.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if len(s.config.Addr) == 0 {
+		return nil
+	}
+
+	log.WithField("addr", s.config.Addr).Info("serving health checks")
+	return httpserver.ListenAndServe(ctx, s.config.Addr, s)
+}
+
+// healthz reports that the process is alive.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, status{Status: "ok"})
+}
+
+type status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// readyz reports whether Popple can do its job: it's connected to the chat
+// service, the connection is still alive, and the database is there and up
+// to date.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	checks := map[string]error{
+		"transport":  s.checkTransport(),
+		"watchdog":   s.checkWatchdog(time.Now()),
+		"database":   s.db.Ping(ctx),
+		"migrations": s.checkMigrations(ctx),
+	}
+
+	rsp := status{Status: "ok", Checks: make(map[string]string)}
+	code := http.StatusOK
+	for name, err := range checks {
+		if err != nil {
+			rsp.Checks[name] = err.Error()
+			rsp.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+			continue
+		}
+		rsp.Checks[name] = "ok"
+	}
+
+	if code != http.StatusOK {
+		log.WithField("checks", rsp.Checks).Warn("not ready")
+	}
+	writeStatus(w, code, rsp)
+}
+
+func (s *Server) checkTransport() error {
+	if !s.transport.Connected() {
+		return errors.New("not connected to the chat service")
+	}
+	return nil
+}
+
+// checkWatchdog fails if the chat service hasn't shown that the connection
+// is alive for too long. That catches a connection that looks open but has
+// gone dead, as well as one that never comes back. How busy the chat is
+// doesn't matter, since a quiet server is nothing to worry about.
+func (s *Server) checkWatchdog(now time.Time) error {
+	heartbeat := s.transport.LastHeartbeat()
+	if heartbeat.IsZero() {
+		return nil
+	}
+	if quiet := now.Sub(heartbeat); quiet > s.config.QuietTimeout {
+		return fmt.Errorf("no heartbeat from the chat service for %s", quiet.Round(time.Second))
+	}
+	return nil
+}
+
+func (s *Server) checkMigrations(ctx context.Context) error {
+	version, err := s.db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if latest := s.db.LatestSchemaVersion(); version != latest {
+		return fmt.Errorf("database is at migration %d, want %d", version, latest)
+	}
+	return nil
+}
+
+func writeStatus(w http.ResponseWriter, code int, rsp status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(rsp); err != nil {
+		log.WithError(err).Warn("write health response")
+	}
+}
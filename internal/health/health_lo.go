@@ -0,0 +1,35 @@
+package health
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+func configFromEnv(f func(key string) (val string)) (Config, error) {
+	addr, err := env.Get("POPPLE_HEALTH_ADDR", f)
+	if errors.Is(err, env.ErrKeyNotFound) {
+		return Config{}, nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+
+	config := Config{Addr: addr, QuietTimeout: DefaultQuietTimeout}
+
+	timeout, err := env.Get("POPPLE_HEALTH_QUIET_TIMEOUT", f)
+	if errors.Is(err, env.ErrKeyNotFound) {
+		return config, nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+
+	config.QuietTimeout, err = time.ParseDuration(timeout)
+	if err != nil || config.QuietTimeout <= 0 {
+		return Config{}, fmt.Errorf("POPPLE_HEALTH_QUIET_TIMEOUT must be a positive duration, e.g., 5m: %q", timeout)
+	}
+	return config, nil
+}
@@ -0,0 +1,157 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/health"
+)
+
+type transport struct {
+	connected     bool
+	lastHeartbeat time.Time
+}
+
+func (t *transport) Connected() bool          { return t.connected }
+func (t *transport) LastHeartbeat() time.Time { return t.lastHeartbeat }
+
+// staleDB hasn't had its newest migrations applied.
+type staleDB struct {
+	*sqlite.DB
+}
+
+func (d staleDB) SchemaVersion(ctx context.Context) (int, error) {
+	return d.LatestSchemaVersion() - 1, nil
+}
+
+func newDB(t *testing.T) *sqlite.DB {
+	t.Helper()
+
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	return db
+}
+
+func check(t *testing.T, h http.Handler, path string) (int, map[string]any) {
+	t.Helper()
+
+	rsp := httptest.NewRecorder()
+	h.ServeHTTP(rsp, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var body map[string]any
+	if err := json.NewDecoder(rsp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return rsp.Code, body
+}
+
+func TestHealthz(t *testing.T) {
+	s := health.New(health.Config{}, &transport{}, newDB(t))
+
+	code, body := check(t, s, "/healthz")
+	if code != http.StatusOK {
+		t.Errorf("want status %d, got %d", http.StatusOK, code)
+	}
+	if want := map[string]any{"status": "ok"}; !reflect.DeepEqual(body, want) {
+		t.Errorf("want %v, got %v", want, body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		transport  *transport
+		db         health.DB
+		wantStatus int
+		wantFailed []string
+	}{
+		{
+			name:       "ready",
+			transport:  &transport{connected: true},
+			db:         newDB(t),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "disconnected",
+			transport:  &transport{connected: false},
+			db:         newDB(t),
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: []string{"transport"},
+		},
+		{
+			name:       "heartbeating",
+			transport:  &transport{connected: true, lastHeartbeat: time.Now()},
+			db:         newDB(t),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "gone quiet",
+			transport:  &transport{connected: true, lastHeartbeat: time.Now().Add(-time.Hour)},
+			db:         newDB(t),
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: []string{"watchdog"},
+		},
+		{
+			name:       "migrations missing",
+			transport:  &transport{connected: true},
+			db:         staleDB{newDB(t)},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: []string{"migrations"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := health.New(health.Config{}, tt.transport, tt.db)
+
+			code, body := check(t, s, "/readyz")
+			if code != tt.wantStatus {
+				t.Errorf("want status %d, got %d: %v", tt.wantStatus, code, body)
+			}
+
+			checks, _ := body["checks"].(map[string]any)
+			var failed []string
+			for _, name := range []string{"database", "migrations", "transport", "watchdog"} {
+				if checks[name] != "ok" {
+					failed = append(failed, name)
+				}
+			}
+			if !reflect.DeepEqual(failed, tt.wantFailed) {
+				t.Errorf("want failed checks %v, got %v", tt.wantFailed, failed)
+			}
+		})
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	tr := &transport{connected: true, lastHeartbeat: time.Now()}
+	s := health.New(health.Config{QuietTimeout: 10 * time.Millisecond}, tr, newDB(t))
+
+	// The connection looks open, but the chat service has stopped
+	// answering.
+	time.Sleep(20 * time.Millisecond)
+
+	code, body := check(t, s, "/readyz")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("want status %d, got %d", http.StatusServiceUnavailable, code)
+	}
+	if checks := body["checks"].(map[string]any); checks["watchdog"] == "ok" {
+		t.Errorf("want the watchdog to fail, got %v", checks)
+	}
+
+	// A heartbeat shows that the connection is alive again.
+	tr.lastHeartbeat = time.Now()
+
+	if code, body := check(t, s, "/readyz"); code != http.StatusOK {
+		t.Errorf("want status %d, got %d: %v", http.StatusOK, code, body)
+	}
+}
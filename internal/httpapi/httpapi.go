@@ -0,0 +1,456 @@
+// Package httpapi serves Popple's karma data over HTTP for dashboards and
+// other tools.
+package httpapi
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/command"
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/env"
+	"github.com/connorkuehl/popple/internal/httpserver"
+	"github.com/connorkuehl/popple/internal/i18n"
+	"github.com/connorkuehl/popple/internal/logging"
+	"github.com/connorkuehl/popple/internal/popple"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Config configures the API. The API is disabled unless Addr is set.
+type Config struct {
+	// Addr is the address to listen on, e.g., ":8080".
+	Addr string
+	// Token is the bearer token requests must present to read.
+	Token string
+	// WriteToken is the bearer token requests must present to change
+	// karma or manage webhooks. It can read too. The API is read-only if
+	// it isn't set.
+	WriteToken string
+}
+
+func ConfigFromEnv(lookup env.Lookup) (Config, error) {
+	return configFromEnv(lookup)
+}
+
+// MaxLimit is the largest page of a board that can be requested at once,
+// and MaxOffset is the furthest into a board a page can start.
+const (
+	MaxLimit  = 100
+	MaxOffset = 10_000
+)
+
+type DB interface {
+	Config(ctx context.Context, serverID string) (popple.ServerConfig, error)
+	Entities(ctx context.Context, serverID string, names ...string) ([]popple.Entity, error)
+	LeaderboardPage(ctx context.Context, serverID string, offset, limit uint) (popple.Board, error)
+	LoserboardPage(ctx context.Context, serverID string, offset, limit uint) (popple.Board, error)
+	WindowLeaderboard(ctx context.Context, serverID string, since time.Time, offset, limit uint) (popple.Board, error)
+	WindowLoserboard(ctx context.Context, serverID string, since time.Time, offset, limit uint) (popple.Board, error)
+	ReserveIdempotencyKey(ctx context.Context, serverID, key, requestHash string) (database.IdempotentResponse, bool, error)
+	PutIdempotentResponse(ctx context.Context, serverID, key string, rsp database.IdempotentResponse) error
+	ReleaseIdempotencyKey(ctx context.Context, serverID, key string) error
+	Webhooks(ctx context.Context, serverID string) ([]popple.Webhook, error)
+	PutWebhook(ctx context.Context, webhook popple.Webhook) (popple.Webhook, error)
+	DeleteWebhook(ctx context.Context, serverID string, id int64) error
+	WebhookDeadLetters(ctx context.Context, serverID string, webhookID int64) ([]database.WebhookDeadLetter, error)
+}
+
+// Karma applies karma changes the same way the bot applies the ones made
+// in chat.
+type Karma interface {
+	ChangeKarma(ctx context.Context, guildID string, event popple.KarmaEvent) (popple.Increments, error)
+}
+
+//go:embed openapi.json
+var openAPI []byte
+
+type Server struct {
+	config Config
+	db     DB
+	karma  Karma
+	log    *logging.Logger
+	mux    *http.ServeMux
+	// writeMu serializes webhook writes so that concurrent requests can't
+	// both get under a server's webhook limit.
+	writeMu sync.Mutex
+}
+
+// New returns an API server that logs with logger, or with the standard
+// logger if logger is nil.
+func New(config Config, db DB, karma Karma, logger *logging.Logger) *Server {
+	if logger == nil {
+		logger = logging.Standard()
+	}
+
+	s := &Server{
+		config: config,
+		db:     db,
+		karma:  karma,
+		log:    logger,
+		mux:    http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/openapi.json", s.openAPI)
+	s.mux.HandleFunc("/servers/", s.servers)
+	return s
+}
+
+// ServeHTTP serves the request. Everything logged while serving it,
+// including by the bot, shares a correlation ID.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r.WithContext(s.log.WithCorrelationID(r.Context())))
+}
+
+// ListenAndServe serves the API until ctx is canceled. It returns
+// immediately if the API is disabled.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if len(s.config.Addr) == 0 {
+		return nil
+	}
+
+	s.log.WithField("addr", s.config.Addr).Info("serving HTTP API")
+	return httpserver.ListenAndServe(ctx, s.config.Addr, s)
+}
+
+// access is what a request's token lets it do.
+type access int
+
+const (
+	noAccess access = iota
+	readAccess
+	writeAccess
+)
+
+func (s *Server) access(r *http.Request) access {
+	auth := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(auth, "Bearer ")
+	switch {
+	case token == auth:
+		return noAccess
+	case matches(token, s.config.WriteToken):
+		return writeAccess
+	case matches(token, s.config.Token):
+		return readAccess
+	}
+	return noAccess
+}
+
+func matches(token, want string) bool {
+	return len(want) > 0 && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPI)
+}
+
+// servers routes /servers/{id}/... Changing karma and anything to do with
+// webhooks, which say where karma is sent, take the write token.
+func (s *Server) servers(w http.ResponseWriter, r *http.Request) {
+	granted := s.access(r)
+	if granted == noAccess {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "missing or invalid API token")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/servers/"), "/")
+	for i, part := range parts {
+		p, err := url.PathUnescape(part)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "malformed path")
+			return
+		}
+		parts[i] = p
+	}
+
+	if len(parts) < 2 || len(parts[0]) == 0 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	serverID := parts[0]
+
+	if (parts[1] == "karma" || parts[1] == "webhooks") && granted < writeAccess {
+		writeError(w, http.StatusForbidden, "this API token can only read")
+		return
+	}
+
+	switch {
+	case len(parts) == 3 && parts[1] == "entities" && len(parts[2]) > 0:
+		s.onlyGet(w, r, func() { s.entity(w, r, serverID, parts[2]) })
+	case len(parts) == 2 && parts[1] == "leaderboard":
+		s.onlyGet(w, r, func() { s.leaderboard(w, r, serverID) })
+	case len(parts) == 2 && parts[1] == "config":
+		s.onlyGet(w, r, func() { s.serverConfig(w, r, serverID) })
+	case len(parts) == 2 && parts[1] == "karma":
+		s.only(http.MethodPost, w, r, func() { s.changeKarma(w, r, serverID) })
+	case len(parts) == 2 && parts[1] == "webhooks":
+		s.webhooks(w, r, serverID)
+	case len(parts) == 3 && parts[1] == "webhooks":
+		s.only(http.MethodDelete, w, r, func() { s.deleteWebhook(w, r, serverID, parts[2]) })
+	case len(parts) == 4 && parts[1] == "webhooks" && parts[3] == "dead-letters":
+		s.onlyGet(w, r, func() { s.webhookDeadLetters(w, r, serverID, parts[2]) })
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) onlyGet(w http.ResponseWriter, r *http.Request, h func()) {
+	s.only(http.MethodGet, w, r, h)
+}
+
+func (s *Server) only(method string, w http.ResponseWriter, r *http.Request, h func()) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	h()
+}
+
+type entity struct {
+	Name  string `json:"name"`
+	Karma int64  `json:"karma"`
+}
+
+func (s *Server) entity(w http.ResponseWriter, r *http.Request, serverID, name string) {
+	ll := logging.FromContext(r.Context()).WithFields(log.Fields{
+		"guild_id": serverID,
+		"name":     name,
+		"handler":  "api_entity",
+	})
+
+	ents, err := s.db.Entities(r.Context(), serverID, name)
+	if err != nil {
+		ll.WithError(err).Error("Entities")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	rsp := entity{Name: name}
+	if len(ents) > 0 {
+		rsp.Karma = ents[0].Karma
+	}
+	writeJSON(w, http.StatusOK, rsp)
+}
+
+type boardEntry struct {
+	Rank  int    `json:"rank"`
+	Name  string `json:"name"`
+	Karma int64  `json:"karma"`
+}
+
+type board struct {
+	Entries []boardEntry `json:"entries"`
+	Offset  uint         `json:"offset"`
+	Limit   uint         `json:"limit"`
+	// NextOffset is where the next page starts, if there might be one.
+	NextOffset *uint `json:"next_offset,omitempty"`
+}
+
+func (s *Server) leaderboard(w http.ResponseWriter, r *http.Request, serverID string) {
+	ll := logging.FromContext(r.Context()).WithFields(log.Fields{
+		"guild_id": serverID,
+		"query":    r.URL.RawQuery,
+		"handler":  "api_leaderboard",
+	})
+
+	q := r.URL.Query()
+
+	limit := command.DefaultLimit
+	if v := q.Get("limit"); len(v) > 0 {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || n == 0 || n > MaxLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(MaxLimit))
+			return
+		}
+		limit = uint(n)
+	}
+
+	var offset uint
+	if v := q.Get("offset"); len(v) > 0 {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || n > MaxOffset {
+			writeError(w, http.StatusBadRequest, "offset must be between 0 and "+strconv.Itoa(MaxOffset))
+			return
+		}
+		offset = uint(n)
+	}
+
+	ord := popple.BoardOrderDsc
+	switch q.Get("order") {
+	case "", "desc":
+	case "asc":
+		ord = popple.BoardOrderAsc
+	default:
+		writeError(w, http.StatusBadRequest, `order must be "asc" or "desc"`)
+		return
+	}
+
+	var window time.Duration
+	if v := q.Get("window"); len(v) > 0 {
+		var err error
+		window, err = parseWindow(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, `window must be a positive duration, e.g., "24h" or "7d"`)
+			return
+		}
+	}
+
+	// Fetch one more entry than asked for to tell whether there's
+	// another page.
+	n := limit + 1
+
+	var entries popple.Board
+	var err error
+	switch {
+	case window > 0 && ord == popple.BoardOrderAsc:
+		entries, err = s.db.WindowLoserboard(r.Context(), serverID, time.Now().Add(-window), offset, n)
+	case window > 0:
+		entries, err = s.db.WindowLeaderboard(r.Context(), serverID, time.Now().Add(-window), offset, n)
+	case ord == popple.BoardOrderAsc:
+		entries, err = s.db.LoserboardPage(r.Context(), serverID, offset, n)
+	default:
+		entries, err = s.db.LeaderboardPage(r.Context(), serverID, offset, n)
+	}
+	if err != nil {
+		ll.WithError(err).Error("board")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	rsp := board{Entries: []boardEntry{}, Offset: offset, Limit: limit}
+	for i, e := range entries {
+		if uint(i) == limit {
+			next := offset + limit
+			rsp.NextOffset = &next
+			break
+		}
+		rsp.Entries = append(rsp.Entries, boardEntry{Rank: int(offset) + i + 1, Name: e.Who, Karma: e.Karma})
+	}
+
+	writeJSON(w, http.StatusOK, rsp)
+}
+
+// parseWindow parses a Go duration, or a number of days such as "7d".
+func parseWindow(v string) (time.Duration, error) {
+	var d time.Duration
+	if strings.HasSuffix(v, "d") {
+		n, err := strconv.ParseUint(strings.TrimSuffix(v, "d"), 10, 16)
+		if err != nil {
+			return 0, err
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		d, err = time.ParseDuration(v)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if d <= 0 {
+		return 0, errors.New("window must be positive")
+	}
+	return d, nil
+}
+
+type serverConfig struct {
+	ServerID             string            `json:"server_id"`
+	Announce             string            `json:"announce"`
+	DigestIntervalSecond int64             `json:"digest_interval_seconds,omitempty"`
+	ChannelFilter        string            `json:"channel_filter"`
+	FilteredChannels     []string          `json:"filtered_channels"`
+	PooledChannels       []string          `json:"pooled_channels"`
+	Language             string            `json:"language"`
+	Embeds               bool              `json:"embeds"`
+	Templates            map[string]string `json:"templates"`
+}
+
+var announceModes = map[popple.AnnounceMode]string{
+	popple.AnnounceMessage: "message",
+	popple.AnnounceOff:     "off",
+	popple.AnnounceReact:   "react",
+	popple.AnnounceReply:   "reply",
+	popple.AnnounceDigest:  "digest",
+}
+
+var channelFilters = map[popple.ChannelFilter]string{
+	popple.ChannelFilterNone:  "all",
+	popple.ChannelFilterAllow: "allow",
+	popple.ChannelFilterDeny:  "deny",
+}
+
+func (s *Server) serverConfig(w http.ResponseWriter, r *http.Request, serverID string) {
+	ll := logging.FromContext(r.Context()).WithFields(log.Fields{
+		"guild_id": serverID,
+		"handler":  "api_config",
+	})
+
+	config, err := s.db.Config(r.Context(), serverID)
+	if errors.Is(err, database.ErrNotFound) {
+		// Servers that haven't changed anything get the defaults, same as
+		// the bot gives them.
+		config, err = popple.ServerConfig{ServerID: serverID}, nil
+	}
+	if err != nil {
+		ll.WithError(err).Error("Config")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	rsp := serverConfig{
+		ServerID:         config.ServerID,
+		Announce:         announceModes[config.Announce],
+		ChannelFilter:    channelFilters[config.ChannelFilter],
+		FilteredChannels: nonNil(config.FilteredChannels),
+		PooledChannels:   nonNil(config.PooledChannels),
+		Language:         config.Locale,
+		Embeds:           config.Embeds,
+		Templates:        config.Templates,
+	}
+	if config.Announce == popple.AnnounceDigest {
+		rsp.DigestIntervalSecond = int64(config.DigestInterval / time.Second)
+	}
+	if len(rsp.Language) == 0 {
+		rsp.Language = i18n.Default
+	}
+	if rsp.Templates == nil {
+		rsp.Templates = map[string]string{}
+	}
+
+	writeJSON(w, http.StatusOK, rsp)
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithError(err).Warn("write API response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{msg})
+}
@@ -0,0 +1,28 @@
+package httpapi
+
+import (
+	"errors"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+var ErrMissingToken = errors.New("POPPLE_HTTP_API_TOKEN must be set when the HTTP API is enabled")
+
+func configFromEnv(f func(key string) (val string)) (Config, error) {
+	addr, err := env.Get("POPPLE_HTTP_API_ADDR", f)
+	if errors.Is(err, env.ErrKeyNotFound) {
+		return Config{}, nil
+	}
+
+	token, err := env.Get("POPPLE_HTTP_API_TOKEN", f)
+	if err != nil {
+		return Config{}, ErrMissingToken
+	}
+
+	writeToken, err := env.Get("POPPLE_HTTP_API_WRITE_TOKEN", f)
+	if err != nil && !errors.Is(err, env.ErrKeyNotFound) {
+		return Config{}, err
+	}
+
+	return Config{Addr: addr, Token: token, WriteToken: writeToken}, nil
+}
@@ -0,0 +1,715 @@
+package httpapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/command"
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/discord/discordtest"
+	"github.com/connorkuehl/popple/internal/httpapi"
+	"github.com/connorkuehl/popple/internal/logging"
+	"github.com/connorkuehl/popple/internal/popple"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+const (
+	token      = "s3cr3t"
+	writeToken = "wr1t3r"
+)
+
+func newServer(t *testing.T) (*httptest.Server, *sqlite.DB) {
+	t.Helper()
+
+	srv, db, _ := newServerWithRecorder(t)
+	return srv, db
+}
+
+// newServerWithRecorder also returns what the bot announced for changes
+// made through the API.
+func newServerWithRecorder(t *testing.T) (*httptest.Server, *sqlite.DB, *discordtest.ResponseRecorder) {
+	t.Helper()
+
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	session := discordtest.NewResponseRecorder(nil)
+	b := bot.New(session, db, command.NewRouter("@popple"), nil)
+
+	srv := httptest.NewServer(httpapi.New(httpapi.Config{Token: token, WriteToken: writeToken}, db, b, nil))
+	t.Cleanup(srv.Close)
+
+	return srv, db, session
+}
+
+func get(t *testing.T, srv *httptest.Server, path string, rsp any) int {
+	t.Helper()
+	return getWith(t, srv, token, path, rsp)
+}
+
+func getWith(t *testing.T, srv *httptest.Server, token, path string, rsp any) int {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if rsp != nil {
+		if err := json.NewDecoder(res.Body).Decode(rsp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res.StatusCode
+}
+
+func TestAuth(t *testing.T) {
+	srv, _ := newServer(t)
+
+	for _, header := range []string{"", "Bearer nope", token} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/servers/1/config", nil)
+		req.Header.Set("Authorization", header)
+		res, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: want status %d, got %d", header, http.StatusUnauthorized, res.StatusCode)
+		}
+	}
+
+	// The OpenAPI document is public.
+	res, err := srv.Client().Get(srv.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var doc map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || doc["openapi"] == nil {
+		t.Errorf("want OpenAPI document, got status %d", res.StatusCode)
+	}
+}
+
+func TestTokenScopes(t *testing.T) {
+	srv, _ := newServer(t)
+
+	tests := []struct {
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{http.MethodGet, "/servers/1/config", token, http.StatusOK},
+		{http.MethodGet, "/servers/1/config", writeToken, http.StatusOK},
+		{http.MethodPost, "/servers/1/karma", token, http.StatusForbidden},
+		{http.MethodGet, "/servers/1/webhooks", token, http.StatusForbidden},
+		{http.MethodGet, "/servers/1/webhooks", writeToken, http.StatusOK},
+		{http.MethodDelete, "/servers/1/webhooks/1", token, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader("{}"))
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		res, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != tt.want {
+			t.Errorf("%s %s with %q: want status %d, got %d", tt.method, tt.path, tt.token, tt.want, res.StatusCode)
+		}
+	}
+
+	// Without a write token, the API is read-only.
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	readOnly := httptest.NewServer(httpapi.New(httpapi.Config{Token: token}, db, nil, nil))
+	t.Cleanup(readOnly.Close)
+
+	req, _ := http.NewRequest(http.MethodPost, readOnly.URL+"/servers/1/karma", strings.NewReader("{}"))
+	req.Header.Set("Authorization", "Bearer ")
+	res, err := readOnly.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("empty token: want status %d, got %d", http.StatusUnauthorized, res.StatusCode)
+	}
+}
+
+func TestEntity(t *testing.T) {
+	srv, db := newServer(t)
+
+	if err := db.PutEntities(context.Background(), "1", popple.Entity{Name: "Poe the Potato Pirate", Karma: 12}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want map[string]any
+	}{
+		{path: "/servers/1/entities/Poe%20the%20Potato%20Pirate", want: map[string]any{"name": "Poe the Potato Pirate", "karma": 12.0}},
+		{path: "/servers/1/entities/nobody", want: map[string]any{"name": "nobody", "karma": 0.0}},
+		{path: "/servers/2/entities/Poe%20the%20Potato%20Pirate", want: map[string]any{"name": "Poe the Potato Pirate", "karma": 0.0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var got map[string]any
+			if status := get(t, srv, tt.path, &got); status != http.StatusOK {
+				t.Errorf("want status %d, got %d", http.StatusOK, status)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+type board struct {
+	Entries []struct {
+		Rank  int    `json:"rank"`
+		Name  string `json:"name"`
+		Karma int64  `json:"karma"`
+	} `json:"entries"`
+	Offset     uint  `json:"offset"`
+	Limit      uint  `json:"limit"`
+	NextOffset *uint `json:"next_offset"`
+}
+
+func (b board) names() []string {
+	var names []string
+	for _, e := range b.Entries {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestLeaderboard(t *testing.T) {
+	ctx := context.Background()
+	srv, db := newServer(t)
+
+	err := db.PutEntities(ctx, "1",
+		popple.Entity{Name: "a", Karma: 5},
+		popple.Entity{Name: "b", Karma: 4},
+		popple.Entity{Name: "c", Karma: 3},
+		popple.Entity{Name: "d", Karma: -1},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutKarmaEvents(ctx, "1", popple.KarmaEvent{ChannelID: "9876", Increments: popple.Increments{"c": 3, "d": -1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		names []string
+		ranks []int
+		next  *uint
+	}{
+		{path: "/servers/1/leaderboard", names: []string{"a", "b", "c", "d"}, ranks: []int{1, 2, 3, 4}},
+		{path: "/servers/1/leaderboard?limit=2", names: []string{"a", "b"}, ranks: []int{1, 2}, next: uintp(2)},
+		{path: "/servers/1/leaderboard?limit=2&offset=2", names: []string{"c", "d"}, ranks: []int{3, 4}},
+		{path: "/servers/1/leaderboard?limit=1&offset=1", names: []string{"b"}, ranks: []int{2}, next: uintp(2)},
+		{path: "/servers/1/leaderboard?offset=4", names: nil},
+		{path: "/servers/1/leaderboard?window=7d&offset=1", names: []string{"d"}, ranks: []int{2}},
+		{path: "/servers/1/leaderboard?order=asc&limit=1", names: []string{"d"}, ranks: []int{1}, next: uintp(1)},
+		{path: "/servers/1/leaderboard?window=7d", names: []string{"c", "d"}, ranks: []int{1, 2}},
+		{path: "/servers/1/leaderboard?window=1h&order=asc", names: []string{"d", "c"}, ranks: []int{1, 2}},
+		{path: "/servers/2/leaderboard", names: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var got board
+			if status := get(t, srv, tt.path, &got); status != http.StatusOK {
+				t.Fatalf("want status %d, got %d", http.StatusOK, status)
+			}
+
+			if !reflect.DeepEqual(got.names(), tt.names) {
+				t.Errorf("want names %v, got %v", tt.names, got.names())
+			}
+			for i, e := range got.Entries {
+				if e.Rank != tt.ranks[i] {
+					t.Errorf("want %s ranked %d, got %d", e.Name, tt.ranks[i], e.Rank)
+				}
+			}
+			if !reflect.DeepEqual(got.NextOffset, tt.next) {
+				t.Errorf("want next offset %v, got %v", tt.next, got.NextOffset)
+			}
+		})
+	}
+
+	for _, path := range []string{
+		"/servers/1/leaderboard?limit=0",
+		"/servers/1/leaderboard?limit=101",
+		"/servers/1/leaderboard?offset=-1",
+		"/servers/1/leaderboard?offset=10001",
+		"/servers/1/leaderboard?order=sideways",
+		"/servers/1/leaderboard?window=-1h",
+		"/servers/1/leaderboard?window=forever",
+	} {
+		if status := get(t, srv, path, nil); status != http.StatusBadRequest {
+			t.Errorf("%s: want status %d, got %d", path, http.StatusBadRequest, status)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	srv, db := newServer(t)
+
+	config := popple.ServerConfig{
+		ServerID:       "1",
+		Announce:       popple.AnnounceDigest,
+		DigestInterval: 30 * time.Second,
+		ChannelFilter:  popple.ChannelFilterDeny,
+		Locale:         "de",
+		Templates:      map[string]string{"levels": "{{ . }}"},
+	}
+	config.FilteredChannels = []string{"9876"}
+	if err := db.PutConfig(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want map[string]any
+	}{
+		{
+			path: "/servers/1/config",
+			want: map[string]any{
+				"server_id":               "1",
+				"announce":                "digest",
+				"digest_interval_seconds": 30.0,
+				"channel_filter":          "deny",
+				"filtered_channels":       []any{"9876"},
+				"pooled_channels":         []any{},
+				"language":                "de",
+				"embeds":                  false,
+				"templates":               map[string]any{"levels": "{{ . }}"},
+			},
+		},
+		{
+			path: "/servers/2/config",
+			want: map[string]any{
+				"server_id":         "2",
+				"announce":          "message",
+				"channel_filter":    "all",
+				"filtered_channels": []any{},
+				"pooled_channels":   []any{},
+				"language":          "en",
+				"embeds":            false,
+				"templates":         map[string]any{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var got map[string]any
+			if status := get(t, srv, tt.path, &got); status != http.StatusOK {
+				t.Errorf("want status %d, got %d", http.StatusOK, status)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func post(t *testing.T, srv *httptest.Server, path, key, body string, rsp any) (int, http.Header) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+writeToken)
+	req.Header.Set("Content-Type", "application/json")
+	if len(key) > 0 {
+		req.Header.Set("Idempotency-Key", key)
+	}
+
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if rsp != nil {
+		if err := json.NewDecoder(res.Body).Decode(rsp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res.StatusCode, res.Header
+}
+
+func TestChangeKarma(t *testing.T) {
+	srv, db, session := newServerWithRecorder(t)
+
+	config := popple.ServerConfig{
+		ServerID:         "1",
+		ChannelFilter:    popple.ChannelFilterDeny,
+		FilteredChannels: []string{"quiet"},
+	}
+	if err := db.PutConfig(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantLevels popple.Increments
+	}{
+		{
+			name:       "applies and sums increments",
+			body:       `{"actor":"ci","reason":"green build","increments":[{"name":"build-cop","delta":2},{"name":"build-cop","delta":1},{"name":"flaky-test","delta":-1}]}`,
+			wantStatus: http.StatusOK,
+			wantLevels: popple.Increments{"build-cop": 3, "flaky-test": -1},
+		},
+		{
+			name:       "ignores net-zero increments",
+			body:       `{"actor":"ci","increments":[{"name":"build-cop","delta":1},{"name":"build-cop","delta":-1}]}`,
+			wantStatus: http.StatusOK,
+			wantLevels: popple.Increments{},
+		},
+		{
+			name:       "missing actor",
+			body:       `{"increments":[{"name":"build-cop","delta":1}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "no increments",
+			body:       `{"actor":"ci","increments":[]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "blank name",
+			body:       `{"actor":"ci","increments":[{"name":" ","delta":1}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "zero delta",
+			body:       `{"actor":"ci","increments":[{"name":"build-cop","delta":0}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "huge delta",
+			body:       `{"actor":"ci","increments":[{"name":"build-cop","delta":9223372036854775807},{"name":"build-cop","delta":1}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "not JSON",
+			body:       `build-cop++`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unwatched channel",
+			body:       `{"channel_id":"quiet","actor":"ci","increments":[{"name":"build-cop","delta":1}]}`,
+			wantStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got struct {
+				Levels popple.Increments `json:"levels"`
+			}
+			if status, _ := post(t, srv, "/servers/1/karma", "", tt.body, &got); status != tt.wantStatus {
+				t.Fatalf("want status %d, got %d", tt.wantStatus, status)
+			}
+			if tt.wantStatus == http.StatusOK && !reflect.DeepEqual(got.Levels, tt.wantLevels) {
+				t.Errorf("want levels %v, got %v", tt.wantLevels, got.Levels)
+			}
+		})
+	}
+
+	if len(session.Responses) > 0 {
+		t.Errorf("want no announcements without a channel, got %v", session.Responses)
+	}
+
+	// Changes with a channel are announced there.
+	body := `{"channel_id":"ci","actor":"ci","increments":[{"name":"build-cop","delta":1}]}`
+	if status, _ := post(t, srv, "/servers/1/karma", "", body, nil); status != http.StatusOK {
+		t.Fatalf("want status %d, got %d", http.StatusOK, status)
+	}
+	want := []discordtest.Response{{Message: discordtest.Message{ChannelID: "ci", Content: "build-cop has 4 karma."}}}
+	if !reflect.DeepEqual(session.Responses, want) {
+		t.Errorf("want announcements %v, got %v", want, session.Responses)
+	}
+
+	var entity map[string]any
+	get(t, srv, "/servers/1/entities/build-cop", &entity)
+	if entity["karma"] != 4.0 {
+		t.Errorf("want build-cop to have 4 karma, got %v", entity["karma"])
+	}
+}
+
+func TestChangeKarmaLogs(t *testing.T) {
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	logger, hook := logtest.NewNullLogger()
+	ll := logging.New(logger, logging.Config{RedactContent: true})
+	b := bot.New(discordtest.NewResponseRecorder(nil), db, command.NewRouter("@popple"), ll)
+	srv := httptest.NewServer(httpapi.New(httpapi.Config{Token: token, WriteToken: writeToken}, db, b, ll))
+	defer srv.Close()
+
+	body := `{"actor":"ci","reason":"shipped the secret project","increments":[{"name":"build-cop","delta":1}]}`
+	for i := 0; i < 2; i++ {
+		if status, _ := post(t, srv, "/servers/1/karma", "", body, nil); status != http.StatusOK {
+			t.Fatalf("want status %d, got %d", http.StatusOK, status)
+		}
+	}
+
+	entries := hook.AllEntries()
+	if len(entries) != 2 {
+		t.Fatalf("want an entry per request, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Data["reason"] != logging.Redacted {
+			t.Errorf("want the reason redacted, got %v", entry.Data["reason"])
+		}
+		if id, _ := entry.Data[logging.CorrelationIDField].(string); len(id) == 0 {
+			t.Errorf("want a correlation ID, got %v", entry.Data)
+		}
+	}
+	if entries[0].Data[logging.CorrelationIDField] == entries[1].Data[logging.CorrelationIDField] {
+		t.Errorf("want each request correlated separately, got %v twice", entries[0].Data[logging.CorrelationIDField])
+	}
+}
+
+func TestChangeKarmaIdempotency(t *testing.T) {
+	srv, _, _ := newServerWithRecorder(t)
+
+	body := `{"actor":"ci","increments":[{"name":"build-cop","delta":1}]}`
+
+	var first, second map[string]any
+	status, header := post(t, srv, "/servers/1/karma", "build-42", body, &first)
+	if status != http.StatusOK {
+		t.Fatalf("want status %d, got %d", http.StatusOK, status)
+	}
+	if header.Get("Idempotent-Replayed") != "" {
+		t.Errorf("first request shouldn't be a replay")
+	}
+
+	status, header = post(t, srv, "/servers/1/karma", "build-42", body, &second)
+	if status != http.StatusOK {
+		t.Fatalf("want status %d, got %d", http.StatusOK, status)
+	}
+	if header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("want retry to be a replay")
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("want replayed response %v, got %v", first, second)
+	}
+
+	var entity map[string]any
+	get(t, srv, "/servers/1/entities/build-cop", &entity)
+	if entity["karma"] != 1.0 {
+		t.Errorf("want retry to be applied once, got %v karma", entity["karma"])
+	}
+
+	// Keys can't be reused for something else.
+	other := `{"actor":"ci","increments":[{"name":"build-cop","delta":5}]}`
+	if status, _ := post(t, srv, "/servers/1/karma", "build-42", other, nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("want status %d, got %d", http.StatusUnprocessableEntity, status)
+	}
+
+	// Keys are per server.
+	if status, header := post(t, srv, "/servers/2/karma", "build-42", body, nil); status != http.StatusOK || header.Get("Idempotent-Replayed") != "" {
+		t.Errorf("want a fresh request on another server, got status %d", status)
+	}
+}
+
+// abandonedKarma fails the first change the way a request does when its
+// client goes away while it's being applied.
+type abandonedKarma struct {
+	httpapi.Karma
+	once sync.Once
+}
+
+func (k *abandonedKarma) ChangeKarma(ctx context.Context, guildID string, event popple.KarmaEvent) (popple.Increments, error) {
+	abandoned := false
+	k.once.Do(func() { abandoned = true })
+	if abandoned {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return k.Karma.ChangeKarma(ctx, guildID, event)
+}
+
+func TestChangeKarmaRetryAfterClientGoesAway(t *testing.T) {
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	b := bot.New(discordtest.NewResponseRecorder(nil), db, command.NewRouter("@popple"), nil)
+	api := httpapi.New(httpapi.Config{Token: token, WriteToken: writeToken}, db, &abandonedKarma{Karma: b}, nil)
+
+	body := `{"actor":"ci","increments":[{"name":"build-cop","delta":1}]}`
+	request := func(ctx context.Context) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/servers/1/karma", strings.NewReader(body)).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer "+writeToken)
+		req.Header.Set("Idempotency-Key", "build-44")
+		rsp := httptest.NewRecorder()
+		api.ServeHTTP(rsp, req)
+		return rsp
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if rsp := request(ctx); rsp.Code != http.StatusInternalServerError {
+		t.Fatalf("want status %d, got %d", http.StatusInternalServerError, rsp.Code)
+	}
+
+	if rsp := request(context.Background()); rsp.Code != http.StatusOK {
+		t.Errorf("want the retry applied with status %d, got %d: %s", http.StatusOK, rsp.Code, rsp.Body)
+	}
+}
+
+func TestChangeKarmaConcurrentRetries(t *testing.T) {
+	srv, _, _ := newServerWithRecorder(t)
+
+	body := `{"actor":"ci","increments":[{"name":"build-cop","delta":1}]}`
+
+	const retries = 8
+	var wg sync.WaitGroup
+	for i := 0; i < retries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			post(t, srv, "/servers/1/karma", "build-43", body, nil)
+		}()
+	}
+	wg.Wait()
+
+	var entity map[string]any
+	get(t, srv, "/servers/1/entities/build-cop", &entity)
+	if entity["karma"] != 1.0 {
+		t.Errorf("want the change applied once, got %v karma", entity["karma"])
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	srv, _ := newServer(t)
+
+	var created map[string]any
+	status, _ := post(t, srv, "/servers/1/webhooks", "", `{"url":"https://example.com/hook","events":["karma.increased"]}`, &created)
+	if status != http.StatusCreated {
+		t.Fatalf("want status %d, got %d", http.StatusCreated, status)
+	}
+	if secret, _ := created["secret"].(string); len(secret) == 0 {
+		t.Errorf("want a generated secret, got %v", created)
+	}
+
+	for _, body := range []string{
+		`{"url":"example.com/hook"}`,
+		`{"url":"ftp://example.com/hook"}`,
+		`{"url":"https://example.com/hook","events":["karma.potato"]}`,
+	} {
+		if status, _ := post(t, srv, "/servers/1/webhooks", "", body, nil); status != http.StatusBadRequest {
+			t.Errorf("%s: want status %d, got %d", body, http.StatusBadRequest, status)
+		}
+	}
+
+	var list map[string]any
+	getWith(t, srv, writeToken, "/servers/1/webhooks", &list)
+	want := map[string]any{"webhooks": []any{
+		map[string]any{"id": created["id"], "url": "https://example.com/hook", "events": []any{"karma.increased"}},
+	}}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("want %v, got %v", want, list)
+	}
+
+	// Webhooks belong to their server.
+	getWith(t, srv, writeToken, "/servers/2/webhooks", &list)
+	if want := map[string]any{"webhooks": []any{}}; !reflect.DeepEqual(list, want) {
+		t.Errorf("want %v, got %v", want, list)
+	}
+
+	var letters map[string]any
+	if status := getWith(t, srv, writeToken, fmt.Sprintf("/servers/1/webhooks/%v/dead-letters", created["id"]), &letters); status != http.StatusOK {
+		t.Errorf("want status %d, got %d", http.StatusOK, status)
+	}
+	if want := map[string]any{"dead_letters": []any{}}; !reflect.DeepEqual(letters, want) {
+		t.Errorf("want %v, got %v", want, letters)
+	}
+
+	path := fmt.Sprintf("/servers/1/webhooks/%v", created["id"])
+	if status := do(t, srv, http.MethodDelete, path); status != http.StatusNoContent {
+		t.Errorf("want status %d, got %d", http.StatusNoContent, status)
+	}
+	if status := do(t, srv, http.MethodDelete, path); status != http.StatusNotFound {
+		t.Errorf("want status %d, got %d", http.StatusNotFound, status)
+	}
+}
+
+func do(t *testing.T, srv *httptest.Server, method, path string) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+writeToken)
+
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestNotFound(t *testing.T) {
+	srv, _ := newServer(t)
+
+	for _, path := range []string{"/servers/", "/servers/1", "/servers/1/potato", "/servers/1/entities/"} {
+		if status := get(t, srv, path, nil); status != http.StatusNotFound {
+			t.Errorf("%s: want status %d, got %d", path, http.StatusNotFound, status)
+		}
+	}
+}
+
+func uintp(n uint) *uint {
+	return &n
+}
@@ -0,0 +1,215 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/logging"
+	"github.com/connorkuehl/popple/internal/popple"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxIncrements is the most subjects a single request can change the karma
+// of.
+const MaxIncrements = 100
+
+// MaxDelta bounds how much a single increment can change karma by, so
+// that adding increments up can't overflow.
+const MaxDelta = 1_000_000
+
+// maxBodySize bounds how much of a request body is read.
+const maxBodySize = 64 << 10
+
+// settleTimeout bounds how long remembering or giving back an idempotency
+// key can take once the change has been applied or has failed.
+const settleTimeout = 5 * time.Second
+
+type changeKarmaRequest struct {
+	// ChannelID is where the change is announced, if anywhere.
+	ChannelID  string      `json:"channel_id"`
+	Actor      string      `json:"actor"`
+	Reason     string      `json:"reason"`
+	Increments []increment `json:"increments"`
+}
+
+type increment struct {
+	Name  string `json:"name"`
+	Delta int64  `json:"delta"`
+}
+
+type changeKarmaResponse struct {
+	Levels popple.Increments `json:"levels"`
+}
+
+// changeKarma applies a karma change. Requests with an Idempotency-Key
+// header are only applied once; retries get the first response back.
+func (s *Server) changeKarma(w http.ResponseWriter, r *http.Request, serverID string) {
+	ll := logging.FromContext(r.Context()).WithFields(log.Fields{
+		"guild_id": serverID,
+		"handler":  "api_change_karma",
+	})
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "could not read request body")
+		return
+	}
+	if len(body) > maxBodySize {
+		writeError(w, http.StatusRequestEntityTooLarge, "request body is too large")
+		return
+	}
+
+	key := r.Header.Get("Idempotency-Key")
+	if len(key) == 0 {
+		status, rsp := s.applyKarma(r, ll, serverID, body)
+		writeJSON(w, status, rsp)
+		return
+	}
+
+	ll = ll.WithField("idempotency_key", key)
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+
+	// The key is claimed before the change is applied so that concurrent
+	// retries can't both apply it.
+	prev, reserved, err := s.db.ReserveIdempotencyKey(r.Context(), serverID, key, hash)
+	switch {
+	case err != nil:
+		ll.WithError(err).Error("ReserveIdempotencyKey")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	case reserved:
+	case prev.RequestHash != hash:
+		writeError(w, http.StatusUnprocessableEntity, "idempotency key was already used for a different request")
+		return
+	case prev.Status == 0:
+		writeError(w, http.StatusConflict, "a request with this idempotency key is still being applied")
+		return
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(prev.Status)
+		_, _ = w.Write(prev.Body)
+		return
+	}
+
+	status, rsp := s.applyKarma(r, ll, serverID, body)
+
+	encoded, err := json.Marshal(rsp)
+	if err != nil {
+		status, rsp = http.StatusInternalServerError, errorResponse{"internal error"}
+		ll.WithError(err).Error("encode response")
+	}
+
+	// The key is settled even if the client went away while the change
+	// was applied, or else its retries would find it still reserved.
+	ctx, cancel := context.WithTimeout(detached{r.Context()}, settleTimeout)
+	defer cancel()
+
+	// Server errors leave karma unchanged and are worth retrying, so the
+	// key is given back instead of remembering them.
+	if status >= http.StatusInternalServerError {
+		if err := s.db.ReleaseIdempotencyKey(ctx, serverID, key); err != nil {
+			ll.WithError(err).Error("ReleaseIdempotencyKey")
+		}
+	} else {
+		remembered := database.IdempotentResponse{RequestHash: hash, Status: status, Body: append(encoded, '\n')}
+		if err := s.db.PutIdempotentResponse(ctx, serverID, key, remembered); err != nil {
+			ll.WithError(err).Error("PutIdempotentResponse")
+		}
+	}
+
+	writeJSON(w, status, rsp)
+}
+
+// applyKarma validates and applies a request body and returns the
+// response to send.
+func (s *Server) applyKarma(r *http.Request, ll *log.Entry, serverID string, body []byte) (int, any) {
+	var req changeKarmaRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return http.StatusBadRequest, errorResponse{"request body must be a JSON object"}
+	}
+
+	req.Actor = strings.TrimSpace(req.Actor)
+	if len(req.Actor) == 0 {
+		return http.StatusBadRequest, errorResponse{"actor is required"}
+	}
+	if len(req.Increments) == 0 {
+		return http.StatusBadRequest, errorResponse{"increments are required"}
+	}
+	if len(req.Increments) > MaxIncrements {
+		return http.StatusBadRequest, errorResponse{"too many increments"}
+	}
+
+	increments := make(popple.Increments)
+	for _, incr := range req.Increments {
+		name := strings.TrimSpace(incr.Name)
+		if len(name) == 0 {
+			return http.StatusBadRequest, errorResponse{"every increment needs a name"}
+		}
+		if incr.Delta == 0 {
+			return http.StatusBadRequest, errorResponse{"every increment needs a non-zero delta"}
+		}
+		if incr.Delta > MaxDelta || incr.Delta < -MaxDelta {
+			return http.StatusBadRequest, errorResponse{"delta is too large"}
+		}
+		increments[name] += incr.Delta
+	}
+
+	// Net-zero changes are ignored, same as in chat.
+	for name, delta := range increments {
+		if delta == 0 {
+			delete(increments, name)
+		}
+	}
+	if len(increments) == 0 {
+		return http.StatusOK, changeKarmaResponse{Levels: popple.Increments{}}
+	}
+
+	event := popple.KarmaEvent{
+		ChannelID:  req.ChannelID,
+		Increments: increments,
+		Actor:      req.Actor,
+		Reason:     req.Reason,
+	}
+
+	levels, err := s.karma.ChangeKarma(r.Context(), serverID, event)
+	if errors.Is(err, bot.ErrNotWatched) {
+		return http.StatusConflict, errorResponse{"the server doesn't count karma in that channel"}
+	}
+	if err != nil {
+		ll.WithError(err).Error("ChangeKarma")
+		return http.StatusInternalServerError, errorResponse{"internal error"}
+	}
+
+	ll.WithFields(log.Fields{
+		"actor":      req.Actor,
+		"reason":     s.log.Content(req.Reason),
+		"increments": increments,
+	}).Info("changed karma")
+
+	return http.StatusOK, changeKarmaResponse{Levels: levels}
+}
+
+// detached keeps a context's values but not its cancellation.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
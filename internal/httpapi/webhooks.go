@@ -0,0 +1,232 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/logging"
+	"github.com/connorkuehl/popple/internal/popple"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxWebhooks is how many webhooks a server can have.
+const MaxWebhooks = 10
+
+type webhook struct {
+	ID     int64    `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret is only shown when the webhook is created.
+	Secret string `json:"secret,omitempty"`
+}
+
+type createWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret is generated if it isn't given.
+	Secret string `json:"secret"`
+}
+
+var webhookEvents = map[string]popple.WebhookEvent{
+	string(popple.WebhookKarmaIncreased): popple.WebhookKarmaIncreased,
+	string(popple.WebhookKarmaDecreased): popple.WebhookKarmaDecreased,
+}
+
+func newWebhook(w popple.Webhook) webhook {
+	rsp := webhook{ID: w.ID, URL: w.URL, Events: []string{}}
+	for _, e := range w.Events {
+		rsp.Events = append(rsp.Events, string(e))
+	}
+	return rsp
+}
+
+// webhooks serves /servers/{id}/webhooks.
+func (s *Server) webhooks(w http.ResponseWriter, r *http.Request, serverID string) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listWebhooks(w, r, serverID)
+	case http.MethodPost:
+		s.createWebhook(w, r, serverID)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) listWebhooks(w http.ResponseWriter, r *http.Request, serverID string) {
+	ll := logging.FromContext(r.Context()).WithFields(log.Fields{
+		"guild_id": serverID,
+		"handler":  "api_webhooks",
+	})
+
+	webhooks, err := s.db.Webhooks(r.Context(), serverID)
+	if err != nil {
+		ll.WithError(err).Error("Webhooks")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	rsp := struct {
+		Webhooks []webhook `json:"webhooks"`
+	}{Webhooks: []webhook{}}
+	for _, wh := range webhooks {
+		rsp.Webhooks = append(rsp.Webhooks, newWebhook(wh))
+	}
+
+	writeJSON(w, http.StatusOK, rsp)
+}
+
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request, serverID string) {
+	ll := logging.FromContext(r.Context()).WithFields(log.Fields{
+		"guild_id": serverID,
+		"handler":  "api_create_webhook",
+	})
+
+	var req createWebhookRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "request body must be a JSON object")
+		return
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		writeError(w, http.StatusBadRequest, "url must be an absolute http or https URL")
+		return
+	}
+
+	wh := popple.Webhook{ServerID: serverID, URL: u.String(), Secret: req.Secret}
+	for _, name := range req.Events {
+		e, ok := webhookEvents[name]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "events must be karma.increased or karma.decreased")
+			return
+		}
+		wh.Events = append(wh.Events, e)
+	}
+
+	if len(wh.Secret) == 0 {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			ll.WithError(err).Error("generate secret")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		wh.Secret = hex.EncodeToString(secret)
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	existing, err := s.db.Webhooks(r.Context(), serverID)
+	if err != nil {
+		ll.WithError(err).Error("Webhooks")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if len(existing) >= MaxWebhooks {
+		writeError(w, http.StatusConflict, "the server already has as many webhooks as it can")
+		return
+	}
+
+	wh, err = s.db.PutWebhook(r.Context(), wh)
+	if err != nil {
+		ll.WithError(err).Error("PutWebhook")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	rsp := newWebhook(wh)
+	rsp.Secret = wh.Secret
+	writeJSON(w, http.StatusCreated, rsp)
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request, serverID, webhookID string) {
+	ll := logging.FromContext(r.Context()).WithFields(log.Fields{
+		"guild_id":   serverID,
+		"webhook_id": webhookID,
+		"handler":    "api_delete_webhook",
+	})
+
+	id, err := strconv.ParseInt(webhookID, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	err = s.db.DeleteWebhook(r.Context(), serverID, id)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		ll.WithError(err).Error("DeleteWebhook")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type deadLetter struct {
+	ID        int64             `json:"id"`
+	URL       string            `json:"url"`
+	ChannelID string            `json:"channel_id,omitempty"`
+	Actor     string            `json:"actor,omitempty"`
+	Reason    string            `json:"reason,omitempty"`
+	Changes   popple.Increments `json:"changes"`
+	QueuedAt  time.Time         `json:"queued_at"`
+	FailedAt  time.Time         `json:"failed_at"`
+	Attempts  int               `json:"attempts"`
+	LastError string            `json:"last_error"`
+}
+
+func (s *Server) webhookDeadLetters(w http.ResponseWriter, r *http.Request, serverID, webhookID string) {
+	ll := logging.FromContext(r.Context()).WithFields(log.Fields{
+		"guild_id":   serverID,
+		"webhook_id": webhookID,
+		"handler":    "api_webhook_dead_letters",
+	})
+
+	id, err := strconv.ParseInt(webhookID, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	letters, err := s.db.WebhookDeadLetters(r.Context(), serverID, id)
+	if err != nil {
+		ll.WithError(err).Error("WebhookDeadLetters")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	rsp := struct {
+		DeadLetters []deadLetter `json:"dead_letters"`
+	}{DeadLetters: []deadLetter{}}
+	for _, l := range letters {
+		rsp.DeadLetters = append(rsp.DeadLetters, deadLetter{
+			ID:        l.ID,
+			URL:       l.URL,
+			ChannelID: l.Event.ChannelID,
+			Actor:     l.Event.Actor,
+			Reason:    l.Event.Reason,
+			Changes:   l.Event.Increments,
+			QueuedAt:  l.QueuedAt.UTC(),
+			FailedAt:  l.FailedAt.UTC(),
+			Attempts:  l.Attempts,
+			LastError: strings.TrimSpace(l.LastError),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, rsp)
+}
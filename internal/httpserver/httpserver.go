@@ -0,0 +1,53 @@
+// Package httpserver runs the HTTP servers that Popple serves beside the
+// bot.
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ShutdownTimeout is how long requests still being served are given to
+// finish once the server is asked to stop. It matches bot.DrainTimeout.
+const ShutdownTimeout = 10 * time.Second
+
+// ListenAndServe serves h on addr until ctx is canceled, then stops taking
+// requests and waits for the ones already taken to finish. Requests don't
+// inherit ctx, so that shutting down lets them finish instead of canceling
+// them.
+func ListenAndServe(ctx context.Context, addr string, h http.Handler) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		select {
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.WithError(err).WithField("addr", addr).Warn("requests were still running at shutdown")
+			}
+		case <-done:
+		}
+	}()
+
+	err := srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		// ListenAndServe returns as soon as Shutdown starts, so wait for
+		// it to finish draining.
+		<-shutdown
+		return nil
+	}
+	return err
+}
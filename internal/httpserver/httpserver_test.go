@@ -0,0 +1,81 @@
+package httpserver_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/httpserver"
+)
+
+// freeAddr returns an address that nothing is listening on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	addr := freeAddr(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-release:
+			_, _ = io.WriteString(w, "done")
+		case <-r.Context().Done():
+			// Shutting down must not cancel requests.
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- httpserver.ListenAndServe(ctx, addr, h) }()
+
+	body := make(chan string, 1)
+	go func() {
+		for {
+			res, err := http.Get("http://" + addr)
+			if err != nil {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			b, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			body <- string(b)
+			return
+		}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the request")
+	}
+	cancel()
+
+	select {
+	case err := <-served:
+		t.Fatalf("returned with a request in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if got := <-body; got != "done" {
+		t.Errorf("want the request to finish, got %q", got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("want err=nil, got err=%v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package i18n
+
+var de = map[Key]string{
+	AnnounceUsage:     `Gültige Einstellungen für announce sind "message", "react", "reply", "digest" (optional gefolgt von Sekunden), "off"`,
+	BoardUsage:        `Die Größe der Rangliste muss eine positive Zahl größer als null sein`,
+	ChannelsUsage:     `Gültige Einstellungen für channels sind "all", oder "allow" bzw. "deny" gefolgt von Kanälen oder "here"`,
+	PoolUsage:         `Gültige Einstellungen für pool sind "yes", "on", "no", "off"`,
+	LanguageUsage:     `Gültige Sprachen sind %s`,
+	TemplateUsage:     `Verwendung: "template set <Name> <Vorlage>" oder "template reset <Name>"`,
+	TemplateNames:     `Gültige Vorlagennamen sind %s`,
+	TemplateBroken:    `Diese Vorlage funktioniert nicht: %v`,
+	EmbedsUsage:       `Gültige Einstellungen für embeds sind "yes", "on", "no", "off"`,
+	ExportUsage:       `Gültige Exportformate sind "json", "csv"`,
+	ExportDenied:      `Nur Server-Admins können Karma exportieren`,
+	ExportUnsupported: `Exporte können hier nicht verschickt werden`,
+	SettingsDenied:    `Nur Server-Admins können das ändern`,
+
+	LeaderboardTitle: `Bestenliste`,
+	LoserboardTitle:  `Schlusslichter`,
+	KarmaTitle:       `Karma`,
+	RankColumn:       `Rang`,
+	SubjectColumn:    `Wer`,
+	KarmaColumn:      `Karma`,
+	FooterOne:        `%d Eintrag, insgesamt %d Karma`,
+	FooterOther:      `%d Einträge, insgesamt %d Karma`,
+
+	Levels: `{{ range $name, $karma := . }}{{ $name }} hat {{ $karma }} Karma. {{ end }}`,
+	Board: `{{ range $entry := . }}* {{ $entry.Who }} hat {{ $entry.Karma }} Karma.
+{{ end }}`,
+	EmptyBoard: `Noch hat niemand Karma.`,
+}
@@ -0,0 +1,31 @@
+package i18n
+
+var en = map[Key]string{
+	AnnounceUsage:     `Valid announce settings are "message", "react", "reply", "digest" (optionally followed by seconds), "off"`,
+	BoardUsage:        `Board size must be a positive, non-zero number`,
+	ChannelsUsage:     `Valid channel settings are "all", or "allow" or "deny" followed by channels or "here"`,
+	PoolUsage:         `Valid pool settings are "yes", "on", "no", "off"`,
+	LanguageUsage:     `Valid languages are %s`,
+	TemplateUsage:     `Usage is "template set <name> <template>" or "template reset <name>"`,
+	TemplateNames:     `Valid template names are %s`,
+	TemplateBroken:    `That template doesn't work: %v`,
+	EmbedsUsage:       `Valid embeds settings are "yes", "on", "no", "off"`,
+	ExportUsage:       `Valid export formats are "json", "csv"`,
+	ExportDenied:      `Only server admins can export karma`,
+	ExportUnsupported: `Exports can't be sent here`,
+	SettingsDenied:    `Only server admins can change that`,
+
+	LeaderboardTitle: `Leaderboard`,
+	LoserboardTitle:  `Loserboard`,
+	KarmaTitle:       `Karma`,
+	RankColumn:       `Rank`,
+	SubjectColumn:    `Subject`,
+	KarmaColumn:      `Karma`,
+	FooterOne:        `%d subject, %d karma in total`,
+	FooterOther:      `%d subjects, %d karma in total`,
+
+	Levels: `{{ range $name, $karma := . }}{{ $name }} has {{ $karma }} karma. {{ end }}`,
+	Board: `{{ range $entry := . }}* {{ $entry.Who }} has {{ $entry.Karma }} karma.
+{{ end }}`,
+	EmptyBoard: `No one has any karma yet.`,
+}
@@ -0,0 +1,31 @@
+package i18n
+
+var es = map[Key]string{
+	AnnounceUsage:     `Los valores válidos de announce son "message", "react", "reply", "digest" (opcionalmente seguido de segundos), "off"`,
+	BoardUsage:        `El tamaño de la tabla debe ser un número positivo mayor que cero`,
+	ChannelsUsage:     `Los valores válidos de channels son "all", o "allow" o "deny" seguido de canales o "here"`,
+	PoolUsage:         `Los valores válidos de pool son "yes", "on", "no", "off"`,
+	LanguageUsage:     `Los idiomas válidos son %s`,
+	TemplateUsage:     `Uso: "template set <nombre> <plantilla>" o "template reset <nombre>"`,
+	TemplateNames:     `Los nombres de plantilla válidos son %s`,
+	TemplateBroken:    `Esa plantilla no funciona: %v`,
+	EmbedsUsage:       `Los valores válidos de embeds son "yes", "on", "no", "off"`,
+	ExportUsage:       `Los formatos de exportación válidos son "json", "csv"`,
+	ExportDenied:      `Solo los administradores del servidor pueden exportar el karma`,
+	ExportUnsupported: `Las exportaciones no se pueden enviar aquí`,
+	SettingsDenied:    `Solo los administradores del servidor pueden cambiar eso`,
+
+	LeaderboardTitle: `Clasificación`,
+	LoserboardTitle:  `Los últimos`,
+	KarmaTitle:       `Karma`,
+	RankColumn:       `Puesto`,
+	SubjectColumn:    `Quién`,
+	KarmaColumn:      `Karma`,
+	FooterOne:        `%d participante, %d de karma en total`,
+	FooterOther:      `%d participantes, %d de karma en total`,
+
+	Levels: `{{ range $name, $karma := . }}{{ $name }} tiene {{ $karma }} {{ pluralize $karma "punto" "puntos" }} de karma. {{ end }}`,
+	Board: `{{ range $entry := . }}* {{ $entry.Who }} tiene {{ $entry.Karma }} {{ pluralize $entry.Karma "punto" "puntos" }} de karma.
+{{ end }}`,
+	EmptyBoard: `Nadie tiene karma todavía.`,
+}
@@ -0,0 +1,90 @@
+// Package i18n holds the translations for everything Popple says.
+package i18n
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Default is the language used when a server hasn't picked one, or when a
+// translation is missing.
+const Default = "en"
+
+type Key string
+
+const (
+	AnnounceUsage     Key = "announce.usage"
+	BoardUsage        Key = "board.usage"
+	ChannelsUsage     Key = "channels.usage"
+	PoolUsage         Key = "pool.usage"
+	LanguageUsage     Key = "language.usage"
+	TemplateUsage     Key = "template.usage"
+	TemplateNames     Key = "template.names"
+	TemplateBroken    Key = "template.broken"
+	EmbedsUsage       Key = "embeds.usage"
+	ExportUsage       Key = "export.usage"
+	ExportDenied      Key = "export.denied"
+	ExportUnsupported Key = "export.unsupported"
+	SettingsDenied    Key = "settings.denied"
+
+	LeaderboardTitle Key = "embed.leaderboard"
+	LoserboardTitle  Key = "embed.loserboard"
+	KarmaTitle       Key = "embed.karma"
+	RankColumn       Key = "embed.rank"
+	SubjectColumn    Key = "embed.subject"
+	KarmaColumn      Key = "embed.karma_column"
+	FooterOne        Key = "embed.footer.one"
+	FooterOther      Key = "embed.footer.other"
+
+	// The default response templates. These are text/template sources
+	// rather than format strings.
+	Levels     Key = "levels"
+	Board      Key = "board"
+	EmptyBoard Key = "empty_board"
+)
+
+var catalogs = map[string]map[Key]string{
+	"en": en,
+	"de": de,
+	"es": es,
+}
+
+// Languages returns the codes of every shipped language.
+func Languages() []string {
+	var langs []string
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Supported reports whether lang is one of the shipped languages.
+func Supported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Text returns the message for key in lang, formatted with args. It falls
+// back to the default language if lang or the key is missing.
+func Text(lang string, key Key, args ...any) string {
+	msg, ok := catalogs[lang][key]
+	if !ok {
+		msg = catalogs[Default][key]
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// Plural returns one or other depending on which plural form lang uses
+// for n.
+func Plural(lang string, n int64, one, other string) string {
+	// All of the shipped languages only distinguish between one and
+	// everything else.
+	if n == 1 || n == -1 {
+		return one
+	}
+	return other
+}
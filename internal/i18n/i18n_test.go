@@ -0,0 +1,91 @@
+package i18n
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestEveryKeyInEveryLanguage(t *testing.T) {
+	for _, lang := range Languages() {
+		t.Run(lang, func(t *testing.T) {
+			for key := range catalogs[Default] {
+				if _, ok := catalogs[lang][key]; !ok {
+					t.Errorf("missing %q", key)
+				}
+			}
+
+			for key := range catalogs[lang] {
+				if _, ok := catalogs[Default][key]; !ok {
+					t.Errorf("%q is not in %q", key, Default)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatVerbsMatchDefault(t *testing.T) {
+	verbs := regexp.MustCompile(`%[a-z]`)
+
+	for _, lang := range Languages() {
+		t.Run(lang, func(t *testing.T) {
+			for key, want := range catalogs[Default] {
+				got := catalogs[lang][key]
+
+				wantVerbs := verbs.FindAllString(want, -1)
+				gotVerbs := verbs.FindAllString(got, -1)
+				if len(wantVerbs) != len(gotVerbs) {
+					t.Errorf("%q: want verbs %v, got %v", key, wantVerbs, gotVerbs)
+				}
+			}
+		})
+	}
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		lang string
+		key  Key
+		args []any
+		want string
+	}{
+		{
+			lang: "de",
+			key:  LanguageUsage,
+			args: []any{`"de", "en"`},
+			want: `Gültige Sprachen sind "de", "en"`,
+		},
+		{
+			lang: "xx",
+			key:  EmptyBoard,
+			want: `No one has any karma yet.`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.lang+"/"+string(tt.key), func(t *testing.T) {
+			got := Text(tt.lang, tt.key, tt.args...)
+			if got != tt.want {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPlural(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{n: 0, want: "puntos"},
+		{n: 1, want: "punto"},
+		{n: -1, want: "punto"},
+		{n: 2, want: "puntos"},
+	}
+
+	for _, tt := range tests {
+		got := Plural("es", tt.n, "punto", "puntos")
+		if got != tt.want {
+			t.Errorf("n=%d: want %q, got %q", tt.n, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,665 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/env"
+	"github.com/connorkuehl/popple/internal/logging"
+)
+
+// Config describes the IRC network to connect to.
+type Config struct {
+	// Addr is the host:port of the server, which must speak TLS.
+	Addr string
+	// Network names the network. It plays the part of Discord's server ID
+	// and defaults to the host in Addr.
+	Network string
+	Nick    string
+	// Channels are joined once the bot has registered.
+	Channels []string
+	// SASLUser and SASLPassword are used to authenticate with SASL PLAIN
+	// if SASLUser is set.
+	SASLUser     string
+	SASLPassword string
+	// TLSConfig is used for the connection if it is set.
+	TLSConfig *tls.Config
+}
+
+func ConfigFromEnv(lookup env.Lookup) (Config, error) {
+	return configFromEnv(lookup)
+}
+
+var (
+	ErrSASLFailed   = errors.New("sasl authentication failed")
+	ErrNotConnected = errors.New("not connected")
+)
+
+type Dialer struct {
+	config Config
+}
+
+func NewDialer(config Config) *Dialer {
+	if len(config.Network) == 0 {
+		host, _, err := net.SplitHostPort(config.Addr)
+		if err != nil {
+			host = config.Addr
+		}
+		config.Network = host
+	}
+	return &Dialer{config: config}
+}
+
+// dial connects and registers with the server and returns the connection
+// along with the nick the server settled on.
+func (d *Dialer) dial(ctx context.Context) (*conn, string, error) {
+	tlsConfig := d.config.TLSConfig
+	if tlsConfig == nil {
+		host, _, err := net.SplitHostPort(d.config.Addr)
+		if err != nil {
+			return nil, "", err
+		}
+		tlsConfig = &tls.Config{ServerName: host}
+	}
+
+	dialer := tls.Dialer{Config: tlsConfig}
+	nc, err := dialer.DialContext(ctx, "tcp", d.config.Addr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	c := &conn{Conn: nc, r: bufio.NewReader(nc)}
+
+	// Don't let a stalled server hang registration.
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = nc.SetDeadline(deadline)
+	} else {
+		_ = nc.SetDeadline(time.Now().Add(time.Minute))
+	}
+
+	nick, err := d.register(c)
+	if err != nil {
+		_ = c.Close()
+		return nil, "", err
+	}
+
+	_ = nc.SetDeadline(time.Time{})
+	return c, nick, nil
+}
+
+// register authenticates, if configured to, and waits for the server to
+// welcome us before joining the configured channels.
+func (d *Dialer) register(c *conn) (string, error) {
+	nick := d.config.Nick
+	sasl := len(d.config.SASLUser) > 0
+
+	if sasl {
+		if err := c.send("CAP", "REQ", "sasl"); err != nil {
+			return "", err
+		}
+	}
+	if err := c.send("NICK", nick); err != nil {
+		return "", err
+	}
+	if err := c.send("USER", nick, "0", "*", "Popple"); err != nil {
+		return "", err
+	}
+
+	for {
+		m, err := c.read()
+		if err != nil {
+			return "", err
+		}
+
+		switch m.command {
+		case "PING":
+			err = c.send("PONG", m.param(0))
+		case "CAP":
+			switch m.param(1) {
+			case "ACK":
+				err = c.send("AUTHENTICATE", "PLAIN")
+			case "NAK":
+				return "", fmt.Errorf("%w: server does not support sasl", ErrSASLFailed)
+			}
+		case "AUTHENTICATE":
+			if m.param(0) == "+" {
+				creds := "\x00" + d.config.SASLUser + "\x00" + d.config.SASLPassword
+				err = c.send("AUTHENTICATE", base64.StdEncoding.EncodeToString([]byte(creds)))
+			}
+		case "903": // RPL_SASLSUCCESS
+			err = c.send("CAP", "END")
+		case "902", "904", "905", "906": // ERR_NICKLOCKED, ERR_SASLFAIL, ERR_SASLTOOLONG, ERR_SASLABORTED
+			return "", fmt.Errorf("%w: %s", ErrSASLFailed, m.param(len(m.params)-1))
+		case "433": // ERR_NICKNAMEINUSE
+			nick += "_"
+			err = c.send("NICK", nick)
+		case "001": // RPL_WELCOME
+			nick = m.param(0)
+			if len(d.config.Channels) > 0 {
+				err = c.send("JOIN", strings.Join(d.config.Channels, ","))
+			}
+			if err != nil {
+				return "", err
+			}
+			return nick, nil
+		case "ERROR":
+			return "", fmt.Errorf("irc: %s", m.param(0))
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// conn is a connection to an IRC server that lines can be sent on from
+// more than one goroutine.
+type conn struct {
+	net.Conn
+	r  *bufio.Reader
+	mu sync.Mutex
+}
+
+// send writes a single line. The last parameter is sent as a trailing
+// parameter so that it may contain spaces.
+func (c *conn) send(command string, params ...string) error {
+	var b strings.Builder
+	b.WriteString(command)
+	for i, p := range params {
+		b.WriteByte(' ')
+		if i == len(params)-1 && (len(p) == 0 || strings.ContainsAny(p, " :") || p[0] == ':') {
+			b.WriteByte(':')
+		}
+		b.WriteString(p)
+	}
+	b.WriteString("\r\n")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.Write([]byte(b.String()))
+	return err
+}
+
+func (c *conn) read() (message, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return message{}, err
+	}
+	return parseMessage(line), nil
+}
+
+// message is a line received from the server.
+type message struct {
+	tags    map[string]string
+	prefix  string
+	command string
+	params  []string
+}
+
+func (m message) param(i int) string {
+	if i < 0 || i >= len(m.params) {
+		return ""
+	}
+	return m.params[i]
+}
+
+// nick is the nick of whoever sent the message.
+func (m message) nick() string {
+	nick, _, _ := strings.Cut(m.prefix, "!")
+	return nick
+}
+
+func parseMessage(line string) message {
+	line = strings.TrimRight(line, "\r\n")
+
+	var m message
+	if strings.HasPrefix(line, "@") {
+		var tags string
+		tags, line, _ = strings.Cut(line[1:], " ")
+		m.tags = make(map[string]string)
+		for _, tag := range strings.Split(tags, ";") {
+			k, v, _ := strings.Cut(tag, "=")
+			m.tags[k] = v
+		}
+	}
+	if strings.HasPrefix(line, ":") {
+		m.prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+
+	var trailing string
+	var hasTrailing bool
+	line, trailing, hasTrailing = strings.Cut(line, " :")
+	if strings.HasPrefix(line, ":") {
+		trailing, hasTrailing, line = line[1:], true, ""
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) > 0 {
+		m.command = strings.ToUpper(fields[0])
+		m.params = fields[1:]
+	}
+	if hasTrailing {
+		m.params = append(m.params, trailing)
+	}
+	return m
+}
+
+type Session struct {
+	chat.ConnState
+
+	d        *Dialer
+	log      *logging.Logger
+	messages chan chat.Message
+
+	mu   sync.Mutex
+	conn *conn
+	nick string
+	// run and seq make up the IDs of messages that don't have one. run
+	// differs between sessions so that a message is never mistaken for
+	// one that has already been applied.
+	run string
+	seq uint64
+	// senders remembers who sent recent messages so that replies and
+	// reactions can address them.
+	senders map[string]string
+	order   []string
+	// ops maps channels to the nicks with operator status in them, both
+	// lowercased.
+	ops map[string]map[string]bool
+}
+
+// maxSenders is how many recent messages replies can be addressed to.
+const maxSenders = 1024
+
+// NewSession returns a session that logs with logger, or with the standard
+// logger if logger is nil.
+func NewSession(dialer *Dialer, logger *logging.Logger) (*Session, func(), error) {
+	if logger == nil {
+		logger = logging.Standard()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c, nick, err := dialer.dial(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	s := &Session{
+		d:        dialer,
+		log:      logger,
+		messages: make(chan chat.Message),
+		conn:     c,
+		nick:     nick,
+		run:      strconv.FormatInt(time.Now().UnixNano(), 36),
+		senders:  make(map[string]string),
+		ops:      make(map[string]map[string]bool),
+	}
+	s.SetConnected(true)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.receive(ctx, c)
+	}()
+
+	return s, func() {
+		s.mu.Lock()
+		if s.conn != nil {
+			_ = s.conn.send("QUIT", "Bye!")
+		}
+		s.mu.Unlock()
+		cancel()
+		<-done
+	}, nil
+}
+
+// receive reads from c until it drops, then redials with backoff until ctx
+// is canceled.
+func (s *Session) receive(ctx context.Context, c *conn) {
+	backoff := time.Second
+	for {
+		err := s.read(ctx, c)
+		if ctx.Err() != nil {
+			return
+		}
+		s.log.WithError(err).Warn("irc connection lost")
+
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+		s.SetConnected(false)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+
+			var nick string
+			c, nick, err = s.d.dial(ctx)
+			if err == nil {
+				s.mu.Lock()
+				s.conn, s.nick = c, nick
+				// The server tells us who the operators are again as we
+				// rejoin.
+				s.ops = make(map[string]map[string]bool)
+				s.mu.Unlock()
+				s.SetConnected(true)
+				backoff = time.Second
+				break
+			}
+			s.log.WithError(err).WithField("backoff", backoff).Warn("irc reconnect failed")
+		}
+	}
+}
+
+// keepalive is how often the server is pinged.
+const keepalive = time.Minute
+
+// read delivers the messages received on c until it drops.
+func (s *Session) read(ctx context.Context, c *conn) error {
+	// Unblock the read below when we're asked to stop, and ping the server
+	// in the meantime so that a dead connection is noticed even when
+	// nobody is talking.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(keepalive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				_ = c.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = c.send("PING", "popple")
+			}
+		}
+	}()
+
+	for {
+		m, err := c.read()
+		if err != nil {
+			return err
+		}
+		s.Heartbeat()
+
+		switch m.command {
+		case "PING":
+			if err := c.send("PONG", m.param(0)); err != nil {
+				return err
+			}
+		case "NICK":
+			s.mu.Lock()
+			if m.nick() == s.nick {
+				s.nick = m.param(0)
+			}
+			s.mu.Unlock()
+			s.renameOp(m.nick(), m.param(0))
+		case "353": // RPL_NAMREPLY
+			s.names(m.param(2), m.param(3))
+		case "MODE":
+			if len(m.params) > 2 {
+				s.modes(m.param(0), m.param(1), m.params[2:])
+			}
+		case "JOIN":
+			// Whoever joins isn't an operator yet, including us, so forget
+			// anything left over from before we last parted.
+			s.setOp(m.param(0), m.nick(), false)
+		case "PART":
+			s.setOp(m.param(0), m.nick(), false)
+		case "KICK":
+			s.setOp(m.param(0), m.param(1), false)
+		case "QUIT":
+			s.renameOp(m.nick(), "")
+		case "PRIVMSG":
+			s.handle(ctx, m)
+		case "ERROR":
+			return fmt.Errorf("irc: %s", m.param(0))
+		}
+	}
+}
+
+// formatting matches IRC's bold, color, italic, etc., control codes.
+var formatting = regexp.MustCompile(`\x03(\d{1,2}(,\d{1,2})?)?|[\x02\x0f\x11\x16\x1d\x1e\x1f]`)
+
+func (s *Session) handle(ctx context.Context, m message) {
+	target, text := m.param(0), m.param(1)
+	sender := m.nick()
+
+	s.mu.Lock()
+	self := strings.EqualFold(sender, s.nick)
+	s.mu.Unlock()
+
+	// Ignore messages from self.
+	if self {
+		return
+	}
+
+	// No DMs.
+	if !strings.HasPrefix(target, "#") && !strings.HasPrefix(target, "&") {
+		return
+	}
+
+	// Ignore CTCP, e.g., /me.
+	if strings.HasPrefix(text, "\x01") {
+		return
+	}
+
+	msg := chat.Message{
+		ID:        s.remember(m.tags["msgid"], sender),
+		GuildID:   s.d.config.Network,
+		ChannelID: strings.ToLower(target),
+		Content:   formatting.ReplaceAllString(text, ""),
+	}
+
+	select {
+	case s.messages <- msg:
+	case <-ctx.Done():
+	}
+}
+
+// remember records who sent a message and returns the message's ID. IRC
+// messages only have IDs if the server supports the msgid tag, so
+// messages without one are numbered.
+func (s *Session) remember(id, sender string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(id) == 0 {
+		s.seq++
+		id = s.run + "-" + strconv.FormatUint(s.seq, 10)
+	}
+
+	s.senders[id] = sender
+	s.order = append(s.order, id)
+	if len(s.order) > maxSenders {
+		delete(s.senders, s.order[0])
+		s.order = s.order[1:]
+	}
+	return id
+}
+
+func (s *Session) sender(messageID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.senders[messageID]
+}
+
+// names records the operators in a channel's names list. Nicks are
+// prefixed with their status in the channel, and servers that support
+// multi-prefix list every status a nick has.
+func (s *Session) names(channel, names string) {
+	for _, name := range strings.Fields(names) {
+		nick := strings.TrimLeft(name, "~&@%+")
+		s.setOp(channel, nick, strings.ContainsAny(name[:len(name)-len(nick)], "~&@"))
+	}
+}
+
+// modes records operators being opped and deopped. Channel owners (+q) and
+// admins (+a) count as operators too.
+func (s *Session) modes(channel, modes string, args []string) {
+	if !strings.HasPrefix(channel, "#") && !strings.HasPrefix(channel, "&") {
+		return
+	}
+
+	adding := true
+	for _, mode := range modes {
+		switch mode {
+		case '+', '-':
+			adding = mode == '+'
+			continue
+		}
+
+		// Work out whether the mode takes an argument so that the
+		// arguments stay lined up with the modes they belong to.
+		switch {
+		case strings.ContainsRune("qaohvbeIk", mode), mode == 'l' && adding:
+		default:
+			continue
+		}
+		if len(args) == 0 {
+			return
+		}
+		arg := args[0]
+		args = args[1:]
+
+		if strings.ContainsRune("qao", mode) {
+			s.setOp(channel, arg, adding)
+		}
+	}
+}
+
+func (s *Session) setOp(channel, nick string, op bool) {
+	channel, nick = strings.ToLower(channel), strings.ToLower(nick)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !op {
+		delete(s.ops[channel], nick)
+		return
+	}
+	if s.ops[channel] == nil {
+		s.ops[channel] = make(map[string]bool)
+	}
+	s.ops[channel][nick] = true
+}
+
+// renameOp moves nick's operator status to newNick in every channel, or
+// drops it if newNick is empty.
+func (s *Session) renameOp(nick, newNick string) {
+	nick, newNick = strings.ToLower(nick), strings.ToLower(newNick)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ops := range s.ops {
+		if !ops[nick] {
+			continue
+		}
+		delete(ops, nick)
+		if len(newNick) > 0 {
+			ops[newNick] = true
+		}
+	}
+}
+
+func (s *Session) SendMessageToChannel(ctx context.Context, channelID string, msg string) error {
+	s.mu.Lock()
+	c := s.conn
+	s.mu.Unlock()
+
+	if c == nil {
+		return ErrNotConnected
+	}
+
+	// IRC messages can't span lines.
+	for _, line := range strings.Split(msg, "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		if err := c.send("PRIVMSG", channelID, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplyToMessage addresses the reply to whoever sent the message.
+func (s *Session) ReplyToMessage(ctx context.Context, channelID, messageID string, msg string) error {
+	if sender := s.sender(messageID); len(sender) > 0 {
+		msg = sender + ": " + msg
+	}
+	return s.SendMessageToChannel(ctx, channelID, msg)
+}
+
+// SendEmbedToChannel sends the embed as text with its title in bold.
+func (s *Session) SendEmbedToChannel(ctx context.Context, channelID string, embed chat.Embed) error {
+	var b strings.Builder
+	if len(embed.Title) > 0 {
+		b.WriteString("\x02" + embed.Title + "\x02\n")
+	}
+	for _, row := range embed.Rows() {
+		b.WriteString(strings.Join(row, " ") + "\n")
+	}
+	if len(embed.Footer) > 0 {
+		b.WriteString(embed.Footer)
+	}
+
+	return s.SendMessageToChannel(ctx, channelID, b.String())
+}
+
+// ReactToMessageWithEmoji replies with the emoji since IRC doesn't have
+// reactions.
+func (s *Session) ReactToMessageWithEmoji(ctx context.Context, channelID, messageID, emojiID string) error {
+	return s.ReplyToMessage(ctx, channelID, messageID, emojiID)
+}
+
+// SendFileToAdmin isn't supported since IRC has no way to send files
+// besides DCC.
+func (s *Session) SendFileToAdmin(ctx context.Context, channelID, messageID string, file chat.File) error {
+	return chat.ErrUnsupported
+}
+
+// IsAdmin reports whether whoever sent the message is an operator in the
+// channel, since IRC networks have no admins of their own that the bot can
+// see.
+func (s *Session) IsAdmin(ctx context.Context, channelID, messageID string) (bool, error) {
+	sender := s.sender(messageID)
+	if len(sender) == 0 {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ops[strings.ToLower(channelID)][strings.ToLower(sender)], nil
+}
+
+func (s *Session) Username() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nick
+}
+
+func (s *Session) Messages() <-chan chat.Message {
+	return s.messages
+}
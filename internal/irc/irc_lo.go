@@ -0,0 +1,40 @@
+package irc
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+// DefaultNick is the nick the bot registers with unless it's told
+// otherwise.
+const DefaultNick = "Popple"
+
+func configFromEnv(f func(key string) (val string)) (Config, error) {
+	addr, err := env.Get("POPPLE_IRC_ADDR", f)
+	if err != nil {
+		return Config{}, err
+	}
+
+	nick, err := env.Get("POPPLE_IRC_NICK", f)
+	if errors.Is(err, env.ErrKeyNotFound) {
+		nick = DefaultNick
+	}
+
+	var channels []string
+	for _, ch := range strings.Split(f("POPPLE_IRC_CHANNELS"), ",") {
+		if ch = strings.TrimSpace(ch); len(ch) > 0 {
+			channels = append(channels, ch)
+		}
+	}
+
+	return Config{
+		Addr:         addr,
+		Network:      f("POPPLE_IRC_NETWORK"),
+		Nick:         nick,
+		Channels:     channels,
+		SASLUser:     f("POPPLE_IRC_SASL_USER"),
+		SASLPassword: f("POPPLE_IRC_SASL_PASSWORD"),
+	}, nil
+}
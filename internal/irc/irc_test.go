@@ -0,0 +1,266 @@
+package irc_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/command"
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/irc"
+	"github.com/connorkuehl/popple/internal/irc/irctest"
+)
+
+func config(srv *irctest.Server) irc.Config {
+	return irc.Config{
+		Addr:         srv.Addr(),
+		Network:      irctest.Network,
+		Nick:         "Popple",
+		Channels:     []string{"#popple", "#potato"},
+		SASLUser:     irctest.SASLUser,
+		SASLPassword: irctest.SASLPassword,
+		TLSConfig:    srv.TLSConfig(),
+	}
+}
+
+func newSession(t *testing.T, srv *irctest.Server) *irc.Session {
+	t.Helper()
+
+	s, cleanup, err := irc.NewSession(irc.NewDialer(config(srv)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	return s
+}
+
+func receive(t *testing.T, s *irc.Session) chat.Message {
+	t.Helper()
+
+	select {
+	case msg := <-s.Messages():
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return chat.Message{}
+	}
+}
+
+// eventually waits for srv to have received want.
+func eventually(ctx context.Context, t *testing.T, srv *irctest.Server, want []irctest.Privmsg) {
+	t.Helper()
+
+	for ctx.Err() == nil {
+		if got := srv.Privmsgs(); reflect.DeepEqual(got, want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("want privmsgs %+v, got %+v", want, srv.Privmsgs())
+}
+
+func TestRegister(t *testing.T) {
+	srv := irctest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.Taken["Popple"] = true
+
+	s := newSession(t, srv)
+
+	if got := s.Username(); got != "Popple_" {
+		t.Errorf("want nick %q, got %q", "Popple_", got)
+	}
+
+	want := []string{"#popple", "#potato"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for ctx.Err() == nil && !reflect.DeepEqual(srv.Joined(), want) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := srv.Joined(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want joined %v, got %v", want, got)
+	}
+}
+
+func TestBadSASL(t *testing.T) {
+	srv := irctest.NewServer()
+	t.Cleanup(srv.Close)
+
+	cfg := config(srv)
+	cfg.SASLPassword = "hunter3"
+
+	_, _, err := irc.NewSession(irc.NewDialer(cfg), nil)
+	if !errors.Is(err, irc.ErrSASLFailed) {
+		t.Errorf("want err=%v, got err=%v", irc.ErrSASLFailed, err)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := irctest.NewServer()
+	t.Cleanup(srv.Close)
+	s := newSession(t, srv)
+
+	// Messages from the bot itself, DMs and CTCP are dropped, so the first
+	// message received is the one that follows them.
+	go func() {
+		_ = srv.Say(ctx, "Popple", "#popple", "talking to myself++")
+		_ = srv.Say(ctx, "alice", "Popple", "psst++")
+		_ = srv.Say(ctx, "alice", "#popple", "\x01ACTION waves\x01")
+		_ = srv.Say(ctx, "alice", "#Popple", "Popple: karma \x02bob\x02")
+		_ = srv.Send(ctx, "@msgid=abc :bob!bob@irctest PRIVMSG #popple :alice++")
+	}()
+
+	want := chat.Message{
+		GuildID:   irctest.Network,
+		ChannelID: "#popple",
+		Content:   "Popple: karma bob",
+	}
+	got := receive(t, s)
+	if len(got.ID) == 0 {
+		t.Errorf("want a message ID made up for a message without a msgid tag, got %+v", got)
+	}
+	if got.ID = ""; got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	if got := receive(t, s); got.ID != "abc" {
+		t.Errorf("want message ID from msgid tag, got %+v", got)
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := irctest.NewServer()
+	t.Cleanup(srv.Close)
+	s := newSession(t, srv)
+
+	go func() {
+		_ = srv.Send(ctx, ":irctest 353 Popple = #popple :Popple @alice +bob ~carol %dave")
+		_ = srv.Send(ctx, ":ChanServ!ChanServ@irctest MODE #popple +vo-q erin bob carol")
+		_ = srv.Send(ctx, ":bob!bob@irctest NICK robert")
+		_ = srv.Say(ctx, "alice", "#popple", "hello")
+		_ = srv.Say(ctx, "robert", "#Popple", "hello")
+		_ = srv.Say(ctx, "carol", "#popple", "hello")
+		_ = srv.Say(ctx, "dave", "#popple", "hello")
+		_ = srv.Say(ctx, "alice", "#potato", "hello")
+		_ = srv.Send(ctx, ":robert!bob@irctest KICK #popple alice :bye")
+		_ = srv.Say(ctx, "alice", "#popple", "hello?")
+	}()
+
+	for _, want := range []bool{true, true, false, false, false, false} {
+		msg := receive(t, s)
+		got, err := s.IsAdmin(ctx, msg.ChannelID, msg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("IsAdmin(%+v): want %v, got %v", msg, want, got)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := irctest.NewServer()
+	t.Cleanup(srv.Close)
+	s := newSession(t, srv)
+
+	go func() {
+		_ = srv.Disconnect(ctx)
+		_ = srv.Say(ctx, "alice", "#popple", "still here++")
+	}()
+
+	if got := receive(t, s); got.Content != "still here++" {
+		t.Errorf("want message after reconnecting, got %+v", got)
+	}
+}
+
+func TestResponses(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := irctest.NewServer()
+	t.Cleanup(srv.Close)
+	s := newSession(t, srv)
+
+	go func() { _ = srv.Say(ctx, "alice", "#popple", "hi") }()
+	msg := receive(t, s)
+
+	if err := s.SendMessageToChannel(ctx, "#popple", "hello\nworld"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReplyToMessage(ctx, "#popple", msg.ID, "hi yourself"); err != nil {
+		t.Fatal(err)
+	}
+	embed := chat.Embed{
+		Title:  "Karma",
+		Fields: []chat.EmbedField{{Name: "Subject", Values: []string{"a", "b"}}, {Name: "Karma", Values: []string{"1", "2"}}},
+		Footer: "2 subjects",
+	}
+	if err := s.SendEmbedToChannel(ctx, "#popple", embed); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReactToMessageWithEmoji(ctx, "#popple", msg.ID, "✅"); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(ctx, t, srv, []irctest.Privmsg{
+		{Target: "#popple", Text: "hello"},
+		{Target: "#popple", Text: "world"},
+		{Target: "#popple", Text: "alice: hi yourself"},
+		{Target: "#popple", Text: "\x02Karma\x02"},
+		{Target: "#popple", Text: "a 1"},
+		{Target: "#popple", Text: "b 2"},
+		{Target: "#popple", Text: "2 subjects"},
+		{Target: "#popple", Text: "alice: ✅"},
+	})
+}
+
+func TestBot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := irctest.NewServer()
+	t.Cleanup(srv.Close)
+	s := newSession(t, srv)
+
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	b := bot.New(s, db, command.NewRouter(s.Username()+":"), nil)
+	go func() { _ = b.Listen(ctx) }()
+
+	if err := srv.Say(ctx, "alice", "#popple", "bob++"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(ctx, t, srv, []irctest.Privmsg{{Target: "#popple", Text: "bob has 1 karma."}})
+
+	if err := srv.Send(ctx, ":irctest 353 Popple = #popple :Popple @alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Say(ctx, "alice", "#popple", "Popple: announce react"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Say(ctx, "alice", "#popple", "bob++"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(ctx, t, srv, []irctest.Privmsg{
+		{Target: "#popple", Text: "bob has 1 karma."},
+		{Target: "#popple", Text: "alice: ✅"},
+		{Target: "#popple", Text: "alice: ▲"},
+	})
+}
@@ -0,0 +1,273 @@
+// Package irctest provides an in-process IRC server that speaks just
+// enough of the protocol for Popple to register, join channels and chat.
+package irctest
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Network  = "irctest"
+	SASLUser = "popple"
+	// SASLPassword is the only password the server accepts.
+	SASLPassword = "hunter2"
+)
+
+// Privmsg is a message the client sent.
+type Privmsg struct {
+	Target string
+	Text   string
+}
+
+type Server struct {
+	ln        net.Listener
+	clientTLS *tls.Config
+
+	// Taken are nicks that are already in use.
+	Taken map[string]bool
+
+	mu       sync.Mutex
+	conn     net.Conn
+	ready    chan struct{}
+	joined   []string
+	privmsgs []Privmsg
+}
+
+func NewServer() *Server {
+	cert, pool := certificate()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		panic(fmt.Sprintf("irctest: listen: %v", err))
+	}
+
+	s := &Server{
+		ln:        ln,
+		clientTLS: &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"},
+		Taken:     make(map[string]bool),
+		ready:     make(chan struct{}),
+	}
+	go s.serve()
+	return s
+}
+
+// Addr is the host:port to point the IRC dialer at.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// TLSConfig is a client configuration that trusts the server.
+func (s *Server) TLSConfig() *tls.Config {
+	return s.clientTLS.Clone()
+}
+
+func (s *Server) Close() {
+	_ = s.ln.Close()
+	s.mu.Lock()
+	if s.conn != nil {
+		_ = s.conn.Close()
+	}
+	s.mu.Unlock()
+}
+
+// Joined returns every channel the client has joined so far.
+func (s *Server) Joined() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.joined...)
+}
+
+// Privmsgs returns every message the client has sent so far.
+func (s *Server) Privmsgs() []Privmsg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Privmsg(nil), s.privmsgs...)
+}
+
+// Say sends a message from nick to target once the client has registered.
+func (s *Server) Say(ctx context.Context, nick, target, text string) error {
+	return s.Send(ctx, fmt.Sprintf(":%s!%s@irctest PRIVMSG %s :%s", nick, nick, target, text))
+}
+
+// Send writes a raw line to the client once it has registered.
+func (s *Server) Send(ctx context.Context, line string) error {
+	conn, err := s.waitConn(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(conn, "%s\r\n", line)
+	return err
+}
+
+// Disconnect drops the client's connection.
+func (s *Server) Disconnect(ctx context.Context) error {
+	conn, err := s.waitConn(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.conn = nil
+	s.ready = make(chan struct{})
+	s.mu.Unlock()
+
+	return conn.Close()
+}
+
+func (s *Server) waitConn(ctx context.Context) (net.Conn, error) {
+	for {
+		s.mu.Lock()
+		conn, ready := s.conn, s.ready
+		s.mu.Unlock()
+
+		if conn != nil {
+			return conn, nil
+		}
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reply := func(format string, args ...any) {
+		_, _ = fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	var nick string
+	var capping bool
+	welcome := func() {
+		if len(nick) == 0 || capping {
+			return
+		}
+		reply(":irctest 001 %s :Welcome to %s, %s", nick, Network, nick)
+
+		s.mu.Lock()
+		s.conn = conn
+		select {
+		case <-s.ready:
+		default:
+			close(s.ready)
+		}
+		s.mu.Unlock()
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		command, rest, _ := strings.Cut(line, " ")
+
+		switch command {
+		case "CAP":
+			switch {
+			case strings.HasPrefix(rest, "REQ"):
+				capping = true
+				reply(":irctest CAP * ACK :sasl")
+			case strings.HasPrefix(rest, "END"):
+				capping = false
+				welcome()
+			}
+		case "AUTHENTICATE":
+			if rest == "PLAIN" {
+				reply("AUTHENTICATE +")
+				continue
+			}
+			creds, _ := base64.StdEncoding.DecodeString(rest)
+			if string(creds) != "\x00"+SASLUser+"\x00"+SASLPassword {
+				reply(":irctest 904 * :SASL authentication failed")
+				continue
+			}
+			reply(":irctest 903 * :SASL authentication successful")
+		case "NICK":
+			if s.Taken[rest] {
+				reply(":irctest 433 * %s :Nickname is already in use", rest)
+				continue
+			}
+			nick = rest
+		case "USER":
+			welcome()
+		case "JOIN":
+			s.mu.Lock()
+			s.joined = append(s.joined, strings.Split(rest, ",")...)
+			s.mu.Unlock()
+		case "PING":
+			reply(":irctest PONG irctest :%s", strings.TrimPrefix(rest, ":"))
+		case "PRIVMSG":
+			target, text, _ := strings.Cut(rest, " ")
+			s.mu.Lock()
+			s.privmsgs = append(s.privmsgs, Privmsg{Target: target, Text: strings.TrimPrefix(text, ":")})
+			s.mu.Unlock()
+		case "QUIT":
+			return
+		}
+	}
+}
+
+// certificate makes a self-signed certificate for 127.0.0.1 along with a
+// pool that trusts it.
+func certificate() (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("irctest: generate key: %v", err))
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"irctest"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(fmt.Sprintf("irctest: create certificate: %v", err))
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(fmt.Sprintf("irctest: parse certificate: %v", err))
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
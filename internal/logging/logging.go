@@ -0,0 +1,119 @@
+// Package logging configures Popple's logs and carries a logger through a
+// context, so that everything done for one message is logged with the same
+// correlation ID.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+// Format is how log entries are written.
+type Format string
+
+const (
+	// Text writes an entry as key=value pairs.
+	Text Format = "text"
+	// JSON writes an entry as a JSON object.
+	JSON Format = "json"
+)
+
+// Redacted stands in for message content when content isn't logged.
+const Redacted = "[redacted]"
+
+// Config configures Popple's logs. ConfigFromEnv defaults to the info
+// level and text.
+type Config struct {
+	Level  log.Level
+	Format Format
+	// RedactContent leaves message content out of the logs.
+	RedactContent bool
+}
+
+func ConfigFromEnv(lookup env.Lookup) (Config, error) {
+	return configFromEnv(lookup)
+}
+
+// Configure makes l write entries as config says.
+func Configure(l *log.Logger, config Config) {
+	l.SetLevel(config.Level)
+	switch config.Format {
+	case JSON:
+		l.SetFormatter(&log.JSONFormatter{})
+	default:
+		l.SetFormatter(&log.TextFormatter{})
+	}
+}
+
+// Logger is the logger handed to the bot.
+type Logger struct {
+	*log.Logger
+	redact bool
+}
+
+// New returns a logger for the bot that logs to l, which Configure has
+// already configured.
+func New(l *log.Logger, config Config) *Logger {
+	return &Logger{Logger: l, redact: config.RedactContent}
+}
+
+// Standard returns a logger that logs to the standard logger and logs
+// content. It's what components log with when they aren't given a logger.
+func Standard() *Logger {
+	return &Logger{Logger: log.StandardLogger()}
+}
+
+// Content is what should be logged for a message's content.
+func (l *Logger) Content(content string) string {
+	if l.redact {
+		return Redacted
+	}
+	return content
+}
+
+// CorrelationIDField is the field an entry's correlation ID is logged in.
+const CorrelationIDField = "correlation_id"
+
+type ctxKey struct{}
+
+// NewContext returns a context that carries e.
+func NewContext(ctx context.Context, e *log.Entry) context.Context {
+	return context.WithValue(ctx, ctxKey{}, e)
+}
+
+// FromContext returns the entry ctx carries, or an entry for the standard
+// logger if it doesn't carry one.
+func FromContext(ctx context.Context) *log.Entry {
+	if e, ok := ctx.Value(ctxKey{}).(*log.Entry); ok {
+		return e.WithContext(ctx)
+	}
+	return log.NewEntry(log.StandardLogger()).WithContext(ctx)
+}
+
+// CorrelationID is the correlation ID of the entry ctx carries, if any.
+func CorrelationID(ctx context.Context) string {
+	id, _ := FromContext(ctx).Data[CorrelationIDField].(string)
+	return id
+}
+
+// WithCorrelationID returns a context that carries an entry from l with a
+// new correlation ID. If ctx already has a correlation ID, it's kept so
+// that work started on behalf of something else stays correlated with it.
+func (l *Logger) WithCorrelationID(ctx context.Context) context.Context {
+	if len(CorrelationID(ctx)) > 0 {
+		return ctx
+	}
+	return NewContext(ctx, l.WithField(CorrelationIDField, NewCorrelationID()))
+}
+
+// NewCorrelationID returns a random ID to correlate log entries with.
+func NewCorrelationID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
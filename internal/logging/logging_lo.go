@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+func configFromEnv(f func(key string) (val string)) (Config, error) {
+	config := Config{Level: log.InfoLevel, Format: Text}
+
+	level, err := env.Get("POPPLE_LOG_LEVEL", f)
+	switch {
+	case errors.Is(err, env.ErrKeyNotFound):
+	case err != nil:
+		return Config{}, err
+	default:
+		config.Level, err = log.ParseLevel(level)
+		if err != nil {
+			return Config{}, fmt.Errorf("POPPLE_LOG_LEVEL must be one of trace, debug, info, warn, error, fatal or panic: %q", level)
+		}
+	}
+
+	format, err := env.Get("POPPLE_LOG_FORMAT", f)
+	switch {
+	case errors.Is(err, env.ErrKeyNotFound):
+	case err != nil:
+		return Config{}, err
+	default:
+		config.Format = Format(format)
+		if config.Format != Text && config.Format != JSON {
+			return Config{}, fmt.Errorf("POPPLE_LOG_FORMAT must be text or json: %q", format)
+		}
+	}
+
+	redact, err := env.Get("POPPLE_LOG_REDACT_CONTENT", f)
+	switch {
+	case errors.Is(err, env.ErrKeyNotFound):
+	case err != nil:
+		return Config{}, err
+	default:
+		config.RedactContent, err = strconv.ParseBool(redact)
+		if err != nil {
+			return Config{}, fmt.Errorf("POPPLE_LOG_REDACT_CONTENT must be true or false: %q", redact)
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,120 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/logging"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    logging.Config
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			want: logging.Config{Level: log.InfoLevel, Format: logging.Text},
+		},
+		{
+			name: "everything",
+			env: map[string]string{
+				"POPPLE_LOG_LEVEL":          "debug",
+				"POPPLE_LOG_FORMAT":         "json",
+				"POPPLE_LOG_REDACT_CONTENT": "true",
+			},
+			want: logging.Config{Level: log.DebugLevel, Format: logging.JSON, RedactContent: true},
+		},
+		{
+			name:    "unknown level",
+			env:     map[string]string{"POPPLE_LOG_LEVEL": "loud"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown format",
+			env:     map[string]string{"POPPLE_LOG_FORMAT": "xml"},
+			wantErr: true,
+		},
+		{
+			name:    "not a bool",
+			env:     map[string]string{"POPPLE_LOG_REDACT_CONTENT": "please"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := logging.ConfigFromEnv(func(key string) string { return tt.env[key] })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	var out bytes.Buffer
+	l := log.New()
+	l.SetOutput(&out)
+	logging.Configure(l, logging.Config{Level: log.WarnLevel, Format: logging.JSON})
+
+	l.Info("quiet")
+	l.WithField("guild_id", "123").Warn("loud")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("want only the warning, got %q", out.String())
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["msg"] != "loud" || entry["guild_id"] != "123" {
+		t.Errorf("want the warning with its fields, got %v", entry)
+	}
+}
+
+func TestContent(t *testing.T) {
+	if got := logging.New(log.New(), logging.Config{}).Content("hi"); got != "hi" {
+		t.Errorf("want content, got %q", got)
+	}
+	if got := logging.New(log.New(), logging.Config{RedactContent: true}).Content("hi"); got != logging.Redacted {
+		t.Errorf("want %q, got %q", logging.Redacted, got)
+	}
+}
+
+func TestCorrelationID(t *testing.T) {
+	l := logging.New(log.New(), logging.Config{})
+
+	if got := logging.CorrelationID(context.Background()); len(got) > 0 {
+		t.Errorf("want no correlation ID, got %q", got)
+	}
+
+	ctx := l.WithCorrelationID(context.Background())
+	id := logging.CorrelationID(ctx)
+	if len(id) == 0 {
+		t.Fatal("want a correlation ID")
+	}
+	if got := logging.FromContext(ctx).Data[logging.CorrelationIDField]; got != id {
+		t.Errorf("want entries logged with %q, got %v", id, got)
+	}
+
+	if got := logging.CorrelationID(l.WithCorrelationID(ctx)); got != id {
+		t.Errorf("want %q kept, got %q", id, got)
+	}
+	if other := logging.CorrelationID(l.WithCorrelationID(context.Background())); other == id {
+		t.Errorf("want a new correlation ID, got %q again", other)
+	}
+}
@@ -0,0 +1,623 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/env"
+	"github.com/connorkuehl/popple/internal/logging"
+)
+
+// Homeserver is the base URL of the homeserver, e.g.,
+// https://matrix.example.org.
+type Homeserver string
+
+// AccessToken is the token the bot's account is logged in with.
+type AccessToken string
+
+// Admins are the IDs of the users who may change the settings of rooms
+// outside of a space, which share the homeserver's settings.
+type Admins []string
+
+func HomeserverFromEnv(lookup env.Lookup) (Homeserver, error) {
+	return homeserverFromEnv(lookup)
+}
+
+func AccessTokenFromEnv(lookup env.Lookup) (AccessToken, error) {
+	return accessTokenFromEnv(lookup)
+}
+
+func AdminsFromEnv(lookup env.Lookup) (Admins, error) {
+	return adminsFromEnv(lookup)
+}
+
+// syncTimeout is how long the homeserver may hold a sync request open
+// while it waits for events.
+const syncTimeout = 30 * time.Second
+
+type Dialer struct {
+	homeserver Homeserver
+	token      AccessToken
+	admins     Admins
+	client     *http.Client
+}
+
+func NewDialer(homeserver Homeserver, token AccessToken, admins Admins) *Dialer {
+	return &Dialer{
+		homeserver: homeserver,
+		token:      token,
+		admins:     admins,
+		client:     &http.Client{Timeout: syncTimeout + 30*time.Second},
+	}
+}
+
+// Error is the error the client-server API responds with.
+type Error struct {
+	Status  int
+	ErrCode string `json:"errcode"`
+	Message string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("matrix: %d %s: %s", e.Status, e.ErrCode, e.Message)
+}
+
+// call makes a client-server API request and decodes the response into rsp
+// if rsp is not nil.
+func (d *Dialer) call(ctx context.Context, method, path string, query url.Values, body, rsp any) error {
+	u := strings.TrimSuffix(string(d.homeserver), "/") + "/_matrix/client/v3" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+string(d.token))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		e := &Error{Status: res.StatusCode}
+		_ = json.NewDecoder(res.Body).Decode(e)
+		return e
+	}
+
+	if rsp == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(rsp)
+}
+
+// Dial logs the bot in and returns a session that logs with logger and has
+// not started syncing yet.
+func (d *Dialer) Dial(ctx context.Context, logger *logging.Logger) (*Session, error) {
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	if err := d.call(ctx, http.MethodGet, "/account/whoami", nil, nil, &whoami); err != nil {
+		return nil, err
+	}
+
+	var profile struct {
+		DisplayName string `json:"displayname"`
+	}
+	if err := d.call(ctx, http.MethodGet, "/profile/"+url.PathEscape(whoami.UserID)+"/displayname", nil, nil, &profile); err != nil {
+		logger.WithError(err).Warn("look up matrix display name")
+	}
+
+	// User IDs look like @localpart:server.name.
+	localpart, serverName, _ := strings.Cut(strings.TrimPrefix(whoami.UserID, "@"), ":")
+	name := profile.DisplayName
+	if len(name) == 0 {
+		name = localpart
+	}
+
+	admins := make(map[string]bool)
+	for _, id := range d.admins {
+		admins[id] = true
+	}
+
+	return &Session{
+		d:          d,
+		userID:     whoami.UserID,
+		name:       name,
+		serverName: serverName,
+		admins:     admins,
+		log:        logger,
+		txnPrefix:  strconv.FormatInt(time.Now().UnixNano(), 36),
+	}, nil
+}
+
+type Session struct {
+	chat.ConnState
+
+	d          *Dialer
+	userID     string
+	name       string
+	serverName string
+	admins     map[string]bool
+	log        *logging.Logger
+	messages   chan chat.Message
+
+	txnPrefix string
+	txn       uint64
+
+	mu sync.Mutex
+	// spaces caches the space each room belongs to. Rooms outside of a
+	// space map to the homeserver's name.
+	spaces map[string]string
+	// encrypted holds the rooms that have end-to-end encryption turned on.
+	encrypted map[string]bool
+	// direct holds the rooms that are direct messages.
+	direct map[string]bool
+}
+
+// NewSession returns a session that logs with logger, or with the standard
+// logger if logger is nil.
+func NewSession(dialer *Dialer, logger *logging.Logger) (*Session, func(), error) {
+	if logger == nil {
+		logger = logging.Standard()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s, err := dialer.Dial(ctx, logger)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	s.messages = make(chan chat.Message)
+	s.spaces = make(map[string]string)
+	s.encrypted = make(map[string]bool)
+	s.direct = make(map[string]bool)
+	s.SetConnected(true)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.receive(ctx)
+	}()
+
+	return s, func() {
+		cancel()
+		<-done
+	}, nil
+}
+
+type event struct {
+	Type     string          `json:"type"`
+	EventID  string          `json:"event_id"`
+	Sender   string          `json:"sender"`
+	StateKey *string         `json:"state_key"`
+	Content  json.RawMessage `json:"content"`
+}
+
+type syncResponse struct {
+	NextBatch   string `json:"next_batch"`
+	AccountData struct {
+		Events []event `json:"events"`
+	} `json:"account_data"`
+	Rooms struct {
+		Join map[string]struct {
+			State struct {
+				Events []event `json:"events"`
+			} `json:"state"`
+			Timeline struct {
+				Events []event `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]struct {
+			InviteState struct {
+				Events []event `json:"events"`
+			} `json:"invite_state"`
+		} `json:"invite"`
+	} `json:"rooms"`
+}
+
+// receive syncs until ctx is canceled. Messages sent before the bot
+// started are skipped.
+func (s *Session) receive(ctx context.Context) {
+	var since string
+	backoff := time.Second
+	for {
+		query := url.Values{"timeout": {strconv.FormatInt(syncTimeout.Milliseconds(), 10)}}
+		if len(since) > 0 {
+			query.Set("since", since)
+		} else {
+			query.Set("timeout", "0")
+		}
+
+		var rsp syncResponse
+		err := s.d.call(ctx, http.MethodGet, "/sync", query, nil, &rsp)
+		if ctx.Err() != nil {
+			return
+		}
+		s.SetConnected(err == nil)
+		if err != nil {
+			s.log.WithError(err).WithField("backoff", backoff).Warn("matrix sync failed")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+
+		s.handle(ctx, rsp, len(since) > 0)
+		since = rsp.NextBatch
+	}
+}
+
+type messageContent struct {
+	MsgType   string `json:"msgtype"`
+	Body      string `json:"body"`
+	RelatesTo *struct {
+		RelType   string `json:"rel_type"`
+		InReplyTo *struct {
+			EventID string `json:"event_id"`
+		} `json:"m.in_reply_to"`
+	} `json:"m.relates_to"`
+}
+
+// text returns the message's body without the quoted fallback that
+// replies carry for clients that don't understand them, so that replying
+// to bob++ doesn't give bob karma again.
+func (c messageContent) text() string {
+	if c.RelatesTo == nil || c.RelatesTo.InReplyTo == nil {
+		return c.Body
+	}
+
+	body := c.Body
+	for strings.HasPrefix(body, ">") {
+		_, rest, ok := strings.Cut(body, "\n")
+		if !ok {
+			return ""
+		}
+		body = rest
+	}
+	return strings.TrimPrefix(body, "\n")
+}
+
+// handle processes one sync response. Timeline events are only delivered
+// if deliver is set so that the initial sync's backlog is skipped.
+func (s *Session) handle(ctx context.Context, rsp syncResponse, deliver bool) {
+	for _, ev := range rsp.AccountData.Events {
+		if ev.Type == "m.direct" {
+			s.setDirect(ev.Content)
+		}
+	}
+
+	for roomID, room := range rsp.Rooms.Invite {
+		// No DMs.
+		if isDirectInvite(room.InviteState.Events, s.userID) {
+			continue
+		}
+		if err := s.d.call(ctx, http.MethodPost, "/rooms/"+url.PathEscape(roomID)+"/join", nil, struct{}{}, nil); err != nil {
+			s.log.WithError(err).WithField("room_id", roomID).Warn("join matrix room")
+		}
+	}
+
+	for roomID, room := range rsp.Rooms.Join {
+		for _, ev := range room.State.Events {
+			s.observe(roomID, ev)
+		}
+		for _, ev := range room.Timeline.Events {
+			s.observe(roomID, ev)
+			if !deliver || ev.Type != "m.room.message" || ev.Sender == s.userID {
+				continue
+			}
+
+			var content messageContent
+			if err := json.Unmarshal(ev.Content, &content); err != nil {
+				continue
+			}
+			// Ignore edits, notices (which other bots send) and the like.
+			if content.MsgType != "m.text" || (content.RelatesTo != nil && content.RelatesTo.RelType == "m.replace") {
+				continue
+			}
+
+			s.mu.Lock()
+			skip := s.direct[roomID] || s.encrypted[roomID]
+			s.mu.Unlock()
+			if skip {
+				continue
+			}
+
+			// Filing the message under the homeserver when the space
+			// can't be looked up would apply the homeserver's settings
+			// and karma to a room that may belong to a space.
+			space, err := s.space(ctx, roomID)
+			if err != nil {
+				s.log.WithError(err).WithField("room_id", roomID).Warn("look up matrix room's space")
+				continue
+			}
+
+			msg := chat.Message{
+				ID:        ev.EventID,
+				GuildID:   space,
+				ChannelID: roomID,
+				Content:   content.text(),
+			}
+
+			select {
+			case s.messages <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// isDirectInvite reports whether the invite is to a direct message.
+func isDirectInvite(state []event, userID string) bool {
+	for _, ev := range state {
+		if ev.Type != "m.room.member" || ev.StateKey == nil || *ev.StateKey != userID {
+			continue
+		}
+
+		var member struct {
+			IsDirect bool `json:"is_direct"`
+		}
+		if err := json.Unmarshal(ev.Content, &member); err == nil && member.IsDirect {
+			return true
+		}
+	}
+	return false
+}
+
+// observe tracks the room state that decides whether and how the bot
+// handles messages in the room.
+func (s *Session) observe(roomID string, ev event) {
+	switch ev.Type {
+	case "m.room.encryption":
+		s.mu.Lock()
+		if !s.encrypted[roomID] {
+			s.log.WithField("room_id", roomID).Warn("ignoring encrypted matrix room")
+		}
+		s.encrypted[roomID] = true
+		s.mu.Unlock()
+	case "m.space.parent":
+		s.mu.Lock()
+		delete(s.spaces, roomID)
+		s.mu.Unlock()
+	}
+}
+
+// setDirect replaces the set of direct message rooms with the ones listed
+// in the m.direct account data.
+func (s *Session) setDirect(content json.RawMessage) {
+	var rooms map[string][]string
+	if err := json.Unmarshal(content, &rooms); err != nil {
+		return
+	}
+
+	direct := make(map[string]bool)
+	for _, ids := range rooms {
+		for _, id := range ids {
+			direct[id] = true
+		}
+	}
+
+	s.mu.Lock()
+	s.direct = direct
+	s.mu.Unlock()
+}
+
+// space returns the ID of the space the room belongs to, or the
+// homeserver's name if it isn't in one.
+func (s *Session) space(ctx context.Context, roomID string) (string, error) {
+	s.mu.Lock()
+	id, ok := s.spaces[roomID]
+	s.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	var state []event
+	if err := s.d.call(ctx, http.MethodGet, "/rooms/"+url.PathEscape(roomID)+"/state", nil, nil, &state); err != nil {
+		return "", err
+	}
+
+	id = s.serverName
+	for _, ev := range state {
+		// A parent event with empty content has been removed.
+		if ev.Type != "m.space.parent" || ev.StateKey == nil || len(ev.Content) <= 2 {
+			continue
+		}
+
+		// Anyone can name any space as their room's parent, so only
+		// believe it if the space names the room as a child, too.
+		child, err := s.isChild(ctx, *ev.StateKey, roomID)
+		if err != nil {
+			return "", err
+		}
+		if child {
+			id = *ev.StateKey
+			break
+		}
+	}
+
+	s.mu.Lock()
+	s.spaces[roomID] = id
+	s.mu.Unlock()
+	return id, nil
+}
+
+// isChild reports whether the space lists the room as one of its children.
+// Spaces the bot can't see don't list any.
+func (s *Session) isChild(ctx context.Context, spaceID, roomID string) (bool, error) {
+	var child struct {
+		Via []string `json:"via"`
+	}
+	path := "/rooms/" + url.PathEscape(spaceID) + "/state/m.space.child/" + url.PathEscape(roomID)
+	err := s.d.call(ctx, http.MethodGet, path, nil, nil, &child)
+	var e *Error
+	if errors.As(err, &e) && (e.Status == http.StatusNotFound || e.Status == http.StatusForbidden) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// A child event without via has been removed.
+	return len(child.Via) > 0, nil
+}
+
+func (s *Session) send(ctx context.Context, roomID, eventType string, content any) error {
+	txn := s.txnPrefix + "." + strconv.FormatUint(atomic.AddUint64(&s.txn, 1), 10)
+	path := "/rooms/" + url.PathEscape(roomID) + "/send/" + eventType + "/" + txn
+	return s.d.call(ctx, http.MethodPut, path, nil, content, nil)
+}
+
+func (s *Session) SendMessageToChannel(ctx context.Context, channelID string, msg string) error {
+	return s.send(ctx, channelID, "m.room.message", map[string]any{
+		"msgtype": "m.text",
+		"body":    msg,
+	})
+}
+
+func (s *Session) ReplyToMessage(ctx context.Context, channelID, messageID string, msg string) error {
+	return s.send(ctx, channelID, "m.room.message", map[string]any{
+		"msgtype": "m.text",
+		"body":    msg,
+		"m.relates_to": map[string]any{
+			"m.in_reply_to": map[string]any{"event_id": messageID},
+		},
+	})
+}
+
+// SendEmbedToChannel sends the embed as an HTML table, with a plain text
+// fallback for clients that can't show it.
+func (s *Session) SendEmbedToChannel(ctx context.Context, channelID string, embed chat.Embed) error {
+	var b strings.Builder
+	if len(embed.Title) > 0 {
+		b.WriteString("<strong>" + html.EscapeString(embed.Title) + "</strong>")
+	}
+	b.WriteString("<table><tr>")
+	for _, f := range embed.Fields {
+		b.WriteString("<th>" + html.EscapeString(f.Name) + "</th>")
+	}
+	b.WriteString("</tr>")
+	for _, row := range embed.Rows() {
+		b.WriteString("<tr>")
+		for _, cell := range row {
+			b.WriteString("<td>" + html.EscapeString(cell) + "</td>")
+		}
+		b.WriteString("</tr>")
+	}
+	b.WriteString("</table>")
+	if len(embed.Footer) > 0 {
+		b.WriteString("<em>" + html.EscapeString(embed.Footer) + "</em>")
+	}
+
+	return s.send(ctx, channelID, "m.room.message", map[string]any{
+		"msgtype":        "m.text",
+		"body":           embed.String(),
+		"format":         "org.matrix.custom.html",
+		"formatted_body": b.String(),
+	})
+}
+
+func (s *Session) ReactToMessageWithEmoji(ctx context.Context, channelID, messageID, emojiID string) error {
+	return s.send(ctx, channelID, "m.reaction", map[string]any{
+		"m.relates_to": map[string]any{
+			"rel_type": "m.annotation",
+			"event_id": messageID,
+			"key":      emojiID,
+		},
+	})
+}
+
+// SendFileToAdmin isn't supported yet.
+func (s *Session) SendFileToAdmin(ctx context.Context, channelID, messageID string, file chat.File) error {
+	return chat.ErrUnsupported
+}
+
+// IsAdmin reports whether the sender of the message may change the settings
+// of the space the room is in, i.e., whether they have enough power in the
+// space to change its power levels. Rooms outside of a space share the
+// homeserver's settings, which only the configured admins may change.
+func (s *Session) IsAdmin(ctx context.Context, channelID, messageID string) (bool, error) {
+	var ev event
+	if err := s.d.call(ctx, http.MethodGet, "/rooms/"+url.PathEscape(channelID)+"/event/"+url.PathEscape(messageID), nil, nil, &ev); err != nil {
+		return false, err
+	}
+
+	space, err := s.space(ctx, channelID)
+	if err != nil {
+		return false, err
+	}
+	if space == s.serverName {
+		return s.admins[ev.Sender], nil
+	}
+	return s.canChangePowerLevels(ctx, space, ev.Sender)
+}
+
+// canChangePowerLevels reports whether the user has enough power in the room
+// to change its power levels.
+func (s *Session) canChangePowerLevels(ctx context.Context, roomID, userID string) (bool, error) {
+	var levels struct {
+		Users        map[string]int `json:"users"`
+		UsersDefault int            `json:"users_default"`
+		Events       map[string]int `json:"events"`
+		StateDefault *int           `json:"state_default"`
+	}
+	if err := s.d.call(ctx, http.MethodGet, "/rooms/"+url.PathEscape(roomID)+"/state/m.room.power_levels/", nil, nil, &levels); err != nil {
+		return false, err
+	}
+
+	level, ok := levels.Users[userID]
+	if !ok {
+		level = levels.UsersDefault
+	}
+	required, ok := levels.Events["m.room.power_levels"]
+	if !ok {
+		// The spec's default when state_default is missing.
+		required = 50
+		if levels.StateDefault != nil {
+			required = *levels.StateDefault
+		}
+	}
+	return level >= required, nil
+}
+
+func (s *Session) Username() string {
+	return s.name
+}
+
+func (s *Session) Messages() <-chan chat.Message {
+	return s.messages
+}
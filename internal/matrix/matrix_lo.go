@@ -0,0 +1,36 @@
+package matrix
+
+import (
+	"strings"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+func homeserverFromEnv(f func(key string) (val string)) (Homeserver, error) {
+	u, err := env.Get("POPPLE_MATRIX_HOMESERVER", f)
+	if err != nil {
+		return "", err
+	}
+
+	return Homeserver(u), nil
+}
+
+func accessTokenFromEnv(f func(key string) (val string)) (AccessToken, error) {
+	token, err := env.Get("POPPLE_MATRIX_ACCESS_TOKEN", f)
+	if err != nil {
+		return "", err
+	}
+
+	return AccessToken(token), nil
+}
+
+func adminsFromEnv(f func(key string) (val string)) (Admins, error) {
+	var admins Admins
+	for _, id := range strings.Split(f("POPPLE_MATRIX_ADMINS"), ",") {
+		if id = strings.TrimSpace(id); len(id) > 0 {
+			admins = append(admins, id)
+		}
+	}
+
+	return admins, nil
+}
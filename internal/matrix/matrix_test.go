@@ -0,0 +1,281 @@
+package matrix_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/command"
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/matrix"
+	"github.com/connorkuehl/popple/internal/matrix/matrixtest"
+)
+
+func newSession(t *testing.T, admins ...string) (*matrixtest.Server, *matrix.Session) {
+	t.Helper()
+
+	srv := matrixtest.NewServer()
+	t.Cleanup(srv.Close)
+
+	s, cleanup, err := matrix.NewSession(matrix.NewDialer(matrix.Homeserver(srv.URL()), matrixtest.AccessToken, admins), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	select {
+	case <-srv.Polling():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the initial sync")
+	}
+
+	return srv, s
+}
+
+func receive(t *testing.T, s *matrix.Session) chat.Message {
+	t.Helper()
+
+	select {
+	case msg := <-s.Messages():
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return chat.Message{}
+	}
+}
+
+func TestMessages(t *testing.T) {
+	srv, s := newSession(t)
+
+	if got := s.Username(); got != matrixtest.DisplayName {
+		t.Errorf("want username %q, got %q", matrixtest.DisplayName, got)
+	}
+
+	srv.SetSpace("!spaced:matrixtest", "!space:matrixtest")
+	srv.SetParent("!claimed:matrixtest", "!space:matrixtest")
+	srv.Hide("!hidden:matrixtest")
+	srv.SetDirect("!dm:matrixtest")
+	srv.Encrypt("!secret:matrixtest")
+
+	// Messages from the bot itself, DMs, encrypted rooms, notices and
+	// edits are dropped.
+	srv.SendMessage("!room:matrixtest", matrixtest.UserID, "talking to myself++")
+	srv.SendMessage("!dm:matrixtest", "@alice:matrixtest", "psst++")
+	srv.SendMessage("!secret:matrixtest", "@alice:matrixtest", "shh++")
+	srv.SendEvent("!room:matrixtest", "@bot:matrixtest", "m.room.message", map[string]any{"msgtype": "m.notice", "body": "beep++"})
+	srv.SendEvent("!room:matrixtest", "@alice:matrixtest", "m.room.message", map[string]any{
+		"msgtype":      "m.text",
+		"body":         "* bob++",
+		"m.relates_to": map[string]any{"rel_type": "m.replace", "event_id": "$event1"},
+	})
+	id := srv.SendMessage("!room:matrixtest", "@alice:matrixtest", "Popple: karma bob")
+
+	want := chat.Message{
+		ID:        id,
+		GuildID:   matrixtest.ServerName,
+		ChannelID: "!room:matrixtest",
+		Content:   "Popple: karma bob",
+	}
+	if got := receive(t, s); got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	id = srv.SendMessage("!spaced:matrixtest", "@alice:matrixtest", "bob++")
+	want = chat.Message{
+		ID:        id,
+		GuildID:   "!space:matrixtest",
+		ChannelID: "!spaced:matrixtest",
+		Content:   "bob++",
+	}
+	if got := receive(t, s); got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	// Rooms whose space can't be looked up are dropped rather than filed
+	// under the homeserver, and a parent space only counts if it lists
+	// the room as a child.
+	srv.SendMessage("!hidden:matrixtest", "@alice:matrixtest", "bob++")
+	id = srv.SendMessage("!claimed:matrixtest", "@alice:matrixtest", "bob++")
+	want = chat.Message{
+		ID:        id,
+		GuildID:   matrixtest.ServerName,
+		ChannelID: "!claimed:matrixtest",
+		Content:   "bob++",
+	}
+	if got := receive(t, s); got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	// Replies quote the message they reply to, which mustn't count again.
+	id = srv.SendEvent("!room:matrixtest", "@carol:matrixtest", "m.room.message", map[string]any{
+		"msgtype":      "m.text",
+		"body":         "> <@bob:matrixtest> > <@alice:matrixtest> bob++\n> nice\n\nthanks",
+		"m.relates_to": map[string]any{"m.in_reply_to": map[string]any{"event_id": id}},
+	})
+	want = chat.Message{
+		ID:        id,
+		GuildID:   matrixtest.ServerName,
+		ChannelID: "!room:matrixtest",
+		Content:   "thanks",
+	}
+	if got := receive(t, s); got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
+
+func TestInvites(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv, _ := newSession(t)
+
+	srv.Invite("!dm:matrixtest", true)
+	srv.Invite("!room:matrixtest", false)
+
+	want := []string{"!room:matrixtest"}
+	for ctx.Err() == nil && !reflect.DeepEqual(srv.Joined(), want) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := srv.Joined(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want joined %v, got %v", want, got)
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	srv, s := newSession(t, "@carol:matrixtest")
+	ctx := context.Background()
+
+	srv.SetSpace("!spaced:matrixtest", "!space:matrixtest")
+	srv.SetParent("!claimed:matrixtest", "!space:matrixtest")
+	srv.SetPowerLevels("!space:matrixtest", map[string]int{
+		"@alice:matrixtest": 100,
+		"@bob:matrixtest":   49,
+	})
+	// Power in the room itself doesn't matter, since anyone can make a
+	// room and invite the bot.
+	for _, room := range []string{"!spaced:matrixtest", "!claimed:matrixtest", "!room:matrixtest"} {
+		srv.SetPowerLevels(room, map[string]int{"@mallory:matrixtest": 100})
+	}
+
+	for _, tt := range []struct {
+		room   string
+		sender string
+		want   bool
+	}{
+		{"!spaced:matrixtest", "@alice:matrixtest", true},
+		{"!spaced:matrixtest", "@bob:matrixtest", false},
+		{"!spaced:matrixtest", "@carol:matrixtest", false},
+		{"!spaced:matrixtest", "@mallory:matrixtest", false},
+		{"!room:matrixtest", "@alice:matrixtest", false},
+		{"!room:matrixtest", "@carol:matrixtest", true},
+		{"!room:matrixtest", "@mallory:matrixtest", false},
+		{"!claimed:matrixtest", "@alice:matrixtest", false},
+		{"!claimed:matrixtest", "@carol:matrixtest", true},
+		{"!claimed:matrixtest", "@mallory:matrixtest", false},
+	} {
+		id := srv.SendMessage(tt.room, tt.sender, "hello")
+		receive(t, s)
+
+		got, err := s.IsAdmin(ctx, tt.room, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("IsAdmin(%s, %s): want %v, got %v", tt.room, tt.sender, tt.want, got)
+		}
+	}
+}
+
+func TestResponses(t *testing.T) {
+	srv, s := newSession(t)
+	ctx := context.Background()
+
+	if err := s.SendMessageToChannel(ctx, "!room:matrixtest", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReplyToMessage(ctx, "!room:matrixtest", "$event1", "hi yourself"); err != nil {
+		t.Fatal(err)
+	}
+	embed := chat.Embed{
+		Title:  "Karma",
+		Fields: []chat.EmbedField{{Name: "Subject", Values: []string{"a<b"}}, {Name: "Karma", Values: []string{"1"}}},
+		Footer: "1 subject",
+	}
+	if err := s.SendEmbedToChannel(ctx, "!room:matrixtest", embed); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReactToMessageWithEmoji(ctx, "!room:matrixtest", "$event1", "✅"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []matrixtest.Event{
+		{
+			RoomID:  "!room:matrixtest",
+			Type:    "m.room.message",
+			Content: map[string]any{"msgtype": "m.text", "body": "hello"},
+		},
+		{
+			RoomID: "!room:matrixtest",
+			Type:   "m.room.message",
+			Content: map[string]any{
+				"msgtype":      "m.text",
+				"body":         "hi yourself",
+				"m.relates_to": map[string]any{"m.in_reply_to": map[string]any{"event_id": "$event1"}},
+			},
+		},
+		{
+			RoomID: "!room:matrixtest",
+			Type:   "m.room.message",
+			Content: map[string]any{
+				"msgtype":        "m.text",
+				"body":           embed.String(),
+				"format":         "org.matrix.custom.html",
+				"formatted_body": "<strong>Karma</strong><table><tr><th>Subject</th><th>Karma</th></tr><tr><td>a&lt;b</td><td>1</td></tr></table><em>1 subject</em>",
+			},
+		},
+		{
+			RoomID: "!room:matrixtest",
+			Type:   "m.reaction",
+			Content: map[string]any{
+				"m.relates_to": map[string]any{"rel_type": "m.annotation", "event_id": "$event1", "key": "✅"},
+			},
+		},
+	}
+	if got := srv.Sent(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want sent %+v, got %+v", want, got)
+	}
+}
+
+func TestBot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv, s := newSession(t)
+
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	b := bot.New(s, db, command.NewRouter(s.Username()+":"), nil)
+	go func() { _ = b.Listen(ctx) }()
+
+	srv.SendMessage("!room:matrixtest", "@alice:matrixtest", "bob++")
+
+	want := []matrixtest.Event{{
+		RoomID:  "!room:matrixtest",
+		Type:    "m.room.message",
+		Content: map[string]any{"msgtype": "m.text", "body": "bob has 1 karma."},
+	}}
+	for ctx.Err() == nil {
+		if got := srv.Sent(); reflect.DeepEqual(got, want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("want sent %+v, got %+v", want, srv.Sent())
+}
@@ -0,0 +1,381 @@
+// Package matrixtest provides a stand-in for the parts of the Matrix
+// client-server API that Popple uses.
+package matrixtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AccessToken = "syt_matrixtest"
+	ServerName  = "matrixtest"
+	UserID      = "@popple:" + ServerName
+	DisplayName = "Popple"
+)
+
+// Event is an event the client sent.
+type Event struct {
+	RoomID  string
+	Type    string
+	Content map[string]any
+}
+
+// update is something that's delivered to the client the next time it
+// syncs.
+type update struct {
+	roomID string
+	// kind is one of "timeline", "invite" or "account_data".
+	kind  string
+	event map[string]any
+}
+
+type Server struct {
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	updates []update
+	// changed is closed and replaced whenever an update is added.
+	changed chan struct{}
+	// polling is closed once the client syncs incrementally, i.e., once
+	// the client is past its initial sync.
+	polling chan struct{}
+	state   map[string][]map[string]any
+	joined  []string
+	sent    []Event
+	events  int
+	// senders maps the IDs of events sent with SendEvent to their senders.
+	senders map[string]string
+	// hidden holds the rooms whose state the client can't see.
+	hidden map[string]bool
+}
+
+func NewServer() *Server {
+	s := &Server{
+		changed: make(chan struct{}),
+		polling: make(chan struct{}),
+		state:   make(map[string][]map[string]any),
+		hidden:  make(map[string]bool),
+		senders: make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_matrix/client/v3/account/whoami", s.withToken(s.whoami))
+	mux.HandleFunc("/_matrix/client/v3/profile/", s.withToken(s.profile))
+	mux.HandleFunc("/_matrix/client/v3/sync", s.withToken(s.sync))
+	mux.HandleFunc("/_matrix/client/v3/rooms/", s.withToken(s.rooms))
+
+	s.srv = httptest.NewServer(mux)
+	return s
+}
+
+// URL is the homeserver URL to point the Matrix dialer at.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Polling is closed once the client has finished its initial sync and
+// will receive anything sent from then on.
+func (s *Server) Polling() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.polling
+}
+
+// Joined returns every room the client has joined so far.
+func (s *Server) Joined() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.joined...)
+}
+
+// Sent returns every event the client has sent so far.
+func (s *Server) Sent() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.sent...)
+}
+
+// SendMessage delivers a text message from sender and returns its event ID.
+func (s *Server) SendMessage(roomID, sender, body string) string {
+	return s.SendEvent(roomID, sender, "m.room.message", map[string]any{"msgtype": "m.text", "body": body})
+}
+
+// SendEvent delivers an arbitrary timeline event and returns its ID.
+func (s *Server) SendEvent(roomID, sender, typ string, content map[string]any) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events++
+	id := "$event" + strconv.Itoa(s.events)
+	s.senders[id] = sender
+	s.add(update{roomID: roomID, kind: "timeline", event: map[string]any{
+		"type":     typ,
+		"event_id": id,
+		"sender":   sender,
+		"content":  content,
+	}})
+	return id
+}
+
+// Encrypt turns on end-to-end encryption in the room.
+func (s *Server) Encrypt(roomID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events++
+	s.add(update{roomID: roomID, kind: "timeline", event: map[string]any{
+		"type":      "m.room.encryption",
+		"event_id":  "$event" + strconv.Itoa(s.events),
+		"sender":    "@admin:" + ServerName,
+		"state_key": "",
+		"content":   map[string]any{"algorithm": "m.megolm.v1.aes-sha2"},
+	}})
+}
+
+// Invite invites the client to the room.
+func (s *Server) Invite(roomID string, direct bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.add(update{roomID: roomID, kind: "invite", event: map[string]any{
+		"type":      "m.room.member",
+		"sender":    "@admin:" + ServerName,
+		"state_key": UserID,
+		"content":   map[string]any{"membership": "invite", "is_direct": direct},
+	}})
+}
+
+// SetDirect marks rooms as direct messages in the client's account data.
+func (s *Server) SetDirect(roomIDs ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.add(update{kind: "account_data", event: map[string]any{
+		"type":    "m.direct",
+		"content": map[string]any{"@admin:" + ServerName: roomIDs},
+	}})
+}
+
+// SetSpace makes the room a child of the space.
+func (s *Server) SetSpace(roomID, spaceID string) {
+	s.SetParent(roomID, spaceID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state[spaceID] = append(s.state[spaceID], map[string]any{
+		"type":      "m.space.child",
+		"sender":    "@admin:" + ServerName,
+		"state_key": roomID,
+		"content":   map[string]any{"via": []string{ServerName}},
+	})
+}
+
+// SetParent names the space as the room's parent without the space naming
+// the room as its child.
+func (s *Server) SetParent(roomID, spaceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state[roomID] = append(s.state[roomID], map[string]any{
+		"type":      "m.space.parent",
+		"sender":    "@admin:" + ServerName,
+		"state_key": spaceID,
+		"content":   map[string]any{"via": []string{ServerName}},
+	})
+}
+
+// Hide refuses the client's requests for the room's state.
+func (s *Server) Hide(roomID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hidden[roomID] = true
+}
+
+// SetPowerLevels sets the room's power levels to the spec's defaults, with
+// users given the levels in users.
+func (s *Server) SetPowerLevels(roomID string, users map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state[roomID] = append(s.state[roomID], map[string]any{
+		"type":      "m.room.power_levels",
+		"sender":    "@admin:" + ServerName,
+		"state_key": "",
+		"content":   map[string]any{"users": users},
+	})
+}
+
+// add must be called with s.mu held.
+func (s *Server) add(u update) {
+	s.updates = append(s.updates, u)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) withToken(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+AccessToken {
+			reply(w, http.StatusUnauthorized, map[string]any{"errcode": "M_UNKNOWN_TOKEN", "error": "Unknown access token"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) whoami(w http.ResponseWriter, r *http.Request) {
+	reply(w, http.StatusOK, map[string]any{"user_id": UserID})
+}
+
+func (s *Server) profile(w http.ResponseWriter, r *http.Request) {
+	reply(w, http.StatusOK, map[string]any{"displayname": DisplayName})
+}
+
+func (s *Server) sync(w http.ResponseWriter, r *http.Request) {
+	since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+	timeout, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+
+	s.mu.Lock()
+	if len(r.URL.Query().Get("since")) > 0 {
+		select {
+		case <-s.polling:
+		default:
+			close(s.polling)
+		}
+	}
+	changed := s.changed
+	n := len(s.updates)
+	s.mu.Unlock()
+
+	if n <= since && timeout > 0 {
+		select {
+		case <-changed:
+		case <-time.After(time.Duration(timeout) * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	s.mu.Lock()
+	updates := s.updates[min(since, len(s.updates)):]
+	next := len(s.updates)
+	s.mu.Unlock()
+
+	var accountData []any
+	join := make(map[string]map[string]any)
+	invite := make(map[string]any)
+	for _, u := range updates {
+		switch u.kind {
+		case "account_data":
+			accountData = append(accountData, u.event)
+		case "invite":
+			invite[u.roomID] = map[string]any{"invite_state": map[string]any{"events": []any{u.event}}}
+		case "timeline":
+			room, ok := join[u.roomID]
+			if !ok {
+				room = map[string]any{"timeline": map[string]any{"events": []any{}}}
+				join[u.roomID] = room
+			}
+			timeline := room["timeline"].(map[string]any)
+			timeline["events"] = append(timeline["events"].([]any), u.event)
+		}
+	}
+
+	reply(w, http.StatusOK, map[string]any{
+		"next_batch":   strconv.Itoa(next),
+		"account_data": map[string]any{"events": accountData},
+		"rooms":        map[string]any{"join": join, "invite": invite},
+	})
+}
+
+// rooms serves /rooms/{roomID}/join, /rooms/{roomID}/state,
+// /rooms/{roomID}/state/{eventType}/{stateKey},
+// /rooms/{roomID}/event/{eventID} and
+// /rooms/{roomID}/send/{eventType}/{txnID}.
+func (s *Server) rooms(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3/rooms/"), "/")
+	roomID := parts[0]
+
+	s.mu.Lock()
+	hidden := s.hidden[roomID]
+	s.mu.Unlock()
+
+	switch {
+	case len(parts) >= 2 && parts[1] == "state" && hidden:
+		reply(w, http.StatusForbidden, map[string]any{"errcode": "M_FORBIDDEN", "error": "You aren't a member of the room"})
+	case len(parts) == 2 && parts[1] == "join" && r.Method == http.MethodPost:
+		s.mu.Lock()
+		s.joined = append(s.joined, roomID)
+		s.mu.Unlock()
+		reply(w, http.StatusOK, map[string]any{"room_id": roomID})
+	case len(parts) == 2 && parts[1] == "state" && r.Method == http.MethodGet:
+		s.mu.Lock()
+		state := append([]map[string]any{}, s.state[roomID]...)
+		s.mu.Unlock()
+		reply(w, http.StatusOK, state)
+	case len(parts) == 4 && parts[1] == "state" && r.Method == http.MethodGet:
+		s.mu.Lock()
+		var content any
+		for _, ev := range s.state[roomID] {
+			if ev["type"] == parts[2] && ev["state_key"] == parts[3] {
+				content = ev["content"]
+			}
+		}
+		s.mu.Unlock()
+		if content == nil {
+			reply(w, http.StatusNotFound, map[string]any{"errcode": "M_NOT_FOUND", "error": "Event not found"})
+			return
+		}
+		reply(w, http.StatusOK, content)
+	case len(parts) == 3 && parts[1] == "event" && r.Method == http.MethodGet:
+		s.mu.Lock()
+		sender, ok := s.senders[parts[2]]
+		s.mu.Unlock()
+		if !ok {
+			reply(w, http.StatusNotFound, map[string]any{"errcode": "M_NOT_FOUND", "error": "Event not found"})
+			return
+		}
+		reply(w, http.StatusOK, map[string]any{"event_id": parts[2], "room_id": roomID, "sender": sender})
+	case len(parts) == 4 && parts[1] == "send" && r.Method == http.MethodPut:
+		var content map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+			reply(w, http.StatusBadRequest, map[string]any{"errcode": "M_NOT_JSON", "error": err.Error()})
+			return
+		}
+		s.mu.Lock()
+		s.events++
+		id := "$event" + strconv.Itoa(s.events)
+		s.sent = append(s.sent, Event{RoomID: roomID, Type: parts[2], Content: content})
+		s.mu.Unlock()
+		reply(w, http.StatusOK, map[string]any{"event_id": id})
+	default:
+		reply(w, http.StatusNotFound, map[string]any{"errcode": "M_UNRECOGNIZED", "error": "Unrecognized request"})
+	}
+}
+
+func reply(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		panic(fmt.Sprintf("matrixtest: encode reply: %v", err))
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
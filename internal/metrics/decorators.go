@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/command"
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/logging"
+	"github.com/connorkuehl/popple/internal/popple"
+)
+
+// DB times every query the bot makes and counts the karma events it
+// applies. Queries are logged at the debug level, correlated with the
+// message they're for.
+func (m *Metrics) DB(db bot.DB) bot.DB {
+	return &instrumentedDB{m: m, db: db}
+}
+
+type instrumentedDB struct {
+	m  *Metrics
+	db bot.DB
+}
+
+func (d *instrumentedDB) observe(ctx context.Context, method string, start time.Time) {
+	took := time.Since(start)
+	d.m.dbDuration.WithLabelValues(method).Observe(took.Seconds())
+	logging.FromContext(ctx).WithFields(log.Fields{
+		"method":   method,
+		"duration": took,
+	}).Debug("database call")
+}
+
+func (d *instrumentedDB) Config(ctx context.Context, serverID string) (popple.ServerConfig, error) {
+	defer d.observe(ctx, "Config", time.Now())
+	return d.db.Config(ctx, serverID)
+}
+
+func (d *instrumentedDB) PutConfig(ctx context.Context, config popple.ServerConfig) error {
+	defer d.observe(ctx, "PutConfig", time.Now())
+	return d.db.PutConfig(ctx, config)
+}
+
+func (d *instrumentedDB) Entities(ctx context.Context, serverID string, names ...string) ([]popple.Entity, error) {
+	defer d.observe(ctx, "Entities", time.Now())
+	return d.db.Entities(ctx, serverID, names...)
+}
+
+func (d *instrumentedDB) Leaderboard(ctx context.Context, serverID string, limit uint) (popple.Board, error) {
+	defer d.observe(ctx, "Leaderboard", time.Now())
+	return d.db.Leaderboard(ctx, serverID, limit)
+}
+
+func (d *instrumentedDB) Loserboard(ctx context.Context, serverID string, limit uint) (popple.Board, error) {
+	defer d.observe(ctx, "Loserboard", time.Now())
+	return d.db.Loserboard(ctx, serverID, limit)
+}
+
+func (d *instrumentedDB) ChannelEntities(ctx context.Context, serverID, channelID string, names ...string) ([]popple.Entity, error) {
+	defer d.observe(ctx, "ChannelEntities", time.Now())
+	return d.db.ChannelEntities(ctx, serverID, channelID, names...)
+}
+
+func (d *instrumentedDB) ChannelLeaderboard(ctx context.Context, serverID, channelID string, limit uint) (popple.Board, error) {
+	defer d.observe(ctx, "ChannelLeaderboard", time.Now())
+	return d.db.ChannelLeaderboard(ctx, serverID, channelID, limit)
+}
+
+func (d *instrumentedDB) ChannelLoserboard(ctx context.Context, serverID, channelID string, limit uint) (popple.Board, error) {
+	defer d.observe(ctx, "ChannelLoserboard", time.Now())
+	return d.db.ChannelLoserboard(ctx, serverID, channelID, limit)
+}
+
+func (d *instrumentedDB) ServerEntities(ctx context.Context, serverID string) ([]database.EntityRecord, error) {
+	defer d.observe(ctx, "ServerEntities", time.Now())
+	return d.db.ServerEntities(ctx, serverID)
+}
+
+func (d *instrumentedDB) ServerKarmaEvents(ctx context.Context, serverID string) ([]database.EventRecord, error) {
+	defer d.observe(ctx, "ServerKarmaEvents", time.Now())
+	return d.db.ServerKarmaEvents(ctx, serverID)
+}
+
+// ApplyKarma is where karma changes, so that's where the change is
+// counted.
+func (d *instrumentedDB) ApplyKarma(ctx context.Context, serverID string, event popple.KarmaEvent) (popple.Increments, error) {
+	defer d.observe(ctx, "ApplyKarma", time.Now())
+	levels, err := d.db.ApplyKarma(ctx, serverID, event)
+	if levels != nil {
+		d.m.karmaEvents.Add(float64(len(event.Increments)))
+	}
+	return levels, err
+}
+
+// Session counts the responses the bot fails to send. Responses are logged at the debug level, correlated with the
+// message they're for.
+func (m *Metrics) Session(s bot.Session) bot.Session {
+	return &instrumentedSession{m: m, s: s}
+}
+
+type instrumentedSession struct {
+	m *Metrics
+	s bot.Session
+}
+
+func (s *instrumentedSession) failed(ctx context.Context, method string, err error) error {
+	ll := logging.FromContext(ctx).WithField("method", method)
+	if err != nil {
+		s.m.sendFailures.WithLabelValues(method).Inc()
+		ll = ll.WithError(err)
+	}
+	ll.Debug("session call")
+	return err
+}
+
+func (s *instrumentedSession) SendMessageToChannel(ctx context.Context, channelID string, msg string) error {
+	return s.failed(ctx, "SendMessageToChannel", s.s.SendMessageToChannel(ctx, channelID, msg))
+}
+
+func (s *instrumentedSession) ReplyToMessage(ctx context.Context, channelID, messageID string, msg string) error {
+	return s.failed(ctx, "ReplyToMessage", s.s.ReplyToMessage(ctx, channelID, messageID, msg))
+}
+
+func (s *instrumentedSession) SendEmbedToChannel(ctx context.Context, channelID string, embed chat.Embed) error {
+	return s.failed(ctx, "SendEmbedToChannel", s.s.SendEmbedToChannel(ctx, channelID, embed))
+}
+
+func (s *instrumentedSession) ReactToMessageWithEmoji(ctx context.Context, channelID, messageID, emojiID string) error {
+	return s.failed(ctx, "ReactToMessageWithEmoji", s.s.ReactToMessageWithEmoji(ctx, channelID, messageID, emojiID))
+}
+
+// SendFileToAdmin doesn't count refusals as failures.
+func (s *instrumentedSession) SendFileToAdmin(ctx context.Context, channelID, messageID string, file chat.File) error {
+	err := s.s.SendFileToAdmin(ctx, channelID, messageID, file)
+	if errors.Is(err, chat.ErrNotAdmin) || errors.Is(err, chat.ErrUnsupported) {
+		return err
+	}
+	return s.failed(ctx, "SendFileToAdmin", err)
+}
+
+// IsAdmin doesn't count unsupported checks as failures.
+func (s *instrumentedSession) IsAdmin(ctx context.Context, channelID, messageID string) (bool, error) {
+	admin, err := s.s.IsAdmin(ctx, channelID, messageID)
+	if errors.Is(err, chat.ErrUnsupported) {
+		return admin, err
+	}
+	return admin, s.failed(ctx, "IsAdmin", err)
+}
+
+func (s *instrumentedSession) Messages() <-chan chat.Message {
+	return s.s.Messages()
+}
+
+// Router counts the messages the bot handles and which command each one is
+// routed to. Every message the bot takes from the session is routed once.
+func (m *Metrics) Router(r bot.CommandRouter) bot.CommandRouter {
+	return &instrumentedRouter{m: m, r: r}
+}
+
+type instrumentedRouter struct {
+	m *Metrics
+	r bot.CommandRouter
+}
+
+func (r *instrumentedRouter) Route(s string) (command.ArgParser, string) {
+	r.m.messagesReceived.Inc()
+	args, remainder := r.r.Route(s)
+	if args != nil {
+		r.m.commands.WithLabelValues(command.Name(args)).Inc()
+	}
+	return args, remainder
+}
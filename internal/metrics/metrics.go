@@ -0,0 +1,161 @@
+// Package metrics exports Prometheus metrics about the bot. The bot isn't
+// aware of it: its dependencies are wrapped in decorators that count what
+// passes through them.
+package metrics
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/env"
+	"github.com/connorkuehl/popple/internal/httpserver"
+)
+
+// Config configures the metrics endpoint. It's disabled unless Addr is
+// set.
+type Config struct {
+	// Addr is the address to serve /metrics on, e.g., ":9090".
+	Addr string
+}
+
+func ConfigFromEnv(lookup env.Lookup) (Config, error) {
+	return configFromEnv(lookup)
+}
+
+type Metrics struct {
+	config   Config
+	registry *prometheus.Registry
+
+	messagesReceived prometheus.Counter
+	commands         *prometheus.CounterVec
+	karmaEvents      prometheus.Counter
+	handlerErrors    *prometheus.CounterVec
+	dbDuration       *prometheus.HistogramVec
+	sendFailures     *prometheus.CounterVec
+}
+
+func New(config Config) *Metrics {
+	m := &Metrics{
+		config:   config,
+		registry: prometheus.NewRegistry(),
+		messagesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "popple",
+			Name:      "messages_received_total",
+			Help:      "Messages received from the chat service.",
+		}),
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "popple",
+			Name:      "commands_total",
+			Help:      "Messages routed to each command.",
+		}, []string{"command"}),
+		karmaEvents: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "popple",
+			Name:      "karma_events_total",
+			Help:      "Changes to a subject's karma that were applied.",
+		}),
+		handlerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "popple",
+			Name:      "handler_errors_total",
+			Help:      "Errors each handler ran into.",
+		}, []string{"handler"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "popple",
+			Name:      "db_query_duration_seconds",
+			Help:      "How long each database method takes.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"method"}),
+		sendFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "popple",
+			Name:      "send_failures_total",
+			Help:      "Responses that couldn't be sent to the chat service, by session method.",
+		}, []string{"method"}),
+	}
+
+	m.registry.MustRegister(
+		m.messagesReceived,
+		m.commands,
+		m.karmaEvents,
+		m.handlerErrors,
+		m.dbDuration,
+		m.sendFailures,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Connection is the bot's connection to its chat service.
+type Connection interface {
+	Connected() bool
+	Reconnects() uint64
+}
+
+// dropper is a connection that drops messages it can't keep up with.
+type dropper interface {
+	Dropped() uint64
+}
+
+// Connection exports the state of the bot's connection to its chat
+// service. It may only be called once.
+func (m *Metrics) Connection(c Connection) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "popple",
+			Name:      "connected",
+			Help:      "Whether the bot is connected to the chat service.",
+		}, func() float64 {
+			if c.Connected() {
+				return 1
+			}
+			return 0
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "popple",
+			Name:      "reconnects_total",
+			Help:      "Times the bot connected to the chat service again after losing its connection.",
+		}, func() float64 {
+			return float64(c.Reconnects())
+		}),
+	)
+
+	if d, ok := c.(dropper); ok {
+		m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "popple",
+			Name:      "messages_dropped_total",
+			Help:      "Messages dropped because the bot couldn't keep up with the chat service.",
+		}, func() float64 {
+			return float64(d.Dropped())
+		}))
+	}
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ListenAndServe serves /metrics until ctx is canceled. It returns
+// immediately if metrics are disabled.
+func (m *Metrics) ListenAndServe(ctx context.Context) error {
+	if len(m.config.Addr) == 0 {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+
+	log.WithField("addr", m.config.Addr).Info("serving metrics")
+	return httpserver.ListenAndServe(ctx, m.config.Addr, mux)
+}
+
+// HandlerFailed counts an error that handler ran into.
+func (m *Metrics) HandlerFailed(handler string) {
+	m.handlerErrors.WithLabelValues(handler).Inc()
+}
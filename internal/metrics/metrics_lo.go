@@ -0,0 +1,15 @@
+package metrics
+
+import (
+	"errors"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+func configFromEnv(f func(key string) (val string)) (Config, error) {
+	addr, err := env.Get("POPPLE_METRICS_ADDR", f)
+	if errors.Is(err, env.ErrKeyNotFound) {
+		return Config{}, nil
+	}
+	return Config{Addr: addr}, err
+}
@@ -0,0 +1,102 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/command"
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/discord/discordtest"
+	"github.com/connorkuehl/popple/internal/metrics"
+)
+
+// brokenSession can't send messages.
+type brokenSession struct {
+	*discordtest.ResponseRecorder
+}
+
+func (s brokenSession) SendMessageToChannel(ctx context.Context, channelID string, msg string) error {
+	return errors.New("nope")
+}
+
+func TestMetrics(t *testing.T) {
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	m := metrics.New(metrics.Config{})
+
+	session := brokenSession{discordtest.NewResponseRecorder([]chat.Message{
+		{ID: "1", GuildID: "1", ChannelID: "2", Content: "link++"},
+		{ID: "2", GuildID: "1", ChannelID: "2", Content: "zelda++ ganon--"},
+		{ID: "3", GuildID: "1", ChannelID: "2", Content: "popple top"},
+	})}
+
+	b := bot.New(m.Session(session), m.DB(db), m.Router(command.NewRouter("popple")), nil)
+	b.CountFailures(m)
+	if err := b.Listen(context.Background()); !errors.Is(err, bot.ErrSessionClosed) {
+		t.Fatalf("want %v, got %v", bot.ErrSessionClosed, err)
+	}
+
+	rsp := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rsp, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rsp.Body)
+	got := string(body)
+
+	for _, want := range []string{
+		`popple_messages_received_total 3`,
+		`popple_commands_total{command="ChangeKarma"} 2`,
+		`popple_commands_total{command="Leaderboard"} 1`,
+		`popple_karma_events_total 3`,
+		`popple_send_failures_total{method="SendMessageToChannel"} 3`,
+		`popple_handler_errors_total{handler="change_karma"} 2`,
+		`popple_handler_errors_total{handler="board"} 1`,
+		`popple_db_query_duration_seconds_count{method="ApplyKarma"} 2`,
+		`popple_db_query_duration_seconds_count{method="Leaderboard"} 1`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("want %q in:\n%s", want, got)
+		}
+	}
+}
+
+type connection struct {
+	chat.ConnState
+	dropped uint64
+}
+
+func (c *connection) Dropped() uint64 { return c.dropped }
+
+func TestConnection(t *testing.T) {
+	m := metrics.New(metrics.Config{})
+
+	c := &connection{dropped: 4}
+	c.SetConnected(true)
+	c.SetConnected(false)
+	c.SetConnected(true)
+	c.SetConnected(false)
+	m.Connection(c)
+
+	rsp := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rsp, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rsp.Body)
+	got := string(body)
+
+	for _, want := range []string{
+		`popple_connected 0`,
+		`popple_reconnects_total 1`,
+		`popple_messages_dropped_total 4`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("want %q in:\n%s", want, got)
+		}
+	}
+}
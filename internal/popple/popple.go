@@ -1,17 +1,163 @@
 package popple
 
+import "time"
+
 type Increments map[string]int64
 
+// KarmaEvent is a change in karma along with where it happened and, if
+// known, who made it and why.
+type KarmaEvent struct {
+	// ChannelID is empty for changes that didn't happen in a channel.
+	ChannelID  string
+	Increments Increments
+	// Levels are the karma levels of everyone in Increments after the
+	// change.
+	Levels Increments
+	Actor  string
+	Reason string
+	// At is when the change happened. It's zero for changes happening
+	// now.
+	At time.Time
+	// MessageID is the message that made the change, if there was one. A
+	// message's changes are only ever applied once.
+	MessageID string
+	// Pooled is set if the channel keeps its own karma instead of
+	// sharing the server's.
+	Pooled bool
+}
+
+// BackfillWindow is how far back a channel's history can be backfilled.
+// Messages whose karma was applied longer ago than that are forgotten,
+// since no backfill can reach them again.
+const BackfillWindow = 30 * 24 * time.Hour
+
+// WebhookEvent is a kind of karma change a webhook can subscribe to.
+type WebhookEvent string
+
+const (
+	WebhookKarmaIncreased WebhookEvent = "karma.increased"
+	WebhookKarmaDecreased WebhookEvent = "karma.decreased"
+)
+
+// Webhook is a URL that is told about a server's karma changes.
+type Webhook struct {
+	ID       int64
+	ServerID string
+	URL      string
+	// Secret signs the webhook's payloads so that the receiver can tell
+	// they came from Popple.
+	Secret string
+	// Events are the changes the webhook wants. Empty means all of them.
+	Events []WebhookEvent
+}
+
+// Filter returns the part of the event the webhook wants, if any.
+func (w Webhook) Filter(event KarmaEvent) (KarmaEvent, bool) {
+	filtered := event
+	filtered.Increments = make(Increments)
+	filtered.Levels = make(Increments)
+
+	for name, delta := range event.Increments {
+		if !w.wants(delta) {
+			continue
+		}
+		filtered.Increments[name] = delta
+		if level, ok := event.Levels[name]; ok {
+			filtered.Levels[name] = level
+		}
+	}
+
+	return filtered, len(filtered.Increments) > 0
+}
+
+func (w Webhook) wants(delta int64) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+
+	for _, e := range w.Events {
+		switch {
+		case e == WebhookKarmaIncreased && delta > 0:
+			return true
+		case e == WebhookKarmaDecreased && delta < 0:
+			return true
+		}
+	}
+	return false
+}
+
 type Entity struct {
 	Name  string
 	Karma int64
 }
 
 type ServerConfig struct {
-	ServerID   string
-	NoAnnounce bool
+	ServerID string
+	Announce AnnounceMode
+	// DigestInterval is how long AnnounceDigest batches announcements
+	// before posting them.
+	DigestInterval time.Duration
+	ChannelFilter  ChannelFilter
+	// Locale is the language code the bot responds in. Empty means the
+	// default language.
+	Locale string
+	// FilteredChannels are the channels ChannelFilter allows or denies.
+	FilteredChannels []string
+	// PooledChannels are the channels that keep their own karma pool
+	// instead of contributing to the server-wide one.
+	PooledChannels []string
+	// Embeds renders boards and karma checks as rich embeds instead of
+	// text.
+	Embeds bool
+	// Templates override the bot's default responses, keyed by the
+	// response's name.
+	Templates map[string]string
+}
+
+// Watches reports whether karma events in channelID should be counted.
+func (c ServerConfig) Watches(channelID string) bool {
+	switch c.ChannelFilter {
+	case ChannelFilterAllow:
+		return contains(c.FilteredChannels, channelID)
+	case ChannelFilterDeny:
+		return !contains(c.FilteredChannels, channelID)
+	default:
+		return true
+	}
+}
+
+// Pools reports whether channelID keeps its own karma pool.
+func (c ServerConfig) Pools(channelID string) bool {
+	return contains(c.PooledChannels, channelID)
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
 
+type AnnounceMode int
+
+const (
+	AnnounceMessage AnnounceMode = 0
+	AnnounceOff     AnnounceMode = 1
+	AnnounceReact   AnnounceMode = 2
+	AnnounceReply   AnnounceMode = 3
+	AnnounceDigest  AnnounceMode = 4
+)
+
+type ChannelFilter int
+
+const (
+	ChannelFilterNone  ChannelFilter = 0
+	ChannelFilterAllow ChannelFilter = 1
+	ChannelFilterDeny  ChannelFilter = 2
+)
+
 type BoardEntry struct {
 	Who   string
 	Karma int64
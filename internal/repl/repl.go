@@ -0,0 +1,222 @@
+// Package repl lets Popple be chatted with from a terminal, which is handy
+// for trying out commands without connecting to a chat service.
+package repl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/chat"
+)
+
+// Name is the bot's name in the REPL.
+const Name = "popple"
+
+const help = `Type a message to send it. Lines starting with / are REPL commands:
+  /guild ID      switch to another server
+  /channel ID    switch to another channel in the server
+  /user NAME     chat as someone else
+  /help          show this help
+  /quit          exit`
+
+type Session struct {
+	out      io.Writer
+	messages chan chat.Message
+
+	mu      sync.Mutex
+	guild   string
+	channel string
+	user    string
+	// run and seq make up message IDs. run differs between sessions so
+	// that a database shared with another session never mistakes a
+	// message for one it has already applied.
+	run string
+	seq int
+	// authors remembers who sent each message so that replies and
+	// reactions can say who they're for.
+	authors map[string]string
+}
+
+// NewStdioSession starts a session on stdin and stdout.
+func NewStdioSession() (*Session, func()) {
+	return NewSession(os.Stdin, os.Stdout)
+}
+
+// NewSession starts reading messages from in and writes the bot's
+// responses to out. The session's messages channel is closed once in is
+// exhausted.
+func NewSession(in io.Reader, out io.Writer) (*Session, func()) {
+	s := &Session{
+		out:      out,
+		messages: make(chan chat.Message),
+		guild:    "repl",
+		channel:  "general",
+		user:     "you",
+		run:      strconv.FormatInt(time.Now().UnixNano(), 36),
+		authors:  make(map[string]string),
+	}
+
+	s.printf("%s\n\n", help)
+	s.printContext()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(s.messages)
+		s.read(in, done)
+	}()
+
+	return s, func() { close(done) }
+}
+
+func (s *Session) read(in io.Reader, done <-chan struct{}) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(line, "/") {
+			if quit := s.command(line); quit {
+				return
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		s.seq++
+		msg := chat.Message{
+			ID:        s.run + "-" + strconv.Itoa(s.seq),
+			GuildID:   s.guild,
+			ChannelID: s.channel,
+			Content:   line,
+		}
+		s.authors[msg.ID] = s.user
+		s.mu.Unlock()
+
+		select {
+		case s.messages <- msg:
+		case <-done:
+			return
+		}
+	}
+}
+
+// command runs a REPL command and reports whether the REPL should exit.
+func (s *Session) command(line string) bool {
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch cmd {
+	case "/quit", "/exit":
+		return true
+	case "/guild", "/channel", "/user":
+		if len(arg) == 0 || strings.ContainsAny(arg, " \t") {
+			s.printf("usage: %s NAME\n", cmd)
+			return false
+		}
+
+		s.mu.Lock()
+		switch cmd {
+		case "/guild":
+			s.guild = arg
+		case "/channel":
+			s.channel = strings.TrimPrefix(arg, "#")
+		case "/user":
+			s.user = arg
+		}
+		s.mu.Unlock()
+
+		s.printContext()
+	default:
+		s.printf("%s\n", help)
+	}
+	return false
+}
+
+func (s *Session) printContext() {
+	s.mu.Lock()
+	guild, channel, user := s.guild, s.channel, s.user
+	s.mu.Unlock()
+
+	s.printf("(chatting as %s in %s #%s)\n", user, guild, channel)
+}
+
+func (s *Session) printf(format string, args ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.out, format, args...)
+}
+
+func (s *Session) author(messageID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.authors[messageID]
+}
+
+func (s *Session) SendMessageToChannel(ctx context.Context, channelID string, msg string) error {
+	s.printf("#%s <%s> %s\n", channelID, Name, indent(msg))
+	return nil
+}
+
+func (s *Session) ReplyToMessage(ctx context.Context, channelID, messageID string, msg string) error {
+	s.printf("#%s <%s> @%s %s\n", channelID, Name, s.author(messageID), indent(msg))
+	return nil
+}
+
+func (s *Session) SendEmbedToChannel(ctx context.Context, channelID string, embed chat.Embed) error {
+	return s.SendMessageToChannel(ctx, channelID, strings.TrimSpace(embed.String()))
+}
+
+// ReactToMessageWithEmoji prints the reaction under the message it's for.
+func (s *Session) ReactToMessageWithEmoji(ctx context.Context, channelID, messageID, emojiID string) error {
+	s.printf("#%s   %s reacted %s to %s's message\n", channelID, Name, emojiID, s.author(messageID))
+	return nil
+}
+
+// SendFileToAdmin prints the file, since whoever is at the REPL runs the
+// server.
+func (s *Session) SendFileToAdmin(ctx context.Context, channelID, messageID string, file chat.File) error {
+	s.printf("#%s   %s sent %s a file, %s:\n%s\n", channelID, Name, s.author(messageID), file.Name, file.Data)
+	return nil
+}
+
+// IsAdmin is always true, since whoever is at the REPL runs the server.
+func (s *Session) IsAdmin(ctx context.Context, channelID, messageID string) (bool, error) {
+	return true, nil
+}
+
+// Connected is always true since there's nothing to be disconnected from.
+func (s *Session) Connected() bool {
+	return true
+}
+
+// LastHeartbeat is always zero since there's no connection to watch.
+func (s *Session) LastHeartbeat() time.Time {
+	return time.Time{}
+}
+
+// Reconnects is always zero.
+func (s *Session) Reconnects() uint64 {
+	return 0
+}
+
+func (s *Session) Username() string {
+	return Name
+}
+
+func (s *Session) Messages() <-chan chat.Message {
+	return s.messages
+}
+
+// indent lines up the lines after the first of a multiline message.
+func indent(msg string) string {
+	return strings.ReplaceAll(msg, "\n", "\n    ")
+}
@@ -0,0 +1,94 @@
+package repl_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/command"
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/repl"
+)
+
+func TestMessages(t *testing.T) {
+	in := strings.NewReader("hello\n\n/guild other\n/channel #random\n/user alice\n/user\nbob++\n/quit\nignored\n")
+	var out bytes.Buffer
+
+	s, cleanup := repl.NewSession(in, &out)
+	defer cleanup()
+
+	var got []chat.Message
+	for msg := range s.Messages() {
+		got = append(got, msg)
+	}
+
+	want := []chat.Message{
+		{GuildID: "repl", ChannelID: "general", Content: "hello"},
+		{GuildID: "other", ChannelID: "random", Content: "bob++"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %+v, got %+v", want, got)
+	}
+	ids := make(map[string]bool)
+	for i := range want {
+		ids[got[i].ID] = true
+		got[i].ID = ""
+		if got[i] != want[i] {
+			t.Errorf("want %+v, got %+v", want[i], got[i])
+		}
+	}
+	if len(ids) != len(want) {
+		t.Errorf("want every message to have its own ID, got %v", ids)
+	}
+
+	if !strings.Contains(out.String(), "(chatting as alice in other #random)") {
+		t.Errorf("want context after switching, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), "usage: /user NAME") {
+		t.Errorf("want usage for /user without a name, got %q", out.String())
+	}
+}
+
+func TestBot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	in := strings.NewReader("/user alice\nbob++\n@popple announce react\nbob++\n@popple karma bob\n")
+	var out bytes.Buffer
+
+	s, cleanup := repl.NewSession(in, &out)
+	defer cleanup()
+
+	db, dbCleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbCleanup()
+
+	b := bot.New(s, db, command.NewRouter("@"+s.Username()), nil)
+	if err := b.Listen(ctx); !errors.Is(err, bot.ErrSessionClosed) {
+		t.Fatalf("want err=%v, got err=%v", bot.ErrSessionClosed, err)
+	}
+
+	want := []string{
+		"#general <popple> bob has 1 karma.",
+		"#general   popple reacted ✅ to alice's message",
+		"#general   popple reacted ▲ to alice's message",
+		"#general <popple> bob has 2 karma.",
+	}
+	lines := strings.Split(out.String(), "\n")
+	var got []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			got = append(got, line)
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want output\n%s\ngot\n%s", strings.Join(want, "\n"), out.String())
+	}
+}
@@ -0,0 +1,485 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/env"
+	"github.com/connorkuehl/popple/internal/logging"
+)
+
+// BotToken is the xoxb- token the Web API is called with.
+type BotToken string
+
+// AppToken is the xapp- token Socket Mode connections are opened with.
+type AppToken string
+
+// APIURL is the base URL of the Slack Web API.
+type APIURL string
+
+const DefaultAPIURL APIURL = "https://slack.com/api/"
+
+func BotTokenFromEnv(lookup env.Lookup) (BotToken, error) {
+	return botTokenFromEnv(lookup)
+}
+
+func AppTokenFromEnv(lookup env.Lookup) (AppToken, error) {
+	return appTokenFromEnv(lookup)
+}
+
+func APIURLFromEnv(lookup env.Lookup) APIURL {
+	return apiURLFromEnv(lookup)
+}
+
+type Dialer struct {
+	botToken BotToken
+	appToken AppToken
+	apiURL   APIURL
+	client   *http.Client
+}
+
+func NewDialer(botToken BotToken, appToken AppToken, apiURL APIURL) *Dialer {
+	return &Dialer{
+		botToken: botToken,
+		appToken: appToken,
+		apiURL:   apiURL,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Dial authenticates the bot and returns a session that has not started
+// receiving events yet.
+func (d *Dialer) Dial(ctx context.Context) (*Session, error) {
+	var auth struct {
+		response
+		UserID string `json:"user_id"`
+		User   string `json:"user"`
+		TeamID string `json:"team_id"`
+	}
+	if err := d.call(ctx, string(d.botToken), "auth.test", nil, &auth); err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		d:         d,
+		botUserID: auth.UserID,
+		botName:   auth.User,
+		teamID:    auth.TeamID,
+	}, nil
+}
+
+// response is the envelope every Web API method responds with.
+type response struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+func (r response) err() error {
+	if r.OK {
+		return nil
+	}
+	return fmt.Errorf("slack: %s", r.Error)
+}
+
+// call invokes a Web API method with form-encoded arguments and decodes the
+// response into rsp, which must embed response.
+func (d *Dialer) call(ctx context.Context, token string, method string, args url.Values, rsp interface{ err() error }) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, string(d.apiURL)+method, strings.NewReader(args.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack: %s: %s", method, res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(rsp); err != nil {
+		return err
+	}
+	return rsp.err()
+}
+
+type Session struct {
+	chat.ConnState
+
+	d         *Dialer
+	botUserID string
+	botName   string
+	teamID    string
+	log       *logging.Logger
+	messages  chan chat.Message
+	// users caches user IDs to the names mentions are replaced with.
+	users sync.Map
+}
+
+// NewSession returns a session that logs with logger, or with the standard
+// logger if logger is nil.
+func NewSession(dialer *Dialer, logger *logging.Logger) (*Session, func(), error) {
+	if logger == nil {
+		logger = logging.Standard()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s, err := dialer.Dial(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	s.log = logger
+	s.messages = make(chan chat.Message)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.receive(ctx)
+	}()
+
+	return s, func() {
+		cancel()
+		<-done
+	}, nil
+}
+
+// receive keeps a Socket Mode connection open until ctx is canceled,
+// reconnecting whenever Slack asks to or the connection drops.
+func (s *Session) receive(ctx context.Context) {
+	backoff := time.Second
+	for {
+		err := s.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.log.WithError(err).WithField("backoff", backoff).Warn("slack connection lost")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+	}
+}
+
+// envelope is a Socket Mode message.
+type envelope struct {
+	Type       string          `json:"type"`
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+type eventCallback struct {
+	TeamID string `json:"team_id"`
+	Event  struct {
+		Type        string `json:"type"`
+		Subtype     string `json:"subtype"`
+		Channel     string `json:"channel"`
+		ChannelType string `json:"channel_type"`
+		User        string `json:"user"`
+		BotID       string `json:"bot_id"`
+		Text        string `json:"text"`
+		TS          string `json:"ts"`
+	} `json:"event"`
+}
+
+// keepalive is how often the connection is pinged.
+const keepalive = time.Minute
+
+// connect opens one Socket Mode connection and delivers its messages until
+// Slack disconnects it. A nil error means the disconnect was requested.
+func (s *Session) connect(ctx context.Context) error {
+	var open struct {
+		response
+		URL string `json:"url"`
+	}
+	if err := s.d.call(ctx, string(s.d.appToken), "apps.connections.open", nil, &open); err != nil {
+		return err
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, open.URL, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s.SetConnected(true)
+	defer s.SetConnected(false)
+
+	conn.SetPongHandler(func(string) error {
+		s.Heartbeat()
+		return nil
+	})
+
+	// Unblock the read below when we're asked to stop, and ping Slack in
+	// the meantime so that a dead connection is noticed even when nobody
+	// is talking.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(keepalive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				_ = conn.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(keepalive))
+			}
+		}
+	}()
+
+	for {
+		var env envelope
+		if err := conn.ReadJSON(&env); err != nil {
+			return err
+		}
+		s.Heartbeat()
+
+		if len(env.EnvelopeID) > 0 {
+			ack := struct {
+				EnvelopeID string `json:"envelope_id"`
+			}{env.EnvelopeID}
+			if err := conn.WriteJSON(ack); err != nil {
+				return err
+			}
+		}
+
+		switch env.Type {
+		case "disconnect":
+			return nil
+		case "events_api":
+			var cb eventCallback
+			if err := json.Unmarshal(env.Payload, &cb); err != nil {
+				s.log.WithError(err).Warn("malformed slack event")
+				continue
+			}
+			s.handle(ctx, cb)
+		}
+	}
+}
+
+func (s *Session) handle(ctx context.Context, cb eventCallback) {
+	ev := cb.Event
+	if ev.Type != "message" {
+		return
+	}
+
+	// Ignore edits, joins and the like, as well as messages from bots,
+	// including ourselves.
+	if len(ev.Subtype) > 0 || len(ev.BotID) > 0 || ev.User == s.botUserID {
+		return
+	}
+
+	// No DMs.
+	if ev.ChannelType == "im" {
+		return
+	}
+
+	// Workspaces play the part of Discord's servers.
+	teamID := cb.TeamID
+	if len(teamID) == 0 {
+		teamID = s.teamID
+	}
+
+	msg := chat.Message{
+		ID:        ev.TS,
+		GuildID:   teamID,
+		ChannelID: ev.Channel,
+		Content:   s.replaceMentions(ctx, ev.Text),
+	}
+
+	select {
+	case s.messages <- msg:
+	case <-ctx.Done():
+	}
+}
+
+var (
+	userMention    = regexp.MustCompile(`<@([A-Z0-9]+)(?:\|([^>]*))?>`)
+	channelMention = regexp.MustCompile(`<#([A-Z0-9]+)(?:\|[^>]*)?>`)
+)
+
+// replaceMentions turns Slack's <@U123> user mentions into @name, the same
+// way Discord mentions are presented to the bot, and strips the names from
+// channel mentions so that they look like <#C123>.
+func (s *Session) replaceMentions(ctx context.Context, text string) string {
+	text = userMention.ReplaceAllStringFunc(text, func(m string) string {
+		groups := userMention.FindStringSubmatch(m)
+		id, label := groups[1], groups[2]
+		if len(label) > 0 {
+			return "@" + label
+		}
+		return "@" + s.userName(ctx, id)
+	})
+	text = channelMention.ReplaceAllString(text, "<#$1>")
+	return html.UnescapeString(text)
+}
+
+func (s *Session) userName(ctx context.Context, userID string) string {
+	if userID == s.botUserID {
+		return s.botName
+	}
+	if name, ok := s.users.Load(userID); ok {
+		return name.(string)
+	}
+
+	var info struct {
+		response
+		User struct {
+			Name    string `json:"name"`
+			Profile struct {
+				DisplayName string `json:"display_name"`
+			} `json:"profile"`
+		} `json:"user"`
+	}
+	if err := s.d.call(ctx, string(s.d.botToken), "users.info", url.Values{"user": {userID}}, &info); err != nil {
+		s.log.WithError(err).WithField("user_id", userID).Warn("look up slack user")
+		return userID
+	}
+
+	name := info.User.Profile.DisplayName
+	if len(name) == 0 {
+		name = info.User.Name
+	}
+	s.users.Store(userID, name)
+	return name
+}
+
+func (s *Session) SendMessageToChannel(ctx context.Context, channelID string, msg string) error {
+	args := url.Values{
+		"channel": {channelID},
+		"text":    {msg},
+	}
+	var rsp response
+	return s.d.call(ctx, string(s.d.botToken), "chat.postMessage", args, &rsp)
+}
+
+// ReplyToMessage replies in the message's thread.
+func (s *Session) ReplyToMessage(ctx context.Context, channelID, messageID string, msg string) error {
+	args := url.Values{
+		"channel":   {channelID},
+		"text":      {msg},
+		"thread_ts": {messageID},
+	}
+	var rsp response
+	return s.d.call(ctx, string(s.d.botToken), "chat.postMessage", args, &rsp)
+}
+
+// SendEmbedToChannel posts the embed as text since Popple doesn't build
+// Block Kit layouts.
+func (s *Session) SendEmbedToChannel(ctx context.Context, channelID string, embed chat.Embed) error {
+	var b strings.Builder
+	if len(embed.Title) > 0 {
+		b.WriteString("*" + embed.Title + "*\n")
+	}
+	for _, row := range embed.Rows() {
+		b.WriteString(strings.Join(row, " ") + "\n")
+	}
+	if len(embed.Footer) > 0 {
+		b.WriteString("_" + embed.Footer + "_")
+	}
+
+	return s.SendMessageToChannel(ctx, channelID, strings.TrimSpace(b.String()))
+}
+
+// emojiNames maps the emoji the bot reacts with to Slack's names for them.
+var emojiNames = map[string]string{
+	"✅": "white_check_mark",
+	"▲": "arrow_up_small",
+	"▼": "arrow_down_small",
+}
+
+var ErrUnknownEmoji = errors.New("no slack name for emoji")
+
+func (s *Session) ReactToMessageWithEmoji(ctx context.Context, channelID, messageID, emojiID string) error {
+	name, ok := emojiNames[emojiID]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownEmoji, emojiID)
+	}
+
+	args := url.Values{
+		"channel":   {channelID},
+		"timestamp": {messageID},
+		"name":      {name},
+	}
+	var rsp response
+	return s.d.call(ctx, string(s.d.botToken), "reactions.add", args, &rsp)
+}
+
+// SendFileToAdmin isn't supported yet.
+func (s *Session) SendFileToAdmin(ctx context.Context, channelID, messageID string, file chat.File) error {
+	return chat.ErrUnsupported
+}
+
+// IsAdmin reports whether the author of the message is an admin or owner of
+// the workspace.
+func (s *Session) IsAdmin(ctx context.Context, channelID, messageID string) (bool, error) {
+	var history struct {
+		response
+		Messages []struct {
+			User string `json:"user"`
+			TS   string `json:"ts"`
+		} `json:"messages"`
+	}
+	args := url.Values{
+		"channel":   {channelID},
+		"latest":    {messageID},
+		"inclusive": {"true"},
+		"limit":     {"1"},
+	}
+	if err := s.d.call(ctx, string(s.d.botToken), "conversations.history", args, &history); err != nil {
+		return false, err
+	}
+	if len(history.Messages) == 0 || history.Messages[0].TS != messageID {
+		return false, nil
+	}
+
+	var info struct {
+		response
+		User struct {
+			IsAdmin bool `json:"is_admin"`
+			IsOwner bool `json:"is_owner"`
+		} `json:"user"`
+	}
+	if err := s.d.call(ctx, string(s.d.botToken), "users.info", url.Values{"user": {history.Messages[0].User}}, &info); err != nil {
+		return false, err
+	}
+	return info.User.IsAdmin || info.User.IsOwner, nil
+}
+
+func (s *Session) Username() string {
+	return s.botName
+}
+
+func (s *Session) Messages() <-chan chat.Message {
+	return s.messages
+}
@@ -0,0 +1,33 @@
+package slack
+
+import (
+	"errors"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+func botTokenFromEnv(f func(key string) (val string)) (BotToken, error) {
+	token, err := env.Get("POPPLE_SLACK_BOT_TOKEN", f)
+	if err != nil {
+		return "", err
+	}
+
+	return BotToken(token), nil
+}
+
+func appTokenFromEnv(f func(key string) (val string)) (AppToken, error) {
+	token, err := env.Get("POPPLE_SLACK_APP_TOKEN", f)
+	if err != nil {
+		return "", err
+	}
+
+	return AppToken(token), nil
+}
+
+func apiURLFromEnv(f func(key string) (val string)) APIURL {
+	u, err := env.Get("POPPLE_SLACK_API_URL", f)
+	if errors.Is(err, env.ErrKeyNotFound) {
+		return DefaultAPIURL
+	}
+	return APIURL(u)
+}
@@ -0,0 +1,192 @@
+package slack_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/command"
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/slack"
+	"github.com/connorkuehl/popple/internal/slack/slacktest"
+)
+
+func newSession(t *testing.T) (*slacktest.Server, *slack.Session) {
+	t.Helper()
+
+	srv := slacktest.NewServer()
+	t.Cleanup(srv.Close)
+
+	dialer := slack.NewDialer(slacktest.BotToken, slacktest.AppToken, slack.APIURL(srv.APIURL()))
+	s, cleanup, err := slack.NewSession(dialer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	return srv, s
+}
+
+func receive(t *testing.T, s *slack.Session) chat.Message {
+	t.Helper()
+
+	select {
+	case msg := <-s.Messages():
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return chat.Message{}
+	}
+}
+
+func TestMessages(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv, s := newSession(t)
+	srv.Users["U0001"] = "alice"
+
+	if got := s.Username(); got != slacktest.BotName {
+		t.Errorf("want username %q, got %q", slacktest.BotName, got)
+	}
+
+	// Messages from the bot itself are dropped, so the first message
+	// received is the one that follows it.
+	go func() {
+		_ = srv.SendMessage(ctx, "C0001", slacktest.BotUserID, "talking to myself++", "1.0")
+		_ = srv.SendMessage(ctx, "C0001", "U0002", "<@U0BOT> karma <@U0001> <@U0003|bob> &lt;3 <#C0002|random>", "2.0")
+	}()
+
+	want := chat.Message{
+		ID:        "2.0",
+		GuildID:   slacktest.TeamID,
+		ChannelID: "C0001",
+		Content:   "@popple karma @alice @bob <3 <#C0002>",
+	}
+	if got := receive(t, s); got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv, s := newSession(t)
+
+	go func() {
+		_ = srv.Disconnect(ctx)
+		_ = srv.SendMessage(ctx, "C0001", "U0001", "still here++", "1.0")
+	}()
+
+	if got := receive(t, s); got.Content != "still here++" {
+		t.Errorf("want message after reconnecting, got %+v", got)
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv, s := newSession(t)
+	srv.Users["U0001"] = "alice"
+	srv.Users["U0002"] = "bob"
+	srv.Admins["U0001"] = true
+
+	go func() {
+		_ = srv.SendMessage(ctx, "C0001", "U0001", "hello", "1.0")
+		_ = srv.SendMessage(ctx, "C0001", "U0002", "hello", "2.0")
+	}()
+	receive(t, s)
+	receive(t, s)
+
+	for _, tt := range []struct {
+		messageID string
+		want      bool
+	}{
+		{"1.0", true},
+		{"2.0", false},
+		{"3.0", false},
+	} {
+		got, err := s.IsAdmin(ctx, "C0001", tt.messageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("IsAdmin(%q): want %v, got %v", tt.messageID, tt.want, got)
+		}
+	}
+}
+
+func TestResponses(t *testing.T) {
+	srv, s := newSession(t)
+	ctx := context.Background()
+
+	if err := s.SendMessageToChannel(ctx, "C0001", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReplyToMessage(ctx, "C0001", "1.0", "in a thread"); err != nil {
+		t.Fatal(err)
+	}
+	embed := chat.Embed{
+		Title:  "Karma",
+		Fields: []chat.EmbedField{{Name: "Subject", Values: []string{"a", "b"}}, {Name: "Karma", Values: []string{"1", "2"}}},
+		Footer: "2 subjects",
+	}
+	if err := s.SendEmbedToChannel(ctx, "C0001", embed); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReactToMessageWithEmoji(ctx, "C0001", "1.0", "✅"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReactToMessageWithEmoji(ctx, "C0001", "1.0", "🥔"); err == nil {
+		t.Error("want error reacting with an emoji slack has no name for")
+	}
+
+	wantPosts := []slacktest.Post{
+		{Channel: "C0001", Text: "hello"},
+		{Channel: "C0001", Text: "in a thread", ThreadTS: "1.0"},
+		{Channel: "C0001", Text: "*Karma*\na 1\nb 2\n_2 subjects_"},
+	}
+	if got := srv.Posts(); !reflect.DeepEqual(got, wantPosts) {
+		t.Errorf("want posts %+v, got %+v", wantPosts, got)
+	}
+
+	wantReactions := []slacktest.Reaction{{Channel: "C0001", Timestamp: "1.0", Name: "white_check_mark"}}
+	if got := srv.Reactions(); !reflect.DeepEqual(got, wantReactions) {
+		t.Errorf("want reactions %+v, got %+v", wantReactions, got)
+	}
+}
+
+func TestBot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv, s := newSession(t)
+	srv.Users["U0001"] = "alice"
+
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	b := bot.New(s, db, command.NewRouter("@"+s.Username()), nil)
+	go func() { _ = b.Listen(ctx) }()
+
+	if err := srv.SendMessage(ctx, "C0001", "U0002", "<@U0001>++", "1.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []slacktest.Post{{Channel: "C0001", Text: "alice has 1 karma."}}
+	for ctx.Err() == nil {
+		if got := srv.Posts(); reflect.DeepEqual(got, want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("want posts %+v, got %+v", want, srv.Posts())
+}
@@ -0,0 +1,295 @@
+// Package slacktest provides a stand-in for the parts of the Slack Web API
+// and Socket Mode that Popple uses.
+package slacktest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	BotToken  = "xoxb-slacktest"
+	AppToken  = "xapp-slacktest"
+	TeamID    = "T0001"
+	BotUserID = "U0BOT"
+	BotName   = "popple"
+)
+
+type Post struct {
+	Channel  string
+	Text     string
+	ThreadTS string
+}
+
+type Reaction struct {
+	Channel   string
+	Timestamp string
+	Name      string
+}
+
+type Server struct {
+	srv *httptest.Server
+	// Users maps user IDs to the names users.info reports for them.
+	Users map[string]string
+	// Admins holds the IDs of users users.info reports as workspace admins.
+	Admins map[string]bool
+
+	mu        sync.Mutex
+	conn      *websocket.Conn
+	ready     chan struct{}
+	acks      chan string
+	envelopes int
+	posts     []Post
+	reactions []Reaction
+	// authors maps channel and timestamp to the user who sent each message.
+	authors map[[2]string]string
+}
+
+func NewServer() *Server {
+	s := &Server{
+		Users:   make(map[string]string),
+		Admins:  make(map[string]bool),
+		authors: make(map[[2]string]string),
+		ready:   make(chan struct{}),
+		acks:    make(chan string, 16),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/auth.test", s.withToken(BotToken, s.authTest))
+	mux.HandleFunc("/api/apps.connections.open", s.withToken(AppToken, s.connectionsOpen))
+	mux.HandleFunc("/api/users.info", s.withToken(BotToken, s.usersInfo))
+	mux.HandleFunc("/api/conversations.history", s.withToken(BotToken, s.conversationsHistory))
+	mux.HandleFunc("/api/chat.postMessage", s.withToken(BotToken, s.postMessage))
+	mux.HandleFunc("/api/reactions.add", s.withToken(BotToken, s.reactionsAdd))
+	mux.HandleFunc("/socket", s.socket)
+
+	s.srv = httptest.NewServer(mux)
+	return s
+}
+
+// APIURL is the base URL to point the Slack dialer at.
+func (s *Server) APIURL() string {
+	return s.srv.URL + "/api/"
+}
+
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.conn != nil {
+		_ = s.conn.Close()
+	}
+	s.mu.Unlock()
+	s.srv.Close()
+}
+
+// Posts returns every message posted so far.
+func (s *Server) Posts() []Post {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Post(nil), s.posts...)
+}
+
+// Reactions returns every reaction added so far.
+func (s *Server) Reactions() []Reaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Reaction(nil), s.reactions...)
+}
+
+// SendMessage delivers a message event over the Socket Mode connection and
+// waits for the client to acknowledge it.
+func (s *Server) SendMessage(ctx context.Context, channel, user, text, ts string) error {
+	s.mu.Lock()
+	s.authors[[2]string{channel, ts}] = user
+	s.mu.Unlock()
+
+	event := map[string]any{
+		"team_id": TeamID,
+		"event": map[string]any{
+			"type":         "message",
+			"channel":      channel,
+			"channel_type": "channel",
+			"user":         user,
+			"text":         text,
+			"ts":           ts,
+		},
+	}
+	return s.SendEnvelope(ctx, "events_api", event)
+}
+
+// SendEnvelope delivers an arbitrary Socket Mode envelope and waits for the
+// client to acknowledge it.
+func (s *Server) SendEnvelope(ctx context.Context, typ string, payload any) error {
+	conn, err := s.waitConn(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.envelopes++
+	id := "env-" + strconv.Itoa(s.envelopes)
+	s.mu.Unlock()
+
+	env := map[string]any{
+		"type":        typ,
+		"envelope_id": id,
+		"payload":     payload,
+	}
+	if err := conn.WriteJSON(env); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case ack := <-s.acks:
+			if ack == id {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Disconnect asks the client to reconnect, the way Slack periodically does.
+func (s *Server) Disconnect(ctx context.Context) error {
+	conn, err := s.waitConn(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.conn = nil
+	s.ready = make(chan struct{})
+	s.mu.Unlock()
+
+	return conn.WriteJSON(map[string]any{"type": "disconnect", "reason": "refresh_requested"})
+}
+
+func (s *Server) waitConn(ctx context.Context) (*websocket.Conn, error) {
+	for {
+		s.mu.Lock()
+		conn, ready := s.conn, s.ready
+		s.mu.Unlock()
+
+		if conn != nil {
+			return conn, nil
+		}
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *Server) withToken(token string, h func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			reply(w, map[string]any{"ok": false, "error": "invalid_auth"})
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) authTest(w http.ResponseWriter, r *http.Request) {
+	reply(w, map[string]any{"ok": true, "user_id": BotUserID, "user": BotName, "team_id": TeamID})
+}
+
+func (s *Server) connectionsOpen(w http.ResponseWriter, r *http.Request) {
+	u := "ws" + strings.TrimPrefix(s.srv.URL, "http") + "/socket"
+	reply(w, map[string]any{"ok": true, "url": u})
+}
+
+func (s *Server) usersInfo(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.Users[r.Form.Get("user")]
+	if !ok {
+		reply(w, map[string]any{"ok": false, "error": "user_not_found"})
+		return
+	}
+	reply(w, map[string]any{"ok": true, "user": map[string]any{
+		"name":     name,
+		"is_admin": s.Admins[r.Form.Get("user")],
+		"profile":  map[string]any{"display_name": name},
+	}})
+}
+
+// conversationsHistory only answers for a single message, the way the client
+// looks up who sent one.
+func (s *Server) conversationsHistory(w http.ResponseWriter, r *http.Request) {
+	channel, ts := r.Form.Get("channel"), r.Form.Get("latest")
+	s.mu.Lock()
+	user, ok := s.authors[[2]string{channel, ts}]
+	s.mu.Unlock()
+	if !ok {
+		reply(w, map[string]any{"ok": true, "messages": []any{}})
+		return
+	}
+	reply(w, map[string]any{"ok": true, "messages": []any{map[string]any{"user": user, "ts": ts}}})
+}
+
+func (s *Server) postMessage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.posts = append(s.posts, Post{Channel: r.Form.Get("channel"), Text: r.Form.Get("text"), ThreadTS: r.Form.Get("thread_ts")})
+	s.mu.Unlock()
+	reply(w, map[string]any{"ok": true})
+}
+
+func (s *Server) reactionsAdd(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.reactions = append(s.reactions, Reaction{Channel: r.Form.Get("channel"), Timestamp: r.Form.Get("timestamp"), Name: r.Form.Get("name")})
+	s.mu.Unlock()
+	reply(w, map[string]any{"ok": true})
+}
+
+func (s *Server) socket(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if err := conn.WriteJSON(map[string]any{"type": "hello"}); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.conn = conn
+	select {
+	case <-s.ready:
+	default:
+		close(s.ready)
+	}
+	s.mu.Unlock()
+
+	for {
+		var ack struct {
+			EnvelopeID string `json:"envelope_id"`
+		}
+		if err := conn.ReadJSON(&ack); err != nil {
+			return
+		}
+		s.acks <- ack.EnvelopeID
+	}
+}
+
+func reply(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		panic(fmt.Sprintf("slacktest: encode reply: %v", err))
+	}
+}
@@ -0,0 +1,181 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/popple"
+)
+
+// DB records a span for every query the bot makes.
+func (t *Tracing) DB(db bot.DB) bot.DB {
+	return &tracedDB{t: t, db: db}
+}
+
+type tracedDB struct {
+	t  *Tracing
+	db bot.DB
+}
+
+func (d *tracedDB) start(ctx context.Context, method, serverID string) (context.Context, trace.Span) {
+	return d.t.tracer.Start(ctx, "db."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "sqlite"),
+			attribute.String("popple.guild_id", serverID),
+		))
+}
+
+func (d *tracedDB) Config(ctx context.Context, serverID string) (popple.ServerConfig, error) {
+	ctx, span := d.start(ctx, "Config", serverID)
+	val, err := d.db.Config(ctx, serverID)
+	end(span, err)
+	return val, err
+}
+
+func (d *tracedDB) PutConfig(ctx context.Context, config popple.ServerConfig) error {
+	ctx, span := d.start(ctx, "PutConfig", config.ServerID)
+	err := d.db.PutConfig(ctx, config)
+	end(span, err)
+	return err
+}
+
+func (d *tracedDB) Entities(ctx context.Context, serverID string, names ...string) ([]popple.Entity, error) {
+	ctx, span := d.start(ctx, "Entities", serverID)
+	span.SetAttributes(attribute.Int("popple.subjects", len(names)))
+	val, err := d.db.Entities(ctx, serverID, names...)
+	end(span, err)
+	return val, err
+}
+
+func (d *tracedDB) Leaderboard(ctx context.Context, serverID string, limit uint) (popple.Board, error) {
+	ctx, span := d.start(ctx, "Leaderboard", serverID)
+	val, err := d.db.Leaderboard(ctx, serverID, limit)
+	end(span, err)
+	return val, err
+}
+
+func (d *tracedDB) Loserboard(ctx context.Context, serverID string, limit uint) (popple.Board, error) {
+	ctx, span := d.start(ctx, "Loserboard", serverID)
+	val, err := d.db.Loserboard(ctx, serverID, limit)
+	end(span, err)
+	return val, err
+}
+
+func (d *tracedDB) ChannelEntities(ctx context.Context, serverID, channelID string, names ...string) ([]popple.Entity, error) {
+	ctx, span := d.start(ctx, "ChannelEntities", serverID)
+	span.SetAttributes(attribute.Int("popple.subjects", len(names)))
+	val, err := d.db.ChannelEntities(ctx, serverID, channelID, names...)
+	end(span, err)
+	return val, err
+}
+
+func (d *tracedDB) ChannelLeaderboard(ctx context.Context, serverID, channelID string, limit uint) (popple.Board, error) {
+	ctx, span := d.start(ctx, "ChannelLeaderboard", serverID)
+	val, err := d.db.ChannelLeaderboard(ctx, serverID, channelID, limit)
+	end(span, err)
+	return val, err
+}
+
+func (d *tracedDB) ChannelLoserboard(ctx context.Context, serverID, channelID string, limit uint) (popple.Board, error) {
+	ctx, span := d.start(ctx, "ChannelLoserboard", serverID)
+	val, err := d.db.ChannelLoserboard(ctx, serverID, channelID, limit)
+	end(span, err)
+	return val, err
+}
+
+func (d *tracedDB) ApplyKarma(ctx context.Context, serverID string, event popple.KarmaEvent) (popple.Increments, error) {
+	ctx, span := d.start(ctx, "ApplyKarma", serverID)
+	val, err := d.db.ApplyKarma(ctx, serverID, event)
+	end(span, err)
+	return val, err
+}
+
+func (d *tracedDB) ServerEntities(ctx context.Context, serverID string) ([]database.EntityRecord, error) {
+	ctx, span := d.start(ctx, "ServerEntities", serverID)
+	val, err := d.db.ServerEntities(ctx, serverID)
+	end(span, err)
+	return val, err
+}
+
+func (d *tracedDB) ServerKarmaEvents(ctx context.Context, serverID string) ([]database.EventRecord, error) {
+	ctx, span := d.start(ctx, "ServerKarmaEvents", serverID)
+	val, err := d.db.ServerKarmaEvents(ctx, serverID)
+	end(span, err)
+	return val, err
+}
+
+// Session records a span for every response the bot sends. Receiving
+// messages isn't traced here: the bot starts a span for each message it
+// handles.
+func (t *Tracing) Session(s bot.Session) bot.Session {
+	return &tracedSession{Session: s, t: t}
+}
+
+type tracedSession struct {
+	bot.Session
+	t *Tracing
+}
+
+func (s *tracedSession) start(ctx context.Context, method, channelID string) (context.Context, trace.Span) {
+	return s.t.tracer.Start(ctx, "session."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("popple.channel_id", channelID)))
+}
+
+func (s *tracedSession) SendMessageToChannel(ctx context.Context, channelID string, msg string) error {
+	ctx, span := s.start(ctx, "SendMessageToChannel", channelID)
+	err := s.Session.SendMessageToChannel(ctx, channelID, msg)
+	end(span, err)
+	return err
+}
+
+func (s *tracedSession) ReplyToMessage(ctx context.Context, channelID, messageID string, msg string) error {
+	ctx, span := s.start(ctx, "ReplyToMessage", channelID)
+	err := s.Session.ReplyToMessage(ctx, channelID, messageID, msg)
+	end(span, err)
+	return err
+}
+
+func (s *tracedSession) SendEmbedToChannel(ctx context.Context, channelID string, embed chat.Embed) error {
+	ctx, span := s.start(ctx, "SendEmbedToChannel", channelID)
+	err := s.Session.SendEmbedToChannel(ctx, channelID, embed)
+	end(span, err)
+	return err
+}
+
+func (s *tracedSession) ReactToMessageWithEmoji(ctx context.Context, channelID, messageID, emojiID string) error {
+	ctx, span := s.start(ctx, "ReactToMessageWithEmoji", channelID)
+	err := s.Session.ReactToMessageWithEmoji(ctx, channelID, messageID, emojiID)
+	end(span, err)
+	return err
+}
+
+func (s *tracedSession) IsAdmin(ctx context.Context, channelID, messageID string) (bool, error) {
+	ctx, span := s.start(ctx, "IsAdmin", channelID)
+	val, err := s.Session.IsAdmin(ctx, channelID, messageID)
+	if errors.Is(err, chat.ErrUnsupported) {
+		span.End()
+		return val, err
+	}
+	end(span, err)
+	return val, err
+}
+
+// SendFileToAdmin doesn't mark refusals as failures.
+func (s *tracedSession) SendFileToAdmin(ctx context.Context, channelID, messageID string, file chat.File) error {
+	ctx, span := s.start(ctx, "SendFileToAdmin", channelID)
+	err := s.Session.SendFileToAdmin(ctx, channelID, messageID, file)
+	if errors.Is(err, chat.ErrNotAdmin) || errors.Is(err, chat.ErrUnsupported) {
+		span.End()
+		return err
+	}
+	end(span, err)
+	return err
+}
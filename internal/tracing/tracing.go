@@ -0,0 +1,95 @@
+// Package tracing records OpenTelemetry spans for the work the bot does and
+// exports them over OTLP. Like metrics, the bot's dependencies are wrapped
+// in decorators that record a span for each call through them.
+package tracing
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+// InstrumentationName is the name spans are recorded under.
+const InstrumentationName = "github.com/connorkuehl/popple"
+
+// Config configures tracing. It's disabled unless Endpoint is set.
+type Config struct {
+	// Endpoint is the OTLP/HTTP collector to export spans to, e.g.,
+	// "http://localhost:4318".
+	Endpoint string
+}
+
+func ConfigFromEnv(lookup env.Lookup) (Config, error) {
+	return configFromEnv(lookup)
+}
+
+// Tracing records spans with the global tracer provider. When tracing is
+// enabled, New points the global tracer provider at the collector.
+type Tracing struct {
+	tracer trace.Tracer
+}
+
+// New starts exporting spans if config enables tracing. The returned
+// function exports the spans that haven't been yet and stops.
+func New(config Config) (*Tracing, func(), error) {
+	t := &Tracing{tracer: otel.Tracer(InstrumentationName)}
+	if len(config.Endpoint) == 0 {
+		return t, func() {}, nil
+	}
+
+	exporter, err := newExporter(config.Endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "popple"))),
+	)
+	otel.SetTracerProvider(provider)
+
+	cleanup := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = provider.Shutdown(ctx)
+	}
+	return t, cleanup, nil
+}
+
+// newExporter returns an exporter that posts spans to the collector at
+// endpoint, keeping any path the endpoint has in front of /v1/traces.
+func newExporter(endpoint string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + "/v1/traces"),
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	return otlptracehttp.New(context.Background(), opts...)
+}
+
+// end ends span, marking it failed if err isn't nil.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
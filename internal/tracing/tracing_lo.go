@@ -0,0 +1,25 @@
+package tracing
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+func configFromEnv(f func(key string) (val string)) (Config, error) {
+	endpoint, err := env.Get("POPPLE_TRACING_ENDPOINT", f)
+	if errors.Is(err, env.ErrKeyNotFound) {
+		return Config{}, nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return Config{}, fmt.Errorf("POPPLE_TRACING_ENDPOINT must be an http or https URL, e.g., http://localhost:4318: %q", endpoint)
+	}
+	return Config{Endpoint: endpoint}, nil
+}
@@ -0,0 +1,220 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/chat"
+	"github.com/connorkuehl/popple/internal/command"
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/discord/discordtest"
+	"github.com/connorkuehl/popple/internal/tracing"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    tracing.Config
+		wantErr bool
+	}{
+		{name: "disabled"},
+		{
+			name: "enabled",
+			env:  map[string]string{"POPPLE_TRACING_ENDPOINT": "http://localhost:4318"},
+			want: tracing.Config{Endpoint: "http://localhost:4318"},
+		},
+		{
+			name:    "not a URL",
+			env:     map[string]string{"POPPLE_TRACING_ENDPOINT": "localhost:4318"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tracing.ConfigFromEnv(func(key string) string { return tt.env[key] })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+// useExporter records spans in exporter for the rest of the test.
+func useExporter(t *testing.T, exporter sdktrace.SpanExporter) {
+	previous := otel.GetTracerProvider()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previous)
+	})
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	useExporter(t, exporter)
+
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	tr, done, err := tracing.New(tracing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	session := discordtest.NewResponseRecorder([]chat.Message{
+		{ID: "1", GuildID: "1", ChannelID: "2", Content: "link++ zelda++"},
+	})
+	b := bot.New(tr.Session(session), tr.DB(db), command.NewRouter("popple"), nil)
+	if err := b.Listen(context.Background()); !errors.Is(err, bot.ErrSessionClosed) {
+		t.Fatalf("want %v, got %v", bot.ErrSessionClosed, err)
+	}
+
+	spans := exporter.GetSpans()
+	byName := make(map[string]tracetest.SpanStub)
+	for _, s := range spans {
+		byName[s.Name] = s
+	}
+
+	handle, ok := byName["handle message"]
+	if !ok {
+		t.Fatalf("want a span for the message, got %v", spanNames(spans))
+	}
+	want := map[attribute.Key]attribute.Value{
+		"popple.guild_id": attribute.StringValue("1"),
+		"popple.command":  attribute.StringValue("ChangeKarma"),
+		"popple.subjects": attribute.IntValue(2),
+	}
+	got := make(map[attribute.Key]attribute.Value)
+	for _, kv := range handle.Attributes {
+		got[kv.Key] = kv.Value
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("want %s=%v, got %v", k, v.Emit(), got[k].Emit())
+		}
+	}
+
+	for _, name := range []string{"route", "db.Config", "db.ApplyKarma", "session.SendMessageToChannel"} {
+		s, ok := byName[name]
+		if !ok {
+			t.Errorf("want a %s span, got %v", name, spanNames(spans))
+			continue
+		}
+		if s.Parent.SpanID() != handle.SpanContext.SpanID() {
+			t.Errorf("want %s to be part of the message's span", name)
+		}
+	}
+
+	for _, s := range spans {
+		for _, kv := range s.Attributes {
+			if strings.Contains(kv.Value.Emit(), "++") {
+				t.Errorf("%s: want no message content, got %s=%q", s.Name, kv.Key, kv.Value.Emit())
+			}
+		}
+	}
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	var names []string
+	for _, s := range spans {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestExport(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []*coltracepb.ExportTraceServiceRequest
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("want protobuf posted to /v1/traces, got %s %s", r.Header.Get("Content-Type"), r.URL.Path)
+		}
+		b, _ := io.ReadAll(r.Body)
+		var req coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(b, &req); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		requests = append(requests, &req)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	_, done, err := tracing.New(tracing.Config{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "work")
+	span.SetAttributes(attribute.Int("popple.subjects", 3))
+	span.End()
+	done()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 || len(requests[0].ResourceSpans) != 1 {
+		t.Fatalf("want one export, got %d", len(requests))
+	}
+	rs := requests[0].ResourceSpans[0]
+	if got := attributes(rs.Resource.Attributes)["service.name"]; got != "popple" {
+		t.Errorf("want service.name popple, got %q", got)
+	}
+	if len(rs.ScopeSpans) != 1 || len(rs.ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("want one span, got %v", rs.ScopeSpans)
+	}
+	got := rs.ScopeSpans[0].Spans[0]
+	if got.Name != "work" {
+		t.Errorf("want span work, got %s", got.Name)
+	}
+	if want := span.SpanContext().TraceID(); !bytes.Equal(got.TraceId, want[:]) {
+		t.Errorf("want trace ID %s, got %x", want, got.TraceId)
+	}
+	if got := attributes(got.Attributes)["popple.subjects"]; got != "3" {
+		t.Errorf("want popple.subjects 3, got %q", got)
+	}
+}
+
+// attributes flattens kvs into their string representations.
+func attributes(kvs []*commonpb.KeyValue) map[string]string {
+	m := make(map[string]string)
+	for _, kv := range kvs {
+		switch v := kv.Value.Value.(type) {
+		case *commonpb.AnyValue_StringValue:
+			m[kv.Key] = v.StringValue
+		case *commonpb.AnyValue_IntValue:
+			m[kv.Key] = strconv.FormatInt(v.IntValue, 10)
+		}
+	}
+	return m
+}
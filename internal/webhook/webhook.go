@@ -0,0 +1,255 @@
+// Package webhook delivers karma events to the webhooks servers have
+// subscribed.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/logging"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Event is the only event sent to webhooks so far. Which changes a webhook
+// gets is up to its popple.WebhookEvent filter.
+const Event = "karma.changed"
+
+// Headers sent with every delivery.
+const (
+	EventHeader     = "X-Popple-Event"
+	DeliveryHeader  = "X-Popple-Delivery"
+	SignatureHeader = "X-Popple-Signature-256"
+)
+
+const (
+	// DefaultMaxAttempts is how many times a delivery is attempted before
+	// it's moved to the dead letters.
+	DefaultMaxAttempts = 8
+	// DefaultBackoff is how long a delivery waits after its first failed
+	// attempt. It doubles after every attempt after that.
+	DefaultBackoff = 10 * time.Second
+	// DefaultPollInterval is how often the queue is checked for
+	// deliveries.
+	DefaultPollInterval = time.Second
+
+	maxBackoff   = time.Hour
+	batchSize    = 50
+	timeout      = 10 * time.Second
+	maxErrorBody = 512
+)
+
+type DB interface {
+	DueWebhookDeliveries(ctx context.Context, limit uint) ([]database.WebhookDelivery, error)
+	CompleteWebhookDelivery(ctx context.Context, id int64) error
+	RetryWebhookDelivery(ctx context.Context, id int64, after time.Duration, reason string) error
+	DeadLetterWebhookDelivery(ctx context.Context, id int64, reason string) error
+}
+
+// Worker delivers the webhook deliveries queued in the database. The bot
+// only queues them, so slow or broken webhooks never hold up the bot.
+type Worker struct {
+	db     DB
+	client *http.Client
+	log    *logging.Logger
+
+	MaxAttempts  int
+	Backoff      time.Duration
+	PollInterval time.Duration
+}
+
+// NewWorker returns a worker that logs with logger, or with the standard
+// logger if logger is nil.
+func NewWorker(db DB, logger *logging.Logger) *Worker {
+	if logger == nil {
+		logger = logging.Standard()
+	}
+
+	return &Worker{
+		db:           db,
+		client:       &http.Client{Timeout: timeout},
+		log:          logger,
+		MaxAttempts:  DefaultMaxAttempts,
+		Backoff:      DefaultBackoff,
+		PollInterval: DefaultPollInterval,
+	}
+}
+
+// Run delivers queued deliveries until ctx is canceled.
+func (w *Worker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		w.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := w.db.DueWebhookDeliveries(ctx, batchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.log.WithError(err).Error("DueWebhookDeliveries")
+			}
+			return
+		}
+
+		for _, delivery := range deliveries {
+			w.deliver(ctx, delivery)
+		}
+
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+// deliver attempts the delivery. Everything logged for the attempt shares
+// a correlation ID.
+func (w *Worker) deliver(ctx context.Context, delivery database.WebhookDelivery) {
+	ctx = w.log.WithCorrelationID(ctx)
+	ll := logging.FromContext(ctx).WithFields(log.Fields{
+		"guild_id":    delivery.Webhook.ServerID,
+		"webhook_id":  delivery.Webhook.ID,
+		"delivery_id": delivery.ID,
+		"attempt":     delivery.Attempts + 1,
+	})
+
+	err := w.send(ctx, delivery)
+	if ctx.Err() != nil {
+		// Shutting down isn't the webhook's fault, so the attempt isn't
+		// counted.
+		return
+	}
+
+	if err == nil {
+		if err := w.db.CompleteWebhookDelivery(ctx, delivery.ID); err != nil {
+			ll.WithError(err).Error("CompleteWebhookDelivery")
+		}
+		return
+	}
+
+	if delivery.Attempts+1 >= w.MaxAttempts {
+		ll.WithError(err).Warn("giving up on webhook delivery")
+		if err := w.db.DeadLetterWebhookDelivery(ctx, delivery.ID, err.Error()); err != nil {
+			ll.WithError(err).Error("DeadLetterWebhookDelivery")
+		}
+		return
+	}
+
+	after := w.backoff(delivery.Attempts)
+	ll.WithError(err).WithField("backoff", after).Warn("webhook delivery failed")
+	if err := w.db.RetryWebhookDelivery(ctx, delivery.ID, after, err.Error()); err != nil {
+		ll.WithError(err).Error("RetryWebhookDelivery")
+	}
+}
+
+// backoff is how long to wait after the given number of earlier failed
+// attempts.
+func (w *Worker) backoff(attempts int) time.Duration {
+	backoff := w.Backoff
+	for i := 0; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+func (w *Worker) send(ctx context.Context, delivery database.WebhookDelivery) error {
+	body, err := json.Marshal(NewPayload(delivery))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Popple-Webhook")
+	req.Header.Set(EventHeader, Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Webhook.Secret, body))
+
+	rsp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(rsp.Body, maxErrorBody))
+		return fmt.Errorf("webhook responded %s: %s", rsp.Status, bytes.TrimSpace(msg))
+	}
+
+	_, _ = io.Copy(io.Discard, rsp.Body)
+	return nil
+}
+
+// Sign returns the signature header value for a payload: the hex-encoded
+// HMAC-SHA256 of the body keyed with the webhook's secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Payload is the JSON body sent to webhooks.
+type Payload struct {
+	// ID identifies the delivery. Retries of a delivery have the same ID.
+	ID        int64     `json:"id"`
+	Event     string    `json:"event"`
+	ServerID  string    `json:"server_id"`
+	ChannelID string    `json:"channel_id,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Changes   []Change  `json:"changes"`
+}
+
+type Change struct {
+	Name  string `json:"name"`
+	Delta int64  `json:"delta"`
+	// Karma is the subject's karma after the change.
+	Karma int64 `json:"karma"`
+}
+
+func NewPayload(delivery database.WebhookDelivery) Payload {
+	p := Payload{
+		ID:        delivery.ID,
+		Event:     Event,
+		ServerID:  delivery.Webhook.ServerID,
+		ChannelID: delivery.Event.ChannelID,
+		Actor:     delivery.Event.Actor,
+		Reason:    delivery.Event.Reason,
+		CreatedAt: delivery.CreatedAt.UTC(),
+		Changes:   []Change{},
+	}
+
+	for name, delta := range delivery.Event.Increments {
+		p.Changes = append(p.Changes, Change{Name: name, Delta: delta, Karma: delivery.Event.Levels[name]})
+	}
+	sort.Slice(p.Changes, func(i, j int) bool { return p.Changes[i].Name < p.Changes[j].Name })
+
+	return p
+}
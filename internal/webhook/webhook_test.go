@@ -0,0 +1,234 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/connorkuehl/popple/internal/database"
+	"github.com/connorkuehl/popple/internal/database/sqlite"
+	"github.com/connorkuehl/popple/internal/popple"
+	"github.com/connorkuehl/popple/internal/webhook"
+)
+
+const secret = "s3cr3t"
+
+type delivery struct {
+	header  http.Header
+	body    []byte
+	payload webhook.Payload
+}
+
+// receiver records deliveries and fails the first few of them.
+type receiver struct {
+	mu         sync.Mutex
+	failures   int
+	deliveries []delivery
+	received   chan struct{}
+}
+
+func newReceiver(t *testing.T, failures int) (*receiver, *httptest.Server) {
+	rcv := &receiver{failures: failures, received: make(chan struct{}, 100)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		var p webhook.Payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+
+		rcv.mu.Lock()
+		rcv.deliveries = append(rcv.deliveries, delivery{header: r.Header, body: body, payload: p})
+		fail := rcv.failures > 0
+		rcv.failures--
+		rcv.mu.Unlock()
+
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		rcv.received <- struct{}{}
+	}))
+	t.Cleanup(srv.Close)
+	return rcv, srv
+}
+
+func (r *receiver) wait(t *testing.T, n int) []delivery {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for delivery %d", i+1)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]delivery(nil), r.deliveries...)
+}
+
+func setup(t *testing.T, url string, events ...popple.WebhookEvent) (*sqlite.DB, popple.Webhook, *webhook.Worker) {
+	t.Helper()
+
+	db, cleanup, err := sqlite.NewInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	wh, err := db.PutWebhook(context.Background(), popple.Webhook{ServerID: "1", URL: url, Secret: secret, Events: events})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := webhook.NewWorker(db, nil)
+	w.PollInterval = 10 * time.Millisecond
+	w.Backoff = 0
+
+	return db, wh, w
+}
+
+func run(t *testing.T, w *webhook.Worker) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = w.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestDeliver(t *testing.T) {
+	rcv, srv := newReceiver(t, 0)
+	db, _, w := setup(t, srv.URL, popple.WebhookKarmaIncreased)
+
+	event := popple.KarmaEvent{
+		ChannelID:  "2",
+		Increments: popple.Increments{"zelda": 2, "link": 1, "ganon": -1},
+		Levels:     popple.Increments{"zelda": 5, "link": 1, "ganon": -3},
+		Actor:      "ci",
+		Reason:     "green build",
+	}
+	if err := db.PutKarmaEvents(context.Background(), "1", event); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing here is wanted, so nothing is delivered for it.
+	if err := db.PutKarmaEvents(context.Background(), "1", popple.KarmaEvent{Increments: popple.Increments{"ganon": -1}}); err != nil {
+		t.Fatal(err)
+	}
+	// Nor for other servers.
+	if err := db.PutKarmaEvents(context.Background(), "2", event); err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, w)
+	got := rcv.wait(t, 1)[0]
+
+	if sig := got.header.Get(webhook.SignatureHeader); sig != webhook.Sign(secret, got.body) {
+		t.Errorf("want signature %q, got %q", webhook.Sign(secret, got.body), sig)
+	}
+	if e := got.header.Get(webhook.EventHeader); e != webhook.Event {
+		t.Errorf("want event header %q, got %q", webhook.Event, e)
+	}
+
+	want := []webhook.Change{{Name: "link", Delta: 1, Karma: 1}, {Name: "zelda", Delta: 2, Karma: 5}}
+	if !reflect.DeepEqual(got.payload.Changes, want) {
+		t.Errorf("want changes %v, got %v", want, got.payload.Changes)
+	}
+	if got.payload.ServerID != "1" || got.payload.ChannelID != "2" || got.payload.Actor != "ci" || got.payload.Reason != "green build" {
+		t.Errorf("unexpected payload %+v", got.payload)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if len(rcv.deliveries) != 1 {
+		t.Errorf("want 1 delivery, got %d", len(rcv.deliveries))
+	}
+}
+
+func TestRetry(t *testing.T) {
+	rcv, srv := newReceiver(t, 2)
+	db, _, w := setup(t, srv.URL)
+
+	if err := db.PutKarmaEvents(context.Background(), "1", popple.KarmaEvent{Increments: popple.Increments{"link": 1}, Levels: popple.Increments{"link": 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, w)
+	got := rcv.wait(t, 3)
+
+	for _, d := range got[1:] {
+		if d.payload.ID != got[0].payload.ID {
+			t.Errorf("want retries to keep delivery ID %d, got %d", got[0].payload.ID, d.payload.ID)
+		}
+	}
+
+	eventually(t, "the delivery to be done", func() bool {
+		due, err := db.DueWebhookDeliveries(context.Background(), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(due) == 0
+	})
+}
+
+func TestDeadLetter(t *testing.T) {
+	rcv, srv := newReceiver(t, 100)
+	db, wh, w := setup(t, srv.URL)
+	w.MaxAttempts = 3
+
+	if err := db.PutKarmaEvents(context.Background(), "1", popple.KarmaEvent{Increments: popple.Increments{"link": 1}, Levels: popple.Increments{"link": 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, w)
+	rcv.wait(t, 3)
+
+	var letters []database.WebhookDeadLetter
+	eventually(t, "the delivery to be given up on", func() bool {
+		var err error
+		letters, err = db.WebhookDeadLetters(context.Background(), "1", wh.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(letters) == 1
+	})
+
+	if letters[0].Attempts != 3 {
+		t.Errorf("want 3 attempts, got %d", letters[0].Attempts)
+	}
+	if !reflect.DeepEqual(letters[0].Event.Increments, popple.Increments{"link": 1}) {
+		t.Errorf("want the event to be kept, got %v", letters[0].Event)
+	}
+
+	due, err := db.DueWebhookDeliveries(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) > 0 {
+		t.Errorf("want the delivery to be out of the queue, got %v", due)
+	}
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
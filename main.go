@@ -2,28 +2,128 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/connorkuehl/popple/internal/bot"
+	"github.com/connorkuehl/popple/internal/config"
+	"github.com/connorkuehl/popple/internal/env"
+	"github.com/connorkuehl/popple/internal/logging"
 )
 
 func main() {
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT)
-	defer cancel()
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	// Once shutdown has started, stop catching signals so that a second one
+	// kills the process instead of waiting for in-flight work.
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+
+	err := run(ctx, os.Args[1:])
+	cancel()
+	os.Exit(exit(err))
+}
+
+func run(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("popple", flag.ContinueOnError)
+	fs.Usage = func() { usage(fs) }
+	config.AddFlags(fs)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	args = fs.Args()
+
+	settings, err := config.Gather(fs, os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	logConfig, err := logging.ConfigFromEnv(settings)
+	if err != nil {
+		return err
+	}
+	logging.Configure(log.StandardLogger(), logConfig)
+
+	// Popple serves when it isn't told to do anything else.
+	if len(args) == 0 {
+		return runServe(ctx, settings, nil)
+	}
+
+	if args[0] == "help" {
+		return runHelp(ctx, settings, fs, args[1:])
+	}
 
-	if err := run(ctx); err != nil {
-		log.WithError(err).Error("shutting down")
+	c, ok := lookupSubcommand(args[0])
+	if !ok {
+		return usagef("unknown command %q", args[0])
 	}
+	return c.run(ctx, settings, args[1:])
 }
 
-func run(ctx context.Context) error {
-	bot, cleanup, err := InitializeBot()
+// runServe connects to the chat service and runs the bot until ctx is
+// canceled.
+func runServe(ctx context.Context, settings env.Lookup, args []string) error {
+	fs := newFlagSet("serve")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef("serve: unexpected arguments %q", fs.Args())
+	}
+
+	app, cleanup, err := initializeApp(settings)
 	if err != nil {
 		return err
 	}
 	defer cleanup()
 
-	return bot.Listen(ctx)
+	return app.Run(ctx)
+}
+
+// runREPL chats with the bot on stdin and stdout until stdin is closed.
+func runREPL(ctx context.Context, settings env.Lookup, args []string) error {
+	fs := newFlagSet("repl")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef("repl: unexpected arguments %q", fs.Args())
+	}
+
+	app, cleanup, err := InitializeREPLApp(settings)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	err = app.Run(ctx)
+	if errors.Is(err, bot.ErrSessionClosed) {
+		return nil
+	}
+	return err
+}
+
+// initializeApp connects the bot to the chat service named by
+// POPPLE_BACKEND, which defaults to Discord.
+func initializeApp(settings env.Lookup) (*App, func(), error) {
+	switch backend := settings("POPPLE_BACKEND"); backend {
+	case "", "discord":
+		return InitializeDiscordApp(settings)
+	case "slack":
+		return InitializeSlackApp(settings)
+	case "irc":
+		return InitializeIRCApp(settings)
+	case "matrix":
+		return InitializeMatrixApp(settings)
+	default:
+		return nil, nil, fmt.Errorf("POPPLE_BACKEND: unknown backend %q", backend)
+	}
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+// runConfig checks the settings for the configured chat service.
+func runConfig(ctx context.Context, settings env.Lookup, args []string) error {
+	fs := newFlagSet("config")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || fs.Arg(0) != "validate" {
+		return usagef("config: expected validate")
+	}
+
+	backend := settings("POPPLE_BACKEND")
+	if len(backend) == 0 {
+		backend = "discord"
+	}
+
+	var err error
+	switch backend {
+	case "discord":
+		_, err = InitializeDiscordSettings(settings)
+	case "slack":
+		_, err = InitializeSlackSettings(settings)
+	case "irc":
+		_, err = InitializeIRCSettings(settings)
+	case "matrix":
+		_, err = InitializeMatrixSettings(settings)
+	default:
+		err = fmt.Errorf("POPPLE_BACKEND: unknown backend %q", backend)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("the settings for %s are valid\n", backend)
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/connorkuehl/popple/internal/env"
+)
+
+// version is set when releases are built, e.g.,
+// go build -ldflags "-X main.version=v1.2.3".
+var version string
+
+// runVersion prints popple's version along with what it was built with.
+func runVersion(ctx context.Context, settings env.Lookup, args []string) error {
+	fs := newFlagSet("version")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef("version: unexpected arguments %q", fs.Args())
+	}
+
+	fmt.Printf("popple %s %s %s/%s\n", buildVersion(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
+}
+
+// buildVersion is the release version, or else the module version or
+// commit the binary was built from.
+func buildVersion() string {
+	if len(version) > 0 {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; len(v) > 0 && v != "(devel)" {
+		return v
+	}
+
+	v := "devel"
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision":
+			v += " " + s.Value
+		case s.Key == "vcs.modified" && s.Value == "true":
+			v += "+dirty"
+		}
+	}
+	return v
+}
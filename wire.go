@@ -4,12 +4,26 @@
 package main
 
 import (
+	"errors"
+
 	"github.com/google/wire"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/connorkuehl/popple/internal/bot"
 	"github.com/connorkuehl/popple/internal/command"
 	"github.com/connorkuehl/popple/internal/database/sqlite"
 	"github.com/connorkuehl/popple/internal/discord"
+	"github.com/connorkuehl/popple/internal/env"
+	"github.com/connorkuehl/popple/internal/health"
+	"github.com/connorkuehl/popple/internal/httpapi"
+	"github.com/connorkuehl/popple/internal/irc"
+	"github.com/connorkuehl/popple/internal/logging"
+	"github.com/connorkuehl/popple/internal/matrix"
+	"github.com/connorkuehl/popple/internal/metrics"
+	"github.com/connorkuehl/popple/internal/repl"
+	"github.com/connorkuehl/popple/internal/slack"
+	"github.com/connorkuehl/popple/internal/tracing"
+	"github.com/connorkuehl/popple/internal/webhook"
 )
 
 var DiscordSet = wire.NewSet(
@@ -18,24 +32,342 @@ var DiscordSet = wire.NewSet(
 	discord.TokenFromEnv,
 )
 
+var SlackSet = wire.NewSet(
+	slack.NewSession,
+	slack.NewDialer,
+	slack.BotTokenFromEnv,
+	slack.AppTokenFromEnv,
+	slack.APIURLFromEnv,
+)
+
+var IRCSet = wire.NewSet(
+	irc.NewSession,
+	irc.NewDialer,
+	irc.ConfigFromEnv,
+)
+
+var MatrixSet = wire.NewSet(
+	matrix.NewSession,
+	matrix.NewDialer,
+	matrix.HomeserverFromEnv,
+	matrix.AccessTokenFromEnv,
+	matrix.AdminsFromEnv,
+)
+
+var APISet = wire.NewSet(
+	httpapi.New,
+	httpapi.ConfigFromEnv,
+)
+
+var LoggingSet = wire.NewSet(
+	provideLogger,
+	logging.ConfigFromEnv,
+)
+
+var TracingSet = wire.NewSet(
+	tracing.New,
+	tracing.ConfigFromEnv,
+)
+
+var MetricsSet = wire.NewSet(
+	metrics.New,
+	provideBot,
+	metrics.ConfigFromEnv,
+	provideBotSession,
+	provideBotDB,
+	provideBotRouter,
+	TracingSet,
+)
+
+var HealthSet = wire.NewSet(
+	provideHealth,
+	health.ConfigFromEnv,
+)
+
 var SQLiteSet = wire.NewSet(
 	sqlite.New,
 	sqlite.PathFromEnv,
+	BackupSet,
 )
 
+var BackupSet = wire.NewSet(
+	sqlite.NewBackups,
+	sqlite.BackupConfigFromEnv,
+)
+
+// settings are what Popple needs to serve on any chat service. They're
+// read without connecting to anything so that they can be checked on their
+// own.
+type settings struct {
+	Log     logging.Config
+	DB      sqlite.Path
+	Backups sqlite.BackupConfig
+	API     httpapi.Config
+	Metrics metrics.Config
+	Health  health.Config
+	Tracing tracing.Config
+}
+
+var SettingsSet = wire.NewSet(
+	wire.Struct(new(settings), "*"),
+	logging.ConfigFromEnv,
+	sqlite.PathFromEnv,
+	sqlite.BackupConfigFromEnv,
+	httpapi.ConfigFromEnv,
+	metrics.ConfigFromEnv,
+	health.ConfigFromEnv,
+	tracing.ConfigFromEnv,
+)
+
+type discordSettings struct {
+	settings
+	Token discord.Token
+}
+
+type slackSettings struct {
+	settings
+	BotToken slack.BotToken
+	AppToken slack.AppToken
+	APIURL   slack.APIURL
+}
+
+type ircSettings struct {
+	settings
+	IRC irc.Config
+}
+
+type matrixSettings struct {
+	settings
+	Homeserver  matrix.Homeserver
+	AccessToken matrix.AccessToken
+	Admins      matrix.Admins
+}
+
+// transport is the chat service the bot is connected to, before it's
+// instrumented.
+type transport interface {
+	bot.Session
+	health.Transport
+	metrics.Connection
+}
+
+func provideHealth(config health.Config, t transport, db *sqlite.DB) *health.Server {
+	return health.New(config, t, db)
+}
+
+// provideLogger hands the bot, the chat session, the HTTP API and the
+// webhook worker the standard logger, which run has already configured.
+func provideLogger(config logging.Config) *logging.Logger {
+	return logging.New(log.StandardLogger(), config)
+}
+
+// provideBot counts the errors the bot's handlers run into.
+func provideBot(m *metrics.Metrics, s bot.Session, db bot.DB, r bot.CommandRouter, logger *logging.Logger) *bot.Bot {
+	b := bot.New(s, db, r, logger)
+	b.CountFailures(m)
+	return b
+}
+
+func provideBotSession(m *metrics.Metrics, t *tracing.Tracing, s transport) bot.Session {
+	m.Connection(s)
+	return m.Session(t.Session(s))
+}
+
+func provideBotDB(m *metrics.Metrics, t *tracing.Tracing, db *sqlite.DB) bot.DB {
+	return m.DB(t.DB(db))
+}
+
+func provideBotRouter(m *metrics.Metrics, r *command.Router) bot.CommandRouter {
+	return m.Router(r)
+}
+
 func provideRouter(s *discord.Session) *command.Router {
 	return command.NewRouter("@" + s.Username())
 }
 
-func InitializeBot() (*bot.Bot, func(), error) {
+func provideSlackRouter(s *slack.Session) *command.Router {
+	return command.NewRouter("@" + s.Username())
+}
+
+// provideIRCRouter routes on the session's current nick, since the server
+// may have given it another one when it reconnected.
+func provideIRCRouter(s *irc.Session) *command.Router {
+	return command.NewRouterFunc(func() string { return s.Username() + ":" })
+}
+
+func provideMatrixRouter(s *matrix.Session) *command.Router {
+	return command.NewRouter(s.Username() + ":")
+}
+
+func provideREPLRouter(s *repl.Session) *command.Router {
+	return command.NewRouter("@" + s.Username())
+}
+
+// provideREPLDB opens the configured database if there is one, and a
+// scratch database otherwise.
+func provideREPLDB(lookup env.Lookup) (*sqlite.DB, func(), error) {
+	path, err := sqlite.PathFromEnv(lookup)
+	if errors.Is(err, env.ErrKeyNotFound) {
+		return sqlite.NewInMemory()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return sqlite.New(path)
+}
+
+func InitializeDiscordApp(lookup env.Lookup) (*App, func(), error) {
 	wire.Build(
-		bot.New,
+		wire.Struct(new(App), "*"),
+		LoggingSet,
+		APISet,
 		provideRouter,
-		wire.Bind(new(bot.CommandRouter), new(*command.Router)),
-		wire.Bind(new(bot.Session), new(*discord.Session)),
+		wire.Bind(new(transport), new(*discord.Session)),
 		DiscordSet,
-		wire.Bind(new(bot.DB), new(*sqlite.DB)),
+		MetricsSet,
+		HealthSet,
+		wire.Bind(new(httpapi.DB), new(*sqlite.DB)),
+		wire.Bind(new(httpapi.Karma), new(*bot.Bot)),
+		webhook.NewWorker,
+		wire.Bind(new(webhook.DB), new(*sqlite.DB)),
+		SQLiteSet,
+	)
+	return nil, nil, nil
+}
+
+func InitializeSlackApp(lookup env.Lookup) (*App, func(), error) {
+	wire.Build(
+		wire.Struct(new(App), "*"),
+		LoggingSet,
+		APISet,
+		provideSlackRouter,
+		wire.Bind(new(transport), new(*slack.Session)),
+		SlackSet,
+		MetricsSet,
+		HealthSet,
+		wire.Bind(new(httpapi.DB), new(*sqlite.DB)),
+		wire.Bind(new(httpapi.Karma), new(*bot.Bot)),
+		webhook.NewWorker,
+		wire.Bind(new(webhook.DB), new(*sqlite.DB)),
 		SQLiteSet,
 	)
 	return nil, nil, nil
 }
+
+func InitializeIRCApp(lookup env.Lookup) (*App, func(), error) {
+	wire.Build(
+		wire.Struct(new(App), "*"),
+		LoggingSet,
+		APISet,
+		provideIRCRouter,
+		wire.Bind(new(transport), new(*irc.Session)),
+		IRCSet,
+		MetricsSet,
+		HealthSet,
+		wire.Bind(new(httpapi.DB), new(*sqlite.DB)),
+		wire.Bind(new(httpapi.Karma), new(*bot.Bot)),
+		webhook.NewWorker,
+		wire.Bind(new(webhook.DB), new(*sqlite.DB)),
+		SQLiteSet,
+	)
+	return nil, nil, nil
+}
+
+func InitializeMatrixApp(lookup env.Lookup) (*App, func(), error) {
+	wire.Build(
+		wire.Struct(new(App), "*"),
+		LoggingSet,
+		APISet,
+		provideMatrixRouter,
+		wire.Bind(new(transport), new(*matrix.Session)),
+		MatrixSet,
+		MetricsSet,
+		HealthSet,
+		wire.Bind(new(httpapi.DB), new(*sqlite.DB)),
+		wire.Bind(new(httpapi.Karma), new(*bot.Bot)),
+		webhook.NewWorker,
+		wire.Bind(new(webhook.DB), new(*sqlite.DB)),
+		SQLiteSet,
+	)
+	return nil, nil, nil
+}
+
+func InitializeREPLApp(lookup env.Lookup) (*App, func(), error) {
+	wire.Build(
+		wire.Struct(new(App), "*"),
+		LoggingSet,
+		APISet,
+		provideREPLRouter,
+		wire.Bind(new(transport), new(*repl.Session)),
+		repl.NewStdioSession,
+		MetricsSet,
+		HealthSet,
+		wire.Bind(new(httpapi.DB), new(*sqlite.DB)),
+		wire.Bind(new(httpapi.Karma), new(*bot.Bot)),
+		webhook.NewWorker,
+		wire.Bind(new(webhook.DB), new(*sqlite.DB)),
+		provideREPLDB,
+		BackupSet,
+	)
+	return nil, nil, nil
+}
+
+// InitializeDB opens the configured database for commands that only need
+// the database.
+func InitializeDB(lookup env.Lookup) (*sqlite.DB, func(), error) {
+	wire.Build(
+		sqlite.New,
+		sqlite.PathFromEnv,
+	)
+	return nil, nil, nil
+}
+
+// InitializeHistory reads Discord channel history without connecting to the
+// gateway.
+func InitializeHistory(lookup env.Lookup) (*discord.History, error) {
+	wire.Build(
+		discord.NewHistory,
+		discord.TokenFromEnv,
+	)
+	return nil, nil
+}
+
+func InitializeDiscordSettings(lookup env.Lookup) (discordSettings, error) {
+	wire.Build(
+		wire.Struct(new(discordSettings), "*"),
+		SettingsSet,
+		discord.TokenFromEnv,
+	)
+	return discordSettings{}, nil
+}
+
+func InitializeSlackSettings(lookup env.Lookup) (slackSettings, error) {
+	wire.Build(
+		wire.Struct(new(slackSettings), "*"),
+		SettingsSet,
+		slack.BotTokenFromEnv,
+		slack.AppTokenFromEnv,
+		slack.APIURLFromEnv,
+	)
+	return slackSettings{}, nil
+}
+
+func InitializeIRCSettings(lookup env.Lookup) (ircSettings, error) {
+	wire.Build(
+		wire.Struct(new(ircSettings), "*"),
+		SettingsSet,
+		irc.ConfigFromEnv,
+	)
+	return ircSettings{}, nil
+}
+
+func InitializeMatrixSettings(lookup env.Lookup) (matrixSettings, error) {
+	wire.Build(
+		wire.Struct(new(matrixSettings), "*"),
+		SettingsSet,
+		matrix.HomeserverFromEnv,
+		matrix.AccessTokenFromEnv,
+		matrix.AdminsFromEnv,
+	)
+	return matrixSettings{}, nil
+}
@@ -7,49 +7,853 @@
 package main
 
 import (
+	"errors"
 	"github.com/connorkuehl/popple/internal/bot"
 	"github.com/connorkuehl/popple/internal/command"
 	"github.com/connorkuehl/popple/internal/database/sqlite"
 	"github.com/connorkuehl/popple/internal/discord"
+	"github.com/connorkuehl/popple/internal/env"
+	"github.com/connorkuehl/popple/internal/health"
+	"github.com/connorkuehl/popple/internal/httpapi"
+	"github.com/connorkuehl/popple/internal/irc"
+	"github.com/connorkuehl/popple/internal/logging"
+	"github.com/connorkuehl/popple/internal/matrix"
+	"github.com/connorkuehl/popple/internal/metrics"
+	"github.com/connorkuehl/popple/internal/repl"
+	"github.com/connorkuehl/popple/internal/slack"
+	"github.com/connorkuehl/popple/internal/tracing"
+	"github.com/connorkuehl/popple/internal/webhook"
 	"github.com/google/wire"
+	"github.com/sirupsen/logrus"
 )
 
 // Injectors from wire.go:
 
-func InitializeBot() (*bot.Bot, func(), error) {
-	token, err := discord.TokenFromEnv()
+func InitializeDiscordApp(lookup env.Lookup) (*App, func(), error) {
+	config, err := metrics.ConfigFromEnv(lookup)
 	if err != nil {
 		return nil, nil, err
 	}
+	metricsMetrics := metrics.New(config)
+	tracingConfig, err := tracing.ConfigFromEnv(lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	tracingTracing, cleanup, err := tracing.New(tracingConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	token, err := discord.TokenFromEnv(lookup)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
 	dialer := discord.NewDialer(token)
-	session, cleanup, err := discord.NewSession(dialer)
+	loggingConfig, err := logging.ConfigFromEnv(lookup)
 	if err != nil {
+		cleanup()
 		return nil, nil, err
 	}
-	path, err := sqlite.PathFromEnv()
+	logger := provideLogger(loggingConfig)
+	session, cleanup2, err := discord.NewSession(dialer, logger)
 	if err != nil {
 		cleanup()
 		return nil, nil, err
 	}
-	db, cleanup2, err := sqlite.New(path)
+	botSession := provideBotSession(metricsMetrics, tracingTracing, session)
+	path, err := sqlite.PathFromEnv(lookup)
 	if err != nil {
+		cleanup2()
 		cleanup()
 		return nil, nil, err
 	}
+	db, cleanup3, err := sqlite.New(path)
+	if err != nil {
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	botDB := provideBotDB(metricsMetrics, tracingTracing, db)
 	router := provideRouter(session)
-	botBot := bot.New(session, db, router)
-	return botBot, func() {
+	commandRouter := provideBotRouter(metricsMetrics, router)
+	bot := provideBot(metricsMetrics, botSession, botDB, commandRouter, logger)
+	httpapiConfig, err := httpapi.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	server := httpapi.New(httpapiConfig, db, bot, logger)
+	worker := webhook.NewWorker(db, logger)
+	healthConfig, err := health.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	healthServer := provideHealth(healthConfig, session, db)
+	backupConfig, err := sqlite.BackupConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	backups := sqlite.NewBackups(db, backupConfig)
+	app := &App{
+		Bot:      bot,
+		API:      server,
+		Webhooks: worker,
+		Metrics:  metricsMetrics,
+		Health:   healthServer,
+		Backups:  backups,
+	}
+	return app, func() {
+		cleanup3()
+		cleanup2()
+		cleanup()
+	}, nil
+}
+
+func InitializeSlackApp(lookup env.Lookup) (*App, func(), error) {
+	config, err := metrics.ConfigFromEnv(lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	metricsMetrics := metrics.New(config)
+	tracingConfig, err := tracing.ConfigFromEnv(lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	tracingTracing, cleanup, err := tracing.New(tracingConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	botToken, err := slack.BotTokenFromEnv(lookup)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	appToken, err := slack.AppTokenFromEnv(lookup)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	apiurl := slack.APIURLFromEnv(lookup)
+	dialer := slack.NewDialer(botToken, appToken, apiurl)
+	loggingConfig, err := logging.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	logger := provideLogger(loggingConfig)
+	session, cleanup2, err := slack.NewSession(dialer, logger)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	botSession := provideBotSession(metricsMetrics, tracingTracing, session)
+	path, err := sqlite.PathFromEnv(lookup)
+	if err != nil {
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	db, cleanup3, err := sqlite.New(path)
+	if err != nil {
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	botDB := provideBotDB(metricsMetrics, tracingTracing, db)
+	router := provideSlackRouter(session)
+	commandRouter := provideBotRouter(metricsMetrics, router)
+	bot := provideBot(metricsMetrics, botSession, botDB, commandRouter, logger)
+	httpapiConfig, err := httpapi.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	server := httpapi.New(httpapiConfig, db, bot, logger)
+	worker := webhook.NewWorker(db, logger)
+	healthConfig, err := health.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	healthServer := provideHealth(healthConfig, session, db)
+	backupConfig, err := sqlite.BackupConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	backups := sqlite.NewBackups(db, backupConfig)
+	app := &App{
+		Bot:      bot,
+		API:      server,
+		Webhooks: worker,
+		Metrics:  metricsMetrics,
+		Health:   healthServer,
+		Backups:  backups,
+	}
+	return app, func() {
+		cleanup3()
+		cleanup2()
+		cleanup()
+	}, nil
+}
+
+func InitializeIRCApp(lookup env.Lookup) (*App, func(), error) {
+	config, err := metrics.ConfigFromEnv(lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	metricsMetrics := metrics.New(config)
+	tracingConfig, err := tracing.ConfigFromEnv(lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	tracingTracing, cleanup, err := tracing.New(tracingConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	ircConfig, err := irc.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	dialer := irc.NewDialer(ircConfig)
+	loggingConfig, err := logging.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	logger := provideLogger(loggingConfig)
+	session, cleanup2, err := irc.NewSession(dialer, logger)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	botSession := provideBotSession(metricsMetrics, tracingTracing, session)
+	path, err := sqlite.PathFromEnv(lookup)
+	if err != nil {
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	db, cleanup3, err := sqlite.New(path)
+	if err != nil {
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	botDB := provideBotDB(metricsMetrics, tracingTracing, db)
+	router := provideIRCRouter(session)
+	commandRouter := provideBotRouter(metricsMetrics, router)
+	bot := provideBot(metricsMetrics, botSession, botDB, commandRouter, logger)
+	httpapiConfig, err := httpapi.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	server := httpapi.New(httpapiConfig, db, bot, logger)
+	worker := webhook.NewWorker(db, logger)
+	healthConfig, err := health.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	healthServer := provideHealth(healthConfig, session, db)
+	backupConfig, err := sqlite.BackupConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	backups := sqlite.NewBackups(db, backupConfig)
+	app := &App{
+		Bot:      bot,
+		API:      server,
+		Webhooks: worker,
+		Metrics:  metricsMetrics,
+		Health:   healthServer,
+		Backups:  backups,
+	}
+	return app, func() {
+		cleanup3()
+		cleanup2()
+		cleanup()
+	}, nil
+}
+
+func InitializeMatrixApp(lookup env.Lookup) (*App, func(), error) {
+	config, err := metrics.ConfigFromEnv(lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	metricsMetrics := metrics.New(config)
+	tracingConfig, err := tracing.ConfigFromEnv(lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	tracingTracing, cleanup, err := tracing.New(tracingConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	homeserver, err := matrix.HomeserverFromEnv(lookup)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	accessToken, err := matrix.AccessTokenFromEnv(lookup)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	admins, err := matrix.AdminsFromEnv(lookup)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	dialer := matrix.NewDialer(homeserver, accessToken, admins)
+	loggingConfig, err := logging.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	logger := provideLogger(loggingConfig)
+	session, cleanup2, err := matrix.NewSession(dialer, logger)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	botSession := provideBotSession(metricsMetrics, tracingTracing, session)
+	path, err := sqlite.PathFromEnv(lookup)
+	if err != nil {
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	db, cleanup3, err := sqlite.New(path)
+	if err != nil {
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	botDB := provideBotDB(metricsMetrics, tracingTracing, db)
+	router := provideMatrixRouter(session)
+	commandRouter := provideBotRouter(metricsMetrics, router)
+	bot := provideBot(metricsMetrics, botSession, botDB, commandRouter, logger)
+	httpapiConfig, err := httpapi.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	server := httpapi.New(httpapiConfig, db, bot, logger)
+	worker := webhook.NewWorker(db, logger)
+	healthConfig, err := health.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	healthServer := provideHealth(healthConfig, session, db)
+	backupConfig, err := sqlite.BackupConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	backups := sqlite.NewBackups(db, backupConfig)
+	app := &App{
+		Bot:      bot,
+		API:      server,
+		Webhooks: worker,
+		Metrics:  metricsMetrics,
+		Health:   healthServer,
+		Backups:  backups,
+	}
+	return app, func() {
+		cleanup3()
+		cleanup2()
+		cleanup()
+	}, nil
+}
+
+func InitializeREPLApp(lookup env.Lookup) (*App, func(), error) {
+	config, err := metrics.ConfigFromEnv(lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	metricsMetrics := metrics.New(config)
+	tracingConfig, err := tracing.ConfigFromEnv(lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	tracingTracing, cleanup, err := tracing.New(tracingConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, cleanup2 := repl.NewStdioSession()
+	botSession := provideBotSession(metricsMetrics, tracingTracing, session)
+	db, cleanup3, err := provideREPLDB(lookup)
+	if err != nil {
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	botDB := provideBotDB(metricsMetrics, tracingTracing, db)
+	router := provideREPLRouter(session)
+	commandRouter := provideBotRouter(metricsMetrics, router)
+	loggingConfig, err := logging.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	logger := provideLogger(loggingConfig)
+	bot := provideBot(metricsMetrics, botSession, botDB, commandRouter, logger)
+	httpapiConfig, err := httpapi.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	server := httpapi.New(httpapiConfig, db, bot, logger)
+	worker := webhook.NewWorker(db, logger)
+	healthConfig, err := health.ConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	healthServer := provideHealth(healthConfig, session, db)
+	backupConfig, err := sqlite.BackupConfigFromEnv(lookup)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	backups := sqlite.NewBackups(db, backupConfig)
+	app := &App{
+		Bot:      bot,
+		API:      server,
+		Webhooks: worker,
+		Metrics:  metricsMetrics,
+		Health:   healthServer,
+		Backups:  backups,
+	}
+	return app, func() {
+		cleanup3()
 		cleanup2()
 		cleanup()
 	}, nil
 }
 
+// InitializeDB opens the configured database for commands that only need
+// the database.
+func InitializeDB(lookup env.Lookup) (*sqlite.DB, func(), error) {
+	path, err := sqlite.PathFromEnv(lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, cleanup, err := sqlite.New(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, func() {
+		cleanup()
+	}, nil
+}
+
+// InitializeHistory reads Discord channel history without connecting to the
+// gateway.
+func InitializeHistory(lookup env.Lookup) (*discord.History, error) {
+	token, err := discord.TokenFromEnv(lookup)
+	if err != nil {
+		return nil, err
+	}
+	history, err := discord.NewHistory(token)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func InitializeDiscordSettings(lookup env.Lookup) (discordSettings, error) {
+	config, err := logging.ConfigFromEnv(lookup)
+	if err != nil {
+		return discordSettings{}, err
+	}
+	path, err := sqlite.PathFromEnv(lookup)
+	if err != nil {
+		return discordSettings{}, err
+	}
+	backupConfig, err := sqlite.BackupConfigFromEnv(lookup)
+	if err != nil {
+		return discordSettings{}, err
+	}
+	httpapiConfig, err := httpapi.ConfigFromEnv(lookup)
+	if err != nil {
+		return discordSettings{}, err
+	}
+	metricsConfig, err := metrics.ConfigFromEnv(lookup)
+	if err != nil {
+		return discordSettings{}, err
+	}
+	healthConfig, err := health.ConfigFromEnv(lookup)
+	if err != nil {
+		return discordSettings{}, err
+	}
+	tracingConfig, err := tracing.ConfigFromEnv(lookup)
+	if err != nil {
+		return discordSettings{}, err
+	}
+	mainSettings := settings{
+		Log:     config,
+		DB:      path,
+		Backups: backupConfig,
+		API:     httpapiConfig,
+		Metrics: metricsConfig,
+		Health:  healthConfig,
+		Tracing: tracingConfig,
+	}
+	token, err := discord.TokenFromEnv(lookup)
+	if err != nil {
+		return discordSettings{}, err
+	}
+	mainDiscordSettings := discordSettings{
+		settings: mainSettings,
+		Token:    token,
+	}
+	return mainDiscordSettings, nil
+}
+
+func InitializeSlackSettings(lookup env.Lookup) (slackSettings, error) {
+	config, err := logging.ConfigFromEnv(lookup)
+	if err != nil {
+		return slackSettings{}, err
+	}
+	path, err := sqlite.PathFromEnv(lookup)
+	if err != nil {
+		return slackSettings{}, err
+	}
+	backupConfig, err := sqlite.BackupConfigFromEnv(lookup)
+	if err != nil {
+		return slackSettings{}, err
+	}
+	httpapiConfig, err := httpapi.ConfigFromEnv(lookup)
+	if err != nil {
+		return slackSettings{}, err
+	}
+	metricsConfig, err := metrics.ConfigFromEnv(lookup)
+	if err != nil {
+		return slackSettings{}, err
+	}
+	healthConfig, err := health.ConfigFromEnv(lookup)
+	if err != nil {
+		return slackSettings{}, err
+	}
+	tracingConfig, err := tracing.ConfigFromEnv(lookup)
+	if err != nil {
+		return slackSettings{}, err
+	}
+	mainSettings := settings{
+		Log:     config,
+		DB:      path,
+		Backups: backupConfig,
+		API:     httpapiConfig,
+		Metrics: metricsConfig,
+		Health:  healthConfig,
+		Tracing: tracingConfig,
+	}
+	botToken, err := slack.BotTokenFromEnv(lookup)
+	if err != nil {
+		return slackSettings{}, err
+	}
+	appToken, err := slack.AppTokenFromEnv(lookup)
+	if err != nil {
+		return slackSettings{}, err
+	}
+	apiurl := slack.APIURLFromEnv(lookup)
+	mainSlackSettings := slackSettings{
+		settings: mainSettings,
+		BotToken: botToken,
+		AppToken: appToken,
+		APIURL:   apiurl,
+	}
+	return mainSlackSettings, nil
+}
+
+func InitializeIRCSettings(lookup env.Lookup) (ircSettings, error) {
+	config, err := logging.ConfigFromEnv(lookup)
+	if err != nil {
+		return ircSettings{}, err
+	}
+	path, err := sqlite.PathFromEnv(lookup)
+	if err != nil {
+		return ircSettings{}, err
+	}
+	backupConfig, err := sqlite.BackupConfigFromEnv(lookup)
+	if err != nil {
+		return ircSettings{}, err
+	}
+	httpapiConfig, err := httpapi.ConfigFromEnv(lookup)
+	if err != nil {
+		return ircSettings{}, err
+	}
+	metricsConfig, err := metrics.ConfigFromEnv(lookup)
+	if err != nil {
+		return ircSettings{}, err
+	}
+	healthConfig, err := health.ConfigFromEnv(lookup)
+	if err != nil {
+		return ircSettings{}, err
+	}
+	tracingConfig, err := tracing.ConfigFromEnv(lookup)
+	if err != nil {
+		return ircSettings{}, err
+	}
+	mainSettings := settings{
+		Log:     config,
+		DB:      path,
+		Backups: backupConfig,
+		API:     httpapiConfig,
+		Metrics: metricsConfig,
+		Health:  healthConfig,
+		Tracing: tracingConfig,
+	}
+	ircConfig, err := irc.ConfigFromEnv(lookup)
+	if err != nil {
+		return ircSettings{}, err
+	}
+	mainIrcSettings := ircSettings{
+		settings: mainSettings,
+		IRC:      ircConfig,
+	}
+	return mainIrcSettings, nil
+}
+
+func InitializeMatrixSettings(lookup env.Lookup) (matrixSettings, error) {
+	config, err := logging.ConfigFromEnv(lookup)
+	if err != nil {
+		return matrixSettings{}, err
+	}
+	path, err := sqlite.PathFromEnv(lookup)
+	if err != nil {
+		return matrixSettings{}, err
+	}
+	backupConfig, err := sqlite.BackupConfigFromEnv(lookup)
+	if err != nil {
+		return matrixSettings{}, err
+	}
+	httpapiConfig, err := httpapi.ConfigFromEnv(lookup)
+	if err != nil {
+		return matrixSettings{}, err
+	}
+	metricsConfig, err := metrics.ConfigFromEnv(lookup)
+	if err != nil {
+		return matrixSettings{}, err
+	}
+	healthConfig, err := health.ConfigFromEnv(lookup)
+	if err != nil {
+		return matrixSettings{}, err
+	}
+	tracingConfig, err := tracing.ConfigFromEnv(lookup)
+	if err != nil {
+		return matrixSettings{}, err
+	}
+	mainSettings := settings{
+		Log:     config,
+		DB:      path,
+		Backups: backupConfig,
+		API:     httpapiConfig,
+		Metrics: metricsConfig,
+		Health:  healthConfig,
+		Tracing: tracingConfig,
+	}
+	homeserver, err := matrix.HomeserverFromEnv(lookup)
+	if err != nil {
+		return matrixSettings{}, err
+	}
+	accessToken, err := matrix.AccessTokenFromEnv(lookup)
+	if err != nil {
+		return matrixSettings{}, err
+	}
+	admins, err := matrix.AdminsFromEnv(lookup)
+	if err != nil {
+		return matrixSettings{}, err
+	}
+	mainMatrixSettings := matrixSettings{
+		settings:    mainSettings,
+		Homeserver:  homeserver,
+		AccessToken: accessToken,
+		Admins:      admins,
+	}
+	return mainMatrixSettings, nil
+}
+
 // wire.go:
 
 var DiscordSet = wire.NewSet(discord.NewSession, discord.NewDialer, discord.TokenFromEnv)
 
-var SQLiteSet = wire.NewSet(sqlite.New, sqlite.PathFromEnv)
+var SlackSet = wire.NewSet(slack.NewSession, slack.NewDialer, slack.BotTokenFromEnv, slack.AppTokenFromEnv, slack.APIURLFromEnv)
+
+var IRCSet = wire.NewSet(irc.NewSession, irc.NewDialer, irc.ConfigFromEnv)
+
+var MatrixSet = wire.NewSet(matrix.NewSession, matrix.NewDialer, matrix.HomeserverFromEnv, matrix.AccessTokenFromEnv, matrix.AdminsFromEnv)
+
+var APISet = wire.NewSet(httpapi.New, httpapi.ConfigFromEnv)
+
+var LoggingSet = wire.NewSet(
+	provideLogger, logging.ConfigFromEnv,
+)
+
+var TracingSet = wire.NewSet(tracing.New, tracing.ConfigFromEnv)
+
+var MetricsSet = wire.NewSet(metrics.New, provideBot, metrics.ConfigFromEnv, provideBotSession,
+	provideBotDB,
+	provideBotRouter,
+	TracingSet,
+)
+
+var HealthSet = wire.NewSet(
+	provideHealth, health.ConfigFromEnv,
+)
+
+var SQLiteSet = wire.NewSet(sqlite.New, sqlite.PathFromEnv, BackupSet)
+
+var BackupSet = wire.NewSet(sqlite.NewBackups, sqlite.BackupConfigFromEnv)
+
+// settings are what Popple needs to serve on any chat service. They're
+// read without connecting to anything so that they can be checked on their
+// own.
+type settings struct {
+	Log     logging.Config
+	DB      sqlite.Path
+	Backups sqlite.BackupConfig
+	API     httpapi.Config
+	Metrics metrics.Config
+	Health  health.Config
+	Tracing tracing.Config
+}
+
+var SettingsSet = wire.NewSet(wire.Struct(new(settings), "*"), logging.ConfigFromEnv, sqlite.PathFromEnv, sqlite.BackupConfigFromEnv, httpapi.ConfigFromEnv, metrics.ConfigFromEnv, health.ConfigFromEnv, tracing.ConfigFromEnv)
+
+type discordSettings struct {
+	settings
+	Token discord.Token
+}
+
+type slackSettings struct {
+	settings
+	BotToken slack.BotToken
+	AppToken slack.AppToken
+	APIURL   slack.APIURL
+}
+
+type ircSettings struct {
+	settings
+	IRC irc.Config
+}
+
+type matrixSettings struct {
+	settings
+	Homeserver  matrix.Homeserver
+	AccessToken matrix.AccessToken
+	Admins      matrix.Admins
+}
+
+// transport is the chat service the bot is connected to, before it's
+// instrumented.
+type transport interface {
+	bot.Session
+	health.Transport
+	metrics.Connection
+}
+
+func provideHealth(config health.Config, t transport, db *sqlite.DB) *health.Server {
+	return health.New(config, t, db)
+}
+
+// provideLogger hands the bot, the chat session, the HTTP API and the
+// webhook worker the standard logger, which run has already configured.
+func provideLogger(config logging.Config) *logging.Logger {
+	return logging.New(logrus.StandardLogger(), config)
+}
+
+// provideBot counts the errors the bot's handlers run into.
+func provideBot(m *metrics.Metrics, s bot.Session, db bot.DB, r bot.CommandRouter, logger *logging.Logger) *bot.Bot {
+	b := bot.New(s, db, r, logger)
+	b.CountFailures(m)
+	return b
+}
+
+func provideBotSession(m *metrics.Metrics, t *tracing.Tracing, s transport) bot.Session {
+	m.Connection(s)
+	return m.Session(t.Session(s))
+}
+
+func provideBotDB(m *metrics.Metrics, t *tracing.Tracing, db *sqlite.DB) bot.DB {
+	return m.DB(t.DB(db))
+}
+
+func provideBotRouter(m *metrics.Metrics, r *command.Router) bot.CommandRouter {
+	return m.Router(r)
+}
 
 func provideRouter(s *discord.Session) *command.Router {
 	return command.NewRouter("@" + s.Username())
 }
+
+func provideSlackRouter(s *slack.Session) *command.Router {
+	return command.NewRouter("@" + s.Username())
+}
+
+// provideIRCRouter routes on the session's current nick, since the server
+// may have given it another one when it reconnected.
+func provideIRCRouter(s *irc.Session) *command.Router {
+	return command.NewRouterFunc(func() string { return s.Username() + ":" })
+}
+
+func provideMatrixRouter(s *matrix.Session) *command.Router {
+	return command.NewRouter(s.Username() + ":")
+}
+
+func provideREPLRouter(s *repl.Session) *command.Router {
+	return command.NewRouter("@" + s.Username())
+}
+
+// provideREPLDB opens the configured database if there is one, and a
+// scratch database otherwise.
+func provideREPLDB(lookup env.Lookup) (*sqlite.DB, func(), error) {
+	path, err := sqlite.PathFromEnv(lookup)
+	if errors.Is(err, env.ErrKeyNotFound) {
+		return sqlite.NewInMemory()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return sqlite.New(path)
+}